@@ -188,6 +188,20 @@ func (c *Change) ScanDoc(dest interface{}) error {
 	return json.Unmarshal(c.doc, dest)
 }
 
+// current returns a copy of the current result as a [Change], for use by
+// [Follower] and [Projector]. The copy remains valid after the iterator
+// advances, as the underlying buffers are reused.
+func (c *Changes) current() *Change {
+	dChange := c.curVal.(*driver.Change)
+	return &Change{
+		ID:      dChange.ID,
+		Seq:     dChange.Seq,
+		Deleted: dChange.Deleted,
+		Changes: append([]string(nil), dChange.Changes...),
+		doc:     append(json.RawMessage(nil), dChange.Doc...),
+	}
+}
+
 // Iterator returns a function that can be used to iterate over the changes
 // feed. This function works with Go 1.23's range functions, and is an
 // alternative to using [Changes.Next] directly.
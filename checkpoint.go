@@ -0,0 +1,68 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+)
+
+// seqCheckpoint persists a changes feed sequence in a document, typically a
+// `_local` one. The zero value, or one with an empty id, is a no-op.
+type seqCheckpoint struct {
+	db  *DB
+	id  string
+	rev string
+}
+
+// seqCheckpointDoc is the content of a checkpoint document.
+type seqCheckpointDoc struct {
+	ID  string `json:"_id"`
+	Rev string `json:"_rev,omitempty"`
+	Seq string `json:"seq"`
+}
+
+// load returns the stored sequence, or an empty string if the checkpoint
+// document does not exist.
+func (c *seqCheckpoint) load(ctx context.Context) (string, error) {
+	if c.id == "" {
+		return "", nil
+	}
+	var doc seqCheckpointDoc
+	err := c.db.Get(ctx, c.id).ScanDoc(&doc)
+	if HTTPStatus(err) == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	c.rev = doc.Rev
+	return doc.Seq, nil
+}
+
+// save stores seq in the checkpoint document.
+func (c *seqCheckpoint) save(ctx context.Context, seq string) error {
+	if c.id == "" {
+		return nil
+	}
+	rev, err := c.db.Put(ctx, c.id, seqCheckpointDoc{
+		ID:  c.id,
+		Rev: c.rev,
+		Seq: seq,
+	})
+	if err != nil {
+		return err
+	}
+	c.rev = rev
+	return nil
+}
@@ -1013,6 +1013,29 @@ func (d *db) SetSecurity(ctx context.Context, security *driver.Security) error {
 	return chttp.ResponseError(res)
 }
 
+var _ driver.RevsLimiter = &db{}
+
+func (d *db) RevsLimit(ctx context.Context) (int, error) {
+	var limit int
+	err := d.Client.DoJSON(ctx, http.MethodGet, d.path("/_revs_limit"), nil, &limit)
+	return limit, err
+}
+
+func (d *db) SetRevsLimit(ctx context.Context, limit int) error {
+	opts := &chttp.Options{
+		GetBody: chttp.BodyEncoder(limit),
+		Header: http.Header{
+			chttp.HeaderIdempotencyKey: []string{},
+		},
+	}
+	res, err := d.Client.DoReq(ctx, http.MethodPut, d.path("/_revs_limit"), opts)
+	if err != nil {
+		return err
+	}
+	defer chttp.CloseBody(res.Body)
+	return chttp.ResponseError(res)
+}
+
 func (d *db) Copy(ctx context.Context, targetID, sourceID string, options driver.Options) (targetRev string, err error) {
 	if sourceID == "" {
 		return "", missingArg("sourceID")
@@ -1897,6 +1897,103 @@ func TestSetSecurity(t *testing.T) {
 	})
 }
 
+func TestRevsLimit(t *testing.T) {
+	type tt struct {
+		db     *db
+		limit  int
+		status int
+		err    string
+	}
+	tests := testy.NewTable()
+
+	tests.Add("network error", tt{
+		db:     newTestDB(nil, errors.New("net error")),
+		status: http.StatusBadGateway,
+		err:    `Get "?http://example.com/testdb/_revs_limit"?: net error`,
+	})
+	tests.Add("success", tt{
+		db: newTestDB(&http.Response{
+			StatusCode: 200,
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Body: io.NopCloser(strings.NewReader("1000\n")),
+		}, nil),
+		limit: 1000,
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		limit, err := tt.db.RevsLimit(context.Background())
+		if d := internal.StatusErrorDiffRE(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+		if limit != tt.limit {
+			t.Errorf("Unexpected limit: %d", limit)
+		}
+	})
+}
+
+func TestSetRevsLimit(t *testing.T) {
+	type tt struct {
+		db     *db
+		limit  int
+		status int
+		err    string
+	}
+	tests := testy.NewTable()
+
+	tests.Add("network error", tt{
+		db:     newTestDB(nil, errors.New("net error")),
+		limit:  10,
+		status: http.StatusBadGateway,
+		err:    `Put "?http://example.com/testdb/_revs_limit"?: net error`,
+	})
+	tests.Add("invalid limit", tt{
+		db: newTestDB(&http.Response{
+			StatusCode: http.StatusBadRequest,
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Body: io.NopCloser(strings.NewReader(`{"error":"bad_request","reason":"Argument must be a positive integer"}`)),
+		}, nil),
+		limit:  -1,
+		status: http.StatusBadRequest,
+		err:    "Bad Request",
+	})
+	tests.Add("success", func(t *testing.T) interface{} {
+		return tt{
+			limit: 10,
+			db: newCustomDB(func(req *http.Request) (*http.Response, error) {
+				defer req.Body.Close() // nolint: errcheck
+				if req.Method != http.MethodPut {
+					t.Errorf("Unexpected method: %s", req.Method)
+				}
+				body, err := io.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				if got := strings.TrimSpace(string(body)); got != "10" {
+					t.Errorf("Unexpected body: %s", got)
+				}
+				return &http.Response{
+					StatusCode: 200,
+					Header: http.Header{
+						"Content-Type": {"application/json"},
+					},
+					Body: io.NopCloser(strings.NewReader(`{"ok":true}`)),
+				}, nil
+			}),
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		err := tt.db.SetRevsLimit(context.Background(), tt.limit)
+		if d := internal.StatusErrorDiffRE(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+	})
+}
+
 func TestGetRev(t *testing.T) {
 	tests := []struct {
 		name   string
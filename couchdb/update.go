@@ -0,0 +1,74 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4/couchdb/chttp"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+const (
+	headerCouchID     = "X-Couch-Id"
+	headerCouchNewRev = "X-Couch-Update-NewRev"
+)
+
+var _ driver.UpdateHandler = &db{}
+
+// UpdateHandler calls the update function with a POST request to
+// /{db}/_design/{ddoc}/_update/{func}, or a PUT request to
+// /{db}/_design/{ddoc}/_update/{func}/{docid} if docID is not empty.
+func (d *db) UpdateHandler(ctx context.Context, ddoc, funcName, docID string, body interface{}, options driver.Options) (string, string, []byte, error) {
+	ddoc = strings.TrimPrefix(ddoc, "_design/")
+	if ddoc == "" {
+		return "", "", nil, missingArg("ddoc")
+	}
+	if funcName == "" {
+		return "", "", nil, missingArg("funcName")
+	}
+	chttpOpts := chttp.NewOptions(options)
+	opts := map[string]interface{}{}
+	options.Apply(opts)
+	var err error
+	chttpOpts.Query, err = optionsToParams(opts)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if body != nil {
+		chttpOpts.GetBody = chttp.BodyEncoder(body)
+	}
+
+	method := http.MethodPost
+	path := "_design/" + chttp.EncodeDocID(ddoc) + "/_update/" + chttp.EncodeDocID(funcName)
+	if docID != "" {
+		method = http.MethodPut
+		path += "/" + chttp.EncodeDocID(docID)
+	}
+	resp, err := d.Client.DoReq(ctx, method, d.path(path), chttpOpts)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer chttp.CloseBody(resp.Body)
+	if err := chttp.ResponseError(resp); err != nil {
+		return "", "", nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return resp.Header.Get(headerCouchID), resp.Header.Get(headerCouchNewRev), respBody, nil
+}
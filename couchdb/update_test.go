@@ -0,0 +1,143 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	kivik "github.com/go-kivik/kivik/v4"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestUpdateHandler(t *testing.T) {
+	type tt struct {
+		db                    *db
+		ddoc, funcName, docID string
+		body                  interface{}
+		options               kivik.Option
+		wantID, wantRev       string
+		wantBody              string
+		status                int
+		err                   string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("missing ddoc", tt{
+		db:     &db{},
+		status: http.StatusBadRequest,
+		err:    "kivik: ddoc required",
+	})
+	tests.Add("missing function", tt{
+		db:     &db{},
+		ddoc:   "foo",
+		status: http.StatusBadRequest,
+		err:    "kivik: funcName required",
+	})
+	tests.Add("network error", tt{
+		db:       newTestDB(nil, errors.New("net error")),
+		ddoc:     "foo",
+		funcName: "bar",
+		status:   http.StatusBadGateway,
+		err:      "Post \"?http://example.com/testdb/_design/foo/_update/bar\"?: net error",
+	})
+	tests.Add("error response", tt{
+		db: newTestDB(&http.Response{
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       Body(`{"error":"not_found","reason":"missing function bar on design doc _design/foo"}`),
+		}, nil),
+		ddoc:     "foo",
+		funcName: "bar",
+		status:   http.StatusNotFound,
+		err:      "Not Found",
+	})
+	tests.Add("no document", tt{
+		db: newCustomDB(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodPost {
+				return nil, fmt.Errorf("Unexpected method: %s", req.Method)
+			}
+			if req.URL.Path != "/testdb/_design/foo/_update/bar" {
+				return nil, fmt.Errorf("Unexpected path: %s", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+				Body:       io.NopCloser(Body("hello")),
+			}, nil
+		}),
+		ddoc:     "_design/foo",
+		funcName: "bar",
+		wantBody: "hello\n",
+	})
+	tests.Add("update document", tt{
+		db: newCustomDB(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodPut {
+				return nil, fmt.Errorf("Unexpected method: %s", req.Method)
+			}
+			if req.URL.Path != "/testdb/_design/foo/_update/bar/baz" {
+				return nil, fmt.Errorf("Unexpected path: %s", req.URL.Path)
+			}
+			if q := req.URL.Query().Get("field"); q != "x" {
+				return nil, fmt.Errorf("Unexpected query: %s", req.URL.RawQuery)
+			}
+			body, _ := io.ReadAll(req.Body)
+			if string(body) != `{"value":1}` {
+				return nil, fmt.Errorf("Unexpected body: %s", body)
+			}
+			return &http.Response{
+				StatusCode: http.StatusCreated,
+				Header: http.Header{
+					"X-Couch-Id":            {"baz"},
+					"X-Couch-Update-Newrev": {"2-xxx"},
+				},
+				Body: io.NopCloser(Body("updated")),
+			}, nil
+		}),
+		ddoc:     "foo",
+		funcName: "bar",
+		docID:    "baz",
+		body:     `{"value":1}`,
+		options:  kivik.Param("field", "x"),
+		wantID:   "baz",
+		wantRev:  "2-xxx",
+		wantBody: "updated\n",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		opts := tt.options
+		if opts == nil {
+			opts = mock.NilOption
+		}
+		id, rev, body, err := tt.db.UpdateHandler(context.Background(), tt.ddoc, tt.funcName, tt.docID, tt.body, opts)
+		if d := internal.StatusErrorDiffRE(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+		if err != nil {
+			return
+		}
+		if id != tt.wantID || rev != tt.wantRev {
+			t.Errorf("Unexpected id/rev: %s/%s", id, rev)
+		}
+		if string(body) != tt.wantBody {
+			t.Errorf("Unexpected body: %q", body)
+		}
+	})
+}
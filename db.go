@@ -833,6 +833,52 @@ func (db *DB) Purge(ctx context.Context, docRevMap map[string][]string) (*PurgeR
 	return nil, &internal.Error{Status: http.StatusNotImplemented, Message: "kivik: purge not supported by driver"}
 }
 
+// RevsLimit returns the maximum number of document revisions that will be
+// tracked by the database.
+//
+// See the [CouchDB documentation].
+//
+// [CouchDB documentation]: https://docs.couchdb.org/en/stable/api/database/misc.html#get--db-_revs_limit
+func (db *DB) RevsLimit(ctx context.Context) (int, error) {
+	if db.err != nil {
+		return 0, db.err
+	}
+	limiter, ok := db.driverDB.(driver.RevsLimiter)
+	if !ok {
+		return 0, errRevsLimitNotImplemented
+	}
+	endQuery, err := db.startQuery()
+	if err != nil {
+		return 0, err
+	}
+	defer endQuery()
+	return limiter.RevsLimit(ctx)
+}
+
+// SetRevsLimit sets the maximum number of document revisions that will be
+// tracked by the database. Older revisions are removed (stemmed) from the
+// revision history of documents as new revisions are added, or when the
+// database is compacted.
+//
+// See the [CouchDB documentation].
+//
+// [CouchDB documentation]: https://docs.couchdb.org/en/stable/api/database/misc.html#put--db-_revs_limit
+func (db *DB) SetRevsLimit(ctx context.Context, limit int) error {
+	if db.err != nil {
+		return db.err
+	}
+	limiter, ok := db.driverDB.(driver.RevsLimiter)
+	if !ok {
+		return errRevsLimitNotImplemented
+	}
+	endQuery, err := db.startQuery()
+	if err != nil {
+		return err
+	}
+	defer endQuery()
+	return limiter.SetRevsLimit(ctx, limit)
+}
+
 // BulkGetReference is a reference to a document given to pass to [DB.BulkGet].
 type BulkGetReference struct {
 	ID        string `json:"id"`
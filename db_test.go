@@ -2753,6 +2753,136 @@ func TestPurge(t *testing.T) {
 	}
 }
 
+func TestRevsLimit(t *testing.T) {
+	type tt struct {
+		db     *DB
+		limit  int
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("not implemented", tt{
+		db: &DB{
+			client:   &Client{},
+			driverDB: &mock.DB{},
+		},
+		status: http.StatusNotImplemented,
+		err:    "kivik: driver does not support RevsLimit interface",
+	})
+	tests.Add("driver error", tt{
+		db: &DB{
+			client: &Client{},
+			driverDB: &mock.RevsLimiter{
+				RevsLimitFunc: func(context.Context) (int, error) {
+					return 0, &internal.Error{Status: http.StatusNotFound, Message: "database does not exist"}
+				},
+			},
+		},
+		status: http.StatusNotFound,
+		err:    "database does not exist",
+	})
+	tests.Add("success", tt{
+		db: &DB{
+			client: &Client{},
+			driverDB: &mock.RevsLimiter{
+				RevsLimitFunc: func(context.Context) (int, error) {
+					return 1000, nil
+				},
+			},
+		},
+		limit: 1000,
+	})
+	tests.Add("client closed", tt{
+		db: &DB{
+			client: &Client{
+				closed: true,
+			},
+			driverDB: &mock.RevsLimiter{},
+		},
+		status: http.StatusServiceUnavailable,
+		err:    "kivik: client closed",
+	})
+	tests.Add("db error", tt{
+		db: &DB{
+			err: errors.New("db error"),
+		},
+		status: http.StatusInternalServerError,
+		err:    "db error",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		limit, err := tt.db.RevsLimit(context.Background())
+		if d := internal.StatusErrorDiff(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+		if limit != tt.limit {
+			t.Errorf("Unexpected limit: %d", limit)
+		}
+	})
+}
+
+func TestSetRevsLimit(t *testing.T) {
+	type tt struct {
+		db     *DB
+		limit  int
+		status int
+		err    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("not implemented", tt{
+		db: &DB{
+			client:   &Client{},
+			driverDB: &mock.DB{},
+		},
+		limit:  10,
+		status: http.StatusNotImplemented,
+		err:    "kivik: driver does not support RevsLimit interface",
+	})
+	tests.Add("driver error", tt{
+		db: &DB{
+			client: &Client{},
+			driverDB: &mock.RevsLimiter{
+				SetRevsLimitFunc: func(context.Context, int) error {
+					return &internal.Error{Status: http.StatusBadRequest, Message: "revs_limit must be positive"}
+				},
+			},
+		},
+		limit:  -1,
+		status: http.StatusBadRequest,
+		err:    "revs_limit must be positive",
+	})
+	tests.Add("success", tt{
+		db: &DB{
+			client: &Client{},
+			driverDB: &mock.RevsLimiter{
+				SetRevsLimitFunc: func(_ context.Context, limit int) error {
+					if limit != 10 {
+						return fmt.Errorf("Unexpected limit: %d", limit)
+					}
+					return nil
+				},
+			},
+		},
+		limit: 10,
+	})
+	tests.Add("db error", tt{
+		db: &DB{
+			err: errors.New("db error"),
+		},
+		status: http.StatusInternalServerError,
+		err:    "db error",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		err := tt.db.SetRevsLimit(context.Background(), tt.limit)
+		if d := internal.StatusErrorDiff(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+	})
+}
+
 func TestBulkGet(t *testing.T) {
 	type bulkGetTest struct {
 		name    string
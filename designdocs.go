@@ -0,0 +1,350 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+// stagedDDocSuffix is appended to the ID of a design document, to form the ID
+// of the temporary design document used to build view indexes in staged mode.
+const stagedDDocSuffix = "-kivik-staged"
+
+// SyncResult is the result of a call to [SyncDesignDocs] or
+// [DesignDocSyncer.Sync]. Each field contains a sorted list of the affected
+// design document IDs.
+type SyncResult struct {
+	// Created lists the design documents which did not previously exist.
+	Created []string `json:"created,omitempty"`
+	// Updated lists the design documents whose content changed.
+	Updated []string `json:"updated,omitempty"`
+	// Unchanged lists the design documents which were already up to date.
+	Unchanged []string `json:"unchanged,omitempty"`
+	// Deleted lists the stale design documents which were removed. This is
+	// only populated when the [SyncDeleteStale] option is used.
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+type syncDeleteStaleOption struct{}
+
+func (syncDeleteStaleOption) Apply(target interface{}) {
+	if s, ok := target.(*DesignDocSyncer); ok {
+		s.deleteStale = true
+	}
+}
+
+// SyncDeleteStale instructs a [DesignDocSyncer] to delete any design documents
+// found in the database, which are not part of the desired set.
+func SyncDeleteStale() Option {
+	return syncDeleteStaleOption{}
+}
+
+type syncViewCleanupOption struct{}
+
+func (syncViewCleanupOption) Apply(target interface{}) {
+	if s, ok := target.(*DesignDocSyncer); ok {
+		s.viewCleanup = true
+	}
+}
+
+// SyncViewCleanup instructs a [DesignDocSyncer] to call [DB.ViewCleanup] after
+// all design documents have been synchronized, if anything was changed.
+func SyncViewCleanup() Option {
+	return syncViewCleanupOption{}
+}
+
+type syncStagedOption struct{}
+
+func (syncStagedOption) Apply(target interface{}) {
+	if s, ok := target.(*DesignDocSyncer); ok {
+		s.staged = true
+	}
+}
+
+// SyncStaged enables staged mode for a [DesignDocSyncer]. In staged mode, each
+// changed design document that defines views is first stored under a temporary
+// ID (the original ID with a "-kivik-staged" suffix), and each of its views is
+// queried to wait for the index to build. Only then is the real design
+// document updated, and the temporary one deleted. Because CouchDB shares view
+// indexes between design documents with identical view definitions, this
+// ensures that production queries never hit a cold index.
+func SyncStaged() Option {
+	return syncStagedOption{}
+}
+
+// DesignDocSyncer synchronizes the design documents of a database with a
+// desired set. Create one with [NewDesignDocSyncer], to synchronize with
+// options, or use [SyncDesignDocs] for the default behavior.
+type DesignDocSyncer struct {
+	db                               *DB
+	options                          Option
+	deleteStale, viewCleanup, staged bool
+}
+
+// NewDesignDocSyncer returns a new [DesignDocSyncer] for db. This function
+// supports the [SyncDeleteStale], [SyncViewCleanup] and [SyncStaged] options.
+// Any other options are passed along to [DB.Put] and [DB.Delete].
+func NewDesignDocSyncer(db *DB, options ...Option) *DesignDocSyncer {
+	s := &DesignDocSyncer{db: db}
+	opts := multiOptions(options)
+	opts.Apply(s)
+	s.options = opts
+	return s
+}
+
+// Sync compares the desired design documents in ddocs with those returned by
+// [DB.DesignDocs], and creates or updates only those that have changed. Each
+// design document may be any value accepted by [DB.Put], and must include an
+// `_id` field, which must begin with "_design/". Any `_rev` field is ignored;
+// the current revision is read from the database.
+//
+// Two design documents are considered equal if all of their fields match,
+// ignoring any special fields which begin with an underscore.
+//
+// The returned result reflects all changes made, even when an error is
+// returned.
+func (s *DesignDocSyncer) Sync(ctx context.Context, ddocs ...interface{}) (*SyncResult, error) {
+	run := &ddocSyncer{DesignDocSyncer: s}
+	err := run.sync(ctx, ddocs, s.options)
+	return run.finalResult(), err
+}
+
+// SyncDesignDocs synchronizes the design documents of db with ddocs, as
+// described for [DesignDocSyncer.Sync]. Stale design documents are kept. Use
+// [NewDesignDocSyncer] to pass options.
+func SyncDesignDocs(ctx context.Context, db *DB, ddocs ...interface{}) (*SyncResult, error) {
+	return NewDesignDocSyncer(db).Sync(ctx, ddocs...)
+}
+
+// ddocSyncer manages a single design document synchronization.
+type ddocSyncer struct {
+	*DesignDocSyncer
+	result SyncResult
+}
+
+func (s *ddocSyncer) finalResult() *SyncResult {
+	result := s.result
+	for _, list := range [][]string{result.Created, result.Updated, result.Unchanged, result.Deleted} {
+		sort.Strings(list)
+	}
+	return &result
+}
+
+func (s *ddocSyncer) sync(ctx context.Context, ddocs []interface{}, options Option) error {
+	desired, err := normalizeDesignDocs(ddocs)
+	if err != nil {
+		return err
+	}
+	current, err := s.currentDesignDocs(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(desired))
+	for id := range desired {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		doc := desired[id]
+		cur, exists := current[id]
+		if exists && ddocEqual(doc, cur) {
+			s.result.Unchanged = append(s.result.Unchanged, id)
+			continue
+		}
+		var rev string
+		if exists {
+			rev, _ = cur["_rev"].(string)
+		}
+		if s.staged && len(ddocViews(doc)) > 0 {
+			if err := s.stage(ctx, id, doc, current, options); err != nil {
+				return err
+			}
+		}
+		if _, err := s.db.Put(ctx, id, withRev(doc, rev), options); err != nil {
+			return fmt.Errorf("failed to store %s: %w", id, err)
+		}
+		if exists {
+			s.result.Updated = append(s.result.Updated, id)
+		} else {
+			s.result.Created = append(s.result.Created, id)
+		}
+		if s.staged && len(ddocViews(doc)) > 0 {
+			if err := s.unstage(ctx, id, options); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.deleteStale {
+		stale := make([]string, 0, len(current))
+		for id := range current {
+			if _, ok := desired[id]; !ok {
+				stale = append(stale, id)
+			}
+		}
+		sort.Strings(stale)
+		for _, id := range stale {
+			rev, _ := current[id]["_rev"].(string)
+			if _, err := s.db.Delete(ctx, id, rev, options); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", id, err)
+			}
+			s.result.Deleted = append(s.result.Deleted, id)
+		}
+	}
+
+	changed := len(s.result.Created)+len(s.result.Updated)+len(s.result.Deleted) > 0
+	if s.viewCleanup && changed {
+		return s.db.ViewCleanup(ctx)
+	}
+	return nil
+}
+
+// currentDesignDocs returns all design documents currently stored in the
+// database, keyed by ID.
+func (s *ddocSyncer) currentDesignDocs(ctx context.Context) (map[string]map[string]interface{}, error) {
+	rs := s.db.DesignDocs(ctx, IncludeDocs())
+	defer rs.Close() // nolint:errcheck
+	current := map[string]map[string]interface{}{}
+	for rs.Next() {
+		id, err := rs.ID()
+		if err != nil {
+			return nil, err
+		}
+		var doc map[string]interface{}
+		if err := rs.ScanDoc(&doc); err != nil {
+			return nil, err
+		}
+		current[id] = doc
+	}
+	return current, rs.Err()
+}
+
+// stage stores doc under a temporary ID, and waits for all of its views to be
+// indexed.
+func (s *ddocSyncer) stage(ctx context.Context, id string, doc map[string]interface{}, current map[string]map[string]interface{}, options Option) error {
+	stagedID := id + stagedDDocSuffix
+	var rev string
+	if cur, ok := current[stagedID]; ok {
+		rev, _ = cur["_rev"].(string)
+	}
+	staged := withRev(doc, rev)
+	staged["_id"] = stagedID
+	if _, err := s.db.Put(ctx, stagedID, staged, options); err != nil {
+		return fmt.Errorf("failed to store %s: %w", stagedID, err)
+	}
+	for _, view := range ddocViews(doc) {
+		rs := s.db.Query(ctx, stagedID, view, Param("limit", 0))
+		for rs.Next() {
+			// Nothing to do; we only wait for the index to be built.
+		}
+		if err := rs.Err(); err != nil {
+			return fmt.Errorf("failed to build index for %s/%s: %w", stagedID, view, err)
+		}
+	}
+	delete(current, stagedID)
+	return nil
+}
+
+// unstage removes the temporary design document created by stage.
+func (s *ddocSyncer) unstage(ctx context.Context, id string, options Option) error {
+	stagedID := id + stagedDDocSuffix
+	rev, err := s.db.GetRev(ctx, stagedID)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", stagedID, err)
+	}
+	if _, err := s.db.Delete(ctx, stagedID, rev, options); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", stagedID, err)
+	}
+	return nil
+}
+
+// normalizeDesignDocs converts each of the input design documents to a
+// map[string]interface{}, keyed by document ID.
+func normalizeDesignDocs(ddocs []interface{}) (map[string]map[string]interface{}, error) {
+	desired := make(map[string]map[string]interface{}, len(ddocs))
+	for _, ddoc := range ddocs {
+		i, err := normalizeFromJSON(ddoc)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(i)
+		if err != nil {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		id, _ := doc["_id"].(string)
+		if id == "" {
+			return nil, missingArg("_id")
+		}
+		if !strings.HasPrefix(id, "_design/") {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("kivik: %s is not a design document", id)}
+		}
+		if _, dupe := desired[id]; dupe {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("kivik: duplicate design document %s", id)}
+		}
+		delete(doc, "_rev")
+		desired[id] = doc
+	}
+	return desired, nil
+}
+
+// ddocEqual returns true if a and b are identical, ignoring special fields.
+func ddocEqual(a, b map[string]interface{}) bool {
+	return reflect.DeepEqual(withoutSpecialFields(a), withoutSpecialFields(b))
+}
+
+func withoutSpecialFields(doc map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if !strings.HasPrefix(k, "_") {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// withRev returns a shallow copy of doc, with _rev set to rev, if non-empty.
+func withRev(doc map[string]interface{}, rev string) map[string]interface{} {
+	result := make(map[string]interface{}, len(doc)+1)
+	for k, v := range doc {
+		result[k] = v
+	}
+	if rev != "" {
+		result["_rev"] = rev
+	}
+	return result
+}
+
+// ddocViews returns the sorted names of the views defined in doc.
+func ddocViews(doc map[string]interface{}) []string {
+	views, _ := doc["views"].(map[string]interface{})
+	names := make([]string, 0, len(views))
+	for name := range views {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
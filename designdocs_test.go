@@ -0,0 +1,326 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+// ddocStore is a minimal in-memory design document store, which records every
+// write operation made against it.
+type ddocStore struct {
+	docs map[string]map[string]interface{}
+	log  []string
+}
+
+func newDDocStore(docs ...map[string]interface{}) *ddocStore {
+	s := &ddocStore{docs: map[string]map[string]interface{}{}}
+	for _, doc := range docs {
+		id := doc["_id"].(string)
+		doc["_rev"] = "1-xxx"
+		s.docs[id] = doc
+	}
+	return s
+}
+
+func (s *ddocStore) nextRev(id string) string {
+	rev := 1
+	if cur, ok := s.docs[id]; ok {
+		rev, _ = strconv.Atoi(strings.SplitN(cur["_rev"].(string), "-", 2)[0])
+		rev++
+	}
+	return strconv.Itoa(rev) + "-xxx"
+}
+
+func (s *ddocStore) db() *DB {
+	return &DB{
+		client: &Client{},
+		driverDB: &mock.DesignDocer{
+			DB: &mock.DB{
+				GetFunc: func(_ context.Context, docID string, _ driver.Options) (*driver.Document, error) {
+					doc, ok := s.docs[docID]
+					if !ok {
+						return nil, &internal.Error{Status: http.StatusNotFound, Message: "missing"}
+					}
+					body, _ := json.Marshal(doc)
+					return &driver.Document{Rev: doc["_rev"].(string), Body: io.NopCloser(bytes.NewReader(body))}, nil
+				},
+				PutFunc: func(_ context.Context, docID string, doc interface{}, _ driver.Options) (string, error) {
+					var d map[string]interface{}
+					body, _ := json.Marshal(doc)
+					_ = json.Unmarshal(body, &d)
+					if cur, ok := s.docs[docID]; ok && cur["_rev"] != d["_rev"] {
+						return "", &internal.Error{Status: http.StatusConflict, Message: "conflict"}
+					}
+					d["_rev"] = s.nextRev(docID)
+					s.docs[docID] = d
+					s.log = append(s.log, "put "+docID)
+					return d["_rev"].(string), nil
+				},
+				DeleteFunc: func(_ context.Context, docID string, options driver.Options) (string, error) {
+					opts := map[string]interface{}{}
+					options.Apply(opts)
+					if cur, ok := s.docs[docID]; !ok || cur["_rev"] != opts["rev"] {
+						return "", &internal.Error{Status: http.StatusConflict, Message: "conflict"}
+					}
+					delete(s.docs, docID)
+					s.log = append(s.log, "delete "+docID)
+					return "2-xxx", nil
+				},
+				QueryFunc: func(_ context.Context, ddoc, view string, _ driver.Options) (driver.Rows, error) {
+					if _, ok := s.docs["_design/"+ddoc]; !ok {
+						return nil, &internal.Error{Status: http.StatusNotFound, Message: "missing"}
+					}
+					s.log = append(s.log, fmt.Sprintf("query _design/%s/%s", ddoc, view))
+					return &mock.Rows{}, nil
+				},
+				ViewCleanupFunc: func(context.Context) error {
+					s.log = append(s.log, "cleanup")
+					return nil
+				},
+			},
+			DesignDocsFunc: func(context.Context, driver.Options) (driver.Rows, error) {
+				ids := make([]string, 0, len(s.docs))
+				for id := range s.docs {
+					ids = append(ids, id)
+				}
+				sort.Strings(ids)
+				return &mock.Rows{
+					NextFunc: func(row *driver.Row) error {
+						if len(ids) == 0 {
+							return io.EOF
+						}
+						var id string
+						id, ids = ids[0], ids[1:]
+						body, _ := json.Marshal(s.docs[id])
+						row.ID = id
+						row.Doc = bytes.NewReader(body)
+						return nil
+					},
+				}, nil
+			},
+		},
+	}
+}
+
+func TestSyncDesignDocs(t *testing.T) {
+	type tt struct {
+		store    *ddocStore
+		db       *DB
+		ddocs    []interface{}
+		options  []Option
+		want     *SyncResult
+		wantLog  []string
+		wantDocs []string
+		status   int
+		err      string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("db error", tt{
+		db:     &DB{err: errors.New("db error")},
+		want:   &SyncResult{},
+		status: http.StatusInternalServerError,
+		err:    "db error",
+	})
+	tests.Add("missing id", tt{
+		store: newDDocStore(),
+		ddocs: []interface{}{
+			map[string]interface{}{"views": map[string]interface{}{}},
+		},
+		want:   &SyncResult{},
+		status: http.StatusBadRequest,
+		err:    "kivik: _id required",
+	})
+	tests.Add("not a design doc", tt{
+		store: newDDocStore(),
+		ddocs: []interface{}{
+			map[string]interface{}{"_id": "foo"},
+		},
+		want:   &SyncResult{},
+		status: http.StatusBadRequest,
+		err:    "kivik: foo is not a design document",
+	})
+	tests.Add("create, update and skip", tt{
+		store: newDDocStore(
+			map[string]interface{}{"_id": "_design/same", "language": "javascript"},
+			map[string]interface{}{"_id": "_design/changed", "language": "javascript"},
+			map[string]interface{}{"_id": "_design/stale"},
+		),
+		ddocs: []interface{}{
+			map[string]interface{}{"_id": "_design/same", "language": "javascript"},
+			json.RawMessage(`{"_id":"_design/changed","language":"query"}`),
+			map[string]interface{}{"_id": "_design/new"},
+		},
+		want: &SyncResult{
+			Created:   []string{"_design/new"},
+			Updated:   []string{"_design/changed"},
+			Unchanged: []string{"_design/same"},
+		},
+		wantLog:  []string{"put _design/changed", "put _design/new"},
+		wantDocs: []string{"_design/changed", "_design/new", "_design/same", "_design/stale"},
+	})
+	tests.Add("delete stale and cleanup", tt{
+		store: newDDocStore(
+			map[string]interface{}{"_id": "_design/same"},
+			map[string]interface{}{"_id": "_design/stale"},
+		),
+		ddocs: []interface{}{
+			map[string]interface{}{"_id": "_design/same"},
+		},
+		options: []Option{SyncDeleteStale(), SyncViewCleanup()},
+		want: &SyncResult{
+			Unchanged: []string{"_design/same"},
+			Deleted:   []string{"_design/stale"},
+		},
+		wantLog:  []string{"delete _design/stale", "cleanup"},
+		wantDocs: []string{"_design/same"},
+	})
+	tests.Add("no cleanup when nothing changed", tt{
+		store: newDDocStore(
+			map[string]interface{}{"_id": "_design/same"},
+		),
+		ddocs: []interface{}{
+			map[string]interface{}{"_id": "_design/same", "_rev": "1-ignored"},
+		},
+		options: []Option{SyncViewCleanup()},
+		want: &SyncResult{
+			Unchanged: []string{"_design/same"},
+		},
+		wantDocs: []string{"_design/same"},
+	})
+	tests.Add("staged", tt{
+		store: newDDocStore(
+			map[string]interface{}{"_id": "_design/foo", "views": map[string]interface{}{
+				"a": map[string]interface{}{"map": "function(doc) {}"},
+			}},
+		),
+		ddocs: []interface{}{
+			map[string]interface{}{"_id": "_design/foo", "views": map[string]interface{}{
+				"a": map[string]interface{}{"map": "function(doc) { emit(doc._id) }"},
+				"b": map[string]interface{}{"map": "function(doc) { emit(doc._id) }"},
+			}},
+			map[string]interface{}{"_id": "_design/noviews"},
+		},
+		options: []Option{SyncStaged()},
+		want: &SyncResult{
+			Created: []string{"_design/noviews"},
+			Updated: []string{"_design/foo"},
+		},
+		wantLog: []string{
+			"put _design/foo-kivik-staged",
+			"query _design/foo-kivik-staged/a",
+			"query _design/foo-kivik-staged/b",
+			"put _design/foo",
+			"delete _design/foo-kivik-staged",
+			"put _design/noviews",
+		},
+		wantDocs: []string{"_design/foo", "_design/noviews"},
+	})
+	tests.Add("staged, left-over temporary doc", tt{
+		store: newDDocStore(
+			map[string]interface{}{"_id": "_design/foo-kivik-staged"},
+		),
+		ddocs: []interface{}{
+			map[string]interface{}{"_id": "_design/foo", "views": map[string]interface{}{
+				"a": map[string]interface{}{"map": "function(doc) { emit(doc._id) }"},
+			}},
+		},
+		options: []Option{SyncStaged(), SyncDeleteStale()},
+		want: &SyncResult{
+			Created: []string{"_design/foo"},
+		},
+		wantLog: []string{
+			"put _design/foo-kivik-staged",
+			"query _design/foo-kivik-staged/a",
+			"put _design/foo",
+			"delete _design/foo-kivik-staged",
+		},
+		wantDocs: []string{"_design/foo"},
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		db := tt.db
+		if db == nil {
+			db = tt.store.db()
+		}
+		got, err := NewDesignDocSyncer(db, tt.options...).Sync(context.Background(), tt.ddocs...)
+		if d := internal.StatusErrorDiff(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+		if d := cmp.Diff(tt.want, got); d != "" {
+			t.Errorf("Unexpected result:\n%s", d)
+		}
+		if tt.store == nil {
+			return
+		}
+		if d := cmp.Diff(tt.wantLog, tt.store.log); d != "" {
+			t.Errorf("Unexpected operations:\n%s", d)
+		}
+		gotDocs := make([]string, 0, len(tt.store.docs))
+		for id := range tt.store.docs {
+			gotDocs = append(gotDocs, id)
+		}
+		sort.Strings(gotDocs)
+		if len(tt.wantDocs) == 0 {
+			tt.wantDocs = []string{}
+		}
+		if d := cmp.Diff(tt.wantDocs, gotDocs); d != "" {
+			t.Errorf("Unexpected documents:\n%s", d)
+		}
+	})
+}
+
+func TestSyncDesignDocsVariadic(t *testing.T) {
+	store := newDDocStore()
+	db := store.db()
+	got, err := SyncDesignDocs(context.Background(), db,
+		map[string]interface{}{"_id": "_design/a"},
+		map[string]interface{}{"_id": "_design/b"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(&SyncResult{Created: []string{"_design/a", "_design/b"}}, got); d != "" {
+		t.Errorf("Unexpected result:\n%s", d)
+	}
+
+	// A DesignDocSyncer may be reused, and reports each sync separately.
+	s := NewDesignDocSyncer(db, SyncDeleteStale())
+	for i := 0; i < 2; i++ {
+		got, err = s.Sync(context.Background(), map[string]interface{}{"_id": "_design/a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := cmp.Diff(&SyncResult{Unchanged: []string{"_design/a"}}, got); d != "" {
+		t.Errorf("Unexpected result of second sync:\n%s", d)
+	}
+}
@@ -296,6 +296,31 @@ type PurgeResult struct {
 	Purged map[string][]string `json:"purged"`
 }
 
+// RevsLimiter is an optional interface which may be implemented by a [DB] to
+// support getting and setting the maximum number of revisions tracked for each
+// document.
+type RevsLimiter interface {
+	// RevsLimit returns the maximum number of document revisions that will be
+	// tracked by the database.
+	RevsLimit(ctx context.Context) (int, error)
+	// SetRevsLimit sets the maximum number of document revisions that will be
+	// tracked by the database.
+	SetRevsLimit(ctx context.Context, limit int) error
+}
+
+// UpdateHandler is an optional interface which may be implemented by a [DB] to
+// support design document [update functions].
+//
+// [update functions]: https://docs.couchdb.org/en/stable/ddocs/ddocs.html#update-functions
+type UpdateHandler interface {
+	// UpdateHandler calls the update function funcName, defined in the design
+	// document ddoc, for the document docID, which may be empty. body is sent
+	// as the request body. It returns the ID and revision of the document
+	// saved by the update function, both empty if no document was saved, and
+	// the response body returned by the function.
+	UpdateHandler(ctx context.Context, ddoc, funcName, docID string, body interface{}, options Options) (id, rev string, respBody []byte, err error)
+}
+
 // BulkDocer is an optional interface which may be implemented by a [DB] to
 // support bulk insert/update operations. For any driver that does not support
 // the BulkDocer interface, the [DB.Put] or [DB.CreateDoc] methods will be
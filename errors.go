@@ -29,6 +29,7 @@ const (
 	errClusterNotImplemented     = internal.CompositeError("501 driver does not support cluster operations")
 	errOpenRevsNotImplemented    = internal.CompositeError("501 driver does not support OpenRevs interface")
 	errSecurityNotImplemented    = internal.CompositeError("501 driver does not support Security interface")
+	errRevsLimitNotImplemented   = internal.CompositeError("501 driver does not support RevsLimit interface")
 	errConfigNotImplemented      = internal.CompositeError("501 driver does not support Config interface")
 	errReplicationNotImplemented = internal.CompositeError("501 driver does not support replication")
 	errNoAttachments             = internal.CompositeError("404 no attachments")
@@ -0,0 +1,313 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	defaultFollowerHeartbeat       = 30 * time.Second
+	defaultFollowerCheckpointEvery = 100
+	defaultFollowerInitialInterval = 500 * time.Millisecond
+	defaultFollowerMaxInterval     = time.Minute
+)
+
+// Follower follows the changes feed of a database indefinitely, reconnecting
+// after errors, and resuming from the last processed sequence. Create one with
+// [NewFollower].
+//
+// A Follower provides at-least-once delivery: a change is considered
+// processed only once the handler passed to [Follower.Run] has returned
+// without error (or, when using [Follower.Iterator], once the next change has
+// been requested). After a reconnect or a restart, unprocessed changes are
+// delivered again.
+//
+// A Follower must not be run concurrently.
+type Follower struct {
+	db      *DB
+	options []Option
+
+	checkpoint      seqCheckpoint
+	checkpointEvery int
+	initialInterval time.Duration
+	maxInterval     time.Duration
+
+	mu  sync.Mutex
+	seq string
+}
+
+type followerCheckpointOption string
+
+var _ Option = followerCheckpointOption("")
+
+func (o followerCheckpointOption) Apply(target interface{}) {
+	if f, ok := target.(*Follower); ok {
+		f.checkpoint.id = string(o)
+	}
+}
+
+// FollowerCheckpoint instructs a [Follower] to persist the last processed
+// sequence in the document docID, and to resume from the sequence stored
+// there, if any, when started. A `_local` document ID is recommended, so that
+// the checkpoint is not replicated.
+func FollowerCheckpoint(docID string) Option {
+	return followerCheckpointOption(docID)
+}
+
+type followerCheckpointEveryOption int
+
+var _ Option = followerCheckpointEveryOption(0)
+
+func (o followerCheckpointEveryOption) Apply(target interface{}) {
+	if f, ok := target.(*Follower); ok && o > 0 {
+		f.checkpointEvery = int(o)
+	}
+}
+
+// FollowerCheckpointEvery sets the number of changes processed between
+// checkpoint writes. The checkpoint is also written whenever the feed is
+// interrupted, and when [Follower.Run] returns. The default is 100. This
+// option has no effect without [FollowerCheckpoint].
+func FollowerCheckpointEvery(n int) Option {
+	return followerCheckpointEveryOption(n)
+}
+
+type followerBackoffOption struct {
+	initial, max time.Duration
+}
+
+var _ Option = followerBackoffOption{}
+
+func (o followerBackoffOption) Apply(target interface{}) {
+	switch t := target.(type) {
+	case *Follower:
+		t.initialInterval = o.initial
+		t.maxInterval = o.max
+	case *Projector:
+		t.initialInterval = o.initial
+		t.maxInterval = o.max
+	}
+}
+
+// FollowerBackoff sets the exponential backoff used by a [Follower] or
+// [Projector] between reconnection attempts. The delay starts at initial, and doubles (with some
+// randomization) after each consecutive failure, up to max. The default is
+// 500ms, up to one minute.
+func FollowerBackoff(initial, max time.Duration) Option {
+	return followerBackoffOption{initial: initial, max: max}
+}
+
+// NewFollower returns a new [Follower] for db. This function supports the
+// [FollowerCheckpoint], [FollowerCheckpointEvery] and [FollowerBackoff]
+// options. All other options are passed to [DB.Changes] on each connection,
+// except that `feed` is always set to `continuous`, and `since` is set to the
+// last processed sequence once one is known. A `heartbeat` of 30 seconds is
+// requested by default, to keep idle connections alive.
+func NewFollower(db *DB, options ...Option) *Follower {
+	f := &Follower{
+		db:              db,
+		checkpoint:      seqCheckpoint{db: db},
+		checkpointEvery: defaultFollowerCheckpointEvery,
+		initialInterval: defaultFollowerInitialInterval,
+		maxInterval:     defaultFollowerMaxInterval,
+	}
+	multiOptions(options).Apply(f)
+	f.options = append([]Option{Duration("heartbeat", defaultFollowerHeartbeat)}, options...)
+	return f
+}
+
+// Seq returns the sequence of the last processed change.
+func (f *Follower) Seq() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seq
+}
+
+func (f *Follower) setSeq(seq string) {
+	f.mu.Lock()
+	f.seq = seq
+	f.mu.Unlock()
+}
+
+// errStopFollowing is used internally to stop [Follower.Iterator].
+var errStopFollowing = errors.New("stop following")
+
+// handlerError wraps an error returned by a [Follower] handler, so that it is
+// not retried.
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string { return e.err.Error() }
+func (e *handlerError) Unwrap() error { return e.err }
+
+// Run follows the changes feed, calling fn for each change, until ctx is
+// cancelled, or fn returns an error. Errors reading the feed are retried with
+// backoff, except for client errors (HTTP 4xx status, other than 408 and
+// 429), which are returned immediately. When the feed is closed cleanly by the
+// server, such as after the `timeout` period, it is re-opened immediately if
+// the sequence advanced, or else after the same backoff.
+//
+// The error returned by fn is returned unaltered, and the change for which it
+// was returned is not considered processed.
+func (f *Follower) Run(ctx context.Context, fn func(context.Context, *Change) error) (err error) {
+	if f.db.err != nil {
+		return f.db.err
+	}
+	if err := f.loadCheckpoint(ctx); err != nil {
+		return err
+	}
+	saved := f.Seq()
+	defer func() {
+		// Use a fresh context, so that the final checkpoint is still saved
+		// when ctx has been cancelled.
+		if cpErr := f.saveCheckpoint(context.Background(), saved); err == nil {
+			err = cpErr
+		}
+	}()
+
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = f.initialInterval
+	bo.MaxInterval = f.maxInterval
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+
+	for {
+		before := f.Seq()
+		err := f.follow(ctx, fn, bo, &saved)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var hErr *handlerError
+		if errors.As(err, &hErr) {
+			return hErr.err
+		}
+		if err != nil && !retriable(err) {
+			return err
+		}
+		if seq := f.Seq(); seq != saved {
+			if cpErr := f.saveCheckpoint(ctx, saved); cpErr == nil {
+				saved = seq
+			}
+		}
+		if err == nil && f.Seq() != before {
+			// The feed ended cleanly after advancing; reconnect immediately.
+			continue
+		}
+		// The feed failed, or ended without advancing the sequence, so wait
+		// before reconnecting, rather than polling a feed which returns
+		// immediately in a tight loop.
+		timer := time.NewTimer(bo.NextBackOff())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retriable returns true if err is worth retrying.
+func retriable(err error) bool {
+	switch status := HTTPStatus(err); {
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return true
+	case status >= 400 && status < 500:
+		return false
+	}
+	return true
+}
+
+// follow reads a single connection to the changes feed, until it is closed.
+func (f *Follower) follow(ctx context.Context, fn func(context.Context, *Change) error, bo backoff.BackOff, saved *string) error {
+	options := append(f.options, Param("feed", "continuous")) // nolint:gocritic
+	if seq := f.Seq(); seq != "" {
+		options = append(options, Param("since", seq))
+	}
+	changes := f.db.Changes(ctx, options...)
+	defer changes.Close() // nolint:errcheck
+
+	var processed int
+	for changes.Next() {
+		change := changes.current()
+		if err := fn(ctx, change); err != nil {
+			return &handlerError{err: err}
+		}
+		f.setSeq(change.Seq)
+		bo.Reset()
+		processed++
+		if f.checkpoint.id != "" && processed%f.checkpointEvery == 0 {
+			if err := f.saveCheckpoint(ctx, *saved); err != nil {
+				return err
+			}
+			*saved = change.Seq
+		}
+	}
+	if err := changes.Err(); err != nil {
+		return err
+	}
+	if meta, err := changes.Metadata(); err == nil && meta != nil && meta.LastSeq != "" {
+		f.setSeq(meta.LastSeq)
+	}
+	return nil
+}
+
+func (f *Follower) loadCheckpoint(ctx context.Context) error {
+	seq, err := f.checkpoint.load(ctx)
+	if err != nil {
+		return err
+	}
+	if seq != "" {
+		f.setSeq(seq)
+	}
+	return nil
+}
+
+// saveCheckpoint writes the current sequence to the checkpoint document, if
+// configured, and if it differs from saved.
+func (f *Follower) saveCheckpoint(ctx context.Context, saved string) error {
+	if seq := f.Seq(); seq != saved {
+		return f.checkpoint.save(ctx, seq)
+	}
+	return nil
+}
+
+// Iterator returns a function that can be used to follow the changes feed.
+// This function works with Go 1.23's range functions, and is an alternative
+// to [Follower.Run]. A change is considered processed when the next change is
+// requested; breaking out of the loop leaves the current change unprocessed,
+// so that it is delivered again the next time the Follower is run. Only
+// unrecoverable errors are yielded, after which iteration ends.
+//
+// !!NOTICE!! This function is considered experimental, and may change without
+// notice.
+func (f *Follower) Iterator(ctx context.Context) func(yield func(*Change, error) bool) {
+	return func(yield func(*Change, error) bool) {
+		err := f.Run(ctx, func(_ context.Context, change *Change) error {
+			if !yield(change, nil) {
+				return errStopFollowing
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopFollowing) {
+			yield(nil, err)
+		}
+	}
+}
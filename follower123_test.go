@@ -0,0 +1,52 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build go1.23
+// +build go1.23
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFollowerIterator(t *testing.T) {
+	t.Parallel()
+
+	feed := &fakeFeed{
+		ids:  []string{"a", "b", "c"},
+		fail: map[int]error{1: errors.New("connection reset")},
+	}
+	f := NewFollower(feed.db(), FollowerCheckpoint("_local/follow"))
+
+	ids := []string{}
+	for change, err := range f.Iterator(context.Background()) {
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		ids = append(ids, change.ID)
+		if change.ID == "c" {
+			break
+		}
+	}
+	if d := cmp.Diff([]string{"a", "b", "c"}, ids); d != "" {
+		t.Errorf("Unexpected changes: %s", d)
+	}
+	// The last change was not acknowledged, so it will be delivered again.
+	if seq := feed.checkpoint["seq"]; seq != "2" {
+		t.Errorf("Unexpected checkpoint: %v", seq)
+	}
+}
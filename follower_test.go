@@ -0,0 +1,299 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+// fakeFeed simulates a database with a fixed set of changes, numbered 1 to
+// len(ids), and a single checkpoint document.
+type fakeFeed struct {
+	ids []string
+	// fail maps a connection number (starting at 1) to the error returned
+	// after the first change of that connection.
+	fail map[int]error
+	// connections records the since value of each connection.
+	connections []string
+	// cancel, if set, is called when connection number cancelAt is opened.
+	cancelAt   int
+	cancel     func()
+	checkpoint map[string]interface{}
+}
+
+func (f *fakeFeed) db() *DB {
+	return &DB{
+		client: &Client{},
+		driverDB: &mock.DB{
+			ChangesFunc: func(ctx context.Context, options driver.Options) (driver.Changes, error) {
+				opts := map[string]interface{}{}
+				options.Apply(opts)
+				if opts["feed"] != "continuous" {
+					return nil, errors.New("feed must be continuous")
+				}
+				since, _ := opts["since"].(string)
+				f.connections = append(f.connections, since)
+				conn := len(f.connections)
+				if conn == f.cancelAt {
+					f.cancel()
+					return nil, ctx.Err()
+				}
+				next, _ := strconv.Atoi(since)
+				var sent int
+				return &mock.Changes{
+					NextFunc: func(ch *driver.Change) error {
+						if err := f.fail[conn]; err != nil && sent == 1 {
+							return err
+						}
+						if next >= len(f.ids) {
+							return io.EOF
+						}
+						ch.ID = f.ids[next]
+						next++
+						sent++
+						ch.Seq = strconv.Itoa(next)
+						return nil
+					},
+					LastSeqFunc: func() string { return strconv.Itoa(len(f.ids)) },
+				}, nil
+			},
+			GetFunc: func(context.Context, string, driver.Options) (*driver.Document, error) {
+				if f.checkpoint == nil {
+					return nil, &internal.Error{Status: http.StatusNotFound, Message: "missing"}
+				}
+				body, _ := json.Marshal(f.checkpoint)
+				return &driver.Document{Body: io.NopCloser(bytes.NewReader(body))}, nil
+			},
+			PutFunc: func(_ context.Context, _ string, doc interface{}, _ driver.Options) (string, error) {
+				var d map[string]interface{}
+				body, _ := json.Marshal(doc)
+				_ = json.Unmarshal(body, &d)
+				if f.checkpoint != nil && f.checkpoint["_rev"] != d["_rev"] {
+					return "", &internal.Error{Status: http.StatusConflict, Message: "conflict"}
+				}
+				d["_rev"] = "rev-" + d["seq"].(string)
+				f.checkpoint = d
+				return d["_rev"].(string), nil
+			},
+		},
+	}
+}
+
+func TestFollowerRun(t *testing.T) {
+	type tt struct {
+		feed *fakeFeed
+		db   *DB
+		// stopAt cancels the context after the change with this ID.
+		stopAt string
+		// stopAtConnection cancels the context when the given connection is
+		// opened.
+		stopAtConnection int
+		// failAt causes the handler to fail for the change with this ID.
+		failAt          string
+		options         []Option
+		wantIDs         []string
+		wantConnections []string
+		wantSeq         string
+		wantCheckpoint  string
+		status          int
+		err             string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("db error", tt{
+		db:     &DB{err: errors.New("db error")},
+		status: http.StatusInternalServerError,
+		err:    "db error",
+	})
+	tests.Add("reconnect after error and clean close", tt{
+		feed: &fakeFeed{
+			ids:  []string{"a", "b", "c", "d"},
+			fail: map[int]error{1: errors.New("connection reset")},
+		},
+		stopAtConnection: 3,
+		wantIDs:          []string{"a", "b", "c", "d"},
+		wantConnections:  []string{"", "1", "4"},
+		wantSeq:          "4",
+		status:           http.StatusInternalServerError,
+		err:              "context canceled",
+	})
+	tests.Add("checkpoint", tt{
+		feed: &fakeFeed{
+			ids: []string{"a", "b", "c"},
+		},
+		stopAt:          "c",
+		options:         []Option{FollowerCheckpoint("_local/follow")},
+		wantIDs:         []string{"a", "b", "c"},
+		wantConnections: []string{""},
+		wantSeq:         "3",
+		wantCheckpoint:  "3",
+		status:          http.StatusInternalServerError,
+		err:             "context canceled",
+	})
+	tests.Add("resume from checkpoint", tt{
+		feed: &fakeFeed{
+			ids:        []string{"a", "b", "c"},
+			checkpoint: map[string]interface{}{"_id": "_local/follow", "_rev": "rev-1", "seq": "1"},
+		},
+		stopAt:          "c",
+		options:         []Option{FollowerCheckpoint("_local/follow")},
+		wantIDs:         []string{"b", "c"},
+		wantConnections: []string{"1"},
+		wantSeq:         "3",
+		wantCheckpoint:  "3",
+		status:          http.StatusInternalServerError,
+		err:             "context canceled",
+	})
+	tests.Add("handler error", tt{
+		feed: &fakeFeed{
+			ids: []string{"a", "b", "c"},
+		},
+		failAt:          "b",
+		options:         []Option{FollowerCheckpoint("_local/follow")},
+		wantIDs:         []string{"a", "b"},
+		wantConnections: []string{""},
+		wantSeq:         "1",
+		wantCheckpoint:  "1",
+		status:          http.StatusInternalServerError,
+		err:             "handler failed",
+	})
+	tests.Add("client error is not retried", tt{
+		feed: &fakeFeed{
+			ids:  []string{"a", "b"},
+			fail: map[int]error{1: &internal.Error{Status: http.StatusUnauthorized, Message: "unauthorized"}},
+		},
+		wantIDs:         []string{"a"},
+		wantConnections: []string{""},
+		wantSeq:         "1",
+		status:          http.StatusUnauthorized,
+		err:             "unauthorized",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		db := tt.db
+		if db == nil {
+			db = tt.feed.db()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if tt.feed != nil {
+			tt.feed.cancelAt, tt.feed.cancel = tt.stopAtConnection, cancel
+		}
+		options := append([]Option{FollowerBackoff(time.Millisecond, time.Millisecond)}, tt.options...)
+		f := NewFollower(db, options...)
+		var ids []string
+		err := f.Run(ctx, func(_ context.Context, change *Change) error {
+			ids = append(ids, change.ID)
+			if change.ID == tt.failAt {
+				return errors.New("handler failed")
+			}
+			if change.ID == tt.stopAt {
+				cancel()
+			}
+			return nil
+		})
+		if d := internal.StatusErrorDiff(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+		if d := cmp.Diff(tt.wantIDs, ids); d != "" {
+			t.Errorf("Unexpected changes:\n%s", d)
+		}
+		if f.Seq() != tt.wantSeq {
+			t.Errorf("Unexpected seq: %q", f.Seq())
+		}
+		if tt.feed == nil {
+			return
+		}
+		if d := cmp.Diff(tt.wantConnections, tt.feed.connections); d != "" {
+			t.Errorf("Unexpected connections:\n%s", d)
+		}
+		var checkpoint string
+		if tt.feed.checkpoint != nil {
+			checkpoint, _ = tt.feed.checkpoint["seq"].(string)
+		}
+		if checkpoint != tt.wantCheckpoint {
+			t.Errorf("Unexpected checkpoint: %q", checkpoint)
+		}
+	})
+}
+
+func TestFollowerRunBacksOffWhenIdle(t *testing.T) {
+	// The fake feed closes immediately once caught up, without advancing the
+	// sequence.
+	feed := &fakeFeed{ids: []string{"a", "b"}}
+	db := feed.db()
+	var puts int
+	mockDB := db.driverDB.(*mock.DB)
+	put := mockDB.PutFunc
+	mockDB.PutFunc = func(ctx context.Context, docID string, doc interface{}, options driver.Options) (string, error) {
+		puts++
+		return put(ctx, docID, doc, options)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	f := NewFollower(db, FollowerCheckpoint("_local/follow"), FollowerBackoff(100*time.Millisecond, 100*time.Millisecond))
+	err := f.Run(ctx, func(context.Context, *Change) error {
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(feed.connections) < 2 || len(feed.connections) > 5 {
+		t.Errorf("Expected the idle feed to be re-opened with backoff, got %d connections", len(feed.connections))
+	}
+	if puts != 1 {
+		t.Errorf("Expected the checkpoint to be written once, got %d writes", puts)
+	}
+	if seq := feed.checkpoint["seq"]; seq != "2" {
+		t.Errorf("Unexpected checkpoint: %v", seq)
+	}
+}
+
+func TestFollowerCheckpointEvery(t *testing.T) {
+	feed := &fakeFeed{ids: []string{"a", "b", "c", "d", "e"}}
+	f := NewFollower(feed.db(), FollowerCheckpoint("_local/follow"), FollowerCheckpointEvery(2))
+	var checkpoints []string
+	err := f.Run(context.Background(), func(_ context.Context, change *Change) error {
+		seq, _ := feed.checkpoint["seq"].(string)
+		checkpoints = append(checkpoints, seq)
+		if change.ID == "e" {
+			return errors.New("stop")
+		}
+		return nil
+	})
+	if !testy.ErrorMatches("stop", err) {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	want := []string{"", "", "2", "2", "4"}
+	if d := cmp.Diff(want, checkpoints); d != "" {
+		t.Error(d)
+	}
+	if seq := feed.checkpoint["seq"]; seq != "4" {
+		t.Errorf("Unexpected final checkpoint: %v", seq)
+	}
+}
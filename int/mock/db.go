@@ -274,6 +274,38 @@ func (db *Purger) Purge(ctx context.Context, docMap map[string][]string) (*drive
 	return db.PurgeFunc(ctx, docMap)
 }
 
+// RevsLimiter mocks a driver.DB and driver.RevsLimiter
+type RevsLimiter struct {
+	*DB
+	RevsLimitFunc    func(context.Context) (int, error)
+	SetRevsLimitFunc func(context.Context, int) error
+}
+
+var _ driver.RevsLimiter = &RevsLimiter{}
+
+// RevsLimit calls db.RevsLimitFunc
+func (db *RevsLimiter) RevsLimit(ctx context.Context) (int, error) {
+	return db.RevsLimitFunc(ctx)
+}
+
+// SetRevsLimit calls db.SetRevsLimitFunc
+func (db *RevsLimiter) SetRevsLimit(ctx context.Context, limit int) error {
+	return db.SetRevsLimitFunc(ctx, limit)
+}
+
+// UpdateHandler mocks a driver.DB and driver.UpdateHandler
+type UpdateHandler struct {
+	*DB
+	UpdateHandlerFunc func(ctx context.Context, ddoc, funcName, docID string, body interface{}, options driver.Options) (string, string, []byte, error)
+}
+
+var _ driver.UpdateHandler = &UpdateHandler{}
+
+// UpdateHandler calls db.UpdateHandlerFunc
+func (db *UpdateHandler) UpdateHandler(ctx context.Context, ddoc, funcName, docID string, body interface{}, options driver.Options) (string, string, []byte, error) {
+	return db.UpdateHandlerFunc(ctx, ddoc, funcName, docID, body, options)
+}
+
 // BulkGetter mocks a driver.DB and driver.BulkGetter
 type BulkGetter struct {
 	*DB
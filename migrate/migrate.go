@@ -0,0 +1,373 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package migrate provides versioned document schema migrations.
+//
+// Migrations are Go functions, registered with a [Migrator] and keyed by a
+// version number. When [Migrator.Run] is called, each pending migration is
+// applied, in version order, to every matching document in the database. The
+// versions that have been applied are tracked in a `_local` document in the
+// database itself, along with a checkpoint of the migration in progress, so
+// that a migration interrupted by a crash can be resumed where it left off.
+//
+// A migration function may be called more than once for the same document (for
+// instance when the write conflicts, or when resuming after a crash), so it
+// must be idempotent.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+// Func is a single-document migration function. It receives the complete
+// document, including the `_id` and `_rev` fields, which it may modify in
+// place. It must return true if the document was modified and needs to be
+// written back to the database. To delete the document, set `_deleted` to
+// true.
+type Func func(ctx context.Context, doc map[string]interface{}) (changed bool, err error)
+
+// migration is a single registered migration.
+type migration struct {
+	version  int
+	name     string
+	selector interface{}
+	fn       Func
+}
+
+// Migrator holds a set of registered migrations. The zero value is not
+// usable; use [New] instead.
+type Migrator struct {
+	migrations map[int]*migration
+}
+
+// New returns a new, empty, Migrator.
+func New() *Migrator {
+	return &Migrator{
+		migrations: map[int]*migration{},
+	}
+}
+
+// Register registers fn as the migration for version, which must be a
+// positive integer, not already registered. This method supports the [Name]
+// and [Selector] options.
+func (m *Migrator) Register(version int, fn Func, options ...kivik.Option) error {
+	if version <= 0 {
+		return &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("migrate: invalid version %d", version)}
+	}
+	if fn == nil {
+		return &internal.Error{Status: http.StatusBadRequest, Message: "migrate: nil migration function"}
+	}
+	if _, ok := m.migrations[version]; ok {
+		return &internal.Error{Status: http.StatusConflict, Message: fmt.Sprintf("migrate: version %d already registered", version)}
+	}
+	mig := &migration{
+		version: version,
+		fn:      fn,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			opt.Apply(mig)
+		}
+	}
+	m.migrations[version] = mig
+	return nil
+}
+
+// versions returns the registered versions, in ascending order.
+func (m *Migrator) versions() []int {
+	versions := make([]int, 0, len(m.migrations))
+	for v := range m.migrations {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Pending returns the versions of registered migrations which have not yet
+// been applied to db, in the order in which they would be applied.
+func (m *Migrator) Pending(ctx context.Context, db *kivik.DB, options ...kivik.Option) ([]int, error) {
+	r := m.newRunner(db, options)
+	st, err := r.readState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.pending(st), nil
+}
+
+func (m *Migrator) pending(st *state) []int {
+	var pending []int
+	for _, v := range m.versions() {
+		if !st.isApplied(v) {
+			pending = append(pending, v)
+		}
+	}
+	return pending
+}
+
+// Report summarizes the work done by a single migration.
+type Report struct {
+	// Version is the migration version.
+	Version int
+	// Name is the migration name, as set with the [Name] option.
+	Name string
+	// Scanned is the number of documents passed to the migration function.
+	Scanned int
+	// Changed is the number of documents the migration function modified. In
+	// dry-run mode, these documents are not written.
+	Changed int
+	// Retries is the number of document writes which were retried, due to
+	// update conflicts.
+	Retries int
+	// Resumed is true if the migration was resumed from a checkpoint left
+	// by a previous, interrupted, run.
+	Resumed bool
+}
+
+// Run applies all pending migrations to db, in version order, and returns a
+// report for each one. This method supports the [DryRun], [BatchSize],
+// [MaxRetries] and [TrackingDoc] options.
+//
+// If a migration fails, Run returns immediately, with the reports gathered so
+// far. Calling Run again resumes the failed migration from the last
+// checkpoint.
+func (m *Migrator) Run(ctx context.Context, db *kivik.DB, options ...kivik.Option) ([]*Report, error) {
+	r := m.newRunner(db, options)
+	st, err := r.readState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	for _, v := range m.pending(st) {
+		report, err := r.apply(ctx, st, m.migrations[v])
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			return reports, fmt.Errorf("migration %d failed: %w", v, err)
+		}
+	}
+	return reports, nil
+}
+
+const (
+	defaultBatchSize   = 100
+	defaultMaxRetries  = 10
+	defaultTrackingDoc = "_local/kivik-migrate"
+)
+
+// runner manages a single call to Run or Pending.
+type runner struct {
+	db          *kivik.DB
+	dryRun      bool
+	batchSize   int
+	maxRetries  int
+	trackingDoc string
+}
+
+func (m *Migrator) newRunner(db *kivik.DB, options []kivik.Option) *runner {
+	r := &runner{
+		db:          db,
+		batchSize:   defaultBatchSize,
+		maxRetries:  defaultMaxRetries,
+		trackingDoc: defaultTrackingDoc,
+	}
+	for _, opt := range options {
+		if opt != nil {
+			opt.Apply(r)
+		}
+	}
+	return r
+}
+
+// apply runs a single migration to completion, checkpointing after each batch.
+func (r *runner) apply(ctx context.Context, st *state, mig *migration) (*Report, error) {
+	report := &Report{
+		Version: mig.version,
+		Name:    mig.name,
+	}
+	var lastID string
+	if cur := st.Current; cur != nil && cur.Version == mig.version {
+		lastID = cur.LastID
+		report.Resumed = true
+	}
+	for {
+		docs, err := r.nextBatch(ctx, mig, lastID)
+		if err != nil {
+			return report, err
+		}
+		if len(docs) == 0 {
+			break
+		}
+		if err := r.migrateBatch(ctx, mig, docs, report); err != nil {
+			return report, err
+		}
+		lastID, _ = docs[len(docs)-1]["_id"].(string)
+		if r.dryRun {
+			continue
+		}
+		st.Current = &progress{Version: mig.version, LastID: lastID}
+		if err := r.writeState(ctx, st); err != nil {
+			return report, err
+		}
+	}
+	if r.dryRun {
+		return report, nil
+	}
+	st.Current = nil
+	st.Applied = append(st.Applied, appliedMigration{
+		Version:   mig.version,
+		Name:      mig.name,
+		AppliedAt: time.Now().UTC(),
+	})
+	return report, r.writeState(ctx, st)
+}
+
+// nextBatch returns up to batchSize documents with IDs greater than lastID,
+// in ID order. Design documents are included, so that the last ID in the batch
+// can be used as the next checkpoint; they are skipped by migrateBatch.
+func (r *runner) nextBatch(ctx context.Context, mig *migration, lastID string) ([]map[string]interface{}, error) {
+	var rs *kivik.ResultSet
+	if mig.selector != nil {
+		selector := mig.selector
+		if lastID != "" {
+			selector = map[string]interface{}{
+				"$and": []interface{}{
+					selector,
+					map[string]interface{}{"_id": map[string]interface{}{"$gt": lastID}},
+				},
+			}
+		}
+		rs = r.db.Find(ctx, map[string]interface{}{
+			"selector": selector,
+			"sort":     []interface{}{map[string]string{"_id": "asc"}},
+			"limit":    r.batchSize,
+		})
+	} else {
+		params := map[string]interface{}{
+			"include_docs": true,
+			"limit":        r.batchSize,
+		}
+		if lastID != "" {
+			// startkey is inclusive, so fetch one extra row, to make up for
+			// lastID itself, which is dropped below.
+			params["startkey"] = lastID
+			params["limit"] = r.batchSize + 1
+		}
+		rs = r.db.AllDocs(ctx, kivik.Params(params))
+	}
+	defer rs.Close() // nolint:errcheck
+
+	docs := make([]map[string]interface{}, 0, r.batchSize)
+	for rs.Next() {
+		if id, _ := rs.ID(); id == lastID {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := rs.ScanDoc(&doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if err := rs.Err(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// migrateBatch passes each document in docs through the migration function,
+// and writes the changed documents back to the database, retrying on
+// conflict.
+func (r *runner) migrateBatch(ctx context.Context, mig *migration, docs []map[string]interface{}, report *Report) error {
+	changed := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		id, _ := doc["_id"].(string)
+		if strings.HasPrefix(id, "_design/") || strings.HasPrefix(id, "_local/") {
+			continue
+		}
+		report.Scanned++
+		ok, err := mig.fn(ctx, doc)
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", id, err)
+		}
+		if ok {
+			report.Changed++
+			changed = append(changed, doc)
+		}
+	}
+	if r.dryRun || len(changed) == 0 {
+		return nil
+	}
+	for attempt := 0; ; attempt++ {
+		results, err := r.db.BulkDocs(ctx, changed)
+		if err != nil {
+			return err
+		}
+		var conflicts []string
+		for _, result := range results {
+			switch {
+			case result.Error == nil:
+			case kivik.HTTPStatus(result.Error) == http.StatusConflict:
+				conflicts = append(conflicts, result.ID)
+			default:
+				return fmt.Errorf("failed to write %s: %w", result.ID, result.Error)
+			}
+		}
+		if len(conflicts) == 0 {
+			return nil
+		}
+		if attempt >= r.maxRetries {
+			return &internal.Error{Status: http.StatusConflict, Message: fmt.Sprintf("migrate: giving up after %d retries: %s", r.maxRetries, strings.Join(conflicts, ", "))}
+		}
+		report.Retries += len(conflicts)
+		changed, err = r.refetch(ctx, mig, conflicts)
+		if err != nil {
+			return err
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+	}
+}
+
+// refetch re-reads the current version of each of the conflicting documents,
+// and passes them through the migration function once more. Documents which
+// no longer need changes, or which have since been deleted, are omitted from
+// the result.
+func (r *runner) refetch(ctx context.Context, mig *migration, ids []string) ([]interface{}, error) {
+	docs := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		var doc map[string]interface{}
+		err := r.db.Get(ctx, id).ScanDoc(&doc)
+		if kivik.HTTPStatus(err) == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ok, err := mig.fn(ctx, doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate %s: %w", id, err)
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
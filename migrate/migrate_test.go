@@ -0,0 +1,489 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+// memStore is a minimal in-memory document store.
+type memStore struct {
+	docs map[string]map[string]interface{}
+	// beforePut, if set, is called before each write.
+	beforePut func(s *memStore, docID string)
+	// failAfter, if positive, causes the given write to fail.
+	failAfter int
+	writes    int
+}
+
+func newMemStore(docs ...map[string]interface{}) *memStore {
+	s := &memStore{docs: map[string]map[string]interface{}{}}
+	for _, doc := range docs {
+		doc["_rev"] = "1-xxx"
+		s.docs[doc["_id"].(string)] = doc
+	}
+	return s
+}
+
+func (s *memStore) ids() []string {
+	ids := make([]string, 0, len(s.docs))
+	for id := range s.docs {
+		if !strings.HasPrefix(id, "_local/") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *memStore) rows(ids []string) driver.Rows {
+	return &mock.Rows{
+		NextFunc: func(row *driver.Row) error {
+			if len(ids) == 0 {
+				return io.EOF
+			}
+			var id string
+			id, ids = ids[0], ids[1:]
+			body, _ := json.Marshal(s.docs[id])
+			row.ID = id
+			row.Doc = bytes.NewReader(body)
+			return nil
+		},
+	}
+}
+
+func (s *memStore) db() driver.DB {
+	return &mock.Finder{
+		DB: &mock.DB{
+			AllDocsFunc: func(_ context.Context, options driver.Options) (driver.Rows, error) {
+				opts := map[string]interface{}{}
+				options.Apply(opts)
+				startKey, _ := opts["startkey"].(string)
+				limit, _ := opts["limit"].(int)
+				ids := make([]string, 0)
+				for _, id := range s.ids() {
+					if id >= startKey && (limit == 0 || len(ids) < limit) {
+						ids = append(ids, id)
+					}
+				}
+				return s.rows(ids), nil
+			},
+			GetFunc: func(_ context.Context, docID string, _ driver.Options) (*driver.Document, error) {
+				doc, ok := s.docs[docID]
+				if !ok {
+					return nil, &internal.Error{Status: http.StatusNotFound, Message: "missing"}
+				}
+				body, _ := json.Marshal(doc)
+				return &driver.Document{Rev: doc["_rev"].(string), Body: io.NopCloser(bytes.NewReader(body))}, nil
+			},
+			PutFunc: func(_ context.Context, docID string, doc interface{}, _ driver.Options) (string, error) {
+				s.writes++
+				if s.failAfter > 0 && s.writes >= s.failAfter {
+					return "", errors.New("write failed")
+				}
+				if s.beforePut != nil {
+					s.beforePut(s, docID)
+				}
+				var d map[string]interface{}
+				body, _ := json.Marshal(doc)
+				_ = json.Unmarshal(body, &d)
+				rev := 1
+				if cur, ok := s.docs[docID]; ok {
+					if cur["_rev"] != d["_rev"] {
+						return "", &internal.Error{Status: http.StatusConflict, Message: "conflict"}
+					}
+					rev, _ = strconv.Atoi(strings.SplitN(cur["_rev"].(string), "-", 2)[0])
+					rev++
+				}
+				d["_rev"] = strconv.Itoa(rev) + "-xxx"
+				s.docs[docID] = d
+				return d["_rev"].(string), nil
+			},
+		},
+		FindFunc: func(_ context.Context, query interface{}, _ driver.Options) (driver.Rows, error) {
+			var q struct {
+				Selector map[string]interface{} `json:"selector"`
+				Limit    int                    `json:"limit"`
+			}
+			body, _ := json.Marshal(query)
+			_ = json.Unmarshal(body, &q)
+			selector, after := q.Selector, ""
+			if and, ok := selector["$and"].([]interface{}); ok {
+				selector = and[0].(map[string]interface{})
+				after = and[1].(map[string]interface{})["_id"].(map[string]interface{})["$gt"].(string)
+			}
+			ids := make([]string, 0)
+			for _, id := range s.ids() {
+				if id <= after || len(ids) == q.Limit {
+					continue
+				}
+				match := true
+				for k, v := range selector {
+					if s.docs[id][k] != v {
+						match = false
+					}
+				}
+				if match {
+					ids = append(ids, id)
+				}
+			}
+			return s.rows(ids), nil
+		},
+	}
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]*memStore{}
+)
+
+func init() {
+	kivik.Register("migrate-test", &mock.Driver{
+		NewClientFunc: func(string, driver.Options) (driver.Client, error) {
+			return &mock.Client{
+				DBFunc: func(name string, _ driver.Options) (driver.DB, error) {
+					storesMu.Lock()
+					defer storesMu.Unlock()
+					return stores[name].db(), nil
+				},
+			}, nil
+		},
+	})
+}
+
+func openDB(t *testing.T, s *memStore) *kivik.DB {
+	t.Helper()
+	storesMu.Lock()
+	stores[t.Name()] = s
+	storesMu.Unlock()
+	client, err := kivik.New("migrate-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.DB(t.Name())
+}
+
+// setField returns a migration function which sets key to value, if it is
+// not already set.
+func setField(key string, value interface{}) Func {
+	return func(_ context.Context, doc map[string]interface{}) (bool, error) {
+		if doc[key] == value {
+			return false, nil
+		}
+		doc[key] = value
+		return true, nil
+	}
+}
+
+func TestRegister(t *testing.T) {
+	m := New()
+	noop := func(context.Context, map[string]interface{}) (bool, error) { return false, nil }
+	if err := m.Register(1, noop); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Register(1, noop)
+	if d := internal.StatusErrorDiff("migrate: version 1 already registered", http.StatusConflict, err); d != "" {
+		t.Error(d)
+	}
+	err = m.Register(0, noop)
+	if d := internal.StatusErrorDiff("migrate: invalid version 0", http.StatusBadRequest, err); d != "" {
+		t.Error(d)
+	}
+	err = m.Register(2, nil)
+	if d := internal.StatusErrorDiff("migrate: nil migration function", http.StatusBadRequest, err); d != "" {
+		t.Error(d)
+	}
+}
+
+func TestRun(t *testing.T) {
+	type migrationDef struct {
+		version int
+		fn      Func
+		options []kivik.Option
+	}
+	type tt struct {
+		store       *memStore
+		migrations  []migrationDef
+		options     []kivik.Option
+		want        []*Report
+		wantDocs    map[string]map[string]interface{}
+		wantApplied []int
+		wantCurrent *progress
+		err         string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("no migrations", tt{
+		store:    newMemStore(),
+		wantDocs: map[string]map[string]interface{}{},
+	})
+	tests.Add("all docs, in batches", tt{
+		store: newMemStore(
+			map[string]interface{}{"_id": "a"},
+			map[string]interface{}{"_id": "b", "v": 2.0},
+			map[string]interface{}{"_id": "c"},
+			map[string]interface{}{"_id": "_design/foo"},
+		),
+		migrations: []migrationDef{
+			{version: 2, fn: setField("v", 2.0), options: []kivik.Option{Name("set v")}},
+			{version: 1, fn: setField("w", 1.0)},
+		},
+		options: []kivik.Option{BatchSize(2)},
+		want: []*Report{
+			{Version: 1, Scanned: 3, Changed: 3},
+			{Version: 2, Name: "set v", Scanned: 3, Changed: 2},
+		},
+		wantDocs: map[string]map[string]interface{}{
+			"a":           {"_id": "a", "_rev": "3-xxx", "v": 2.0, "w": 1.0},
+			"b":           {"_id": "b", "_rev": "2-xxx", "v": 2.0, "w": 1.0},
+			"c":           {"_id": "c", "_rev": "3-xxx", "v": 2.0, "w": 1.0},
+			"_design/foo": {"_id": "_design/foo", "_rev": "1-xxx"},
+		},
+		wantApplied: []int{1, 2},
+	})
+	tests.Add("selector", tt{
+		store: newMemStore(
+			map[string]interface{}{"_id": "a", "type": "user"},
+			map[string]interface{}{"_id": "b", "type": "post"},
+			map[string]interface{}{"_id": "c", "type": "user"},
+		),
+		migrations: []migrationDef{
+			{version: 1, fn: setField("v", 1.0), options: []kivik.Option{Selector(map[string]interface{}{"type": "user"})}},
+		},
+		options: []kivik.Option{BatchSize(1)},
+		want: []*Report{
+			{Version: 1, Scanned: 2, Changed: 2},
+		},
+		wantDocs: map[string]map[string]interface{}{
+			"a": {"_id": "a", "_rev": "2-xxx", "type": "user", "v": 1.0},
+			"b": {"_id": "b", "_rev": "1-xxx", "type": "post"},
+			"c": {"_id": "c", "_rev": "2-xxx", "type": "user", "v": 1.0},
+		},
+		wantApplied: []int{1},
+	})
+	tests.Add("already applied", func() interface{} {
+		s := newMemStore(map[string]interface{}{"_id": "a"})
+		s.docs[defaultTrackingDoc] = map[string]interface{}{
+			"_id":     defaultTrackingDoc,
+			"_rev":    "1-xxx",
+			"applied": []interface{}{map[string]interface{}{"version": 1, "applied_at": "2020-01-01T00:00:00Z"}},
+		}
+		return tt{
+			store: s,
+			migrations: []migrationDef{
+				{version: 1, fn: setField("v", 1.0)},
+				{version: 2, fn: setField("w", 2.0)},
+			},
+			want: []*Report{
+				{Version: 2, Scanned: 1, Changed: 1},
+			},
+			wantDocs: map[string]map[string]interface{}{
+				"a": {"_id": "a", "_rev": "2-xxx", "w": 2.0},
+			},
+			wantApplied: []int{1, 2},
+		}
+	})
+	tests.Add("resume from checkpoint", func() interface{} {
+		s := newMemStore(
+			map[string]interface{}{"_id": "a"},
+			map[string]interface{}{"_id": "b"},
+		)
+		s.docs[defaultTrackingDoc] = map[string]interface{}{
+			"_id":     defaultTrackingDoc,
+			"_rev":    "1-xxx",
+			"current": map[string]interface{}{"version": 1, "last_id": "a"},
+		}
+		return tt{
+			store: s,
+			migrations: []migrationDef{
+				{version: 1, fn: setField("v", 1.0)},
+			},
+			want: []*Report{
+				{Version: 1, Scanned: 1, Changed: 1, Resumed: true},
+			},
+			wantDocs: map[string]map[string]interface{}{
+				"a": {"_id": "a", "_rev": "1-xxx"},
+				"b": {"_id": "b", "_rev": "2-xxx", "v": 1.0},
+			},
+			wantApplied: []int{1},
+		}
+	})
+	tests.Add("dry run", tt{
+		store: newMemStore(
+			map[string]interface{}{"_id": "a"},
+			map[string]interface{}{"_id": "b", "v": 1.0},
+		),
+		migrations: []migrationDef{
+			{version: 1, fn: setField("v", 1.0)},
+		},
+		options: []kivik.Option{DryRun()},
+		want: []*Report{
+			{Version: 1, Scanned: 2, Changed: 1},
+		},
+		wantDocs: map[string]map[string]interface{}{
+			"a": {"_id": "a", "_rev": "1-xxx"},
+			"b": {"_id": "b", "_rev": "1-xxx", "v": 1.0},
+		},
+	})
+	tests.Add("conflict is retried", func() interface{} {
+		s := newMemStore(
+			map[string]interface{}{"_id": "a", "n": 1.0},
+		)
+		conflicted := false
+		s.beforePut = func(s *memStore, docID string) {
+			if docID == "a" && !conflicted {
+				conflicted = true
+				s.docs["a"] = map[string]interface{}{"_id": "a", "_rev": "2-xxx", "n": 2.0}
+			}
+		}
+		return tt{
+			store: s,
+			migrations: []migrationDef{
+				{version: 1, fn: setField("v", 1.0)},
+			},
+			want: []*Report{
+				{Version: 1, Scanned: 1, Changed: 1, Retries: 1},
+			},
+			wantDocs: map[string]map[string]interface{}{
+				"a": {"_id": "a", "_rev": "3-xxx", "n": 2.0, "v": 1.0},
+			},
+			wantApplied: []int{1},
+		}
+	})
+	tests.Add("migration error", tt{
+		store: newMemStore(
+			map[string]interface{}{"_id": "a"},
+		),
+		migrations: []migrationDef{
+			{version: 1, fn: func(context.Context, map[string]interface{}) (bool, error) {
+				return false, errors.New("bad doc")
+			}},
+		},
+		want: []*Report{
+			{Version: 1, Scanned: 1},
+		},
+		wantDocs: map[string]map[string]interface{}{
+			"a": {"_id": "a", "_rev": "1-xxx"},
+		},
+		err: "migration 1 failed: failed to migrate a: bad doc",
+	})
+	tests.Add("crash leaves checkpoint", func() interface{} {
+		s := newMemStore(
+			map[string]interface{}{"_id": "a"},
+			map[string]interface{}{"_id": "b"},
+		)
+		// Writes: a, checkpoint, b (fails)
+		s.failAfter = 3
+		return tt{
+			store: s,
+			migrations: []migrationDef{
+				{version: 1, fn: setField("v", 1.0)},
+			},
+			options: []kivik.Option{BatchSize(1)},
+			want: []*Report{
+				{Version: 1, Scanned: 2, Changed: 2},
+			},
+			wantDocs: map[string]map[string]interface{}{
+				"a": {"_id": "a", "_rev": "2-xxx", "v": 1.0},
+				"b": {"_id": "b", "_rev": "1-xxx"},
+			},
+			wantCurrent: &progress{Version: 1, LastID: "a"},
+			err:         "migration 1 failed: failed to write b: write failed",
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		db := openDB(t, tt.store)
+		m := New()
+		for _, mig := range tt.migrations {
+			if err := m.Register(mig.version, mig.fn, mig.options...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := m.Run(context.Background(), db, tt.options...)
+		if !testy.ErrorMatches(tt.err, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if d := cmp.Diff(tt.want, got); d != "" {
+			t.Errorf("Unexpected reports:\n%s", d)
+		}
+		tracking, hasTracking := tt.store.docs[defaultTrackingDoc]
+		delete(tt.store.docs, defaultTrackingDoc)
+		if d := cmp.Diff(tt.wantDocs, tt.store.docs); d != "" {
+			t.Errorf("Unexpected documents:\n%s", d)
+		}
+		if !hasTracking {
+			if tt.wantApplied != nil || tt.wantCurrent != nil {
+				t.Error("Tracking document not written")
+			}
+			return
+		}
+		var st state
+		body, _ := json.Marshal(tracking)
+		if err := json.Unmarshal(body, &st); err != nil {
+			t.Fatal(err)
+		}
+		var applied []int
+		for _, a := range st.Applied {
+			applied = append(applied, a.Version)
+		}
+		if d := cmp.Diff(tt.wantApplied, applied); d != "" {
+			t.Errorf("Unexpected applied versions:\n%s", d)
+		}
+		if d := cmp.Diff(tt.wantCurrent, st.Current); d != "" {
+			t.Errorf("Unexpected checkpoint:\n%s", d)
+		}
+	})
+}
+
+func TestPending(t *testing.T) {
+	s := newMemStore()
+	s.docs["_local/custom"] = map[string]interface{}{
+		"_id":     "_local/custom",
+		"_rev":    "1-xxx",
+		"applied": []interface{}{map[string]interface{}{"version": 2, "applied_at": "2020-01-01T00:00:00Z"}},
+	}
+	db := openDB(t, s)
+	m := New()
+	noop := func(context.Context, map[string]interface{}) (bool, error) { return false, nil }
+	for _, v := range []int{3, 1, 2} {
+		if err := m.Register(v, noop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := m.Pending(context.Background(), db, TrackingDoc("_local/custom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]int{1, 3}, got); d != "" {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,128 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package migrate
+
+import (
+	"github.com/go-kivik/kivik/v4"
+)
+
+type nameOption string
+
+var _ kivik.Option = nameOption("")
+
+func (o nameOption) Apply(target interface{}) {
+	if m, ok := target.(*migration); ok {
+		m.name = string(o)
+	}
+}
+
+// Name sets a human-readable name for a migration, which is recorded in the
+// tracking document, and included in the migration's [Report]. This option is
+// supported by [Migrator.Register].
+func Name(name string) kivik.Option {
+	return nameOption(name)
+}
+
+type selectorOption struct {
+	selector interface{}
+}
+
+var _ kivik.Option = selectorOption{}
+
+func (o selectorOption) Apply(target interface{}) {
+	if m, ok := target.(*migration); ok {
+		m.selector = o.selector
+	}
+}
+
+// Selector restricts a migration to the documents matching the Mango selector,
+// which may be any value that marshals to a JSON object. Matching documents are
+// read with [kivik.DB.Find], so the database must support Mango queries.
+// Without this option, every document returned by [kivik.DB.AllDocs] is passed
+// to the migration function. This option is supported by [Migrator.Register].
+func Selector(selector interface{}) kivik.Option {
+	return selectorOption{selector: selector}
+}
+
+type dryRunOption struct{}
+
+var _ kivik.Option = dryRunOption{}
+
+func (dryRunOption) Apply(target interface{}) {
+	if r, ok := target.(*runner); ok {
+		r.dryRun = true
+	}
+}
+
+// DryRun instructs [Migrator.Run] to pass each document through the pending
+// migration functions, and report the number of documents that would change,
+// without writing anything to the database.
+//
+// Because nothing is written, each migration function sees the documents as
+// they are currently stored, and not as they would be after earlier pending
+// migrations have been applied.
+func DryRun() kivik.Option {
+	return dryRunOption{}
+}
+
+type batchSizeOption int
+
+var _ kivik.Option = batchSizeOption(0)
+
+func (o batchSizeOption) Apply(target interface{}) {
+	if r, ok := target.(*runner); ok && o > 0 {
+		r.batchSize = int(o)
+	}
+}
+
+// BatchSize sets the number of documents read, migrated and written at a
+// time, and thus the interval at which progress is checkpointed. The default
+// is 100. This option is supported by [Migrator.Run].
+func BatchSize(size int) kivik.Option {
+	return batchSizeOption(size)
+}
+
+type maxRetriesOption int
+
+var _ kivik.Option = maxRetriesOption(0)
+
+func (o maxRetriesOption) Apply(target interface{}) {
+	if r, ok := target.(*runner); ok && o >= 0 {
+		r.maxRetries = int(o)
+	}
+}
+
+// MaxRetries sets the number of times a batch of conflicting writes is
+// re-read, re-migrated, and retried, before giving up. The default is 10. This
+// option is supported by [Migrator.Run].
+func MaxRetries(retries int) kivik.Option {
+	return maxRetriesOption(retries)
+}
+
+type trackingDocOption string
+
+var _ kivik.Option = trackingDocOption("")
+
+func (o trackingDocOption) Apply(target interface{}) {
+	if r, ok := target.(*runner); ok && o != "" {
+		r.trackingDoc = string(o)
+	}
+}
+
+// TrackingDoc sets the ID of the document used to track applied migrations.
+// The default is "_local/kivik-migrate". Local documents are not replicated,
+// so each replica tracks its own migrations. This option is supported by
+// [Migrator.Run] and [Migrator.Pending].
+func TrackingDoc(docID string) kivik.Option {
+	return trackingDocOption(docID)
+}
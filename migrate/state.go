@@ -0,0 +1,76 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package migrate
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+// state is the content of the tracking document.
+type state struct {
+	ID      string             `json:"_id"`
+	Rev     string             `json:"_rev,omitempty"`
+	Applied []appliedMigration `json:"applied"`
+	Current *progress          `json:"current,omitempty"`
+}
+
+// appliedMigration records a single completed migration.
+type appliedMigration struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name,omitempty"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// progress is the checkpoint of a migration in progress.
+type progress struct {
+	Version int    `json:"version"`
+	LastID  string `json:"last_id"`
+}
+
+func (s *state) isApplied(version int) bool {
+	for _, a := range s.Applied {
+		if a.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// readState reads the tracking document. A missing tracking document is
+// treated as an empty state.
+func (r *runner) readState(ctx context.Context) (*state, error) {
+	st := &state{}
+	err := r.db.Get(ctx, r.trackingDoc).ScanDoc(st)
+	if kivik.HTTPStatus(err) == http.StatusNotFound {
+		return &state{ID: r.trackingDoc}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// writeState stores st in the tracking document, and updates its revision.
+func (r *runner) writeState(ctx context.Context, st *state) error {
+	st.ID = r.trackingDoc
+	rev, err := r.db.Put(ctx, r.trackingDoc, st)
+	if err != nil {
+		return err
+	}
+	st.Rev = rev
+	return nil
+}
@@ -163,6 +163,37 @@ func (db *driverDB) Put(ctx context.Context, arg0 string, arg1 interface{}, opti
 	return expected.ret0, expected.wait(ctx)
 }
 
+func (db *driverDB) RevsLimit(ctx context.Context) (int, error) {
+	expected := &ExpectedRevsLimit{
+		commonExpectation: commonExpectation{
+			db: db.DB,
+		},
+	}
+	if err := db.client.nextExpectation(expected); err != nil {
+		return 0, err
+	}
+	if expected.callback != nil {
+		return expected.callback(ctx)
+	}
+	return expected.ret0, expected.wait(ctx)
+}
+
+func (db *driverDB) SetRevsLimit(ctx context.Context, arg0 int) error {
+	expected := &ExpectedSetRevsLimit{
+		arg0: arg0,
+		commonExpectation: commonExpectation{
+			db: db.DB,
+		},
+	}
+	if err := db.client.nextExpectation(expected); err != nil {
+		return err
+	}
+	if expected.callback != nil {
+		return expected.callback(ctx, arg0)
+	}
+	return expected.wait(ctx)
+}
+
 func (db *driverDB) ViewCleanup(ctx context.Context) error {
 	expected := &ExpectedViewCleanup{
 		commonExpectation: commonExpectation{
@@ -561,3 +592,23 @@ func (db *driverDB) Stats(ctx context.Context) (*driver.DBStats, error) {
 	}
 	return expected.ret0, expected.wait(ctx)
 }
+
+func (db *driverDB) UpdateHandler(ctx context.Context, arg0 string, arg1 string, arg2 string, arg3 interface{}, options driver.Options) (string, string, []uint8, error) {
+	expected := &ExpectedUpdateHandler{
+		arg0: arg0,
+		arg1: arg1,
+		arg2: arg2,
+		arg3: arg3,
+		commonExpectation: commonExpectation{
+			db:      db.DB,
+			options: options,
+		},
+	}
+	if err := db.client.nextExpectation(expected); err != nil {
+		return "", "", nil, err
+	}
+	if expected.callback != nil {
+		return expected.callback(ctx, arg0, arg1, arg2, arg3, options)
+	}
+	return expected.ret0, expected.ret1, expected.ret2, expected.wait(ctx)
+}
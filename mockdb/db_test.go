@@ -2091,6 +2091,57 @@ func TestLocalDocs(t *testing.T) {
 	tests.Run(t, testMock)
 }
 
+func TestUpdateHandler(t *testing.T) {
+	tests := testy.NewTable()
+	tests.Add("error", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectUpdateHandler().WillReturnError(errors.New("foo err"))
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			_, err := db.UpdateHandler(context.TODO(), "ddoc", "func", "", nil)
+			if !testy.ErrorMatches("foo err", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		},
+	})
+	tests.Add("success", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectUpdateHandler().WithDDoc("ddoc").WithFuncName("func").WillReturn("doc", "1-xxx", []byte("ok"))
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			result, err := db.UpdateHandler(context.TODO(), "ddoc", "func", "doc", nil)
+			if !testy.ErrorMatches("", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+			if result.Rev != "1-xxx" || string(result.Body) != "ok" {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+		},
+	})
+	tests.Add("wrong function", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectUpdateHandler().WithFuncName("bar")
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			_, err := db.UpdateHandler(context.TODO(), "ddoc", "func", "", nil)
+			if !testy.ErrorMatchesRE("has funcName: bar", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		},
+		err: "there is a remaining unmet expectation",
+	})
+	tests.Run(t, testMock)
+}
+
 func TestPurge(t *testing.T) {
 	tests := testy.NewTable()
 	tests.Add("error", mockTest{
@@ -2546,6 +2597,104 @@ func TestSetSecurity(t *testing.T) {
 	tests.Run(t, testMock)
 }
 
+func TestRevsLimit(t *testing.T) {
+	tests := testy.NewTable()
+	tests.Add("error", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectRevsLimit().WillReturnError(errors.New("foo err"))
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			_, err := db.RevsLimit(context.TODO())
+			if !testy.ErrorMatches("foo err", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		},
+	})
+	tests.Add("success", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectRevsLimit().WillReturn(123)
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			limit, err := db.RevsLimit(context.TODO())
+			if !testy.ErrorMatches("", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+			if limit != 123 {
+				t.Errorf("Unexpected limit: %d", limit)
+			}
+		},
+	})
+	tests.Add("delay", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectRevsLimit().WillDelay(time.Second)
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			_, err := db.RevsLimit(newCanceledContext())
+			if !testy.ErrorMatches("context canceled", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		},
+	})
+	tests.Run(t, testMock)
+}
+
+func TestSetRevsLimit(t *testing.T) {
+	tests := testy.NewTable()
+	tests.Add("error", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectSetRevsLimit().WillReturnError(errors.New("foo err"))
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			err := db.SetRevsLimit(context.TODO(), 10)
+			if !testy.ErrorMatches("foo err", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		},
+	})
+	tests.Add("success", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectSetRevsLimit().WithLimit(10)
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			err := db.SetRevsLimit(context.TODO(), 10)
+			if !testy.ErrorMatches("", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		},
+	})
+	tests.Add("wrong limit", mockTest{
+		setup: func(m *Client) {
+			db := m.NewDB()
+			m.ExpectDB().WillReturn(db)
+			db.ExpectSetRevsLimit().WithLimit(10)
+		},
+		test: func(t *testing.T, c *kivik.Client) {
+			db := c.DB("foo")
+			err := db.SetRevsLimit(context.TODO(), 20)
+			if !testy.ErrorMatchesRE("has limit: 10", err) {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		},
+		err: "there is a remaining unmet expectation",
+	})
+	tests.Run(t, testMock)
+}
+
 func TestStats(t *testing.T) {
 	tests := testy.NewTable()
 	tests.Add("error", mockTest{
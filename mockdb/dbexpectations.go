@@ -517,6 +517,63 @@ func (e *ExpectedPurge) WithDocRevMap(docRevMap map[string][]string) *ExpectedPu
 	return e
 }
 
+func (e *ExpectedUpdateHandler) String() string {
+	var opts, rets []string
+	if e.arg0 == "" {
+		opts = append(opts, "has any ddoc")
+	} else {
+		opts = append(opts, "has ddoc: "+e.arg0)
+	}
+	if e.arg1 == "" {
+		opts = append(opts, "has any funcName")
+	} else {
+		opts = append(opts, "has funcName: "+e.arg1)
+	}
+	if e.arg2 == "" {
+		opts = append(opts, "has any docID")
+	} else {
+		opts = append(opts, "has docID: "+e.arg2)
+	}
+	if e.arg3 == nil {
+		opts = append(opts, "has any body")
+	} else {
+		opts = append(opts, fmt.Sprintf("has body: %v", e.arg3))
+	}
+	if e.ret1 != "" {
+		rets = append(rets, "should return rev: "+e.ret1)
+	}
+	if e.ret2 != nil {
+		rets = append(rets, fmt.Sprintf("should return body: %s", e.ret2))
+	}
+	return dbStringer("UpdateHandler", &e.commonExpectation, withOptions, opts, rets)
+}
+
+// WithDDoc sets the expected design document for the call to
+// DB.UpdateHandler().
+func (e *ExpectedUpdateHandler) WithDDoc(ddoc string) *ExpectedUpdateHandler {
+	e.arg0 = ddoc
+	return e
+}
+
+// WithFuncName sets the expected update function name for the call to
+// DB.UpdateHandler().
+func (e *ExpectedUpdateHandler) WithFuncName(funcName string) *ExpectedUpdateHandler {
+	e.arg1 = funcName
+	return e
+}
+
+// WithDocID sets the expected docID for the call to DB.UpdateHandler().
+func (e *ExpectedUpdateHandler) WithDocID(docID string) *ExpectedUpdateHandler {
+	e.arg2 = docID
+	return e
+}
+
+// WithBody sets the expected request body for the call to DB.UpdateHandler().
+func (e *ExpectedUpdateHandler) WithBody(body interface{}) *ExpectedUpdateHandler {
+	e.arg3 = body
+	return e
+}
+
 func (e *ExpectedPutAttachment) String() string {
 	var opts, rets []string
 	if e.arg0 == "" {
@@ -601,6 +658,30 @@ func (e *ExpectedSetSecurity) WithSecurity(sec *driver.Security) *ExpectedSetSec
 	return e
 }
 
+func (e *ExpectedRevsLimit) String() string {
+	var rets []string
+	if e.ret0 != 0 {
+		rets = append(rets, fmt.Sprintf("should return: %d", e.ret0))
+	}
+	return dbStringer("RevsLimit", &e.commonExpectation, 0, nil, rets)
+}
+
+func (e *ExpectedSetRevsLimit) String() string {
+	var opts []string
+	if e.arg0 == 0 {
+		opts = append(opts, "has any limit")
+	} else {
+		opts = append(opts, fmt.Sprintf("has limit: %d", e.arg0))
+	}
+	return dbStringer("SetRevsLimit", &e.commonExpectation, 0, opts, nil)
+}
+
+// WithLimit sets the expected limit for the DB.SetRevsLimit() call.
+func (e *ExpectedSetRevsLimit) WithLimit(limit int) *ExpectedSetRevsLimit {
+	e.arg0 = limit
+	return e
+}
+
 func (e *ExpectedStats) String() string {
 	var rets []string
 	if e.ret0 != nil {
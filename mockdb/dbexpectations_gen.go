@@ -512,6 +512,96 @@ func (e *ExpectedPut) method(v bool) string {
 	return fmt.Sprintf("DB(%s).Put(ctx, %s, %s, %s)", e.dbo().name, arg0, arg1, options)
 }
 
+// ExpectedRevsLimit represents an expectation for a call to DB.RevsLimit().
+type ExpectedRevsLimit struct {
+	commonExpectation
+	callback func(ctx context.Context) (int, error)
+	ret0     int
+}
+
+// WillExecute sets a callback function to be called with any inputs to the
+// original function. Any values returned by the callback will be returned as
+// if generated by the driver.
+func (e *ExpectedRevsLimit) WillExecute(cb func(ctx context.Context) (int, error)) *ExpectedRevsLimit {
+	e.callback = cb
+	return e
+}
+
+// WillReturn sets the values that will be returned by the call to DB.RevsLimit().
+func (e *ExpectedRevsLimit) WillReturn(ret0 int) *ExpectedRevsLimit {
+	e.ret0 = ret0
+	return e
+}
+
+// WillReturnError sets the error value that will be returned by the call to DB.RevsLimit().
+func (e *ExpectedRevsLimit) WillReturnError(err error) *ExpectedRevsLimit {
+	e.err = err
+	return e
+}
+
+// WillDelay causes the call to DB.RevsLimit() to delay.
+func (e *ExpectedRevsLimit) WillDelay(delay time.Duration) *ExpectedRevsLimit {
+	e.delay = delay
+	return e
+}
+
+func (e *ExpectedRevsLimit) met(_ expectation) bool {
+	return true
+}
+
+func (e *ExpectedRevsLimit) method(v bool) string {
+	if !v {
+		return "DB.RevsLimit()"
+	}
+	return fmt.Sprintf("DB(%s).RevsLimit(ctx)", e.dbo().name)
+}
+
+// ExpectedSetRevsLimit represents an expectation for a call to DB.SetRevsLimit().
+type ExpectedSetRevsLimit struct {
+	commonExpectation
+	callback func(ctx context.Context, arg0 int) error
+	arg0     int
+}
+
+// WillExecute sets a callback function to be called with any inputs to the
+// original function. Any values returned by the callback will be returned as
+// if generated by the driver.
+func (e *ExpectedSetRevsLimit) WillExecute(cb func(ctx context.Context, arg0 int) error) *ExpectedSetRevsLimit {
+	e.callback = cb
+	return e
+}
+
+// WillReturnError sets the error value that will be returned by the call to DB.SetRevsLimit().
+func (e *ExpectedSetRevsLimit) WillReturnError(err error) *ExpectedSetRevsLimit {
+	e.err = err
+	return e
+}
+
+// WillDelay causes the call to DB.SetRevsLimit() to delay.
+func (e *ExpectedSetRevsLimit) WillDelay(delay time.Duration) *ExpectedSetRevsLimit {
+	e.delay = delay
+	return e
+}
+
+func (e *ExpectedSetRevsLimit) met(ex expectation) bool {
+	exp := ex.(*ExpectedSetRevsLimit)
+	if exp.arg0 != 0 && exp.arg0 != e.arg0 {
+		return false
+	}
+	return true
+}
+
+func (e *ExpectedSetRevsLimit) method(v bool) string {
+	if !v {
+		return "DB.SetRevsLimit()"
+	}
+	arg0 := "?"
+	if e.arg0 != 0 {
+		arg0 = fmt.Sprintf("%q", e.arg0)
+	}
+	return fmt.Sprintf("DB(%s).SetRevsLimit(ctx, %s)", e.dbo().name, arg0)
+}
+
 // ExpectedViewCleanup represents an expectation for a call to DB.ViewCleanup().
 type ExpectedViewCleanup struct {
 	commonExpectation
@@ -1846,3 +1936,87 @@ func (e *ExpectedStats) method(v bool) string {
 	}
 	return fmt.Sprintf("DB(%s).Stats(ctx)", e.dbo().name)
 }
+
+// ExpectedUpdateHandler represents an expectation for a call to DB.UpdateHandler().
+type ExpectedUpdateHandler struct {
+	commonExpectation
+	callback func(ctx context.Context, arg0 string, arg1 string, arg2 string, arg3 interface{}, options driver.Options) (string, string, []uint8, error)
+	arg0     string
+	arg1     string
+	arg2     string
+	arg3     interface{}
+	ret0     string
+	ret1     string
+	ret2     []uint8
+}
+
+// WithOptions sets the expected options for the call to DB.UpdateHandler().
+func (e *ExpectedUpdateHandler) WithOptions(options ...kivik.Option) *ExpectedUpdateHandler {
+	e.options = multiOptions{e.options, multiOptions(options)}
+	return e
+}
+
+// WillExecute sets a callback function to be called with any inputs to the
+// original function. Any values returned by the callback will be returned as
+// if generated by the driver.
+func (e *ExpectedUpdateHandler) WillExecute(cb func(ctx context.Context, arg0 string, arg1 string, arg2 string, arg3 interface{}, options driver.Options) (string, string, []uint8, error)) *ExpectedUpdateHandler {
+	e.callback = cb
+	return e
+}
+
+// WillReturn sets the values that will be returned by the call to DB.UpdateHandler().
+func (e *ExpectedUpdateHandler) WillReturn(ret0 string, ret1 string, ret2 []uint8) *ExpectedUpdateHandler {
+	e.ret0 = ret0
+	e.ret1 = ret1
+	e.ret2 = ret2
+	return e
+}
+
+// WillReturnError sets the error value that will be returned by the call to DB.UpdateHandler().
+func (e *ExpectedUpdateHandler) WillReturnError(err error) *ExpectedUpdateHandler {
+	e.err = err
+	return e
+}
+
+// WillDelay causes the call to DB.UpdateHandler() to delay.
+func (e *ExpectedUpdateHandler) WillDelay(delay time.Duration) *ExpectedUpdateHandler {
+	e.delay = delay
+	return e
+}
+
+func (e *ExpectedUpdateHandler) met(ex expectation) bool {
+	exp := ex.(*ExpectedUpdateHandler)
+	if exp.arg0 != "" && exp.arg0 != e.arg0 {
+		return false
+	}
+	if exp.arg1 != "" && exp.arg1 != e.arg1 {
+		return false
+	}
+	if exp.arg2 != "" && exp.arg2 != e.arg2 {
+		return false
+	}
+	if exp.arg3 != nil && !jsonMeets(exp.arg3, e.arg3) {
+		return false
+	}
+	return true
+}
+
+func (e *ExpectedUpdateHandler) method(v bool) string {
+	if !v {
+		return "DB.UpdateHandler()"
+	}
+	arg0, arg1, arg2, arg3, options := "?", "?", "?", "?", formatOptions(e.options)
+	if e.arg0 != "" {
+		arg0 = fmt.Sprintf("%q", e.arg0)
+	}
+	if e.arg1 != "" {
+		arg1 = fmt.Sprintf("%q", e.arg1)
+	}
+	if e.arg2 != "" {
+		arg2 = fmt.Sprintf("%q", e.arg2)
+	}
+	if e.arg3 != nil {
+		arg3 = fmt.Sprintf("%v", e.arg3)
+	}
+	return fmt.Sprintf("DB(%s).UpdateHandler(ctx, %s, %s, %s, %s, %s)", e.dbo().name, arg0, arg1, arg2, arg3, options)
+}
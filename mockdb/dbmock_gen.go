@@ -100,6 +100,26 @@ func (db *DB) ExpectPut() *ExpectedPut {
 	return e
 }
 
+// ExpectRevsLimit queues an expectation that DB.RevsLimit will be called.
+func (db *DB) ExpectRevsLimit() *ExpectedRevsLimit {
+	e := &ExpectedRevsLimit{
+		commonExpectation: commonExpectation{db: db},
+	}
+	db.count++
+	db.client.expected = append(db.client.expected, e)
+	return e
+}
+
+// ExpectSetRevsLimit queues an expectation that DB.SetRevsLimit will be called.
+func (db *DB) ExpectSetRevsLimit() *ExpectedSetRevsLimit {
+	e := &ExpectedSetRevsLimit{
+		commonExpectation: commonExpectation{db: db},
+	}
+	db.count++
+	db.client.expected = append(db.client.expected, e)
+	return e
+}
+
 // ExpectViewCleanup queues an expectation that DB.ViewCleanup will be called.
 func (db *DB) ExpectViewCleanup() *ExpectedViewCleanup {
 	e := &ExpectedViewCleanup{
@@ -341,3 +361,13 @@ func (db *DB) ExpectStats() *ExpectedStats {
 	db.client.expected = append(db.client.expected, e)
 	return e
 }
+
+// ExpectUpdateHandler queues an expectation that DB.UpdateHandler will be called.
+func (db *DB) ExpectUpdateHandler() *ExpectedUpdateHandler {
+	e := &ExpectedUpdateHandler{
+		commonExpectation: commonExpectation{db: db},
+	}
+	db.count++
+	db.client.expected = append(db.client.expected, e)
+	return e
+}
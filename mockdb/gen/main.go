@@ -100,9 +100,11 @@ type fullDB interface {
 	driver.RevGetter
 	driver.Purger
 	driver.RevsDiffer
+	driver.RevsLimiter
 	driver.PartitionedDB
 	driver.SecurityDB
 	driver.OpenRever
+	driver.UpdateHandler
 }
 
 func db() error {
@@ -957,6 +957,39 @@ func TestCopyString(t *testing.T) {
 	tests.Run(t, testStringer)
 }
 
+func TestUpdateHandlerString(t *testing.T) {
+	tests := testy.NewTable()
+	tests.Add("empty", stringerTest{
+		input: &ExpectedUpdateHandler{commonExpectation: commonExpectation{db: &DB{name: "foo"}}},
+		expected: `call to DB(foo#0).UpdateHandler() which:
+	- has any ddoc
+	- has any funcName
+	- has any docID
+	- has any body
+	- has any options`,
+	})
+	tests.Add("full", stringerTest{
+		input: &ExpectedUpdateHandler{
+			commonExpectation: commonExpectation{db: &DB{name: "foo"}},
+			arg0:              "ddoc",
+			arg1:              "func",
+			arg2:              "doc",
+			arg3:              "body",
+			ret1:              "1-foo",
+			ret2:              []byte("ok"),
+		},
+		expected: `call to DB(foo#0).UpdateHandler() which:
+	- has ddoc: ddoc
+	- has funcName: func
+	- has docID: doc
+	- has body: body
+	- has any options
+	- should return rev: 1-foo
+	- should return body: ok`,
+	})
+	tests.Run(t, testStringer)
+}
+
 func TestGetString(t *testing.T) {
 	tests := testy.NewTable()
 	tests.Add("empty", stringerTest{
@@ -0,0 +1,376 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+const (
+	defaultProjectorBatchSize          = 100
+	defaultProjectorCheckpointInterval = 5 * time.Second
+)
+
+// Projector maintains a materialized projection (a read model, such as an
+// in-memory index or aggregate) of a database, by applying each change from the
+// changes feed to a handler, and periodically checkpointing its progress.
+// Create one with [NewProjector].
+//
+// Changes are read in batches, using a `longpoll` feed with `include_docs`
+// enabled, so that the number of pending changes can be reported by
+// [Projector.Stats] as the projection's lag. Like [Follower], a Projector
+// retries failed requests with backoff, and provides at-least-once delivery.
+type Projector struct {
+	db      *DB
+	handler func(context.Context, *Change) error
+	options []Option
+
+	checkpoint         seqCheckpoint
+	since              string
+	batchSize          int
+	checkpointInterval time.Duration
+	reset              func(context.Context) error
+	initialInterval    time.Duration
+	maxInterval        time.Duration
+
+	mu      sync.Mutex
+	running bool
+	stats   ProjectorStats
+}
+
+// ProjectorStats reports the progress of a [Projector].
+type ProjectorStats struct {
+	// Seq is the sequence of the last processed change.
+	Seq string
+	// Pending is the number of changes not yet processed, as reported by the
+	// last batch read from the changes feed.
+	Pending int64
+	// Processed is the number of changes processed since the Projector was
+	// created, or last rebuilt.
+	Processed uint64
+	// CaughtUp is true once a batch has been read with no pending changes.
+	CaughtUp bool
+	// LastCheckpoint is the time the checkpoint document was last written.
+	LastCheckpoint time.Time
+}
+
+type projectorSinceOption string
+
+var _ Option = projectorSinceOption("")
+
+func (o projectorSinceOption) Apply(target interface{}) {
+	if p, ok := target.(*Projector); ok {
+		p.since = string(o)
+	}
+}
+
+// ProjectorSince sets the sequence from which a [Projector] starts, when no
+// checkpoint is found. By default, the projection is built from the beginning
+// of the changes feed.
+func ProjectorSince(seq string) Option {
+	return projectorSinceOption(seq)
+}
+
+type projectorCheckpointOption string
+
+var _ Option = projectorCheckpointOption("")
+
+func (o projectorCheckpointOption) Apply(target interface{}) {
+	if p, ok := target.(*Projector); ok {
+		p.checkpoint.id = string(o)
+	}
+}
+
+// ProjectorCheckpoint instructs a [Projector] to persist the last processed
+// sequence in the document docID, and to resume from it when started. A
+// `_local` document ID is recommended, so that the checkpoint is not
+// replicated. Without this option, progress is only held in memory.
+func ProjectorCheckpoint(docID string) Option {
+	return projectorCheckpointOption(docID)
+}
+
+type projectorBatchSizeOption int
+
+var _ Option = projectorBatchSizeOption(0)
+
+func (o projectorBatchSizeOption) Apply(target interface{}) {
+	if p, ok := target.(*Projector); ok && o > 0 {
+		p.batchSize = int(o)
+	}
+}
+
+// ProjectorBatchSize sets the maximum number of changes read per request. The
+// default is 100.
+func ProjectorBatchSize(size int) Option {
+	return projectorBatchSizeOption(size)
+}
+
+type projectorCheckpointIntervalOption time.Duration
+
+var _ Option = projectorCheckpointIntervalOption(0)
+
+func (o projectorCheckpointIntervalOption) Apply(target interface{}) {
+	if p, ok := target.(*Projector); ok && o >= 0 {
+		p.checkpointInterval = time.Duration(o)
+	}
+}
+
+// ProjectorCheckpointInterval sets the minimum interval between checkpoint
+// writes. The checkpoint is written after the first batch completed after the
+// interval has elapsed, and when [Projector.Run] returns. The default is five
+// seconds. An interval of 0 writes a checkpoint after every batch.
+func ProjectorCheckpointInterval(interval time.Duration) Option {
+	return projectorCheckpointIntervalOption(interval)
+}
+
+type projectorResetOption func(context.Context) error
+
+var _ Option = projectorResetOption(nil)
+
+func (o projectorResetOption) Apply(target interface{}) {
+	if p, ok := target.(*Projector); ok {
+		p.reset = o
+	}
+}
+
+// ProjectorReset sets the function called by [Projector.Rebuild] to discard
+// the current state of the projection.
+func ProjectorReset(fn func(context.Context) error) Option {
+	return projectorResetOption(fn)
+}
+
+// NewProjector returns a new [Projector], which applies each change in db to
+// handler. This function supports the [ProjectorSince], [ProjectorCheckpoint],
+// [ProjectorBatchSize], [ProjectorCheckpointInterval], [ProjectorReset] and
+// [FollowerBackoff] options. All other options are passed to [DB.Changes],
+// except that `feed`, `since`, `limit` and `include_docs` are always set by
+// the Projector.
+func NewProjector(db *DB, handler func(context.Context, *Change) error, options ...Option) *Projector {
+	p := &Projector{
+		db:                 db,
+		handler:            handler,
+		checkpoint:         seqCheckpoint{db: db},
+		batchSize:          defaultProjectorBatchSize,
+		checkpointInterval: defaultProjectorCheckpointInterval,
+		initialInterval:    defaultFollowerInitialInterval,
+		maxInterval:        defaultFollowerMaxInterval,
+	}
+	multiOptions(options).Apply(p)
+	p.options = append([]Option{Duration("heartbeat", defaultFollowerHeartbeat)}, options...)
+	return p
+}
+
+// Stats returns the current progress of the projection.
+func (p *Projector) Stats() ProjectorStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+func (p *Projector) seq() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats.Seq
+}
+
+func (p *Projector) start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running {
+		return &internal.Error{Status: http.StatusConflict, Message: "kivik: projector already running"}
+	}
+	p.running = true
+	return nil
+}
+
+func (p *Projector) stop() {
+	p.mu.Lock()
+	p.running = false
+	p.mu.Unlock()
+}
+
+// Run applies changes to the handler until ctx is cancelled, or the handler
+// returns an error. The projection resumes from the checkpoint, if any, or
+// else from the sequence set by [ProjectorSince]. Errors reading the feed are
+// retried with backoff, except for client errors (HTTP 4xx status, other than
+// 408 and 429), which are returned immediately.
+//
+// A batch which does not advance the sequence is followed by the same backoff
+// as a failed request, so that a feed which returns immediately is not polled
+// in a tight loop.
+//
+// The error returned by the handler is returned unaltered, and the change for
+// which it was returned is not considered processed.
+func (p *Projector) Run(ctx context.Context) (err error) {
+	if p.db.err != nil {
+		return p.db.err
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+	defer p.stop()
+
+	seq, err := p.checkpoint.load(ctx)
+	if err != nil {
+		return err
+	}
+	if seq == "" {
+		seq = p.since
+	}
+	p.mu.Lock()
+	if p.stats.Seq == "" {
+		p.stats.Seq = seq
+	}
+	p.mu.Unlock()
+	saved := p.seq()
+	defer func() {
+		// Use a fresh context, so that the final checkpoint is still saved
+		// when ctx has been cancelled.
+		if cpErr := p.saveCheckpoint(context.Background(), saved); err == nil {
+			err = cpErr
+		}
+	}()
+
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = p.initialInterval
+	bo.MaxInterval = p.maxInterval
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+
+	for {
+		before := p.seq()
+		err := p.batch(ctx)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var hErr *handlerError
+		if errors.As(err, &hErr) {
+			return hErr.err
+		}
+		if err != nil && !retriable(err) {
+			return err
+		}
+		if err == nil && p.seq() != before {
+			bo.Reset()
+			if time.Since(p.Stats().LastCheckpoint) >= p.checkpointInterval {
+				if err := p.saveCheckpoint(ctx, saved); err != nil {
+					return err
+				}
+				saved = p.seq()
+			}
+			continue
+		}
+		// The batch failed, or returned without advancing the sequence, as a
+		// longpoll request may do immediately, so wait before the next one.
+		timer := time.NewTimer(bo.NextBackOff())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// batch reads and applies a single batch of changes.
+func (p *Projector) batch(ctx context.Context) error {
+	options := append(p.options, // nolint:gocritic
+		IncludeDocs(),
+		Param("feed", "longpoll"),
+		Param("limit", p.batchSize),
+	)
+	if seq := p.seq(); seq != "" {
+		options = append(options, Param("since", seq))
+	}
+	changes := p.db.Changes(ctx, options...)
+	defer changes.Close() // nolint:errcheck
+
+	for changes.Next() {
+		change := changes.current()
+		if err := p.handler(ctx, change); err != nil {
+			return &handlerError{err: err}
+		}
+		p.mu.Lock()
+		p.stats.Seq = change.Seq
+		p.stats.Processed++
+		p.mu.Unlock()
+	}
+	if err := changes.Err(); err != nil {
+		return err
+	}
+	meta, err := changes.Metadata()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if meta.LastSeq != "" {
+		p.stats.Seq = meta.LastSeq
+	}
+	p.stats.Pending = meta.Pending
+	p.stats.CaughtUp = p.stats.CaughtUp || meta.Pending == 0
+	return nil
+}
+
+// saveCheckpoint writes the current sequence to the checkpoint document, if it
+// differs from saved.
+func (p *Projector) saveCheckpoint(ctx context.Context, saved string) error {
+	seq := p.seq()
+	if seq == saved {
+		return nil
+	}
+	if err := p.checkpoint.save(ctx, seq); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.stats.LastCheckpoint = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// Rebuild discards the projection, by calling the function set with
+// [ProjectorReset], and rewinds the checkpoint to the beginning of the changes
+// feed, so that the next call to [Projector.Run] rebuilds the projection from
+// scratch. Rebuild must not be called while the Projector is running.
+func (p *Projector) Rebuild(ctx context.Context) error {
+	if p.db.err != nil {
+		return p.db.err
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+	defer p.stop()
+	if p.reset != nil {
+		if err := p.reset(ctx); err != nil {
+			return err
+		}
+	}
+	if _, err := p.checkpoint.load(ctx); err != nil {
+		return err
+	}
+	if err := p.checkpoint.save(ctx, "0"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.stats = ProjectorStats{Seq: "0"}
+	p.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,251 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+// longpollFeed wraps fakeFeed, to serve batches of changes from a longpoll
+// feed.
+type longpollFeed struct {
+	*fakeFeed
+	requests []string
+}
+
+func (f *longpollFeed) db() *DB {
+	db := f.fakeFeed.db()
+	db.driverDB.(*mock.DB).ChangesFunc = func(_ context.Context, options driver.Options) (driver.Changes, error) {
+		opts := map[string]interface{}{}
+		options.Apply(opts)
+		if opts["feed"] != "longpoll" || opts["include_docs"] != true {
+			return nil, errors.New("unexpected options")
+		}
+		since, _ := opts["since"].(string)
+		limit, _ := opts["limit"].(int)
+		f.requests = append(f.requests, since)
+		next, _ := strconv.Atoi(since)
+		end := next + limit
+		if end > len(f.ids) {
+			end = len(f.ids)
+		}
+		return &mock.Changes{
+			NextFunc: func(ch *driver.Change) error {
+				if next >= end {
+					return io.EOF
+				}
+				ch.ID = f.ids[next]
+				next++
+				ch.Seq = strconv.Itoa(next)
+				ch.Doc, _ = json.Marshal(map[string]interface{}{"_id": ch.ID, "n": next})
+				return nil
+			},
+			LastSeqFunc: func() string { return strconv.Itoa(end) },
+			PendingFunc: func() int64 { return int64(len(f.ids) - end) },
+		}, nil
+	}
+	return db
+}
+
+func TestProjectorRun(t *testing.T) {
+	type tt struct {
+		feed     *longpollFeed
+		options  []Option
+		failAt   string
+		want     []string
+		wantReqs []string
+		// wantPending is the pending count observed by the handler, for each
+		// change.
+		wantPending    []int64
+		wantStats      ProjectorStats
+		wantCheckpoint string
+		status         int
+		err            string
+	}
+
+	ids := []string{"a", "b", "c", "d", "e"}
+	tests := testy.NewTable()
+	tests.Add("from scratch", tt{
+		feed:           &longpollFeed{fakeFeed: &fakeFeed{ids: ids}},
+		want:           []string{"a", "b", "c", "d", "e"},
+		wantReqs:       []string{"", "2", "4"},
+		wantPending:    []int64{0, 0, 3, 3, 1},
+		wantStats:      ProjectorStats{Seq: "5", Processed: 5, CaughtUp: true},
+		wantCheckpoint: "5",
+		status:         http.StatusInternalServerError,
+		err:            "context canceled",
+	})
+	tests.Add("resume from checkpoint", tt{
+		feed: &longpollFeed{fakeFeed: &fakeFeed{
+			ids:        ids,
+			checkpoint: map[string]interface{}{"_id": "_local/proj", "_rev": "rev-3", "seq": "3"},
+		}},
+		options:        []Option{ProjectorSince("1")},
+		want:           []string{"d", "e"},
+		wantReqs:       []string{"3"},
+		wantPending:    []int64{0, 0},
+		wantStats:      ProjectorStats{Seq: "5", Processed: 2, CaughtUp: true},
+		wantCheckpoint: "5",
+		status:         http.StatusInternalServerError,
+		err:            "context canceled",
+	})
+	tests.Add("since", tt{
+		feed:           &longpollFeed{fakeFeed: &fakeFeed{ids: ids}},
+		options:        []Option{ProjectorSince("3")},
+		want:           []string{"d", "e"},
+		wantReqs:       []string{"3"},
+		wantPending:    []int64{0, 0},
+		wantStats:      ProjectorStats{Seq: "5", Processed: 2, CaughtUp: true},
+		wantCheckpoint: "5",
+		status:         http.StatusInternalServerError,
+		err:            "context canceled",
+	})
+	tests.Add("handler error", tt{
+		feed:           &longpollFeed{fakeFeed: &fakeFeed{ids: ids}},
+		failAt:         "d",
+		want:           []string{"a", "b", "c", "d"},
+		wantReqs:       []string{"", "2"},
+		wantPending:    []int64{0, 0, 3, 3},
+		wantStats:      ProjectorStats{Seq: "3", Pending: 3, Processed: 3},
+		wantCheckpoint: "3",
+		status:         http.StatusInternalServerError,
+		err:            "handler failed",
+	})
+
+	tests.Run(t, func(t *testing.T, tt tt) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var got []string
+		var pending []int64
+		var p *Projector
+		options := append([]Option{
+			ProjectorCheckpoint("_local/proj"),
+			ProjectorBatchSize(2),
+			ProjectorCheckpointInterval(time.Hour),
+		}, tt.options...)
+		p = NewProjector(tt.feed.db(), func(_ context.Context, change *Change) error {
+			var doc struct {
+				ID string `json:"_id"`
+			}
+			if err := change.ScanDoc(&doc); err != nil || doc.ID != change.ID {
+				t.Errorf("Unexpected doc for %s: %v", change.ID, err)
+			}
+			got = append(got, change.ID)
+			pending = append(pending, p.Stats().Pending)
+			if change.ID == tt.failAt {
+				return errors.New("handler failed")
+			}
+			if change.ID == "e" {
+				cancel()
+			}
+			return nil
+		}, options...)
+		err := p.Run(ctx)
+		if d := internal.StatusErrorDiff(tt.err, tt.status, err); d != "" {
+			t.Error(d)
+		}
+		if d := cmp.Diff(tt.want, got); d != "" {
+			t.Errorf("Unexpected changes:\n%s", d)
+		}
+		if d := cmp.Diff(tt.wantReqs, tt.feed.requests); d != "" {
+			t.Errorf("Unexpected requests:\n%s", d)
+		}
+		if d := cmp.Diff(tt.wantPending, pending); d != "" {
+			t.Errorf("Unexpected pending counts:\n%s", d)
+		}
+		stats := p.Stats()
+		stats.LastCheckpoint = time.Time{}
+		if d := cmp.Diff(tt.wantStats, stats); d != "" {
+			t.Errorf("Unexpected stats:\n%s", d)
+		}
+		if seq := tt.feed.checkpoint["seq"]; seq != tt.wantCheckpoint {
+			t.Errorf("Unexpected checkpoint: %v", seq)
+		}
+	})
+}
+
+func TestProjectorRunBacksOffWhenIdle(t *testing.T) {
+	// The fake longpoll feed returns immediately once caught up, without
+	// advancing the sequence.
+	feed := &longpollFeed{fakeFeed: &fakeFeed{ids: []string{"a", "b", "c"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	p := NewProjector(feed.db(), func(context.Context, *Change) error {
+		return nil
+	}, ProjectorBatchSize(2), FollowerBackoff(100*time.Millisecond, 100*time.Millisecond))
+	err := p.Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(feed.requests) < 3 || len(feed.requests) > 6 {
+		t.Errorf("Expected the idle feed to be polled with backoff, got %d requests: %v", len(feed.requests), feed.requests)
+	}
+	if d := cmp.Diff([]string{"", "2", "3"}, feed.requests[:3]); d != "" {
+		t.Errorf("Unexpected requests:\n%s", d)
+	}
+}
+
+func TestProjectorRebuild(t *testing.T) {
+	feed := &longpollFeed{fakeFeed: &fakeFeed{
+		ids:        []string{"a", "b"},
+		checkpoint: map[string]interface{}{"_id": "_local/proj", "_rev": "rev-2", "seq": "2"},
+	}}
+	projection := map[string]bool{"a": true, "b": true}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p := NewProjector(feed.db(), func(_ context.Context, change *Change) error {
+		projection[change.ID] = true
+		if change.ID == "b" {
+			cancel()
+		}
+		return nil
+	},
+		ProjectorCheckpoint("_local/proj"),
+		ProjectorReset(func(context.Context) error {
+			projection = map[string]bool{}
+			return nil
+		}),
+	)
+	if err := p.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(projection) != 0 {
+		t.Errorf("Projection not reset: %v", projection)
+	}
+	if seq := feed.checkpoint["seq"]; seq != "0" {
+		t.Errorf("Unexpected checkpoint after rebuild: %v", seq)
+	}
+	if err := p.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if d := cmp.Diff(map[string]bool{"a": true, "b": true}, projection); d != "" {
+		t.Error(d)
+	}
+	if d := cmp.Diff([]string{"0"}, feed.requests); d != "" {
+		t.Errorf("Unexpected requests:\n%s", d)
+	}
+}
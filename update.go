@@ -0,0 +1,78 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+// UpdateResult is the result of calling an update function with
+// [DB.UpdateHandler].
+type UpdateResult struct {
+	// ID is the ID of the document saved by the update function. It is empty
+	// if the function did not save a document.
+	ID string
+	// Rev is the new revision of the document saved by the update function.
+	// It is empty if the function did not save a document.
+	Rev string
+	// Body is the response body returned by the update function.
+	Body []byte
+}
+
+// UpdateHandler calls the [update function] funcName, defined in the design
+// document ddoc, which may be specified with or without the `_design/` prefix.
+// If docID is not empty, the update function is called with the current
+// version of that document, or null if it does not exist. body is sent as the
+// request body: strings and byte slices are sent as-is, and other values are
+// encoded as JSON. options are passed to the update function as query
+// parameters.
+//
+// See the [CouchDB documentation].
+//
+// [update function]: https://docs.couchdb.org/en/stable/ddocs/ddocs.html#update-functions
+// [CouchDB documentation]: https://docs.couchdb.org/en/stable/api/ddoc/render.html#db-design-design-doc-update-update-name
+func (db *DB) UpdateHandler(ctx context.Context, ddoc, funcName, docID string, body interface{}, options ...Option) (*UpdateResult, error) {
+	if db.err != nil {
+		return nil, db.err
+	}
+	ddoc = strings.TrimPrefix(ddoc, "_design/")
+	if ddoc == "" {
+		return nil, missingArg("ddoc")
+	}
+	if funcName == "" {
+		return nil, missingArg("funcName")
+	}
+	updater, ok := db.driverDB.(driver.UpdateHandler)
+	if !ok {
+		return nil, &internal.Error{Status: http.StatusNotImplemented, Message: "kivik: update functions not supported by driver"}
+	}
+	endQuery, err := db.startQuery()
+	if err != nil {
+		return nil, err
+	}
+	defer endQuery()
+	id, rev, respBody, err := updater.UpdateHandler(ctx, ddoc, funcName, docID, body, multiOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateResult{
+		ID:   id,
+		Rev:  rev,
+		Body: respBody,
+	}, nil
+}
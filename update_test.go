@@ -0,0 +1,142 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package kivik
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestUpdateHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       *DB
+		ddoc     string
+		funcName string
+		docID    string
+		body     interface{}
+		options  []Option
+		expected *UpdateResult
+		status   int
+		err      string
+	}{
+		{
+			name:     "db error",
+			db:       &DB{err: errors.New("db error")},
+			ddoc:     "foo",
+			funcName: "bar",
+			status:   http.StatusInternalServerError,
+			err:      "db error",
+		},
+		{
+			name:     "missing ddoc",
+			db:       &DB{client: &Client{}, driverDB: &mock.UpdateHandler{}},
+			ddoc:     "_design/",
+			funcName: "bar",
+			status:   http.StatusBadRequest,
+			err:      "kivik: ddoc required",
+		},
+		{
+			name:   "missing function name",
+			db:     &DB{client: &Client{}, driverDB: &mock.UpdateHandler{}},
+			ddoc:   "foo",
+			status: http.StatusBadRequest,
+			err:    "kivik: funcName required",
+		},
+		{
+			name: "not supported",
+			db: &DB{
+				client:   &Client{},
+				driverDB: &mock.DB{},
+			},
+			ddoc:     "foo",
+			funcName: "bar",
+			status:   http.StatusNotImplemented,
+			err:      "kivik: update functions not supported by driver",
+		},
+		{
+			name: "client closed",
+			db: &DB{
+				client:   &Client{closed: true},
+				driverDB: &mock.UpdateHandler{},
+			},
+			ddoc:     "foo",
+			funcName: "bar",
+			status:   http.StatusServiceUnavailable,
+			err:      "kivik: client closed",
+		},
+		{
+			name: "driver error",
+			db: &DB{
+				client: &Client{},
+				driverDB: &mock.UpdateHandler{
+					UpdateHandlerFunc: func(context.Context, string, string, string, interface{}, driver.Options) (string, string, []byte, error) {
+						return "", "", nil, &internal.Error{Status: http.StatusNotFound, Message: "missing"}
+					},
+				},
+			},
+			ddoc:     "foo",
+			funcName: "bar",
+			status:   http.StatusNotFound,
+			err:      "missing",
+		},
+		{
+			name: "success",
+			db: &DB{
+				client: &Client{},
+				driverDB: &mock.UpdateHandler{
+					UpdateHandlerFunc: func(_ context.Context, ddoc, funcName, docID string, body interface{}, options driver.Options) (string, string, []byte, error) {
+						if ddoc != "foo" || funcName != "bar" || docID != "baz" {
+							return "", "", nil, fmt.Errorf("Unexpected arguments: %s, %s, %s", ddoc, funcName, docID)
+						}
+						if body != "qux" {
+							return "", "", nil, fmt.Errorf("Unexpected body: %v", body)
+						}
+						gotOpts := map[string]interface{}{}
+						options.Apply(gotOpts)
+						if d := testy.DiffInterface(map[string]interface{}{"field": "x"}, gotOpts); d != nil {
+							return "", "", nil, fmt.Errorf("Unexpected options: %s", d)
+						}
+						return "baz", "2-xxx", []byte("ok"), nil
+					},
+				},
+			},
+			ddoc:     "_design/foo",
+			funcName: "bar",
+			docID:    "baz",
+			body:     "qux",
+			options:  []Option{Param("field", "x")},
+			expected: &UpdateResult{ID: "baz", Rev: "2-xxx", Body: []byte("ok")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.db.UpdateHandler(context.Background(), test.ddoc, test.funcName, test.docID, test.body, test.options...)
+			if d := internal.StatusErrorDiff(test.err, test.status, err); d != "" {
+				t.Error(d)
+			}
+			if d := testy.DiffInterface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
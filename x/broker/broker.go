@@ -0,0 +1,190 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+// Package broker provides an in-process changes feed fan-out broker.
+//
+// A [Broker] holds a single continuous changes feed (via a [kivik.Follower])
+// for a database, and distributes each change to any number of in-process
+// [Subscription]s, each with its own filter and buffering policy. This avoids
+// opening one changes feed per consumer.
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-kivik/kivik/v4"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+// ErrClosed is returned by [Broker.Subscribe] after the broker has been
+// closed.
+var ErrClosed = errors.New("broker: closed")
+
+// Broker fans out a single changes feed to many subscribers.
+type Broker struct {
+	follower    *kivik.Follower
+	includeDocs bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	done   chan struct{}
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+	err  error
+}
+
+// New returns a new broker for db. The changes feed is opened when the first
+// subscriber is added, and remains open until [Broker.Close] is called.
+//
+// All options are passed to [kivik.NewFollower], so the [kivik.Follower]
+// options may be used to tune the reconnection behaviour, and any other
+// options are passed along to [kivik.DB.Changes]. By default, the feed starts
+// from `since=now`; pass [kivik.Param]("since", ...) to override this. To use
+// [Selector] subscriptions, pass [kivik.IncludeDocs].
+func New(db *kivik.DB, options ...kivik.Option) *Broker {
+	ctx, cancel := context.WithCancel(context.Background())
+	params := map[string]interface{}{}
+	for _, opt := range options {
+		if opt != nil {
+			opt.Apply(params)
+		}
+	}
+	includeDocs, _ := params["include_docs"].(bool)
+	return &Broker{
+		follower:    kivik.NewFollower(db, append([]kivik.Option{kivik.Param("since", "now")}, options...)...),
+		includeDocs: includeDocs,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		subs:        map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe adds a new subscriber. This method supports the [IDPrefix],
+// [Selector], [Predicate], [Buffer] and [Overflow] options. When more than one
+// filter is given, a change must match all of them to be delivered.
+func (b *Broker) Subscribe(options ...kivik.Option) (*Subscription, error) {
+	s := &Subscription{
+		broker: b,
+		buffer: defaultBuffer,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range options {
+		if opt != nil {
+			opt.Apply(s)
+		}
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.selector != nil && !b.includeDocs {
+		return nil, &internal.Error{Status: http.StatusBadRequest, Message: "broker: Selector requires include_docs"}
+	}
+	s.ch = make(chan *kivik.Change, s.buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return nil, b.err
+	}
+	b.subs[s] = struct{}{}
+	b.once.Do(func() {
+		go b.run()
+	})
+	return s, nil
+}
+
+// Close stops the changes feed, and closes all subscriptions.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = ErrClosed
+	}
+	started := true
+	b.once.Do(func() {
+		started = false
+	})
+	b.mu.Unlock()
+	b.cancel()
+	if started {
+		<-b.done
+	}
+	b.closeAll(nil)
+	return nil
+}
+
+// Seq returns the sequence of the last change dispatched to subscribers.
+func (b *Broker) Seq() string {
+	return b.follower.Seq()
+}
+
+func (b *Broker) run() {
+	defer close(b.done)
+	err := b.follower.Run(b.ctx, func(ctx context.Context, change *kivik.Change) error {
+		b.dispatch(ctx, change)
+		return nil
+	})
+	if b.ctx.Err() != nil {
+		return
+	}
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+	b.closeAll(err)
+}
+
+// dispatch delivers change to each matching subscriber.
+func (b *Broker) dispatch(ctx context.Context, change *kivik.Change) {
+	b.mu.Lock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+
+	var doc interface{}
+	var docErr error
+	var docParsed bool
+	for _, s := range subs {
+		if s.selector != nil && !docParsed {
+			docErr = change.ScanDoc(&doc)
+			docParsed = true
+		}
+		if !s.match(change, doc, docErr) {
+			continue
+		}
+		s.send(ctx, change)
+	}
+}
+
+func (b *Broker) remove(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+}
+
+func (b *Broker) closeAll(err error) {
+	b.mu.Lock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.close(err)
+	}
+}
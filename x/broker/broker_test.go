@@ -0,0 +1,263 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+type change struct {
+	id      string
+	deleted bool
+	doc     map[string]interface{}
+	err     error
+}
+
+// feed is a fake continuous changes feed, driven by the test.
+type feed struct {
+	in          chan change
+	mu          sync.Mutex
+	connections int
+	seq         int
+	// pushed counts the changes sent by the test.
+	pushed int
+}
+
+func (f *feed) db() driver.DB {
+	return &mock.DB{
+		ChangesFunc: func(ctx context.Context, _ driver.Options) (driver.Changes, error) {
+			f.mu.Lock()
+			f.connections++
+			f.mu.Unlock()
+			return &mock.Changes{
+				NextFunc: func(ch *driver.Change) error {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case c := <-f.in:
+						if c.err != nil {
+							return c.err
+						}
+						f.seq++
+						ch.ID = c.id
+						ch.Seq = strconv.Itoa(f.seq)
+						ch.Deleted = c.deleted
+						if c.doc != nil {
+							ch.Doc, _ = json.Marshal(c.doc)
+						}
+						return nil
+					}
+				},
+			}, nil
+		},
+	}
+}
+
+var (
+	feedsMu sync.Mutex
+	feeds   = map[string]*feed{}
+)
+
+func init() {
+	kivik.Register("broker-test", &mock.Driver{
+		NewClientFunc: func(string, driver.Options) (driver.Client, error) {
+			return &mock.Client{
+				DBFunc: func(name string, _ driver.Options) (driver.DB, error) {
+					feedsMu.Lock()
+					defer feedsMu.Unlock()
+					return feeds[name].db(), nil
+				},
+			}, nil
+		},
+	})
+}
+
+func newFeed(t *testing.T) (*feed, *kivik.DB) {
+	t.Helper()
+	f := &feed{in: make(chan change)}
+	feedsMu.Lock()
+	feeds[t.Name()] = f
+	feedsMu.Unlock()
+	client, err := kivik.New("broker-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, client.DB(t.Name())
+}
+
+// push sends the changes to the feed, and waits until they have been
+// dispatched.
+func push(t *testing.T, b *Broker, f *feed, changes ...change) {
+	t.Helper()
+	for _, c := range changes {
+		f.in <- c
+		f.pushed++
+	}
+	want := strconv.Itoa(f.pushed)
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Seq() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for seq %s", want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func drain(s *Subscription) []string {
+	ids := []string{}
+	for c := range s.Changes() {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestBroker(t *testing.T) {
+	f, db := newFeed(t)
+	b := New(db, kivik.IncludeDocs())
+
+	subscribe := func(options ...kivik.Option) *Subscription {
+		s, err := b.Subscribe(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	all := subscribe()
+	users := subscribe(IDPrefix("user:"))
+	posts := subscribe(Selector(map[string]interface{}{"type": "post"}))
+	deleted := subscribe(Predicate(func(c *kivik.Change) bool { return c.Deleted }))
+	both := subscribe(IDPrefix("user:"), Selector(map[string]interface{}{"type": "post"}))
+
+	push(t, b, f,
+		change{id: "user:1", doc: map[string]interface{}{"type": "user"}},
+		change{id: "post:1", doc: map[string]interface{}{"type": "post"}},
+		change{id: "user:2", deleted: true, doc: map[string]interface{}{"_deleted": true}},
+		change{id: "user:3", doc: map[string]interface{}{"type": "post"}},
+	)
+	_ = b.Close()
+
+	want := map[string][]string{
+		"all":     {"user:1", "post:1", "user:2", "user:3"},
+		"users":   {"user:1", "user:2", "user:3"},
+		"posts":   {"post:1", "user:3"},
+		"deleted": {"user:2"},
+		"both":    {"user:3"},
+	}
+	got := map[string][]string{
+		"all":     drain(all),
+		"users":   drain(users),
+		"posts":   drain(posts),
+		"deleted": drain(deleted),
+		"both":    drain(both),
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Error(d)
+	}
+	if f.connections != 1 {
+		t.Errorf("Expected a single changes feed, got %d", f.connections)
+	}
+	if _, err := b.Subscribe(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Unexpected error after close: %s", err)
+	}
+}
+
+func TestBrokerSelectorRequiresDocs(t *testing.T) {
+	_, db := newFeed(t)
+	b := New(db)
+	defer b.Close() // nolint:errcheck
+	_, err := b.Subscribe(Selector(map[string]interface{}{"type": "post"}))
+	if d := internal.StatusErrorDiff("broker: Selector requires include_docs", http.StatusBadRequest, err); d != "" {
+		t.Error(d)
+	}
+	_, err = b.Subscribe(Selector(map[string]interface{}{"$bogus": 1}))
+	if status := kivik.HTTPStatus(err); status != http.StatusBadRequest {
+		t.Errorf("Unexpected status for invalid selector: %d", status)
+	}
+}
+
+func TestBrokerOverflow(t *testing.T) {
+	f, db := newFeed(t)
+	b := New(db)
+
+	subscribe := func(policy Policy) *Subscription {
+		s, err := b.Subscribe(Buffer(1), Overflow(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	dropNewest := subscribe(DropNewest)
+	dropOldest := subscribe(DropOldest)
+	disconnect := subscribe(Disconnect)
+
+	push(t, b, f, change{id: "a"}, change{id: "b"}, change{id: "c"})
+	_ = b.Close()
+
+	if d := cmp.Diff([]string{"a"}, drain(dropNewest)); d != "" {
+		t.Errorf("DropNewest: %s", d)
+	}
+	if n := dropNewest.Dropped(); n != 2 {
+		t.Errorf("DropNewest: unexpected drop count: %d", n)
+	}
+	if d := cmp.Diff([]string{"c"}, drain(dropOldest)); d != "" {
+		t.Errorf("DropOldest: %s", d)
+	}
+	if n := dropOldest.Dropped(); n != 2 {
+		t.Errorf("DropOldest: unexpected drop count: %d", n)
+	}
+	if d := cmp.Diff([]string{"a"}, drain(disconnect)); d != "" {
+		t.Errorf("Disconnect: %s", d)
+	}
+	if err := disconnect.Err(); !errors.Is(err, ErrSlowSubscriber) {
+		t.Errorf("Disconnect: unexpected error: %s", err)
+	}
+	if err := dropNewest.Err(); err != nil {
+		t.Errorf("DropNewest: unexpected error: %s", err)
+	}
+}
+
+func TestBrokerFeedError(t *testing.T) {
+	f, db := newFeed(t)
+	b := New(db)
+	defer b.Close() // nolint:errcheck
+
+	s, err := b.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.in <- change{err: &internal.Error{Status: http.StatusUnauthorized, Message: "unauthorized"}}
+	if d := cmp.Diff([]string{}, drain(s)); d != "" {
+		t.Error(d)
+	}
+	if d := internal.StatusErrorDiff("unauthorized", http.StatusUnauthorized, s.Err()); d != "" {
+		t.Error(d)
+	}
+	_, err = b.Subscribe()
+	if d := internal.StatusErrorDiff("unauthorized", http.StatusUnauthorized, err); d != "" {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,133 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kivik/kivik/v4"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/x/mango"
+)
+
+// Policy determines what happens when a subscriber's buffer is full.
+type Policy int
+
+const (
+	// Block waits for the subscriber to make room in its buffer. This is the
+	// default. Note that a blocked subscriber delays delivery to all other
+	// subscribers of the same broker.
+	Block Policy = iota
+	// DropNewest discards the new change.
+	DropNewest
+	// DropOldest discards the oldest buffered change, to make room for the new
+	// one.
+	DropOldest
+	// Disconnect closes the subscription, with [ErrSlowSubscriber].
+	Disconnect
+)
+
+type idPrefixOption string
+
+var _ kivik.Option = idPrefixOption("")
+
+func (o idPrefixOption) Apply(target interface{}) {
+	if s, ok := target.(*Subscription); ok {
+		s.prefix = string(o)
+	}
+}
+
+// IDPrefix limits a subscription to changes to documents whose ID begins with
+// prefix.
+func IDPrefix(prefix string) kivik.Option {
+	return idPrefixOption(prefix)
+}
+
+type selectorOption struct {
+	selector interface{}
+}
+
+var _ kivik.Option = selectorOption{}
+
+func (o selectorOption) Apply(target interface{}) {
+	s, ok := target.(*Subscription)
+	if !ok {
+		return
+	}
+	raw, err := json.Marshal(o.selector)
+	if err != nil {
+		s.err = &internal.Error{Status: http.StatusBadRequest, Err: err}
+		return
+	}
+	node, err := mango.Parse(raw)
+	if err != nil {
+		s.err = &internal.Error{Status: http.StatusBadRequest, Err: err}
+		return
+	}
+	s.selector = node
+}
+
+// Selector limits a subscription to changes whose document matches the Mango
+// selector, which may be any value that marshals to a JSON object. The broker
+// must be created with [kivik.IncludeDocs].
+func Selector(selector interface{}) kivik.Option {
+	return selectorOption{selector: selector}
+}
+
+type predicateOption func(*kivik.Change) bool
+
+var _ kivik.Option = predicateOption(nil)
+
+func (o predicateOption) Apply(target interface{}) {
+	if s, ok := target.(*Subscription); ok {
+		s.predicate = o
+	}
+}
+
+// Predicate limits a subscription to changes for which fn returns true. fn is
+// called from the broker's dispatch goroutine, so it should return quickly.
+func Predicate(fn func(*kivik.Change) bool) kivik.Option {
+	return predicateOption(fn)
+}
+
+type bufferOption int
+
+var _ kivik.Option = bufferOption(0)
+
+func (o bufferOption) Apply(target interface{}) {
+	if s, ok := target.(*Subscription); ok && o >= 0 {
+		s.buffer = int(o)
+	}
+}
+
+// Buffer sets the size of a subscription's channel buffer. The default is 64.
+func Buffer(size int) kivik.Option {
+	return bufferOption(size)
+}
+
+type overflowOption Policy
+
+var _ kivik.Option = overflowOption(0)
+
+func (o overflowOption) Apply(target interface{}) {
+	if s, ok := target.(*Subscription); ok {
+		s.policy = Policy(o)
+	}
+}
+
+// Overflow sets the policy applied when a subscription's buffer is full. The
+// default is [Block].
+func Overflow(policy Policy) kivik.Option {
+	return overflowOption(policy)
+}
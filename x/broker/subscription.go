@@ -0,0 +1,163 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/x/mango"
+)
+
+// ErrSlowSubscriber is the error reported by [Subscription.Err] when the
+// subscription was closed due to the [Disconnect] overflow policy.
+var ErrSlowSubscriber = errors.New("broker: subscriber too slow")
+
+const defaultBuffer = 64
+
+// Subscription receives changes from a [Broker].
+type Subscription struct {
+	broker *Broker
+
+	prefix    string
+	selector  mango.Node
+	predicate func(*kivik.Change) bool
+	buffer    int
+	policy    Policy
+
+	ch      chan *kivik.Change
+	dropped uint64
+
+	// err is set when the subscription is closed, or if an option fails to
+	// apply.
+	errMu sync.Mutex
+	err   error
+
+	closeOnce sync.Once
+	done      chan struct{}
+
+	// mu protects closed and ch, so that ch is never closed while a send is in
+	// progress.
+	mu     sync.Mutex
+	closed bool
+}
+
+// Changes returns the channel on which changes are delivered. The channel is
+// closed when the subscription is closed, after which [Subscription.Err] may
+// be consulted.
+func (s *Subscription) Changes() <-chan *kivik.Change {
+	return s.ch
+}
+
+// Err returns the reason the subscription was closed: nil if it was closed by
+// [Subscription.Close] or [Broker.Close], [ErrSlowSubscriber], or the error
+// that terminated the changes feed.
+func (s *Subscription) Err() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.err
+}
+
+// Dropped returns the number of changes dropped due to the overflow policy.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes from the broker, and closes the changes channel.
+func (s *Subscription) Close() error {
+	s.close(nil)
+	return nil
+}
+
+func (s *Subscription) close(err error) {
+	s.closeOnce.Do(func() {
+		s.errMu.Lock()
+		s.err = err
+		s.errMu.Unlock()
+		close(s.done)
+		s.broker.remove(s)
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
+
+// match returns true if change passes all of the subscription's filters.
+func (s *Subscription) match(change *kivik.Change, doc interface{}, docErr error) bool {
+	if s.prefix != "" && !strings.HasPrefix(change.ID, s.prefix) {
+		return false
+	}
+	if s.selector != nil && (docErr != nil || !s.selector.Match(doc)) {
+		return false
+	}
+	if s.predicate != nil && !s.predicate(change) {
+		return false
+	}
+	return true
+}
+
+// send delivers change according to the overflow policy.
+func (s *Subscription) send(ctx context.Context, change *kivik.Change) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	switch s.policy {
+	case Block:
+		// Prefer delivery over cancellation, when there is room in the buffer.
+		select {
+		case s.ch <- change:
+		default:
+			select {
+			case s.ch <- change:
+			case <-s.done:
+			case <-ctx.Done():
+			}
+		}
+	case DropNewest:
+		select {
+		case s.ch <- change:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- change:
+				s.mu.Unlock()
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	case Disconnect:
+		select {
+		case s.ch <- change:
+		default:
+			s.mu.Unlock()
+			s.close(ErrSlowSubscriber)
+			return
+		}
+	}
+	s.mu.Unlock()
+}
@@ -0,0 +1,146 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+// encodingGzip is the only encoding in which attachments are stored, other
+// than identity.
+const encodingGzip = "gzip"
+
+// compressionLevel matches the default attachments/compression_level setting
+// of CouchDB.
+const compressionLevel = 8
+
+// defaultCompressibleTypes matches the default attachments/compressible_types
+// setting of CouchDB.
+var defaultCompressibleTypes = []string{"text/*", "application/javascript", "application/json", "application/xml"}
+
+type optionCompressibleTypes []string
+
+var _ kivik.Option = optionCompressibleTypes(nil)
+
+func (o optionCompressibleTypes) Apply(target interface{}) {
+	if client, ok := target.(*client); ok {
+		client.compressibleTypes = o
+	}
+}
+
+// OptionCompressibleTypes sets the content types of attachments which are
+// stored gzip-compressed, as does the attachments/compressible_types setting
+// of CouchDB. A type ending in "/*" matches any subtype. The default is
+// text/*, application/javascript, application/json and application/xml.
+// Passing no types disables compression.
+func OptionCompressibleTypes(types ...string) kivik.Option {
+	return optionCompressibleTypes(types)
+}
+
+// compressible reports whether attachments of contentType are stored
+// compressed.
+func (d *db) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range d.compressibleTypes {
+		if prefix, ok := strings.CutSuffix(t, "*"); ok && strings.HasPrefix(mediaType, prefix) || t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// storeAttachmentData stores the content of att, unless identical content is
+// already stored, in which case it is shared, and returns the SHA-256 hash by
+// which the content is stored. The content is compressed if contentType is
+// compressible, and written to the attachment directory if it is larger than
+// the threshold set by [OptionAttachmentDir].
+func (d *db) storeAttachmentData(ctx context.Context, tx *sql.Tx, stmts stmtCache, att *attachment, contentType string) ([]byte, error) {
+	sum := sha256.Sum256(att.Content)
+	hash := sum[:]
+	existsStmt, err := stmts.prepare(ctx, tx, d.query(`
+		SELECT TRUE FROM {{ .AttachmentData }} WHERE hash = $1
+	`))
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	switch err := existsStmt.QueryRowContext(ctx, hash).Scan(&exists); {
+	case err == nil:
+		return hash, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	var encoding *string
+	data := att.Content
+	if d.compressible(contentType) {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, compressionLevel)
+		if _, err := zw.Write(att.Content); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		gz := encodingGzip
+		encoding, data = &gz, buf.Bytes()
+	}
+	if data == nil {
+		// NULL data denotes content stored in the attachment directory.
+		data = []byte{}
+	}
+	encodedLength := len(data)
+	if d.attachmentDir != "" && att.Length > d.attachmentThreshold {
+		if err := d.writeBlob(hash, data); err != nil {
+			return nil, err
+		}
+		data = nil
+	}
+	insertStmt, err := stmts.prepare(ctx, tx, d.query(`
+		INSERT INTO {{ .AttachmentData }} (hash, encoding, encoded_length, data)
+		VALUES ($1, $2, $3, $4)
+	`))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := insertStmt.ExecContext(ctx, hash, encoding, encodedLength, data); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// readAttachment returns the content of an attachment, as selected from the
+// attachment data table. If blob is not empty, the content is read from that
+// file in the attachment directory, rather than from data.
+func (d *db) readAttachment(encoding *string, data []byte, blob string) ([]byte, error) {
+	var enc string
+	if encoding != nil {
+		enc = *encoding
+	}
+	r, err := openAttachment(enc, data, blob, d.blobDir())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
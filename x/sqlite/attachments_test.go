@@ -0,0 +1,292 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestAttachmentStorage(t *testing.T) {
+	t.Parallel()
+
+	content := strings.Repeat("Lorem ipsum dolor sit amet. ", 100)
+	// encoding returns the stored encoding of the attachment of docID.
+	encoding := func(t *testing.T, d *testDB, docID string) string {
+		t.Helper()
+		var encoding *string
+		err := d.underlying().QueryRow(`
+			SELECT data.encoding
+			FROM test_attachment_data AS data
+			JOIN test_attachments AS att ON att.hash = data.hash
+			JOIN test_attachments_bridge AS bridge ON bridge.pk = att.pk
+			WHERE bridge.id = $1
+		`, docID).Scan(&encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding == nil {
+			return "identity"
+		}
+		return *encoding
+	}
+	// checkContent checks that the attachment of docID is returned intact.
+	checkContent := func(t *testing.T, d *testDB, docID, want string) {
+		t.Helper()
+		att, err := d.GetAttachment(context.Background(), docID, "att", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(att.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("Unexpected content: %q", got)
+		}
+	}
+	put := func(d *testDB, docID, contentType, content string) {
+		_ = d.tPut(docID, map[string]interface{}{
+			"_attachments": map[string]interface{}{
+				"att": map[string]interface{}{
+					"content_type": contentType,
+					"data":         []byte(content),
+				},
+			},
+		})
+	}
+
+	t.Run("compressible content is gzipped", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		put(d, "foo", "text/plain; charset=utf-8", content)
+
+		if got := encoding(t, d, "foo"); got != "gzip" {
+			t.Errorf("Unexpected encoding: %s", got)
+		}
+		var length int
+		if err := d.underlying().QueryRow(`SELECT encoded_length FROM test_attachment_data`).Scan(&length); err != nil {
+			t.Fatal(err)
+		}
+		if length >= len(content) {
+			t.Errorf("Expected content to be compressed, but it is %d bytes", length)
+		}
+		checkContent(t, d, "foo", content)
+	})
+	t.Run("other content is stored as is", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		put(d, "foo", "image/png", content)
+
+		if got := encoding(t, d, "foo"); got != "identity" {
+			t.Errorf("Unexpected encoding: %s", got)
+		}
+		checkContent(t, d, "foo", content)
+	})
+	t.Run("compression can be disabled", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t, OptionCompressibleTypes())
+		put(d, "foo", "text/plain", content)
+
+		if got := encoding(t, d, "foo"); got != "identity" {
+			t.Errorf("Unexpected encoding: %s", got)
+		}
+	})
+	t.Run("custom compressible types", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t, OptionCompressibleTypes("image/*"))
+		put(d, "foo", "image/svg+xml", content)
+		put(d, "bar", "text/plain", content+"!")
+
+		if got := encoding(t, d, "foo"); got != "gzip" {
+			t.Errorf("Unexpected encoding for image: %s", got)
+		}
+		if got := encoding(t, d, "bar"); got != "identity" {
+			t.Errorf("Unexpected encoding for text: %s", got)
+		}
+	})
+	t.Run("empty content", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		put(d, "foo", "text/plain", "")
+
+		checkContent(t, d, "foo", "")
+	})
+	t.Run("identical content is stored once", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		put(d, "foo", "text/plain", content)
+		put(d, "bar", "text/plain", content)
+		rev := d.tPut("baz", map[string]interface{}{
+			"_attachments": newAttachments().add("att", content),
+		})
+
+		if n := d.count(`SELECT COUNT(*) FROM test_attachment_data`); n != 1 {
+			t.Errorf("Expected 1 copy of the content, found %d", n)
+		}
+		if n := d.count(`SELECT COUNT(*) FROM test_attachments`); n != 3 {
+			t.Errorf("Expected 3 attachments, found %d", n)
+		}
+
+		// Compaction keeps content shared with a remaining attachment.
+		_ = d.tDelete("baz", kivik.Rev(rev))
+		if err := d.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if n := d.count(`SELECT COUNT(*) FROM test_attachment_data`); n != 1 {
+			t.Errorf("Expected shared content to survive compaction, found %d copies", n)
+		}
+		checkContent(t, d, "bar", content)
+	})
+	t.Run("content with colliding MD5 digests is stored separately", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		// A well-known pair of distinct blocks with the same MD5 digest.
+		a, _ := hex.DecodeString("d131dd02c5e6eec4693d9a0698aff95c2fcab58712467eab4004583eb8fb7f8955ad340609f4b30283e488832571415a085125e8f7cdc99fd91dbdf280373c5bd8823e3156348f5bae6dacd436c919c6dd53e2b487da03fd02396306d248cda0e99f33420f577ee8ce54b67080a80d1ec69821bcb6a8839396f9652b6ff72a70")
+		b, _ := hex.DecodeString("d131dd02c5e6eec4693d9a0698aff95c2fcab50712467eab4004583eb8fb7f8955ad340609f4b30283e4888325f1415a085125e8f7cdc99fd91dbd7280373c5bd8823e3156348f5bae6dacd436c919c6dd53e23487da03fd02396306d248cda0e99f33420f577ee8ce54b67080280d1ec69821bcb6a8839396f965ab6ff72a70")
+		put(d, "foo", "application/octet-stream", string(a))
+		put(d, "bar", "application/octet-stream", string(b))
+
+		if n := d.count(`SELECT COUNT(DISTINCT digest) FROM test_attachments`); n != 1 {
+			t.Fatalf("Expected a single MD5 digest, found %d", n)
+		}
+		if n := d.count(`SELECT COUNT(*) FROM test_attachment_data`); n != 2 {
+			t.Errorf("Expected 2 copies of the content, found %d", n)
+		}
+		checkContent(t, d, "foo", string(a))
+		checkContent(t, d, "bar", string(b))
+	})
+}
+
+func TestEmptyAttachment(t *testing.T) {
+	t.Parallel()
+
+	d := newDB(t)
+	_ = d.tPut("_design/foo", map[string]interface{}{
+		"views": map[string]interface{}{
+			"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id, null); }"},
+		},
+	})
+	_ = d.tPut("foo", map[string]interface{}{
+		"_attachments": map[string]interface{}{
+			"empty.bin": map[string]interface{}{
+				"content_type": "application/octet-stream",
+				"data":         "",
+			},
+		},
+	})
+	ctx := context.Background()
+	includeAttachments := kivik.Params(map[string]interface{}{"include_docs": true, "attachments": true})
+
+	// checkDoc checks that doc contains the empty attachment inline.
+	checkDoc := func(t *testing.T, doc io.Reader) {
+		t.Helper()
+		var body struct {
+			Attachments map[string]struct {
+				Data *string `json:"data"`
+				Stub bool    `json:"stub"`
+			} `json:"_attachments"`
+		}
+		if err := json.NewDecoder(doc).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		att, ok := body.Attachments["empty.bin"]
+		if !ok || att.Stub || att.Data == nil || *att.Data != "" {
+			t.Errorf("Unexpected attachments: %+v", body.Attachments)
+		}
+	}
+	// checkRows checks the document of the row with ID foo.
+	checkRows := func(t *testing.T, rows driver.Rows, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var found bool
+		for {
+			var row driver.Row
+			if err := rows.Next(&row); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if row.ID == "foo" {
+				found = true
+				checkDoc(t, row.Doc)
+			}
+		}
+		if !found {
+			t.Error("Document not found")
+		}
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		t.Parallel()
+		doc, err := d.Get(ctx, "foo", kivik.Param("attachments", true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer doc.Body.Close()
+		checkDoc(t, doc.Body)
+	})
+	t.Run("OpenRevs", func(t *testing.T) {
+		t.Parallel()
+		rows, err := d.OpenRevs(ctx, "foo", []string{"all"}, mock.NilOption)
+		checkRows(t, rows, err)
+	})
+	t.Run("Changes", func(t *testing.T) {
+		t.Parallel()
+		changes, err := d.Changes(ctx, includeAttachments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer changes.Close()
+		var found bool
+		for {
+			var change driver.Change
+			if err := changes.Next(&change); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if change.ID == "foo" {
+				found = true
+				checkDoc(t, strings.NewReader(string(change.Doc)))
+			}
+		}
+		if !found {
+			t.Error("Change not found")
+		}
+	})
+	t.Run("AllDocs", func(t *testing.T) {
+		t.Parallel()
+		rows, err := d.AllDocs(ctx, includeAttachments)
+		checkRows(t, rows, err)
+	})
+	t.Run("Query", func(t *testing.T) {
+		t.Parallel()
+		rows, err := d.Query(ctx, "_design/foo", "_view/bar", includeAttachments)
+		checkRows(t, rows, err)
+	})
+}
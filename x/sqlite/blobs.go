@@ -0,0 +1,187 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+type optionAttachmentDir struct {
+	dir       string
+	threshold int64
+}
+
+var _ kivik.Option = optionAttachmentDir{}
+
+func (o optionAttachmentDir) Apply(target interface{}) {
+	if client, ok := target.(*client); ok {
+		dir := o.dir
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		client.attachmentDir = dir
+		client.attachmentThreshold = o.threshold
+	}
+}
+
+// OptionAttachmentDir stores the content of attachments larger than threshold
+// bytes as files in dir, typically next to the database file, rather than in
+// SQLite. Files are named by the SHA-256 hash of their content, in a
+// subdirectory for each database, and are streamed from disk by GetAttachment.
+// Compaction removes files which are no longer referenced.
+func OptionAttachmentDir(dir string, threshold int64) kivik.Option {
+	return optionAttachmentDir{dir: dir, threshold: threshold}
+}
+
+// blobDir returns the directory of the database's externally stored
+// attachments, or "" if attachments are stored only in SQLite.
+func (d *db) blobDir() string {
+	return blobDir(d.attachmentDir, d.name)
+}
+
+func blobDir(attachmentDir, dbName string) string {
+	if attachmentDir == "" {
+		return ""
+	}
+	return filepath.Join(attachmentDir, url.PathEscape(dbName))
+}
+
+// blobPath returns the path of the file name, the hex-encoded SHA-256 hash of
+// its content, in dir. Files are spread across subdirectories by the first byte
+// of the hash.
+func blobPath(dir, name string) string {
+	return filepath.Join(dir, name[:2], name)
+}
+
+// writeBlob writes data, the encoded content with hash, to the attachment
+// directory. The file is written under a temporary name and then renamed, so
+// that a partially written file is never read.
+func (d *db) writeBlob(hash, data []byte) error {
+	path := blobPath(d.blobDir(), hex.EncodeToString(hash))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// openAttachment returns a reader of the content of an attachment, decoded
+// from encoding. If blob is not empty, the content is read from that file in
+// dir, rather than from data.
+func openAttachment(encoding string, data []byte, blob, dir string) (io.ReadCloser, error) {
+	var r io.ReadCloser = io.NopCloser(bytes.NewReader(data))
+	if blob != "" {
+		if dir == "" {
+			return nil, fmt.Errorf("attachment %s is stored externally, but no attachment directory is configured", blob)
+		}
+		f, err := os.Open(blobPath(dir, blob))
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	switch encoding {
+	case "":
+		return r, nil
+	case encodingGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		return &gzipReadCloser{Reader: zr, underlying: r}, nil
+	default:
+		_ = r.Close()
+		return nil, fmt.Errorf("unsupported attachment encoding: %s", encoding)
+	}
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying reader.
+type gzipReadCloser struct {
+	*gzip.Reader
+	underlying io.Closer
+}
+
+func (r *gzipReadCloser) Close() error {
+	err := r.Reader.Close()
+	if cerr := r.underlying.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// collectBlobs removes the files of the attachment directory which are not
+// referenced by the database. It must be called within a write transaction,
+// so that no attachment is being stored concurrently.
+func (d *db) collectBlobs(ctx context.Context, tx *sql.Tx) error {
+	dir := d.blobDir()
+	if dir == "" {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx, d.query(`
+		SELECT lower(hex(hash)) FROM {{ .AttachmentData }} WHERE data IS NULL
+	`))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	referenced := map[string]struct{}{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		referenced[blobPath(dir, name)] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if _, ok := referenced[path]; ok {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestAttachmentDir(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("All work and no play makes Jack a dull boy. ", 100)
+	// blobs returns the files in the attachment directory of the test
+	// database.
+	blobs := func(t *testing.T, dir string) []string {
+		t.Helper()
+		var files []string
+		err := filepath.WalkDir(filepath.Join(dir, "test"), func(path string, entry os.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return files
+	}
+	readAttachment := func(t *testing.T, d *testDB, docID, filename string) string {
+		t.Helper()
+		att, err := d.GetAttachment(context.Background(), docID, filename, mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer att.Content.Close()
+		content, err := io.ReadAll(att.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	t.Run("large attachments are stored as files", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		d := newDB(t, OptionAttachmentDir(dir, 1024))
+		_ = d.tPut("foo", map[string]interface{}{
+			"_attachments": map[string]interface{}{
+				"large.txt": map[string]interface{}{"content_type": "text/plain", "data": []byte(large)},
+				"large.bin": map[string]interface{}{"content_type": "application/octet-stream", "data": []byte(large + "!")},
+				"small.txt": map[string]interface{}{"content_type": "text/plain", "data": []byte("small")},
+			},
+		})
+
+		if n := d.count(`SELECT COUNT(*) FROM test_attachment_data WHERE data IS NULL`); n != 2 {
+			t.Errorf("Expected 2 attachments stored externally, found %d", n)
+		}
+		if files := blobs(t, dir); len(files) != 2 {
+			t.Errorf("Expected 2 files, found %v", files)
+		}
+		for filename, want := range map[string]string{"large.txt": large, "large.bin": large + "!", "small.txt": "small"} {
+			if got := readAttachment(t, d, "foo", filename); got != want {
+				t.Errorf("Unexpected content of %s: %q", filename, got)
+			}
+		}
+
+		// Inline attachments are read from the files as well.
+		doc, err := d.Get(context.Background(), "foo", kivik.Param("attachments", true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Attachments map[string]struct {
+				Data string `json:"data"`
+			} `json:"_attachments"`
+		}
+		if err := json.NewDecoder(doc.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if got := body.Attachments["large.txt"].Data; got != base64.StdEncoding.EncodeToString([]byte(large)) {
+			t.Errorf("Unexpected inline data: %s", got)
+		}
+	})
+	t.Run("compaction removes unreferenced files", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		d := newDB(t, OptionAttachmentDir(dir, 1024))
+		rev := d.tPut("foo", map[string]interface{}{
+			"_attachments": newAttachments().add("old.txt", large),
+		})
+		_ = d.tPut("bar", map[string]interface{}{
+			"_attachments": newAttachments().add("kept.txt", large+"!"),
+		})
+		_ = d.tPut("foo", map[string]interface{}{"_rev": rev})
+		stray := filepath.Join(dir, "test", "00", "stray")
+		if err := os.MkdirAll(filepath.Dir(stray), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(stray, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if files := blobs(t, dir); len(files) != 1 {
+			t.Errorf("Expected 1 file to remain, found %v", files)
+		}
+		if got := readAttachment(t, d, "bar", "kept.txt"); got != large+"!" {
+			t.Errorf("Unexpected content after compaction: %q", got)
+		}
+	})
+	t.Run("destroying the database removes its files", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		client, err := drv{}.NewClient("file:attachment-dir-destroy?mode=memory&cache=shared", OptionAttachmentDir(dir, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if err := client.CreateDB(ctx, "test", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+		db, err := client.DB("test", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		d := &testDB{DB: db.(DB), t: t}
+		_ = d.tPut("foo", map[string]interface{}{
+			"_attachments": newAttachments().add("att.txt", "content"),
+		})
+		if files := blobs(t, dir); len(files) != 1 {
+			t.Fatalf("Expected 1 file, found %v", files)
+		}
+
+		if err := client.DestroyDB(ctx, "test", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "test")); !os.IsNotExist(err) {
+			t.Errorf("Expected the attachment directory to be removed, got %v", err)
+		}
+	})
+}
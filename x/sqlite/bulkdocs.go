@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// BulkDocs writes all documents in a single transaction. As in CouchDB, each
+// document succeeds or fails independently: a failed document is rolled back
+// to a savepoint, and its error reported in its result, without affecting the
+// others.
+//
+// With new_edits=false, as used by replication, the provided revisions and
+// revision histories are stored as-is, and, as in CouchDB, results are only
+// returned for documents which could not be stored.
+func (d *db) BulkDocs(ctx context.Context, docs []interface{}, options driver.Options) ([]driver.BulkResult, error) {
+	d = d.withUserCtx(options)
+	opts := newOpts(options)
+	newEdits := opts.newEdits()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]driver.BulkResult, 0, len(docs))
+	for _, doc := range docs {
+		id, rev, err := d.bulkDoc(ctx, tx, doc, opts)
+		if !newEdits && err == nil {
+			continue
+		}
+		if err != nil && !isDocError(err) {
+			return nil, err
+		}
+		results = append(results, driver.BulkResult{
+			ID:    id,
+			Rev:   rev,
+			Error: err,
+		})
+	}
+	return results, d.commit(ctx, tx)
+}
+
+// bulkDoc writes a single document of a bulk request, within a savepoint, so
+// that a failure can be undone without aborting the transaction.
+func (d *db) bulkDoc(ctx context.Context, tx *sql.Tx, doc interface{}, opts optsMap) (id, rev string, err error) {
+	data, err := prepareDoc("", doc)
+	if err != nil {
+		return "", "", err
+	}
+	id = data.ID
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT bulk_doc"); err != nil {
+		return id, "", err
+	}
+	switch {
+	case id != "":
+		rev, err = d.put(ctx, tx, id, doc, opts)
+	case opts.newEdits():
+		data.ID = uuid.NewString()
+		id = data.ID
+		rev, err = d.createDoc(ctx, tx, data)
+	default:
+		rev, err = d.put(ctx, tx, id, doc, opts)
+	}
+	if err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO bulk_doc"); rbErr != nil {
+			return id, "", rbErr
+		}
+	}
+	if _, relErr := tx.ExecContext(ctx, "RELEASE bulk_doc"); relErr != nil {
+		return id, "", relErr
+	}
+	return id, rev, err
+}
+
+// isDocError returns true if err is specific to a single document, such as
+// a conflict or a validation failure, rather than a failure of the database.
+func isDocError(err error) bool {
+	status := kivik.HTTPStatus(err)
+	return status >= http.StatusBadRequest && status < http.StatusInternalServerError
+}
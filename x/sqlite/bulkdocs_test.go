@@ -0,0 +1,191 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestBulkDocs(t *testing.T) {
+	t.Parallel()
+	type result struct {
+		ID     string
+		Rev    string
+		Status int
+	}
+	type test struct {
+		db      *testDB
+		docs    []interface{}
+		options driver.Options
+		want    []result
+		// check is called after BulkDocs returns, to verify the database state.
+		check func(*testing.T, *testDB)
+	}
+
+	tests := testy.NewTable()
+	tests.Add("new docs", test{
+		docs: []interface{}{
+			map[string]interface{}{"_id": "foo", "value": 1},
+			map[string]interface{}{"_id": "bar", "value": 2},
+		},
+		want: []result{
+			{ID: "foo", Rev: "1-31c1d305a6d908be2ec3ee6205515c76"},
+			{ID: "bar", Rev: "1-2e97626c38fda7b8e713d55e64a7a9e5"},
+		},
+	})
+	tests.Add("update and conflict", func(t *testing.T) interface{} {
+		d := newDB(t)
+		rev := d.tPut("foo", map[string]interface{}{"value": 1})
+		_ = d.tPut("bar", map[string]interface{}{"value": 1})
+
+		return test{
+			db: d,
+			docs: []interface{}{
+				map[string]interface{}{"_id": "foo", "_rev": rev, "value": 2},
+				map[string]interface{}{"_id": "bar", "value": 2},
+				map[string]interface{}{"_id": "baz", "value": 2},
+			},
+			want: []result{
+				{ID: "foo", Rev: "2-2e97626c38fda7b8e713d55e64a7a9e5"},
+				{ID: "bar", Status: http.StatusConflict},
+				{ID: "baz", Rev: "1-2e97626c38fda7b8e713d55e64a7a9e5"},
+			},
+		}
+	})
+	tests.Add("same doc twice", test{
+		docs: []interface{}{
+			map[string]interface{}{"_id": "foo", "value": 1},
+			map[string]interface{}{"_id": "foo", "value": 2},
+		},
+		want: []result{
+			{ID: "foo", Rev: "1-31c1d305a6d908be2ec3ee6205515c76"},
+			{ID: "foo", Status: http.StatusConflict},
+		},
+	})
+	tests.Add("failed doc is rolled back", test{
+		docs: []interface{}{
+			map[string]interface{}{
+				"_id":     "_design/foo",
+				"views":   map[string]interface{}{"bar": map[string]interface{}{"map": "function(doc) {}"}},
+				"options": map[string]interface{}{"collation": "chicken"},
+			},
+			map[string]interface{}{"_id": "bar"},
+		},
+		want: []result{
+			{ID: "_design/foo", Status: http.StatusBadRequest},
+			{ID: "bar", Rev: "1-52a640a54c0880d3e7b04709b18719c5"},
+		},
+		check: func(t *testing.T, d *testDB) {
+			var count int
+			if err := d.underlying().QueryRow(`SELECT COUNT(*) FROM test_revs WHERE id = '_design/foo'`).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 0 {
+				t.Errorf("Failed document left %d revisions behind", count)
+			}
+		},
+	})
+	tests.Add("new_edits=false", test{
+		docs: []interface{}{
+			map[string]interface{}{
+				"_id": "foo",
+				"_revisions": map[string]interface{}{
+					"start": 3,
+					"ids":   []string{"ccc", "bbb", "aaa"},
+				},
+				"value": 1,
+			},
+			map[string]interface{}{"_id": "bar", "_rev": "5-abc"},
+			map[string]interface{}{"_id": "baz"},
+		},
+		options: kivik.Param("new_edits", false),
+		want: []result{
+			{ID: "baz", Status: http.StatusBadRequest},
+		},
+		check: func(t *testing.T, d *testDB) {
+			var revs int
+			if err := d.underlying().QueryRow(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`).Scan(&revs); err != nil {
+				t.Fatal(err)
+			}
+			if revs != 3 {
+				t.Errorf("Expected 3 revisions of foo, got %d", revs)
+			}
+			for id, want := range map[string]string{"foo": "3-ccc", "bar": "5-abc"} {
+				doc, err := d.Get(context.Background(), id, mock.NilOption)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = doc.Body.Close()
+				if doc.Rev != want {
+					t.Errorf("Unexpected rev for %s: %s", id, doc.Rev)
+				}
+			}
+		},
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		db := tt.db
+		if db == nil {
+			db = newDB(t)
+		}
+		options := tt.options
+		if options == nil {
+			options = mock.NilOption
+		}
+		results, err := db.DB.(driver.BulkDocer).BulkDocs(context.Background(), tt.docs, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]result, len(results))
+		for i, r := range results {
+			got[i] = result{ID: r.ID, Rev: r.Rev, Status: kivik.HTTPStatus(r.Error)}
+		}
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+		if tt.check != nil {
+			tt.check(t, db)
+		}
+	})
+}
+
+func TestBulkDocsGeneratesIDs(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	results, err := d.DB.(driver.BulkDocer).BulkDocs(context.Background(), []interface{}{
+		map[string]interface{}{"value": 1},
+	}, mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID == "" || results[0].Rev == "" || results[0].Error != nil {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+	doc, err := d.Get(context.Background(), results[0].ID, mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = doc.Body.Close()
+}
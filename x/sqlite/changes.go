@@ -29,15 +29,19 @@ import (
 
 	"github.com/go-kivik/kivik/v4/driver"
 	internal "github.com/go-kivik/kivik/v4/int/errors"
-	"github.com/go-kivik/kivik/x/sqlite/v4/js"
 )
 
 const (
-	feedNormal   = "normal"
-	feedLongpoll = "longpoll"
+	feedNormal      = "normal"
+	feedLongpoll    = "longpoll"
+	feedContinuous  = "continuous"
+	feedEventsource = "eventsource"
+
+	defaultChangesTimeout = 60 * time.Second
 )
 
 type normalChanges struct {
+	db          *db
 	rows        *sql.Rows
 	pending     int64
 	lastSeq     string
@@ -66,7 +70,7 @@ func (d *db) newNormalChanges(ctx context.Context, opts optsMap, since, lastSeq
 		}
 	}
 
-	c := &normalChanges{}
+	c := &normalChanges{db: d}
 
 	if sinceNow {
 		if lastSeq == nil {
@@ -114,35 +118,40 @@ func (d *db) newNormalChanges(ctx context.Context, opts optsMap, since, lastSeq
 				deleted,
 				rev,
 				rev_id,
-				IIF($3 OR $6 != '', doc, NULL) AS doc
+				IIF($3 OR $6 != '' OR $4 = '_selector', doc, NULL) AS doc
 			FROM {{ .Docs }}
 			WHERE ($1 IS NULL OR seq > $1)
 			ORDER BY seq
+		),
+		filter AS (
+			-- Will return no rows if the ddoc doesn't exist, an empty
+			-- body if it exists but the filter func doesn't exist, or the
+			-- filter function and its language if it does exist.
+			SELECT
+				COALESCE(design.func_body, '') AS func_body,
+				COALESCE(design.language, '')  AS language,
+				leaves.doc                     AS ddoc
+			FROM leaves
+			LEFT JOIN {{ .Design }} AS design ON design.id = leaves.id AND design.rev = leaves.rev AND design.rev_id = leaves.rev_id AND design.func_type = $4 AND design.func_name = $6
+			WHERE leaves.id = $5
+			ORDER BY leaves.rev DESC
+			LIMIT 1
 		)
 		SELECT
 			COUNT(*) AS id,
-			(
-				-- Will return NULL if the ddoc doesn't exist, empty string
-				-- if it exists but the filter func doesn't exist, or the
-				-- filter function if it does exist.
-				SELECT
-					COALESCE(design.func_body, '')
-				FROM leaves
-				LEFT JOIN {{ .Design }} AS design ON design.id = leaves.id AND design.rev = leaves.rev AND design.rev_id = leaves.rev_id AND design.func_type = $4 AND design.func_name = $6
-				WHERE leaves.id = $5
-				ORDER BY leaves.rev DESC
-				LIMIT 1
-			) AS filter_func,
-			NULL AS deleted,
+			(SELECT func_body FROM filter)              AS filter_func,
+			COALESCE((SELECT language FROM filter), '') AS filter_language,
 			COALESCE(MAX(seq),0) AS summary,
-			NULL AS doc,
+			(SELECT ddoc FROM filter) AS doc,
 			NULL AS attachment_count,
 			NULL AS filename,
 			NULL AS content_type,
 			NULL AS length,
 			NULL AS digest,
 			NULL AS rev_pos,
-			NULL AS data
+			NULL AS encoding,
+			NULL AS data,
+			NULL AS blob
 		FROM results
 
 		UNION ALL
@@ -159,7 +168,9 @@ func (d *db) newNormalChanges(ctx context.Context, opts optsMap, since, lastSeq
 			length,
 			digest,
 			rev_pos,
-			data
+			encoding,
+			data,
+			blob
 		FROM (
 			SELECT
 				results.id,
@@ -174,10 +185,13 @@ func (d *db) newNormalChanges(ctx context.Context, opts optsMap, since, lastSeq
 				att.length,
 				att.digest,
 				att.rev_pos,
-				IIF($2, data, NULL) AS data
+				content.encoding,
+				IIF($2, content.data, NULL) AS data,
+				IIF($2, IIF(content.data IS NULL, lower(hex(content.hash)), ''), NULL) AS blob
 			FROM results
 			LEFT JOIN {{ .AttachmentsBridge }} AS bridge ON bridge.id = results.id AND bridge.rev = results.rev AND bridge.rev_id = results.rev_id AND $3
 			LEFT JOIN {{ .Attachments }} AS att ON att.pk = bridge.pk
+			LEFT JOIN {{ .AttachmentData }} AS content ON content.hash = att.hash
 			%[2]s -- WHERE
 			ORDER BY seq %[1]s
 		)
@@ -202,16 +216,28 @@ func (d *db) newNormalChanges(ctx context.Context, opts optsMap, since, lastSeq
 	var (
 		summary      string
 		filterFuncJS *string
+		language     string
+		ddocJSON     *string
 	)
 	if err := c.rows.Scan(
 		&c.pending, &filterFuncJS,
-		discard{},
+		&language,
 		&summary,
-		discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
+		&ddocJSON, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
 	); err != nil {
 		return nil, err
 	}
 
+	if filterType == "_selector" {
+		selector, _, err := opts.changesSelector()
+		if err != nil {
+			return nil, err
+		}
+		c.filter = func(doc, _ any) (bool, error) {
+			return selector.Match(doc), nil
+		}
+	}
+
 	if filterName != "" {
 		if filterFuncJS == nil {
 			return nil, &internal.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("design doc '%s' not found", filterDdoc)}
@@ -220,16 +246,20 @@ func (d *db) newNormalChanges(ctx context.Context, opts optsMap, since, lastSeq
 			return nil, &internal.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("design doc '%s' missing %s function '%s'", filterDdoc, filterType, filterName)}
 		}
 
+		jsOpts, err := d.jsOptions(ddocJSON)
+		if err != nil {
+			return nil, err
+		}
 		if filterType == "filter" {
-			c.filter, err = js.Filter(*filterFuncJS)
+			c.filter, err = compileFilter(language, *filterFuncJS, jsOpts...)
 			if err != nil {
 				return nil, &internal.Error{Status: http.StatusInternalServerError, Err: err}
 			}
 		} else {
 			var emitted bool
-			mapFunc, err := js.Map(*filterFuncJS, func(any, any) {
+			mapFunc, err := compileMap(language, *filterFuncJS, func(any, any) {
 				emitted = true
-			})
+			}, jsOpts...)
 			if err != nil {
 				return nil, &internal.Error{Status: http.StatusInternalServerError, Err: err}
 			}
@@ -272,11 +302,13 @@ func (c *normalChanges) Next(change *driver.Change) error {
 			length                        *int64
 			revPos                        *int
 			digest                        *md5sum
-			data                          *[]byte
+			encoding                      *string
+			data                          []byte
+			blob                          *string
 		)
 		if err := c.rows.Scan(
 			&rowID, &rowSeq, &rowDeleted, &rowRev, &rowDoc,
-			&attachmentCount, &filename, &contentType, &length, &digest, &revPos, &data,
+			&attachmentCount, &filename, &contentType, &length, &digest, &revPos, &encoding, &data, &blob,
 		); err != nil {
 			return err
 		}
@@ -299,8 +331,12 @@ func (c *normalChanges) Next(change *driver.Change) error {
 				Length:      *length,
 				RevPos:      *revPos,
 			}
-			if data != nil {
-				att.Data, _ = json.Marshal(*data)
+			if blob != nil {
+				content, err := c.db.readAttachment(encoding, data, *blob)
+				if err != nil {
+					return err
+				}
+				att.Data, _ = json.Marshal(content)
 			}
 
 			atts[*filename] = att
@@ -357,6 +393,9 @@ func (c *normalChanges) ETag() string {
 }
 
 func (d *db) Changes(ctx context.Context, options driver.Options) (driver.Changes, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
 	opts := newOpts(options)
 
 	var lastSeq *uint64
@@ -372,6 +411,14 @@ func (d *db) Changes(ctx context.Context, options driver.Options) (driver.Change
 	if err != nil {
 		return nil, err
 	}
+	if feed == feedContinuous {
+		return d.newContinuousChanges(ctx, opts, sinceNow, since, false)
+	}
+	if _, ok := opts["filter"]; ok && sinceNow && feed == feedLongpoll {
+		// The longpoll feed below doesn't support filters, so wait for the
+		// first batch of matching changes instead.
+		return d.newContinuousChanges(ctx, opts, sinceNow, since, true)
+	}
 	if sinceNow && feed == feedLongpoll {
 		attachments, err := opts.attachments()
 		if err != nil {
@@ -384,6 +431,7 @@ func (d *db) Changes(ctx context.Context, options driver.Options) (driver.Change
 }
 
 type longpollChanges struct {
+	db          *db
 	stmt        *sql.Stmt
 	since       uint64
 	includeDocs bool
@@ -419,7 +467,9 @@ func (d *db) newLongpollChanges(ctx context.Context, includeDocs, attachments bo
 			length,
 			digest,
 			rev_pos,
-			data
+			encoding,
+			data,
+			blob
 		FROM (
 			SELECT
 				doc.id,
@@ -432,7 +482,9 @@ func (d *db) newLongpollChanges(ctx context.Context, includeDocs, attachments bo
 				att.length,
 				att.digest,
 				att.rev_pos,
-				IIF($2, att.data, NULL) AS data,
+				content.encoding,
+				IIF($2, content.data, NULL) AS data,
+				IIF($2, IIF(content.data IS NULL, lower(hex(content.hash)), ''), NULL) AS blob,
 				ROW_NUMBER() OVER () AS row_number
 			FROM (
 				SELECT
@@ -449,6 +501,7 @@ func (d *db) newLongpollChanges(ctx context.Context, includeDocs, attachments bo
 			) AS doc
 			LEFT JOIN {{ .AttachmentsBridge }} AS bridge ON bridge.id = doc.id AND bridge.rev = doc.rev AND bridge.rev_id = doc.rev_id AND doc IS NOT NULL
 			LEFT JOIN {{ .Attachments }} AS att ON att.pk = bridge.pk
+			LEFT JOIN {{ .AttachmentData }} AS content ON content.hash = att.hash
 		)
 	`))
 	if err != nil {
@@ -458,6 +511,7 @@ func (d *db) newLongpollChanges(ctx context.Context, includeDocs, attachments bo
 	ctx, cancel := context.WithCancel(ctx)
 	changes := make(chan longpollChange)
 	c := &longpollChanges{
+		db:          d,
 		stmt:        stmt,
 		since:       since,
 		attachments: attachments,
@@ -501,12 +555,14 @@ func (c *longpollChanges) watch(changes chan<- longpollChange) {
 			length                        *int64
 			digest                        *md5sum
 			revPos                        *int
-			data                          *[]byte
+			encoding                      *string
+			data                          []byte
+			blob                          *string
 		)
 		for rows.Next() {
 			if err := rows.Scan(
 				&rowID, &rowSeq, &rowDeleted, &rowRev, &rowDoc,
-				&filename, &contentType, &length, &digest, &revPos, &data,
+				&filename, &contentType, &length, &digest, &revPos, &encoding, &data, &blob,
 			); err != nil {
 				return backoff.Permanent(err)
 			}
@@ -528,8 +584,12 @@ func (c *longpollChanges) watch(changes chan<- longpollChange) {
 					Length:      *length,
 					RevPos:      *revPos,
 				}
-				if data != nil {
-					att.Data, _ = json.Marshal(*data)
+				if blob != nil {
+					content, err := c.db.readAttachment(encoding, data, *blob)
+					if err != nil {
+						return backoff.Permanent(err)
+					}
+					att.Data, _ = json.Marshal(content)
 				}
 				atts[*filename] = att
 			}
@@ -590,3 +650,151 @@ func (c *longpollChanges) LastSeq() string {
 
 func (*longpollChanges) Pending() int64 { return 0 }
 func (*longpollChanges) ETag() string   { return "" }
+
+// continuousChanges streams changes as they are committed, by reading
+// successive batches of changes with [normalChanges], each starting after the
+// last sequence of the previous batch, so that all filters are supported.
+type continuousChanges struct {
+	d     *db
+	ctx   context.Context
+	opts  optsMap
+	since uint64
+	// limit is the maximum number of changes to return, or 0 for no limit.
+	limit uint64
+	sent  uint64
+	// timeout is the maximum time to wait for a change. It is ignored if
+	// heartbeat is true.
+	timeout   time.Duration
+	heartbeat bool
+	batch     *normalChanges
+	// longpoll ends the feed after the first batch which includes any
+	// changes.
+	longpoll bool
+	// notified is closed at the first commit after batch was read.
+	notified <-chan struct{}
+}
+
+var _ driver.Changes = (*continuousChanges)(nil)
+
+// newContinuousChanges returns a continuous changes feed. As in CouchDB, the
+// feed ends when no change is committed within the timeout, unless a heartbeat
+// is requested, in which case it runs until ctx is cancelled. The heartbeats
+// themselves are left to the caller, such as an HTTP server, as they have no
+// representation in [driver.Changes]. If longpoll is true, the feed instead
+// ends after the first changes are returned.
+func (d *db) newContinuousChanges(ctx context.Context, opts optsMap, sinceNow bool, since *uint64, longpoll bool) (*continuousChanges, error) {
+	limit, err := opts.changesLimit()
+	if err != nil {
+		return nil, err
+	}
+	heartbeat, err := opts.heartbeat()
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := opts.timeout()
+	if err != nil {
+		return nil, err
+	}
+	c := &continuousChanges{
+		d:         d,
+		ctx:       ctx,
+		opts:      opts,
+		limit:     limit,
+		timeout:   timeout,
+		heartbeat: heartbeat,
+		longpoll:  longpoll,
+	}
+	switch {
+	case sinceNow:
+		c.since, err = d.lastSeq(ctx)
+		if err != nil {
+			return nil, err
+		}
+	case since != nil:
+		c.since = *since
+	}
+	// Read the first batch now, so that invalid filters are reported
+	// immediately.
+	if err := c.nextBatch(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// nextBatch starts reading the changes committed after c.since.
+func (c *continuousChanges) nextBatch() error {
+	// Subscribe before querying, so that no commit can be missed in between.
+	c.notified = c.d.notifier.wait(c.d.name)
+	since := c.since
+	batch, err := c.d.newNormalChanges(c.ctx, c.opts, nil, &since, true, feedContinuous)
+	if err != nil {
+		return err
+	}
+	c.batch = batch
+	return nil
+}
+
+func (c *continuousChanges) Next(change *driver.Change) error {
+	for {
+		if c.limit > 0 && c.sent >= c.limit {
+			return io.EOF
+		}
+		if c.batch == nil {
+			if err := c.wait(); err != nil {
+				return err
+			}
+			if err := c.nextBatch(); err != nil {
+				return err
+			}
+		}
+		err := c.batch.Next(change)
+		if c.batch.lastSeq != "" {
+			c.since, _ = strconv.ParseUint(c.batch.lastSeq, 10, 64)
+		}
+		switch {
+		case err == nil:
+			c.sent++
+			return nil
+		case !errors.Is(err, io.EOF):
+			return err
+		}
+		_ = c.batch.Close()
+		c.batch = nil
+		if c.longpoll && c.sent > 0 {
+			return io.EOF
+		}
+	}
+}
+
+// wait blocks until a write is committed, the timeout expires, or the context
+// is cancelled.
+func (c *continuousChanges) wait() error {
+	var timeout <-chan time.Time
+	if !c.heartbeat {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-timeout:
+		return io.EOF
+	case <-c.notified:
+		return nil
+	}
+}
+
+func (c *continuousChanges) Close() error {
+	if c.batch != nil {
+		return c.batch.Close()
+	}
+	return nil
+}
+
+func (c *continuousChanges) LastSeq() string {
+	return strconv.FormatUint(c.since, 10)
+}
+
+func (*continuousChanges) Pending() int64 { return 0 }
+func (*continuousChanges) ETag() string   { return "" }
@@ -17,6 +17,7 @@ package sqlite
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -119,7 +120,7 @@ func TestDBChanges(t *testing.T) {
 	})
 	tests.Add("invalid feed type", test{
 		options:    kivik.Param("feed", "invalid"),
-		wantErr:    "supported `feed` types: normal, longpoll",
+		wantErr:    "supported `feed` types: normal, longpoll, continuous, eventsource",
 		wantStatus: http.StatusBadRequest,
 	})
 	tests.Add("since=1", func(t *testing.T) interface{} {
@@ -597,6 +598,35 @@ func TestDBChanges(t *testing.T) {
 			wantETag:    &[]string{"eccbc87e4b5ce2fe28308fd9f2a7baf3"}[0],
 		}
 	})
+	tests.Add("filter function requires module", func(t *testing.T) any {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]any{
+			"filters": map[string]interface{}{
+				"bar": "function(doc, req) { return require('lib/match').match(doc); }",
+			},
+			"lib": map[string]interface{}{
+				"match": "exports.match = function(doc) { return doc.foo; };",
+			},
+		})
+		rev := d.tPut("doc1", map[string]bool{"foo": true})
+		_ = d.tPut("doc2", map[string]bool{"foo": false})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"filter": "foo/bar",
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "doc1",
+					Seq:     "2",
+					Changes: driver.ChangedRevs{rev},
+				},
+			},
+			wantLastSeq: &[]string{"3"}[0],
+			wantETag:    &[]string{"eccbc87e4b5ce2fe28308fd9f2a7baf3"}[0],
+		}
+	})
 	tests.Add("filter=_view without view parameter", test{
 		options:    kivik.Param("filter", "_view"),
 		wantStatus: http.StatusBadRequest,
@@ -670,19 +700,239 @@ func TestDBChanges(t *testing.T) {
 			wantETag:    &[]string{"eccbc87e4b5ce2fe28308fd9f2a7baf3"}[0],
 		}
 	})
+	tests.Add("filter=_selector without selector", test{
+		options: kivik.Params(map[string]interface{}{
+			"filter": "_selector",
+		}),
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "filter=_selector requires 'selector' parameter",
+	})
+	tests.Add("filter=_selector with invalid selector", test{
+		options: kivik.Params(map[string]interface{}{
+			"filter":   "_selector",
+			"selector": map[string]interface{}{"foo": map[string]interface{}{"$invalid": 1}},
+		}),
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "invalid value for 'selector': ",
+	})
+	tests.Add("filter=_selector", func(t *testing.T) any {
+		d := newDB(t)
+		rev := d.tPut("doc1", map[string]interface{}{"type": "post", "n": 1})
+		_ = d.tPut("doc2", map[string]interface{}{"type": "comment", "n": 2})
+		_ = d.tPut("doc3", map[string]interface{}{"type": "post", "n": 3})
+		rev4 := d.tPut("doc4", map[string]interface{}{"type": "post", "n": 4, "tags": []string{"a"}})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"filter": "_selector",
+				"selector": map[string]interface{}{
+					"type": "post",
+					"$or": []interface{}{
+						map[string]interface{}{"n": map[string]interface{}{"$lt": 2}},
+						map[string]interface{}{"tags": map[string]interface{}{"$size": 1}},
+					},
+				},
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "doc1",
+					Seq:     "1",
+					Changes: driver.ChangedRevs{rev},
+				},
+				{
+					ID:      "doc4",
+					Seq:     "4",
+					Changes: driver.ChangedRevs{rev4},
+				},
+			},
+			wantLastSeq: &[]string{"4"}[0],
+		}
+	})
+	tests.Add("filter=_selector, raw JSON selector on _id, with docs", func(t *testing.T) any {
+		d := newDB(t)
+		_ = d.tPut("a", map[string]interface{}{})
+		rev := d.tPut("b", map[string]interface{}{"foo": "bar"})
+		_ = d.tPut("c", map[string]interface{}{})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"filter":       "_selector",
+				"selector":     json.RawMessage(`{"_id":{"$gt":"a"},"foo":"bar"}`),
+				"include_docs": true,
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "b",
+					Seq:     "2",
+					Changes: driver.ChangedRevs{rev},
+					Doc:     []byte(`{"_id":"b","_rev":"` + rev + `","foo":"bar"}`),
+				},
+			},
+			wantLastSeq: &[]string{"2"}[0],
+		}
+	})
+	tests.Add("filter=_selector, deleted documents", func(t *testing.T) any {
+		d := newDB(t)
+		rev := d.tPut("doc1", map[string]interface{}{"type": "post"})
+		rev2 := d.tDelete("doc1", kivik.Rev(rev))
+		_ = d.tPut("doc2", map[string]interface{}{"type": "post"})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"filter":   "_selector",
+				"selector": map[string]interface{}{"_deleted": true},
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "doc1",
+					Seq:     "2",
+					Deleted: true,
+					Changes: driver.ChangedRevs{rev2},
+				},
+			},
+			wantLastSeq: &[]string{"3"}[0],
+		}
+	})
+	tests.Add("filter=_design", func(t *testing.T) any {
+		d := newDB(t)
+		_ = d.tPut("doc1", map[string]interface{}{})
+		rev := d.tPut("_design/foo", map[string]interface{}{})
+		_ = d.tPut("doc2", map[string]interface{}{})
+		// The underscore must not be treated as a LIKE wildcard.
+		_ = d.tPut("xdesign/foo", map[string]interface{}{})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"filter": "_design",
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "_design/foo",
+					Seq:     "2",
+					Changes: driver.ChangedRevs{rev},
+				},
+			},
+			wantLastSeq: &[]string{"2"}[0],
+		}
+	})
 	/*
 		TODO:
 		- Options
 			- conflicts
-			- feed
-				- normal
-				- longpoll
-				- continuous
 			- att_encoding_info
 			- style
-			- timeout
 	*/
 
+	tests.Add("feed=continuous, timeout=0 returns history", func(t *testing.T) interface{} {
+		d := newDB(t)
+		rev := d.tPut("doc1", map[string]string{"foo": "bar"})
+		rev2 := d.tPut("doc2", map[string]string{"foo": "bar"})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"feed":    "continuous",
+				"timeout": 0,
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "doc1",
+					Seq:     "1",
+					Changes: driver.ChangedRevs{rev},
+				},
+				{
+					ID:      "doc2",
+					Seq:     "2",
+					Changes: driver.ChangedRevs{rev2},
+				},
+			},
+			wantLastSeq: &[]string{"2"}[0],
+			wantETag:    &[]string{""}[0],
+		}
+	})
+	tests.Add("feed=continuous, limit", func(t *testing.T) interface{} {
+		d := newDB(t)
+		rev := d.tPut("doc1", map[string]string{"foo": "bar"})
+		_ = d.tPut("doc2", map[string]string{"foo": "bar"})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"feed":      "continuous",
+				"heartbeat": true,
+				"limit":     1,
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "doc1",
+					Seq:     "1",
+					Changes: driver.ChangedRevs{rev},
+				},
+			},
+			wantLastSeq: &[]string{"1"}[0],
+		}
+	})
+	tests.Add("feed=eventsource, since and doc_ids filter", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("doc1", map[string]string{"foo": "bar"})
+		_ = d.tPut("doc2", map[string]string{"foo": "bar"})
+		rev3 := d.tPut("doc3", map[string]string{"foo": "bar"})
+		_ = d.tPut("doc4", map[string]string{"foo": "bar"})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"feed":    "eventsource",
+				"timeout": "0",
+				"since":   "1",
+				"filter":  "_doc_ids",
+				"doc_ids": []interface{}{"doc1", "doc3"},
+			}),
+			wantChanges: []driver.Change{
+				{
+					ID:      "doc3",
+					Seq:     "3",
+					Changes: driver.ChangedRevs{rev3},
+				},
+			},
+			wantLastSeq: &[]string{"3"}[0],
+		}
+	})
+	tests.Add("feed=continuous, missing filter function", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{})
+
+		return test{
+			db: d,
+			options: kivik.Params(map[string]interface{}{
+				"feed":   "continuous",
+				"filter": "foo/bar",
+			}),
+			wantErr:    "design doc '_design/foo' missing filter function 'bar'",
+			wantStatus: http.StatusNotFound,
+		}
+	})
+	tests.Add("feed=continuous, invalid timeout", test{
+		options: kivik.Params(map[string]interface{}{
+			"feed":    "continuous",
+			"timeout": "chicken",
+		}),
+		wantErr:    "invalid value for 'timeout': chicken",
+		wantStatus: http.StatusBadRequest,
+	})
+	tests.Add("feed=continuous, invalid heartbeat", test{
+		options: kivik.Params(map[string]interface{}{
+			"feed":      "continuous",
+			"heartbeat": "chicken",
+		}),
+		wantErr:    "invalid value for 'heartbeat': chicken",
+		wantStatus: http.StatusBadRequest,
+	})
+
 	tests.Run(t, func(t *testing.T, tt test) {
 		t.Parallel()
 		dbc := tt.db
@@ -879,6 +1129,69 @@ loop:
 	}
 }
 
+func TestDBChanges_longpoll_selector(t *testing.T) {
+	t.Parallel()
+	db := newDB(t)
+	_ = db.tPut("doc1", map[string]string{"type": "post"})
+
+	feed, err := db.Changes(context.Background(), kivik.Params(map[string]interface{}{
+		"feed":     "longpoll",
+		"since":    "now",
+		"filter":   "_selector",
+		"selector": map[string]interface{}{"type": "post"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to start changes feed: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = feed.Close()
+	})
+
+	revs := make(chan string, 1)
+	go func() {
+		for i, docType := range []string{"comment", "post"} {
+			time.Sleep(50 * time.Millisecond)
+			rev, err := db.Put(context.Background(), fmt.Sprintf("doc%d", i+2), map[string]string{"type": docType}, mock.NilOption)
+			if err != nil {
+				panic(fmt.Sprintf("Failed to put doc: %s", err))
+			}
+			if docType == "post" {
+				revs <- rev
+			}
+		}
+	}()
+
+	var got []driver.Change
+loop:
+	for {
+		change := driver.Change{}
+		err := feed.Next(&change)
+		switch err {
+		case io.EOF:
+			break loop
+		case nil:
+			// continue
+		default:
+			t.Fatalf("iteration failed: %s", err)
+		}
+		got = append(got, change)
+	}
+
+	wantChanges := []driver.Change{
+		{
+			ID:      "doc3",
+			Seq:     "3",
+			Changes: driver.ChangedRevs{<-revs},
+		},
+	}
+	if d := cmp.Diff(wantChanges, got); d != "" {
+		t.Errorf("Unexpected changes:\n%s", d)
+	}
+	if lastSeq := feed.LastSeq(); lastSeq != "3" {
+		t.Errorf("Unexpected LastSeq: %s", lastSeq)
+	}
+}
+
 func TestDBChanges_longpoll_include_docs(t *testing.T) {
 	t.Parallel()
 	db := newDB(t)
@@ -1137,7 +1450,7 @@ func Test_normal_changes_query(t *testing.T) {
 		var result row
 		if err := changes.rows.Scan(
 			&result.ID, &result.Seq, &result.Deleted, &result.Rev, &result.Doc,
-			&result.AttachmentCount, &result.Filename, discard{}, discard{}, discard{}, discard{}, discard{},
+			&result.AttachmentCount, &result.Filename, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1192,7 +1505,7 @@ func Test_normal_changes_query_without_docs(t *testing.T) {
 		var result row
 		if err := changes.rows.Scan(
 			&result.ID, &result.Seq, &result.Deleted, &result.Rev, &result.Doc,
-			&result.AttachmentCount, &result.Filename, discard{}, discard{}, discard{}, discard{}, discard{},
+			&result.AttachmentCount, &result.Filename, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1252,7 +1565,7 @@ func Test_longpoll_changes_query(t *testing.T) {
 		var result row
 		if err := rows.Scan(
 			&result.ID, &result.Seq, &result.Deleted, &result.Rev, &result.Doc,
-			&result.Filename, discard{}, discard{}, discard{}, discard{}, discard{},
+			&result.Filename, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1313,7 +1626,7 @@ func Test_longpoll_changes_query_without_docs(t *testing.T) {
 		var result row
 		if err := rows.Scan(
 			&result.ID, &result.Seq, &result.Deleted, &result.Rev, &result.Doc,
-			&result.Filename, discard{}, discard{}, discard{}, discard{}, discard{},
+			&result.Filename, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1331,3 +1644,108 @@ func Test_longpoll_changes_query_without_docs(t *testing.T) {
 		t.Errorf("Unexpected rows:\n%s", d)
 	}
 }
+
+func TestDBChanges_continuous(t *testing.T) {
+	t.Parallel()
+	db := newDB(t)
+	_ = db.tPut("doc1", map[string]string{"foo": "bar"})
+	_ = db.tPut("_design/foo", map[string]interface{}{
+		"filters": map[string]string{
+			"odd": `function(doc) { return doc.odd === true; }`,
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	feed, err := db.Changes(ctx, kivik.Params(map[string]interface{}{
+		"feed":      "continuous",
+		"since":     "now",
+		"heartbeat": 1000,
+		"filter":    "foo/odd",
+	}))
+	if err != nil {
+		t.Fatalf("Failed to start changes feed: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = feed.Close()
+	})
+
+	revs := make(chan string, 2)
+	go func() {
+		for i, doc := range []map[string]interface{}{
+			{"odd": true},
+			{"odd": false},
+			{"odd": true},
+		} {
+			time.Sleep(50 * time.Millisecond)
+			rev, err := db.Put(context.Background(), fmt.Sprintf("doc%d", i+2), doc, mock.NilOption)
+			if err != nil {
+				panic(fmt.Sprintf("Failed to put doc: %s", err))
+			}
+			if doc["odd"] == true {
+				revs <- rev
+			}
+		}
+	}()
+
+	var got []driver.Change
+	for len(got) < 2 {
+		change := driver.Change{}
+		if err := feed.Next(&change); err != nil {
+			t.Fatalf("iteration failed: %s", err)
+		}
+		got = append(got, change)
+	}
+
+	wantChanges := []driver.Change{
+		{
+			ID:      "doc2",
+			Seq:     "3",
+			Changes: driver.ChangedRevs{<-revs},
+		},
+		{
+			ID:      "doc4",
+			Seq:     "5",
+			Changes: driver.ChangedRevs{<-revs},
+		},
+	}
+	if d := cmp.Diff(wantChanges, got); d != "" {
+		t.Errorf("Unexpected changes:\n%s", d)
+	}
+	if lastSeq := feed.LastSeq(); lastSeq != "5" {
+		t.Errorf("Unexpected LastSeq: %s", lastSeq)
+	}
+
+	// With a heartbeat, the feed stays open until the context is cancelled.
+	cancel()
+	err = feed.Next(&driver.Change{})
+	if !testy.ErrorMatches("context canceled", err) {
+		t.Errorf("Unexpected error from Next(): %s", err)
+	}
+}
+
+func TestDBChanges_continuous_timeout(t *testing.T) {
+	t.Parallel()
+	db := newDB(t)
+
+	feed, err := db.Changes(context.Background(), kivik.Params(map[string]interface{}{
+		"feed":    "continuous",
+		"timeout": 100,
+	}))
+	if err != nil {
+		t.Fatalf("Failed to start changes feed: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = feed.Close()
+	})
+
+	start := time.Now()
+	err = feed.Next(&driver.Change{})
+	if err != io.EOF {
+		t.Errorf("Unexpected error from Next(): %s", err)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Errorf("Changes feed returned too quickly")
+	}
+}
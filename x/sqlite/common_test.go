@@ -81,6 +81,13 @@ func (tdb *testDB) tDelete(docID string, options ...driver.Options) string { //n
 	return rev
 }
 
+func (tdb *testDB) tCreateIndex(ddoc, name, index string) {
+	tdb.t.Helper()
+	if err := tdb.CreateIndex(context.Background(), ddoc, name, index, mock.NilOption); err != nil {
+		tdb.t.Fatalf("Failed to create index: %s", err)
+	}
+}
+
 type multiOptions []kivik.Option
 
 var _ kivik.Option = (multiOptions)(nil)
@@ -95,7 +102,8 @@ func (o multiOptions) Apply(t interface{}) {
 
 // newDB creates a new driver.DB instance backed by an in-memory SQLite database,
 // and registers a cleanup function to close the database when the test is done.
-func newDB(t *testing.T) *testDB {
+// clientOptions are passed to the driver's NewClient.
+func newDB(t *testing.T, clientOptions ...kivik.Option) *testDB {
 	var dsn string
 	if os.Getenv("KEEP_TEST_DB") != "" {
 		file, err := os.CreateTemp("", "kivik-sqlite-test-*.db")
@@ -116,9 +124,9 @@ func newDB(t *testing.T) *testDB {
 	d := drv{}
 	buf := &bytes.Buffer{}
 	logger := log.New(buf, "", 0)
-	options := OptionLogger(logger)
+	options := append(multiOptions{OptionLogger(logger)}, clientOptions...)
 	if os.Getenv("QUERY_LOG") != "" {
-		options = multiOptions{options, OptionQueryLogger(log.New(os.Stderr, "", 0))}
+		options = append(options, OptionQueryLogger(log.New(os.Stderr, "", 0)))
 	}
 	client, err := d.NewClient(dsn, options)
 	if err != nil {
@@ -0,0 +1,364 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kivik/kivik/v4"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+// compactions tracks the databases currently being compacted, so that it can
+// be reported by [db.Stats].
+type compactions struct {
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// start marks name as being compacted. It returns false if a compaction of
+// name is already running.
+func (c *compactions) start(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running[name] {
+		return false
+	}
+	if c.running == nil {
+		c.running = map[string]bool{}
+	}
+	c.running[name] = true
+	return true
+}
+
+func (c *compactions) done(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, name)
+}
+
+func (c *compactions) isRunning(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running[name]
+}
+
+type optionVacuum bool
+
+var _ kivik.Option = optionVacuum(false)
+
+func (o optionVacuum) Apply(target interface{}) {
+	if client, ok := target.(*client); ok {
+		client.vacuum = bool(o)
+	}
+}
+
+// OptionVacuum causes [kivik.DB.Compact] to VACUUM the SQLite database after
+// compaction, to return the freed space to the operating system. As this
+// rebuilds the entire SQLite database file, including all other Kivik
+// databases stored in it, it may be slow for large files.
+func OptionVacuum(vacuum bool) kivik.Option {
+	return optionVacuum(vacuum)
+}
+
+// Compact removes the bodies of all non-leaf revisions, stems the revision
+// tree of each document to the database's revs_limit, removes attachments
+// which are no longer referenced, and drops the map tables and Mango indexes
+// of outdated design document revisions. Compaction completes before Compact
+// returns. If a compaction of the database is already running, Compact returns
+// immediately.
+//
+// Revision bodies still referenced by a view index are retained until the
+// index has been updated, so that stale view results remain available.
+func (d *db) Compact(ctx context.Context) error {
+	if err := d.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	if !d.compactions.start(d.name) {
+		return nil
+	}
+	defer d.compactions.done(d.name)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := d.viewCleanup(ctx, tx, ""); err != nil {
+		return err
+	}
+
+	limit, err := d.revsLimit(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := d.stemRevs(ctx, tx, "", limit); err != nil {
+		return err
+	}
+
+	maps, err := d.viewTables(ctx, tx, "{{ .Map }}")
+	if err != nil {
+		return err
+	}
+	var indexed strings.Builder
+	for _, table := range maps {
+		indexed.WriteString(d.query(`
+			AND NOT EXISTS (
+				SELECT 1
+				FROM ` + table + ` AS view
+				WHERE view.id = {{ .Docs }}.id
+					AND view.rev = {{ .Docs }}.rev
+					AND view.rev_id = {{ .Docs }}.rev_id
+			)`))
+	}
+	if _, err := tx.ExecContext(ctx, d.query(`
+		DELETE FROM {{ .Docs }}
+		WHERE EXISTS (
+			SELECT 1
+			FROM {{ .Revs }} AS child
+			WHERE child.id = {{ .Docs }}.id
+				AND child.parent_rev = {{ .Docs }}.rev
+				AND child.parent_rev_id = {{ .Docs }}.rev_id
+		)
+	`)+indexed.String()); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, d.query(`
+		DELETE FROM {{ .Attachments }}
+		WHERE pk NOT IN (
+			SELECT pk FROM {{ .AttachmentsBridge }}
+		)
+	`)); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, d.query(`
+		DELETE FROM {{ .AttachmentData }}
+		WHERE hash NOT IN (
+			SELECT hash FROM {{ .Attachments }}
+		)
+	`)); err != nil {
+		return err
+	}
+
+	if err := d.collectBlobs(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if d.vacuum {
+		_, err = d.db.ExecContext(ctx, "VACUUM")
+	}
+	return err
+}
+
+// CompactView drops the map tables of outdated revisions of the design
+// document ddocID.
+func (d *db) CompactView(ctx context.Context, ddocID string) error {
+	if ddocID == "" {
+		return &internal.Error{Status: http.StatusBadRequest, Message: "missing design document ID"}
+	}
+	id := "_design/" + strings.TrimPrefix(ddocID, "_design/")
+	if err := d.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, d.query(`
+		SELECT EXISTS (SELECT 1 FROM {{ .Revs }} WHERE id = $1)
+	`), id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return &internal.Error{Status: http.StatusNotFound, Message: "missing"}
+	}
+	if err := d.viewCleanup(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ViewCleanup drops the map tables and Mango indexes of outdated design
+// document revisions.
+func (d *db) ViewCleanup(ctx context.Context) error {
+	if err := d.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := d.viewCleanup(ctx, tx, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// viewCleanup drops the map tables of all non-leaf design document revisions,
+// and the SQLite indexes of their Mango indexes which are no longer used, and
+// removes their functions from the design table. If ddoc is not empty,
+// only the revisions of that design document are cleaned up.
+func (d *db) viewCleanup(ctx context.Context, tx *sql.Tx, ddoc string) error {
+	const staleWhere = `
+		WHERE ($1 = '' OR design.id = $1)
+			AND EXISTS (
+				SELECT 1
+				FROM {{ .Revs }} AS child
+				WHERE child.id = design.id
+					AND child.parent_rev = design.rev
+					AND child.parent_rev_id = design.rev_id
+			)
+	`
+	rows, err := tx.QueryContext(ctx, d.query(`
+		SELECT DISTINCT design.id, design.rev, design.rev_id, design.func_name, design.language, design.func_body
+		FROM {{ .Design }} AS design
+	`+staleWhere+`
+			AND design.func_type = 'map'
+	`), ddoc)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var drop []string
+	// staleIndexes holds the SQLite indexes of the Mango indexes defined by
+	// the stale revisions.
+	staleIndexes := map[string]bool{}
+	for rows.Next() {
+		var (
+			id, name       string
+			rev            revision
+			language, body *string
+		)
+		if err := rows.Scan(&id, &rev.rev, &rev.id, &name, &language, &body); err != nil {
+			return err
+		}
+		drop = append(drop,
+			d.ddocQuery(id, name, rev.String(), "{{ .Map }}"),
+			d.ddocQuery(id, name, rev.String(), "{{ .Reduce }}"),
+		)
+		if language != nil && *language == "query" && body != nil {
+			if fields, err := parseIndexFields(json.RawMessage(*body)); err == nil {
+				staleIndexes[d.sqlIndexName(fields)] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	for _, table := range drop {
+		if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, d.query(`
+		DELETE FROM {{ .Design }}
+		WHERE rowid IN (
+			SELECT design.rowid
+			FROM {{ .Design }} AS design
+	`+staleWhere+`
+		)
+	`), ddoc); err != nil {
+		return err
+	}
+	return d.dropStaleMangoIndexes(ctx, tx, staleIndexes)
+}
+
+// dropStaleMangoIndexes drops the named SQLite indexes, except for those
+// which still back a current Mango index.
+func (d *db) dropStaleMangoIndexes(ctx context.Context, tx *sql.Tx, names map[string]bool) error {
+	if len(names) == 0 {
+		return nil
+	}
+	indexes, err := d.mangoIndexes(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		delete(names, d.sqlIndexName(idx.fields))
+	}
+	drop := make([]string, 0, len(names))
+	for name := range names {
+		drop = append(drop, name)
+	}
+	sort.Strings(drop)
+	for _, name := range drop {
+		if _, err := tx.ExecContext(ctx, "DROP INDEX IF EXISTS "+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// viewTables returns the quoted names of the existing tables of the database's
+// views, of the kind given by the template name, i.e. "{{ .Map }}" or
+// "{{ .Reduce }}".
+func (d *db) viewTables(ctx context.Context, tx *sql.Tx, name string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, d.query(`
+		SELECT DISTINCT id, rev, rev_id, func_name
+		FROM {{ .Design }}
+		WHERE func_type = 'map'
+	`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var (
+			ddoc, view string
+			rev        revision
+		)
+		if err := rows.Scan(&ddoc, &rev.rev, &rev.id, &view); err != nil {
+			return nil, err
+		}
+		tables = append(tables, d.ddocQuery(ddoc, view, rev.String(), name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+
+	existing := tables[:0]
+	for _, table := range tables {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'table' AND name = $1)
+		`, unquote(table)).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			existing = append(existing, table)
+		}
+	}
+	return existing, nil
+}
@@ -0,0 +1,224 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func (tdb *testDB) count(query string, args ...interface{}) int {
+	tdb.t.Helper()
+	var n int
+	if err := tdb.underlying().QueryRow(query, args...).Scan(&n); err != nil {
+		tdb.t.Fatal(err)
+	}
+	return n
+}
+
+func (tdb *testDB) tableExists(name string) bool {
+	tdb.t.Helper()
+	return tdb.count(`SELECT COUNT(*) FROM sqlite_schema WHERE type = 'table' AND name = $1`, unquote(name)) > 0
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	rev := d.tPut("foo", map[string]interface{}{"_attachments": newAttachments().add("old.txt", "old")})
+	rev = d.tPut("foo", map[string]interface{}{"_rev": rev, "value": 2})
+	_ = d.tPut("bar", map[string]interface{}{"value": 1})
+
+	if err := d.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := d.count(`SELECT COUNT(*) FROM test WHERE id = 'foo'`); n != 1 {
+		t.Errorf("Expected 1 revision body for foo, got %d", n)
+	}
+	if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 2 {
+		t.Errorf("Expected the revision tree to be kept, got %d revisions", n)
+	}
+	if n := d.count(`SELECT COUNT(*) FROM test_attachments`); n != 0 {
+		t.Errorf("Expected orphaned attachments to be removed, found %d", n)
+	}
+	if n := d.count(`SELECT COUNT(*) FROM test_attachment_data`); n != 0 {
+		t.Errorf("Expected orphaned attachment data to be removed, found %d", n)
+	}
+	doc, err := d.Get(context.Background(), "foo", kivik.Param("revs_info", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = doc.Body.Close()
+	if doc.Rev != rev {
+		t.Errorf("Unexpected rev after compaction: %s", doc.Rev)
+	}
+}
+
+func TestCompactKeepsIndexedRevisions(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	_ = d.tPut("_design/foo", map[string]interface{}{
+		"views": map[string]interface{}{
+			"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id, null); }"},
+		},
+	})
+	rev := d.tPut("foo", map[string]interface{}{"value": 1})
+	rows, err := d.Query(context.Background(), "_design/foo", "_view/bar", mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rows.Close()
+	_ = d.tPut("foo", map[string]interface{}{"_rev": rev, "value": 2})
+
+	if err := d.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.count(`SELECT COUNT(*) FROM test WHERE id = 'foo'`); n != 2 {
+		t.Errorf("Expected the indexed revision to be kept, got %d revision bodies", n)
+	}
+}
+
+func TestViewCleanup(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	ddoc := func(rev string) map[string]interface{} {
+		doc := map[string]interface{}{
+			"views": map[string]interface{}{
+				"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id, null); }"},
+			},
+		}
+		if rev != "" {
+			doc["_rev"] = rev
+			doc["language"] = "javascript"
+		}
+		return doc
+	}
+	oldRev := d.tPut("_design/foo", ddoc(""))
+	newRev := d.tPut("_design/foo", ddoc(oldRev))
+	oldTable := d.DB.(*db).ddocQuery("_design/foo", "bar", oldRev, "{{ .Map }}")
+	newTable := d.DB.(*db).ddocQuery("_design/foo", "bar", newRev, "{{ .Map }}")
+	if !d.tableExists(oldTable) || !d.tableExists(newTable) {
+		t.Fatal("Expected both map tables to exist before cleanup")
+	}
+
+	if err := d.ViewCleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d.tableExists(oldTable) {
+		t.Error("Old map table was not dropped")
+	}
+	if !d.tableExists(newTable) {
+		t.Error("Current map table was dropped")
+	}
+	if n := d.count(`SELECT COUNT(*) FROM test_design WHERE rev_id = $1`, oldRev[2:]); n != 0 {
+		t.Errorf("Expected old design functions to be removed, found %d", n)
+	}
+}
+
+func TestViewCleanupDropsSQLiteIndexes(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	d.tCreateIndex("a", "a", `{"fields":["foo"]}`)
+	d.tCreateIndex("b", "b", `{"fields":["foo"]}`)
+	d.tCreateIndex("c", "c", `{"fields":["bar"]}`)
+	for _, id := range []string{"_design/a", "_design/c"} {
+		doc, err := d.Get(context.Background(), id, mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = doc.Body.Close()
+		_ = d.tDelete(id, kivik.Rev(doc.Rev))
+	}
+	count := func(field string) int {
+		t.Helper()
+		return d.count(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND sql LIKE '%$."' || $1 || '"%'`, field)
+	}
+	if count("foo") != 1 || count("bar") != 1 {
+		t.Fatal("Expected SQLite indexes to be kept until cleanup")
+	}
+
+	if err := d.ViewCleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count("foo") != 1 {
+		t.Error("SQLite index dropped while still in use")
+	}
+	if count("bar") != 0 {
+		t.Error("SQLite index of a deleted Mango index was not dropped")
+	}
+}
+
+func TestCompactView(t *testing.T) {
+	t.Parallel()
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		err := d.CompactView(context.Background(), "foo")
+		if status := kivik.HTTPStatus(err); status != http.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+	t.Run("drops old map tables", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		views := map[string]interface{}{
+			"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id, null); }"},
+		}
+		oldRev := d.tPut("_design/foo", map[string]interface{}{"views": views})
+		_ = d.tPut("_design/foo", map[string]interface{}{"_rev": oldRev, "views": views, "language": "javascript"})
+		otherRev := d.tPut("_design/other", map[string]interface{}{"views": views})
+		_ = d.tPut("_design/other", map[string]interface{}{"_rev": otherRev, "views": views, "language": "javascript"})
+
+		if err := d.CompactView(context.Background(), "foo"); err != nil {
+			t.Fatal(err)
+		}
+		if d.tableExists(d.DB.(*db).ddocQuery("_design/foo", "bar", oldRev, "{{ .Map }}")) {
+			t.Error("Old map table was not dropped")
+		}
+		if d.tableExists(d.DB.(*db).ddocQuery("_design/foo", "bar", oldRev, "{{ .Reduce }}")) {
+			t.Error("Old reduce cache table was not dropped")
+		}
+		if !d.tableExists(d.DB.(*db).ddocQuery("_design/other", "bar", otherRev, "{{ .Map }}")) {
+			t.Error("Map table of another design document was dropped")
+		}
+	})
+}
+
+func TestStatsCompactRunning(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	compactions := d.DB.(*db).compactions
+	compactions.start("test")
+	stats, err := d.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.CompactRunning {
+		t.Error("Expected compaction to be reported as running")
+	}
+	compactions.done("test")
+	stats, err = d.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CompactRunning {
+		t.Error("Expected compaction to be reported as finished")
+	}
+}
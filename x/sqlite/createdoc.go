@@ -24,7 +24,8 @@ import (
 	kerrors "github.com/go-kivik/kivik/v4/int/errors"
 )
 
-func (d *db) CreateDoc(ctx context.Context, doc interface{}, _ driver.Options) (string, string, error) {
+func (d *db) CreateDoc(ctx context.Context, doc interface{}, options driver.Options) (string, string, error) {
+	d = d.withUserCtx(options)
 	data, err := prepareDoc("", doc)
 	if err != nil {
 		return "", "", err
@@ -39,8 +40,17 @@ func (d *db) CreateDoc(ctx context.Context, doc interface{}, _ driver.Options) (
 	}
 	defer tx.Rollback()
 
+	rev, err := d.createDoc(ctx, tx, data)
+	if err != nil {
+		return "", "", err
+	}
+	return data.ID, rev, d.commit(ctx, tx)
+}
+
+// createDoc stores a new document within tx, and returns its revision.
+func (d *db) createDoc(ctx context.Context, tx *sql.Tx, data *docData) (string, error) {
 	var exists bool
-	err = tx.QueryRowContext(ctx, d.query(`
+	err := tx.QueryRowContext(ctx, d.query(`
 		SELECT EXISTS (
 			SELECT 1
 			FROM {{ .Revs }} AS rev
@@ -52,10 +62,14 @@ func (d *db) CreateDoc(ctx context.Context, doc interface{}, _ driver.Options) (
 		)
 	`), data.ID).Scan(&exists)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return "", "", err
+		return "", err
 	}
 	if exists {
-		return "", "", &kerrors.Error{Status: http.StatusConflict, Message: "document update conflict"}
+		return "", &kerrors.Error{Status: http.StatusConflict, Message: "document update conflict"}
+	}
+
+	if err := d.validateDocUpdate(ctx, tx, data, revision{}); err != nil {
+		return "", err
 	}
 
 	rev := revision{rev: 1, id: data.RevID()}
@@ -64,7 +78,7 @@ func (d *db) CreateDoc(ctx context.Context, doc interface{}, _ driver.Options) (
 		VALUES ($1, 1, $2)
 	`), data.ID, rev.id)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
 	_, err = tx.ExecContext(ctx, d.query(`
@@ -72,12 +86,12 @@ func (d *db) CreateDoc(ctx context.Context, doc interface{}, _ driver.Options) (
 		VALUES ($1, 1, $2, $3, $4, $5)
 	`), data.ID, rev.id, data.Doc, data.MD5sum, data.Deleted)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
 	if err := d.createDocAttachments(ctx, data, tx, rev, nil); err != nil {
-		return "", "", err
+		return "", err
 	}
 
-	return data.ID, rev.String(), tx.Commit()
+	return rev.String(), nil
 }
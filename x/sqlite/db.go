@@ -16,14 +16,29 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"time"
 
 	"github.com/go-kivik/kivik/v4/driver"
 )
 
 type db struct {
-	db     *sql.DB
-	name   string
-	logger *log.Logger
+	db                *sql.DB
+	name              string
+	logger            *log.Logger
+	compactions       *compactions
+	notifier          *notifier
+	mappers           *mapperPools
+	vacuum            bool
+	parallelism       int
+	funcTimeout       time.Duration
+	compressibleTypes []string
+	// attachmentDir is the directory in which attachments larger than
+	// attachmentThreshold are stored, as set by [OptionAttachmentDir].
+	attachmentDir       string
+	attachmentThreshold int64
+	// user is the user on whose behalf requests are made, as set by
+	// [OptionUserCtx], or nil for server admin.
+	user *userCtx
 }
 
 var (
@@ -33,9 +48,19 @@ var (
 
 func (c *client) newDB(name string) *db {
 	return &db{
-		db:     c.db,
-		name:   name,
-		logger: c.logger,
+		db:                c.db,
+		name:              name,
+		logger:            c.logger,
+		compactions:       c.compactions,
+		notifier:          c.notifier,
+		mappers:           c.mappers,
+		vacuum:            c.vacuum,
+		parallelism:       c.parallelism,
+		funcTimeout:       c.funcTimeout,
+		compressibleTypes: c.compressibleTypes,
+
+		attachmentDir:       c.attachmentDir,
+		attachmentThreshold: c.attachmentThreshold,
 	}
 }
 
@@ -49,42 +74,6 @@ func (d *db) Ping(ctx context.Context) error {
 
 /* -- stub methods -- */
 
-func (db) Stats(context.Context) (*driver.DBStats, error) {
-	return nil, nil
-}
-
-func (db) Compact(context.Context) error {
-	return nil
-}
-
-func (db) CompactView(context.Context, string) error {
-	return nil
-}
-
-func (db) ViewCleanup(context.Context) error {
-	return nil
-}
-
-func (db) BulkDocs(context.Context, []interface{}, driver.Options) ([]driver.BulkResult, error) {
-	return nil, nil
-}
-
 func (db) Copy(context.Context, string, string, driver.Options) (string, error) {
 	return "", nil
 }
-
-func (db) CreateIndex(context.Context, string, string, interface{}, driver.Options) error {
-	return nil
-}
-
-func (db) GetIndexes(context.Context, driver.Options) ([]driver.Index, error) {
-	return nil, nil
-}
-
-func (db) DeleteIndex(context.Context, string, string, driver.Options) error {
-	return nil
-}
-
-func (db) Explain(context.Context, interface{}, driver.Options) (*driver.QueryPlan, error) {
-	return nil, nil
-}
@@ -0,0 +1,191 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// dbUpdatesTable is the name of the global table which records database
+// events. The leading underscore ensures it can't collide with the tables of a
+// database.
+const dbUpdatesTable = "_db_updates"
+
+const (
+	dbUpdateCreated = "created"
+	dbUpdateUpdated = "updated"
+	dbUpdateDeleted = "deleted"
+)
+
+// dbUpdatesSchema creates the global DB updates table. As with CouchDB's
+// _global_changes database, only the most recent event of each database is
+// kept. AUTOINCREMENT ensures the sequence numbers of removed events are never
+// reused.
+const dbUpdatesSchema = `
+	CREATE TABLE IF NOT EXISTS "` + dbUpdatesTable + `" (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		db_name TEXT NOT NULL UNIQUE,
+		type TEXT CHECK (type IN ('created', 'updated', 'deleted')) NOT NULL
+	)
+`
+
+// recordDBUpdate records an event of type typ for the named database, as part
+// of tx.
+func recordDBUpdate(ctx context.Context, tx *sql.Tx, name, typ string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO "`+dbUpdatesTable+`" (db_name, type)
+		VALUES ($1, $2)
+	`, name, typ)
+	return err
+}
+
+var _ driver.DBUpdater = (*client)(nil)
+
+func (c *client) DBUpdates(ctx context.Context, options driver.Options) (driver.DBUpdates, error) {
+	opts := newOpts(options)
+	feed, err := opts.feed()
+	if err != nil {
+		return nil, err
+	}
+	sinceNow, since, err := opts.since()
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := opts.timeout()
+	if err != nil {
+		return nil, err
+	}
+	heartbeat, err := opts.heartbeat()
+	if err != nil {
+		return nil, err
+	}
+	u := &dbUpdates{
+		c:         c,
+		ctx:       ctx,
+		feed:      feed,
+		timeout:   timeout,
+		heartbeat: heartbeat,
+	}
+	switch {
+	case sinceNow:
+		if err := c.db.QueryRowContext(ctx, `
+			SELECT COALESCE(MAX(seq), 0)
+			FROM "`+dbUpdatesTable+`"
+		`).Scan(&u.since); err != nil {
+			return nil, err
+		}
+	case since != nil:
+		u.since = *since
+	}
+	// Read the first batch eagerly, so that errors are reported immediately.
+	if err := u.nextBatch(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// dbUpdates serves the DB updates feed. A normal feed returns the events
+// recorded so far. A longpoll feed waits for the first event, if there are
+// none yet. A continuous feed waits for events until the timeout expires
+// without one, or indefinitely with a heartbeat.
+type dbUpdates struct {
+	c         *client
+	ctx       context.Context
+	feed      string
+	since     uint64
+	sent      int
+	timeout   time.Duration
+	heartbeat bool
+	batch     []driver.DBUpdate
+	notified  <-chan struct{}
+}
+
+var (
+	_ driver.DBUpdates = (*dbUpdates)(nil)
+	_ driver.LastSeqer = (*dbUpdates)(nil)
+)
+
+func (u *dbUpdates) nextBatch() error {
+	// Subscribe before querying, so that no event can be missed in between.
+	u.notified = u.c.notifier.wait(dbUpdatesTable)
+	rows, err := u.c.db.QueryContext(u.ctx, `
+		SELECT seq, db_name, type
+		FROM "`+dbUpdatesTable+`"
+		WHERE seq > $1
+		ORDER BY seq
+	`, u.since)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var update driver.DBUpdate
+		if err := rows.Scan(&update.Seq, &update.DBName, &update.Type); err != nil {
+			return err
+		}
+		u.batch = append(u.batch, update)
+	}
+	return rows.Err()
+}
+
+func (u *dbUpdates) Next(update *driver.DBUpdate) error {
+	for len(u.batch) == 0 {
+		if u.feed == feedNormal || (u.feed == feedLongpoll && u.sent > 0) {
+			return io.EOF
+		}
+		if err := u.wait(); err != nil {
+			return err
+		}
+		if err := u.nextBatch(); err != nil {
+			return err
+		}
+	}
+	*update, u.batch = u.batch[0], u.batch[1:]
+	u.since, _ = strconv.ParseUint(update.Seq, 10, 64)
+	u.sent++
+	return nil
+}
+
+// wait blocks until an event is recorded, the timeout expires, or the context
+// is cancelled.
+func (u *dbUpdates) wait() error {
+	var timeout <-chan time.Time
+	if !u.heartbeat {
+		timer := time.NewTimer(u.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-u.ctx.Done():
+		return u.ctx.Err()
+	case <-timeout:
+		return io.EOF
+	case <-u.notified:
+		return nil
+	}
+}
+
+func (u *dbUpdates) Close() error {
+	u.batch = nil
+	return nil
+}
+
+func (u *dbUpdates) LastSeq() (string, error) {
+	return strconv.FormatUint(u.since, 10), nil
+}
@@ -0,0 +1,265 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+// newUpdatesClient returns a client backed by an in-memory SQLite database,
+// in which the database foo was created and written to, and the database bar
+// was created and deleted.
+func newUpdatesClient(t *testing.T) *client {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%x?mode=memory&cache=shared", md5.Sum([]byte(t.Name())))
+	c, err := drv{}.NewClient(dsn, mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"foo", "bar"} {
+		if err := c.CreateDB(ctx, name, mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := c.DB("foo", mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Put(ctx, "doc", map[string]string{}, mock.NilOption); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DestroyDB(ctx, "bar", mock.NilOption); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.(*client).db.Close()
+	})
+	return c.(*client)
+}
+
+func readDBUpdates(t *testing.T, updates driver.DBUpdates) []driver.DBUpdate {
+	t.Helper()
+	var got []driver.DBUpdate
+	for {
+		var update driver.DBUpdate
+		err := updates.Next(&update)
+		if errors.Is(err, io.EOF) {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("iteration failed: %s", err)
+		}
+		got = append(got, update)
+	}
+}
+
+func TestClientDBUpdates(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		options     driver.Options
+		want        []driver.DBUpdate
+		wantLastSeq string
+		wantStatus  int
+		wantErr     string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("normal feed", test{
+		want: []driver.DBUpdate{
+			{DBName: "foo", Type: "updated", Seq: "3"},
+			{DBName: "bar", Type: "deleted", Seq: "4"},
+		},
+		wantLastSeq: "4",
+	})
+	tests.Add("since", test{
+		options: kivik.Param("since", "3"),
+		want: []driver.DBUpdate{
+			{DBName: "bar", Type: "deleted", Seq: "4"},
+		},
+		wantLastSeq: "4",
+	})
+	tests.Add("since now", test{
+		options:     kivik.Param("since", "now"),
+		wantLastSeq: "4",
+	})
+	tests.Add("continuous feed times out", test{
+		options: kivik.Params(map[string]interface{}{
+			"feed":    "continuous",
+			"timeout": 10,
+		}),
+		want: []driver.DBUpdate{
+			{DBName: "foo", Type: "updated", Seq: "3"},
+			{DBName: "bar", Type: "deleted", Seq: "4"},
+		},
+		wantLastSeq: "4",
+	})
+	tests.Add("invalid feed", test{
+		options:    kivik.Param("feed", "foo"),
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "supported `feed` types: normal, longpoll, continuous, eventsource",
+	})
+	tests.Add("invalid since", test{
+		options:    kivik.Param("since", "foo"),
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "malformed sequence supplied in 'since' parameter: foo",
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		c := newUpdatesClient(t)
+		opts := tt.options
+		if opts == nil {
+			opts = mock.NilOption
+		}
+		updates, err := c.DBUpdates(context.Background(), opts)
+		if !testy.ErrorMatches(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if err != nil {
+			return
+		}
+		defer updates.Close()
+		if d := cmp.Diff(tt.want, readDBUpdates(t, updates)); d != "" {
+			t.Errorf("Unexpected updates:\n%s", d)
+		}
+		lastSeq, _ := updates.(driver.LastSeqer).LastSeq()
+		if lastSeq != tt.wantLastSeq {
+			t.Errorf("Unexpected last seq: %s", lastSeq)
+		}
+	})
+}
+
+func TestClientDBUpdates_longpoll(t *testing.T) {
+	t.Parallel()
+	c := newUpdatesClient(t)
+
+	updates, err := c.DBUpdates(context.Background(), kivik.Params(map[string]interface{}{
+		"feed":  "longpoll",
+		"since": "now",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updates.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if err := c.CreateDB(context.Background(), "baz", mock.NilOption); err != nil {
+			panic(err)
+		}
+		if err := c.CreateDB(context.Background(), "qux", mock.NilOption); err != nil {
+			panic(err)
+		}
+	}()
+
+	got := readDBUpdates(t, updates)
+	want := []driver.DBUpdate{
+		{DBName: "baz", Type: "created", Seq: "5"},
+	}
+	// The second database may or may not be created before the first event
+	// is read.
+	if len(got) > 1 {
+		want = append(want, driver.DBUpdate{DBName: "qux", Type: "created", Seq: "6"})
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("Unexpected updates:\n%s", d)
+	}
+}
+
+func TestClientDBUpdates_continuous(t *testing.T) {
+	t.Parallel()
+	c := newUpdatesClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	updates, err := c.DBUpdates(ctx, kivik.Params(map[string]interface{}{
+		"feed":      "continuous",
+		"since":     "now",
+		"heartbeat": true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updates.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		d, err := c.DB("foo", mock.NilOption)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := d.Put(context.Background(), "doc2", map[string]string{}, mock.NilOption); err != nil {
+			panic(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if err := c.DestroyDB(context.Background(), "foo", mock.NilOption); err != nil {
+			panic(err)
+		}
+	}()
+
+	var got []driver.DBUpdate
+	for len(got) < 2 {
+		var update driver.DBUpdate
+		if err := updates.Next(&update); err != nil {
+			t.Fatalf("iteration failed: %s", err)
+		}
+		got = append(got, update)
+	}
+	want := []driver.DBUpdate{
+		{DBName: "foo", Type: "updated", Seq: "5"},
+		{DBName: "foo", Type: "deleted", Seq: "6"},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("Unexpected updates:\n%s", d)
+	}
+
+	cancel()
+	if err := updates.Next(&driver.DBUpdate{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Unexpected error after cancellation: %v", err)
+	}
+}
+
+func TestClientAllDBs_excludes_db_updates(t *testing.T) {
+	t.Parallel()
+	c := newUpdatesClient(t)
+	dbs, err := c.AllDBs(context.Background(), mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range dbs {
+		if name == dbUpdatesTable {
+			t.Errorf("AllDBs returned %s", name)
+		}
+	}
+}
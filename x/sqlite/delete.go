@@ -21,6 +21,7 @@ import (
 )
 
 func (d *db) Delete(ctx context.Context, docID string, options driver.Options) (string, error) {
+	d = d.withUserCtx(options)
 	opts := newOpts(options)
 	options.Apply(opts)
 	optRev := opts.rev()
@@ -58,5 +59,5 @@ func (d *db) Delete(ctx context.Context, docID string, options driver.Options) (
 		return "", err
 	}
 
-	return r.String(), tx.Commit()
+	return r.String(), d.commit(ctx, tx)
 }
@@ -21,6 +21,7 @@ import (
 )
 
 func (d *db) DeleteAttachment(ctx context.Context, docID, filename string, options driver.Options) (string, error) {
+	d = d.withUserCtx(options)
 	opts := newOpts(options)
 	if opts.rev() == "" {
 		// Special case: No rev for DELETE is always a conflict, since you can't
@@ -91,7 +92,7 @@ func (d *db) DeleteAttachment(ctx context.Context, docID, filename string, optio
 		return "", err
 	}
 
-	return r.String(), tx.Commit()
+	return r.String(), d.commit(ctx, tx)
 }
 
 func attachmentsContains(attachments []string, filename string) bool {
@@ -15,9 +15,11 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"net/http"
 
 	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/x/sqlite/v4/js"
 )
 
 func (d *db) updateDesignDoc(ctx context.Context, tx *sql.Tx, rev revision, data *docData) error {
@@ -34,6 +36,23 @@ func (d *db) updateDesignDoc(ctx context.Context, tx *sql.Tx, rev revision, data
 	defer stmt.Close()
 
 	for name, view := range data.DesignFields.Views {
+		// views.lib holds CommonJS modules for map functions, not a view.
+		if name == "lib" {
+			continue
+		}
+		if view.Index != nil {
+			def, _ := json.Marshal(view.Index)
+			if _, err := stmt.ExecContext(ctx,
+				data.ID, rev.rev, rev.id, data.DesignFields.Language, "map", name, string(def),
+				data.DesignFields.AutoUpdate, nil, nil, nil,
+			); err != nil {
+				return err
+			}
+			if err := d.createMangoIndex(ctx, tx, view.Index); err != nil {
+				return err
+			}
+			continue
+		}
 		if view.Map != "" {
 			if _, err := stmt.ExecContext(ctx,
 				data.ID, rev.rev, rev.id, data.DesignFields.Language, "map", name, view.Map,
@@ -65,7 +84,7 @@ func (d *db) updateDesignDoc(ctx context.Context, tx *sql.Tx, rev revision, data
 		}
 	}
 	if data.DesignFields.ValidateDocUpdates != "" {
-		if _, err := stmt.ExecContext(ctx, data.ID, rev.rev, rev.id, data.DesignFields.Language, "validate_doc_update", "validate", data.DesignFields.ValidateDocUpdates, data.DesignFields.AutoUpdate, nil, nil); err != nil {
+		if _, err := stmt.ExecContext(ctx, data.ID, rev.rev, rev.id, data.DesignFields.Language, "validate", "validate_doc_update", data.DesignFields.ValidateDocUpdates, data.DesignFields.AutoUpdate, nil, nil, nil); err != nil {
 			return err
 		}
 	}
@@ -80,3 +99,19 @@ func (d *db) createViewMap(ctx context.Context, tx *sql.Tx, ddoc, name, rev stri
 	}
 	return nil
 }
+
+// jsOptions returns the options of the JavaScript environment of the functions
+// of a design document, whose body, as stored in the docs table, is ddocJSON.
+// log messages are sent to d's logger, calls are limited to d's function
+// timeout, and require loads modules from the design document.
+func (d *db) jsOptions(ddocJSON *string) ([]js.Option, error) {
+	opts := []js.Option{js.Logger(d.logger), js.Timeout(d.funcTimeout)}
+	if ddocJSON != nil {
+		var ddoc map[string]interface{}
+		if err := json.Unmarshal([]byte(*ddocJSON), &ddoc); err != nil {
+			return nil, err
+		}
+		opts = append(opts, js.DesignDoc(ddoc))
+	}
+	return opts, nil
+}
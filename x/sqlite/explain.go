@@ -0,0 +1,219 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// maxKey is the placeholder reported by Explain for an open-ended key range,
+// as CouchDB does.
+const maxKey = "<MAX>"
+
+func (d *db) Explain(ctx context.Context, query interface{}, options driver.Options) (*driver.QueryPlan, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
+	vopts, err := findOptions(query, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.planFind(ctx, vopts); err != nil {
+		return nil, err
+	}
+
+	var fields []interface{}
+	for _, f := range vopts.fields {
+		fields = append(fields, f)
+	}
+	return &driver.QueryPlan{
+		DBName:   d.name,
+		Index:    explainIndex(vopts.index),
+		Selector: normalizeSelector(vopts.rawSelector),
+		Options:  explainOptions(vopts),
+		Limit:    vopts.findLimit,
+		Skip:     vopts.findSkip,
+		Fields:   fields,
+		Range:    explainRange(vopts),
+	}, nil
+}
+
+func explainIndex(idx *mangoIndex) map[string]interface{} {
+	if idx == nil {
+		return map[string]interface{}{
+			"ddoc": nil,
+			"name": allDocsIndex.Name,
+			"type": allDocsIndex.Type,
+			"def":  allDocsIndex.Definition,
+		}
+	}
+	return map[string]interface{}{
+		"ddoc": idx.ddoc,
+		"name": idx.name,
+		"type": "json",
+		"def":  idx.fields.definition(),
+	}
+}
+
+func explainOptions(v *viewOptions) map[string]interface{} {
+	useIndex := []interface{}{}
+	for _, u := range v.useIndex {
+		useIndex = append(useIndex, u)
+	}
+	sortOpt := map[string]interface{}{}
+	for _, f := range v.sort {
+		sortOpt[f.name] = f.direction()
+	}
+	var fields interface{} = "all_fields"
+	if len(v.fields) > 0 {
+		fields = v.fields
+	}
+	bookmark := "nil"
+	if v.bookmark != "" {
+		bookmark = v.bookmark
+	}
+	return map[string]interface{}{
+		"use_index":      useIndex,
+		"allow_fallback": v.allowFallback,
+		"bookmark":       bookmark,
+		"limit":          v.findLimit,
+		"skip":           v.findSkip,
+		"sort":           sortOpt,
+		"fields":         fields,
+		"conflicts":      v.conflicts,
+	}
+}
+
+// explainRange returns the key range scanned by the chosen index: the
+// document ID range for _all_docs, or else the range over the leading index
+// fields which are constrained by the selector.
+func explainRange(v *viewOptions) map[string]interface{} {
+	if v.index == nil {
+		start, end, _ := fieldRange(v.indexConds, "_id")
+		return map[string]interface{}{"start_key": start, "end_key": end}
+	}
+	startKey := []interface{}{}
+	endKey := []interface{}{}
+	for _, f := range v.index.fields {
+		start, end, eq := fieldRange(v.indexConds, f.name)
+		if start == nil && end == maxKey {
+			break
+		}
+		startKey = append(startKey, start)
+		endKey = append(endKey, end)
+		if !eq {
+			break
+		}
+	}
+	return map[string]interface{}{"start_key": startKey, "end_key": endKey}
+}
+
+// fieldRange returns the range of values of field allowed by conds, and
+// whether the field is constrained to a single value.
+func fieldRange(conds []indexCond, field string) (start, end interface{}, eq bool) {
+	end = maxKey
+	for _, c := range conds {
+		if c.field != field {
+			continue
+		}
+		var value interface{}
+		_ = json.Unmarshal([]byte(c.value), &value)
+		switch c.op {
+		case "=":
+			return value, value, true
+		case ">", ">=":
+			start = value
+		case "<", "<=":
+			end = value
+		}
+	}
+	return start, end, false
+}
+
+// normalizeSelector converts a selector to the explicit form reported by
+// CouchDB: implicit equality is converted to `$eq`, and implicit conjunction
+// of multiple conditions is converted to `$and`.
+func normalizeSelector(raw json.RawMessage) map[string]interface{} {
+	var sel map[string]interface{}
+	if err := json.Unmarshal(raw, &sel); err != nil {
+		return nil
+	}
+	return normalizeObject(sel)
+}
+
+func normalizeObject(sel map[string]interface{}) map[string]interface{} {
+	keys := make([]string, 0, len(sel))
+	for key := range sel {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	clauses := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		clauses = append(clauses, map[string]interface{}{key: normalizeValue(key, sel[key])})
+	}
+	switch len(clauses) {
+	case 0:
+		return map[string]interface{}{}
+	case 1:
+		return clauses[0].(map[string]interface{})
+	}
+	return map[string]interface{}{"$and": clauses}
+}
+
+func normalizeValue(key string, value interface{}) interface{} {
+	switch key {
+	case "$and", "$or", "$nor":
+		list, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		result := make([]interface{}, len(list))
+		for i, sub := range list {
+			if obj, ok := sub.(map[string]interface{}); ok {
+				result[i] = normalizeObject(obj)
+			} else {
+				result[i] = sub
+			}
+		}
+		return result
+	case "$not":
+		if obj, ok := value.(map[string]interface{}); ok {
+			return normalizeObject(obj)
+		}
+		return value
+	}
+	if strings.HasPrefix(key, "$") {
+		return value
+	}
+	if obj, ok := value.(map[string]interface{}); ok && isOperatorObject(obj) {
+		return obj
+	}
+	return map[string]interface{}{"$eq": value}
+}
+
+func isOperatorObject(obj map[string]interface{}) bool {
+	if len(obj) == 0 {
+		return false
+	}
+	for key := range obj {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
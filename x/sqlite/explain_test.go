@@ -0,0 +1,182 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		db         *testDB
+		query      string
+		want       *driver.QueryPlan
+		wantStatus int
+		wantErr    string
+	}
+
+	defaultOpts := func(overrides map[string]interface{}) map[string]interface{} {
+		opts := map[string]interface{}{
+			"use_index":      []interface{}{},
+			"allow_fallback": true,
+			"bookmark":       "nil",
+			"limit":          int64(25),
+			"skip":           int64(0),
+			"sort":           map[string]interface{}{},
+			"fields":         "all_fields",
+			"conflicts":      false,
+		}
+		for k, v := range overrides {
+			opts[k] = v
+		}
+		return opts
+	}
+
+	tests := testy.NewTable()
+	tests.Add("invalid query", test{
+		query:      `{}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "selector cannot be null",
+	})
+	tests.Add("all docs", test{
+		query: `{"selector":{"foo":"bar","$or":[{"a":1},{"b":{"$gt":2}}]}}`,
+		want: &driver.QueryPlan{
+			DBName: "test",
+			Index: map[string]interface{}{
+				"ddoc": nil,
+				"name": "_all_docs",
+				"type": "special",
+				"def":  map[string]interface{}{"fields": []interface{}{map[string]interface{}{"_id": "asc"}}},
+			},
+			Selector: map[string]interface{}{"$and": []interface{}{
+				map[string]interface{}{"$or": []interface{}{
+					map[string]interface{}{"a": map[string]interface{}{"$eq": float64(1)}},
+					map[string]interface{}{"b": map[string]interface{}{"$gt": float64(2)}},
+				}},
+				map[string]interface{}{"foo": map[string]interface{}{"$eq": "bar"}},
+			}},
+			Options: defaultOpts(nil),
+			Limit:   25,
+			Range:   map[string]interface{}{"start_key": nil, "end_key": "<MAX>"},
+		},
+	})
+	tests.Add("_id range", test{
+		query: `{"selector":{"_id":{"$gte":"a"}},"limit":5,"skip":1,"fields":["_id"]}`,
+		want: &driver.QueryPlan{
+			DBName: "test",
+			Index: map[string]interface{}{
+				"ddoc": nil,
+				"name": "_all_docs",
+				"type": "special",
+				"def":  map[string]interface{}{"fields": []interface{}{map[string]interface{}{"_id": "asc"}}},
+			},
+			Selector: map[string]interface{}{"_id": map[string]interface{}{"$gte": "a"}},
+			Options:  defaultOpts(map[string]interface{}{"limit": int64(5), "skip": int64(1), "fields": []string{"_id"}}),
+			Limit:    5,
+			Skip:     1,
+			Fields:   []interface{}{"_id"},
+			Range:    map[string]interface{}{"start_key": "a", "end_key": "<MAX>"},
+		},
+	})
+	tests.Add("json index", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("foo", "foo-index", `{"fields":["type","n"]}`)
+
+		return test{
+			db:    d,
+			query: `{"selector":{"type":"post","n":{"$gt":3}},"sort":[{"type":"desc"},{"n":"desc"}]}`,
+			want: &driver.QueryPlan{
+				DBName: "test",
+				Index: map[string]interface{}{
+					"ddoc": "_design/foo",
+					"name": "foo-index",
+					"type": "json",
+					"def": map[string]interface{}{"fields": []interface{}{
+						map[string]interface{}{"type": "asc"},
+						map[string]interface{}{"n": "asc"},
+					}},
+				},
+				Selector: map[string]interface{}{"$and": []interface{}{
+					map[string]interface{}{"n": map[string]interface{}{"$gt": float64(3)}},
+					map[string]interface{}{"type": map[string]interface{}{"$eq": "post"}},
+				}},
+				Options: defaultOpts(map[string]interface{}{"sort": map[string]interface{}{"type": "desc", "n": "desc"}}),
+				Limit:   25,
+				Range: map[string]interface{}{
+					"start_key": []interface{}{"post", float64(3)},
+					"end_key":   []interface{}{"post", "<MAX>"},
+				},
+			},
+		}
+	})
+	tests.Add("use_index", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("a", "a", `{"fields":["x"]}`)
+		d.tCreateIndex("b", "b", `{"fields":["x"]}`)
+
+		return test{
+			db:    d,
+			query: `{"selector":{"x":{"$lt":10}},"use_index":"b"}`,
+			want: &driver.QueryPlan{
+				DBName: "test",
+				Index: map[string]interface{}{
+					"ddoc": "_design/b",
+					"name": "b",
+					"type": "json",
+					"def":  map[string]interface{}{"fields": []interface{}{map[string]interface{}{"x": "asc"}}},
+				},
+				Selector: map[string]interface{}{"x": map[string]interface{}{"$lt": float64(10)}},
+				Options:  defaultOpts(map[string]interface{}{"use_index": []interface{}{"_design/b"}}),
+				Limit:    25,
+				Range: map[string]interface{}{
+					"start_key": []interface{}{nil},
+					"end_key":   []interface{}{float64(10)},
+				},
+			},
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		db := tt.db
+		if db == nil {
+			db = newDB(t)
+		}
+		got, err := db.Explain(context.Background(), json.RawMessage(tt.query), mock.NilOption)
+		if !testy.ErrorMatches(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if err != nil {
+			return
+		}
+		if d := testy.DiffInterface(tt.want, got); d != nil {
+			t.Error(d)
+		}
+	})
+}
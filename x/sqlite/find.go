@@ -18,11 +18,28 @@ import (
 	"github.com/go-kivik/kivik/v4/driver"
 )
 
-func (d *db) Find(ctx context.Context, query interface{}, _ driver.Options) (driver.Rows, error) {
-	vopts, err := findOptions(query)
+// ExecutionStats are the statistics of a _find query, in the format returned
+// by CouchDB when the execution_stats option is set. They are available from
+// the rows returned by Find, by way of an ExecutionStats() method.
+type ExecutionStats struct {
+	TotalKeysExamined       int64   `json:"total_keys_examined"`
+	TotalDocsExamined       int64   `json:"total_docs_examined"`
+	TotalQuorumDocsExamined int64   `json:"total_quorum_docs_examined"`
+	ResultsReturned         int64   `json:"results_returned"`
+	ExecutionTimeMs         float64 `json:"execution_time_ms"`
+}
+
+func (d *db) Find(ctx context.Context, query interface{}, options driver.Options) (driver.Rows, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
+	vopts, err := findOptions(query, options)
 	if err != nil {
 		return nil, err
 	}
+	if err := d.planFind(ctx, vopts); err != nil {
+		return nil, err
+	}
 
 	return d.queryBuiltinView(ctx, vopts)
 }
@@ -15,10 +15,12 @@ package sqlite
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"gitlab.com/flimzy/testy"
 
 	"github.com/go-kivik/kivik/v4"
@@ -31,7 +33,10 @@ func TestFind(t *testing.T) {
 	type test struct {
 		db         *testDB
 		query      string
+		options    driver.Options
 		want       []rowResult
+		wantWarn   string
+		wantStats  *ExecutionStats
 		wantStatus int
 		wantErr    string
 	}
@@ -59,6 +64,19 @@ func TestFind(t *testing.T) {
 			},
 		}
 	})
+	tests.Add("design documents are excluded", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]string{"foo": "bar"})
+		rev := d.tPut("xdesign/foo", map[string]string{"foo": "bar"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"foo":"bar"}}`,
+			want: []rowResult{
+				{Doc: `{"_id":"xdesign/foo","_rev":"` + rev + `","foo":"bar"}`},
+			},
+		}
+	})
 	tests.Add("limit", func(t *testing.T) interface{} {
 		d := newDB(t)
 		rev := d.tPut("foo", map[string]string{"foo": "bar"})
@@ -170,6 +188,39 @@ func TestFind(t *testing.T) {
 			},
 		}
 	})
+	tests.Add("bookmark, sorted", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("", "", `{"fields":["n"]}`)
+		_ = d.tPut("a", map[string]interface{}{"n": 3})
+		_ = d.tPut("b", map[string]interface{}{"n": 2})
+		_ = d.tPut("c", map[string]interface{}{"n": 1})
+
+		rows, err := d.Find(context.Background(), json.RawMessage(`{"selector":{},"sort":["n"],"limit":1}`), mock.NilOption)
+		if err != nil {
+			t.Fatalf("Failed to get bookmark: %s", err)
+		}
+		defer rows.Close()
+		var row driver.Row
+		for {
+			err := rows.Next(&row)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		bookmark := rows.(driver.Bookmarker).Bookmark()
+
+		return test{
+			db:    d,
+			query: `{"selector":{},"sort":["n"],"bookmark":"` + bookmark + `","fields":["_id"]}`,
+			want: []rowResult{
+				{Doc: `{"_id":"b"}`},
+				{Doc: `{"_id":"a"}`},
+			},
+		}
+	})
 	tests.Add("non-string bookmark", test{
 		query:      `{"selector":{},"bookmark":true}`,
 		wantStatus: http.StatusBadRequest,
@@ -180,21 +231,248 @@ func TestFind(t *testing.T) {
 		wantStatus: http.StatusBadRequest,
 		wantErr:    "invalid value for 'bookmark': moo",
 	})
+	tests.Add("sort without index", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("a", map[string]interface{}{"name": "Bob"})
+
+		return test{
+			db:         d,
+			query:      `{"selector":{},"sort":["name"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "no index exists for this sort, try indexing by the sort fields",
+		}
+	})
 	tests.Add("sort", func(t *testing.T) interface{} {
 		d := newDB(t)
-		// revA := d.tPut("a", map[string]interface{}{"name": "Bob"})
-		// revB := d.tPut("b", map[string]interface{}{"name": "Alice"})
-		// revC := d.tPut("c", map[string]interface{}{"name": "Charlie"})
-		// revD := d.tPut("d", map[string]interface{}{"name": "Dick"})
+		d.tCreateIndex("", "", `{"fields":["name"]}`)
+		revA := d.tPut("a", map[string]interface{}{"name": "Bob"})
+		revB := d.tPut("b", map[string]interface{}{"name": "alice"})
+		revC := d.tPut("c", map[string]interface{}{"name": "Charlie"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{},"sort":["name"],"fields":["_id","_rev"]}`,
+			want: []rowResult{
+				{Doc: `{"_id":"b","_rev":"` + revB + `"}`},
+				{Doc: `{"_id":"a","_rev":"` + revA + `"}`},
+				{Doc: `{"_id":"c","_rev":"` + revC + `"}`},
+			},
+		}
+	})
+	tests.Add("sort descending, multiple fields", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("", "", `{"fields":["type","n","m"]}`)
+		_ = d.tPut("a", map[string]interface{}{"type": "x", "n": 1, "m": 1})
+		_ = d.tPut("b", map[string]interface{}{"type": "y", "n": 2, "m": 1})
+		_ = d.tPut("c", map[string]interface{}{"type": "y", "n": 2, "m": 2})
+		_ = d.tPut("d", map[string]interface{}{"type": "y", "n": 3, "m": 1})
 
 		return test{
 			db:    d,
-			query: `{"selector":{},"sort":["name"]}`,
-			// TODO: Support sorting
+			query: `{"selector":{"type":"y"},"sort":[{"n":"desc"},{"m":"desc"}],"fields":["_id"]}`,
+			want: []rowResult{
+				{Doc: `{"_id":"d"}`},
+				{Doc: `{"_id":"c"}`},
+				{Doc: `{"_id":"b"}`},
+			},
+		}
+	})
+	tests.Add("sort and select on a field name containing a dot", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("", "", `{"fields":["a.b"]}`)
+		_ = d.tPut("a", map[string]interface{}{"a.b": 2})
+		_ = d.tPut("b", map[string]interface{}{"a.b": 1})
+		_ = d.tPut("c", map[string]interface{}{"a": map[string]interface{}{"b": 3}})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"a.b":{"$gt":0}},"sort":["a.b"],"fields":["_id"]}`,
+			want: []rowResult{
+				{Doc: `{"_id":"b"}`},
+				{Doc: `{"_id":"a"}`},
+			},
+		}
+	})
+	tests.Add("sort by _id descending", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("a", map[string]interface{}{})
+		_ = d.tPut("b", map[string]interface{}{})
+
+		return test{
+			db:    d,
+			query: `{"selector":{},"sort":[{"_id":"desc"}],"fields":["_id"]}`,
+			want: []rowResult{
+				{Doc: `{"_id":"b"}`},
+				{Doc: `{"_id":"a"}`},
+			},
+			wantWarn: "No matching index found, create an index to optimize query time.",
+		}
+	})
+	tests.Add("sort, unconstrained leading index field", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("", "", `{"fields":["type","n"]}`)
+
+		return test{
+			db:         d,
+			query:      `{"selector":{},"sort":["n"]}`,
 			wantStatus: http.StatusBadRequest,
 			wantErr:    "no index exists for this sort, try indexing by the sort fields",
 		}
 	})
+	tests.Add("sort, mixed directions", test{
+		query:      `{"selector":{},"sort":["a",{"b":"desc"}]}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "sorts currently only support a single direction for all fields",
+	})
+	tests.Add("sort, invalid direction", test{
+		query:      `{"selector":{},"sort":[{"b":"down"}]}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    `invalid sort direction for field "b"`,
+	})
+	tests.Add("default limit", func(t *testing.T) interface{} {
+		d := newDB(t)
+		want := make([]rowResult, 0, 25)
+		for i := 0; i < 30; i++ {
+			id := fmt.Sprintf("%02d", i)
+			_ = d.tPut(id, map[string]interface{}{})
+			if i < 25 {
+				want = append(want, rowResult{Doc: `{"_id":"` + id + `"}`})
+			}
+		}
+
+		return test{
+			db:    d,
+			query: `{"selector":{},"fields":["_id"]}`,
+			want:  want,
+		}
+	})
+	tests.Add("limit 0", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("a", map[string]interface{}{})
+
+		return test{
+			db:    d,
+			query: `{"selector":{},"limit":0}`,
+			want:  nil,
+		}
+	})
+	tests.Add("options override query", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("a", map[string]interface{}{})
+		_ = d.tPut("b", map[string]interface{}{})
+
+		return test{
+			db:      d,
+			query:   `{"selector":{},"limit":2,"fields":["_id"]}`,
+			options: kivik.Param("limit", 1),
+			want: []rowResult{
+				{Doc: `{"_id":"a"}`},
+			},
+		}
+	})
+	tests.Add("use_index", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("foo", "foo", `{"fields":["foo"]}`)
+		_ = d.tPut("a", map[string]interface{}{"foo": "bar"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"foo":"bar"},"use_index":["foo","foo"],"fields":["_id"]}`,
+			want: []rowResult{
+				{Doc: `{"_id":"a"}`},
+			},
+		}
+	})
+	tests.Add("use_index, unusable", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("foo", "foo", `{"fields":["foo"]}`)
+		_ = d.tPut("a", map[string]interface{}{"bar": "baz"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"bar":"baz"},"use_index":"foo","fields":["_id"]}`,
+			want: []rowResult{
+				{Doc: `{"_id":"a"}`},
+			},
+			wantWarn: "_design/foo was not used because it does not contain a valid index for this query.\nNo matching index found, create an index to optimize query time.",
+		}
+	})
+	tests.Add("use_index, no fallback", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("foo", "foo", `{"fields":["foo"]}`)
+
+		return test{
+			db:         d,
+			query:      `{"selector":{"bar":"baz"},"use_index":"foo","allow_fallback":false}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    `the index specified with "use_index" is not usable for the query`,
+		}
+	})
+	tests.Add("use_index, invalid", test{
+		query:      `{"selector":{},"use_index":3}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "invalid value for 'use_index': 3",
+	})
+	tests.Add("invalid r", test{
+		query:      `{"selector":{},"r":0}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "invalid value for 'r': 0",
+	})
+	tests.Add("invalid update", test{
+		query:      `{"selector":{},"update":"sometimes"}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "invalid value for 'update': sometimes",
+	})
+	tests.Add("invalid execution_stats", test{
+		query:      `{"selector":{},"execution_stats":"yes"}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "invalid value for 'execution_stats': yes",
+	})
+	tests.Add("r, update, stable and execution_stats", test{
+		query: `{"selector":{},"r":1,"update":false,"stable":true,"execution_stats":false}`,
+		want:  nil,
+	})
+	tests.Add("execution_stats", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]string{"foo": "bar"})
+		revA := d.tPut("a", map[string]string{"foo": "bar"})
+		_ = d.tPut("b", map[string]string{"foo": "baz"})
+		revC := d.tPut("c", map[string]string{"foo": "bar"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"foo":"bar"},"execution_stats":true}`,
+			want: []rowResult{
+				{Doc: `{"_id":"a","_rev":"` + revA + `","foo":"bar"}`},
+				{Doc: `{"_id":"c","_rev":"` + revC + `","foo":"bar"}`},
+			},
+			wantStats: &ExecutionStats{
+				TotalKeysExamined: 3,
+				TotalDocsExamined: 3,
+				ResultsReturned:   2,
+			},
+		}
+	})
+	tests.Add("execution_stats with index", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("idx", "foo", `{"fields":["foo"]}`)
+		revA := d.tPut("a", map[string]string{"foo": "bar"})
+		_ = d.tPut("b", map[string]string{"foo": "baz"})
+		_ = d.tPut("c", map[string]string{"foo": "bar"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"foo":"bar"},"limit":1,"execution_stats":true}`,
+			want: []rowResult{
+				{Doc: `{"_id":"a","_rev":"` + revA + `","foo":"bar"}`},
+			},
+			wantStats: &ExecutionStats{
+				TotalKeysExamined: 2,
+				TotalDocsExamined: 2,
+				ResultsReturned:   1,
+			},
+		}
+	})
 	tests.Add("sort, non-array", test{
 		query:      `{"selector":{},"sort":"x"}`,
 		wantStatus: http.StatusBadRequest,
@@ -215,16 +493,66 @@ func TestFind(t *testing.T) {
 		wantStatus: http.StatusBadRequest,
 		wantErr:    "invalid 'fields' field: 3",
 	})
+	tests.Add("indexed equality", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("", "", `{"fields":["foo"]}`)
+		rev := d.tPut("foo", map[string]string{"foo": "bar"})
+		_ = d.tPut("bar", map[string]string{"foo": "baz"})
+		_ = d.tPut("baz", map[string]string{"bar": "bar"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"foo":"bar"}}`,
+			want: []rowResult{
+				{Doc: `{"_id":"foo","_rev":"` + rev + `","foo":"bar"}`},
+			},
+		}
+	})
+	tests.Add("indexed range, with additional conditions", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("", "", `{"fields":["n"]}`)
+		_ = d.tPut("a", map[string]interface{}{"n": 1})
+		rev := d.tPut("b", map[string]interface{}{"n": 5, "even": false})
+		_ = d.tPut("c", map[string]interface{}{"n": 6, "even": true})
+		_ = d.tPut("d", map[string]interface{}{"n": 20, "even": false})
+		_ = d.tPut("e", map[string]interface{}{"n": "5"})
+
+		return test{
+			db:    d,
+			query: `{"selector":{"$and":[{"n":{"$gt":2}},{"n":{"$lte":10}},{"even":false}]}}`,
+			want: []rowResult{
+				{Doc: `{"_id":"b","_rev":"` + rev + `","even":false,"n":5}`},
+			},
+		}
+	})
+	tests.Add("indexed field, updated doc", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("", "", `{"fields":["foo"]}`)
+		rev := d.tPut("foo", map[string]string{"foo": "bar"})
+		rev = d.tPut("foo", map[string]string{"foo": "baz"}, kivik.Rev(rev))
+
+		return test{
+			db:    d,
+			query: `{"selector":{"foo":{"$gte":"bar"}}}`,
+			want: []rowResult{
+				{Doc: `{"_id":"foo","_rev":"` + rev + `","foo":"baz"}`},
+			},
+		}
+	})
+	tests.Add("_id range", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("a", map[string]string{"foo": "bar"})
+		rev := d.tPut("b", map[string]string{"foo": "bar"})
+		_ = d.tPut("c", map[string]string{"foo": "bar"})
 
-	/*
-		TODO:
-		- sort
-		- stable
-		- update
-		- stale
-		- use_index
-		- execution_stats -- Not currently supported by Kivik
-	*/
+		return test{
+			db:    d,
+			query: `{"selector":{"$and":[{"_id":{"$gt":"a"}},{"_id":{"$lt":"c"}}]}}`,
+			want: []rowResult{
+				{Doc: `{"_id":"b","_rev":"` + rev + `","foo":"bar"}`},
+			},
+		}
+	})
 
 	tests.Run(t, func(t *testing.T, tt test) {
 		t.Parallel()
@@ -232,7 +560,11 @@ func TestFind(t *testing.T) {
 		if db == nil {
 			db = newDB(t)
 		}
-		rows, err := db.Find(context.Background(), json.RawMessage(tt.query), mock.NilOption)
+		options := tt.options
+		if options == nil {
+			options = mock.NilOption
+		}
+		rows, err := db.Find(context.Background(), json.RawMessage(tt.query), options)
 		if !testy.ErrorMatchesRE(tt.wantErr, err) {
 			t.Errorf("Unexpected error: %s", err)
 		}
@@ -242,6 +574,21 @@ func TestFind(t *testing.T) {
 		if err != nil {
 			return
 		}
+		if tt.wantWarn != "" {
+			if warning := rows.(driver.RowsWarner).Warning(); warning != tt.wantWarn {
+				t.Errorf("Unexpected warning: %s", warning)
+			}
+		}
 		checkRows(t, rows, tt.want)
+		if tt.wantStats != nil {
+			stats := rows.(interface{ ExecutionStats() *ExecutionStats }).ExecutionStats()
+			if stats == nil || stats.ExecutionTimeMs < 0 {
+				t.Fatalf("Unexpected stats: %+v", stats)
+			}
+			stats.ExecutionTimeMs = 0
+			if d := cmp.Diff(tt.wantStats, stats); d != "" {
+				t.Errorf("Unexpected stats:\n%s", d)
+			}
+		}
 	})
 }
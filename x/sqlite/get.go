@@ -24,6 +24,9 @@ import (
 )
 
 func (d *db) Get(ctx context.Context, id string, options driver.Options) (*driver.Document, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
 	opts := newOpts(options)
 
 	var r revision
@@ -144,7 +147,11 @@ func (d *db) Get(ctx context.Context, id string, options driver.Options) (*drive
 	if err != nil {
 		return nil, err
 	}
-	atts, err := d.getAttachments(ctx, tx, id, r, attachments, opts.attsSince())
+	encodingInfo, err := opts.attEncodingInfo()
+	if err != nil {
+		return nil, err
+	}
+	atts, err := d.getAttachments(ctx, tx, id, r, attachments, encodingInfo, opts.attsSince())
 	if err != nil {
 		return nil, err
 	}
@@ -195,8 +202,9 @@ func (d *db) conflicts(ctx context.Context, tx dbOrTx, id string, r revision, de
 }
 
 // getAttachments returns the attachments for the given docID and revision.
-// It may return nil if there are no attachments.
-func (d *db) getAttachments(ctx context.Context, tx *sql.Tx, id string, rev revision, includeAttachments bool, since []string) (*attachments, error) {
+// It may return nil if there are no attachments. If encodingInfo is true, the
+// encoding of compressed attachments is included.
+func (d *db) getAttachments(ctx context.Context, tx *sql.Tx, id string, rev revision, includeAttachments, encodingInfo bool, since []string) (*attachments, error) {
 	for _, s := range since {
 		if _, err := parseRev(s); err != nil {
 			return nil, err
@@ -228,14 +236,19 @@ func (d *db) getAttachments(ctx context.Context, tx *sql.Tx, id string, rev revi
 				att.digest,
 				att.length,
 				att.rev_pos,
-				MAX(IIF($4 OR %s, att.data, NULL)) AS data
+				content.encoding,
+				content.encoded_length,
+				IIF(MAX($4 OR %[1]s), content.data, NULL) AS data,
+				COALESCE(MAX($4 OR %[1]s), FALSE) AS include,
+				IIF(content.data IS NULL, lower(hex(content.hash)), '') AS blob
 			FROM {{ .Attachments }} AS att
 			JOIN {{ .AttachmentsBridge }} AS bridge ON att.pk = bridge.pk
+			JOIN {{ .AttachmentData }} AS content ON content.hash = att.hash
 			LEFT JOIN ancestors AS a ON att.rev_pos = a.rev
 			WHERE bridge.id = $1
 				AND bridge.rev = $2
 				AND bridge.rev_id = $3
-			GROUP BY att.filename, att.content_type, att.digest, att.length, att.rev_pos
+			GROUP BY att.filename, att.content_type, att.digest, att.length, att.rev_pos, content.encoding, content.encoded_length
 		`), sinceQuery)
 	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -245,15 +258,29 @@ func (d *db) getAttachments(ctx context.Context, tx *sql.Tx, id string, rev revi
 	var atts attachments
 	var digest md5sum
 	for rows.Next() {
-		var a driver.Attachment
-		var data *[]byte
-		if err := rows.Scan(&a.Filename, &a.ContentType, &digest, &a.Size, &a.RevPos, &data); err != nil {
+		var (
+			a             driver.Attachment
+			data          []byte
+			encoding      *string
+			encodedLength int64
+			blob          string
+			include       bool
+		)
+		if err := rows.Scan(&a.Filename, &a.ContentType, &digest, &a.Size, &a.RevPos, &encoding, &encodedLength, &data, &include, &blob); err != nil {
 			return nil, err
 		}
-		if data == nil {
-			a.Stub = true
+		if encodingInfo && encoding != nil {
+			a.ContentEncoding = *encoding
+			a.EncodedLength = encodedLength
+		}
+		if include {
+			content, err := d.readAttachment(encoding, data, blob)
+			if err != nil {
+				return nil, err
+			}
+			a.Content = io.NopCloser(bytes.NewReader(content))
 		} else {
-			a.Content = io.NopCloser(bytes.NewReader(*data))
+			a.Stub = true
 		}
 		a.Digest = digest.Digest()
 		atts = append(atts, &a)
@@ -295,16 +322,17 @@ func (a *attachments) inlineAttachments() map[string]*attachment {
 			panic(err)
 		}
 		newAtt := &attachment{
-			ContentType: att.ContentType,
-			Digest:      digest,
-			Length:      att.Size,
-			RevPos:      int(att.RevPos),
-			Stub:        att.Stub,
+			ContentType:   att.ContentType,
+			Digest:        digest,
+			Length:        att.Size,
+			RevPos:        int(att.RevPos),
+			Stub:          att.Stub,
+			Encoding:      att.ContentEncoding,
+			EncodedLength: att.EncodedLength,
 		}
 		if att.Content != nil {
-			var data bytes.Buffer
-			_, _ = io.Copy(&data, att.Content)
-			newAtt.Data, _ = json.Marshal(data.Bytes())
+			data, _ := io.ReadAll(att.Content)
+			newAtt.Data, _ = json.Marshal(data)
 		}
 		atts[att.Filename] = newAtt
 	}
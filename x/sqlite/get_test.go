@@ -605,6 +605,33 @@ func TestDBGet(t *testing.T) {
 			},
 		}
 	})
+	tests.Add("att_encoding_info=true, doc with compressed attachment", func(t *testing.T) interface{} {
+		db := newDB(t)
+		rev := db.tPut("foo", map[string]interface{}{
+			"_attachments": newAttachments().add("att.txt", "att.txt"),
+		})
+
+		return test{
+			db:      db,
+			id:      "foo",
+			options: kivik.Param("att_encoding_info", true),
+			wantDoc: map[string]interface{}{
+				"_id":  "foo",
+				"_rev": rev,
+				"_attachments": map[string]interface{}{
+					"att.txt": map[string]interface{}{
+						"content_type":   "text/plain",
+						"digest":         "md5-a4NyknGw7YOh+a5ezPdZ4A==",
+						"length":         float64(7),
+						"revpos":         float64(1),
+						"stub":           true,
+						"encoding":       "gzip",
+						"encoded_length": float64(28),
+					},
+				},
+			},
+		}
+	})
 	tests.Add("attachments=true, doc without attachments", func(t *testing.T) interface{} {
 		db := newDB(t)
 		rev := db.tPut("foo", map[string]interface{}{
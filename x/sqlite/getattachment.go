@@ -13,11 +13,9 @@
 package sqlite
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"errors"
-	"io"
 	"net/http"
 
 	"github.com/go-kivik/kivik/v4/driver"
@@ -25,6 +23,9 @@ import (
 )
 
 func (d *db) GetAttachment(ctx context.Context, docID string, filename string, options driver.Options) (*driver.Attachment, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
 	opts := newOpts(options)
 
 	tx, err := d.db.BeginTx(ctx, nil)
@@ -63,8 +64,13 @@ func (d *db) getAttachment(
 	docID, filename string,
 	rev revision,
 ) (*driver.Attachment, error) {
-	var att driver.Attachment
-	var data []byte
+	var (
+		att      driver.Attachment
+		encoding *string
+		data     []byte
+		blob     string
+		digest   md5sum
+	)
 	err := tx.QueryRowContext(ctx, d.query(`
 		SELECT
 			att.filename,
@@ -72,17 +78,29 @@ func (d *db) getAttachment(
 			att.digest,
 			att.length,
 			att.rev_pos,
-			att.data
+			content.encoding,
+			content.data,
+			IIF(content.data IS NULL, lower(hex(content.hash)), '')
 		FROM {{ .Attachments }} AS att
 		JOIN {{ .AttachmentsBridge }} AS bridge ON bridge.pk = att.pk
+		JOIN {{ .AttachmentData }} AS content ON content.hash = att.hash
 		WHERE
 			bridge.id = $1
 			AND att.filename = $2
 			AND bridge.rev = $3
 			AND bridge.rev_id = $4	
 	`), docID, filename, rev.rev, rev.id).
-		Scan(&att.Filename, &att.ContentType, &att.Digest, &att.Size, &att.RevPos, &data)
+		Scan(&att.Filename, &att.ContentType, &digest, &att.Size, &att.RevPos, &encoding, &data, &blob)
+	if err != nil {
+		return nil, err
+	}
+	att.Digest = digest.Digest()
 
-	att.Content = io.NopCloser(bytes.NewReader(data))
+	// Content stored in the attachment directory is streamed from disk.
+	var enc string
+	if encoding != nil {
+		enc = *encoding
+	}
+	att.Content, err = openAttachment(enc, data, blob, d.blobDir())
 	return &att, err
 }
@@ -23,6 +23,9 @@ import (
 )
 
 func (d *db) GetAttachmentMeta(ctx context.Context, docID, filename string, options driver.Options) (*driver.Attachment, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
 	opts := newOpts(options)
 
 	tx, err := d.db.BeginTx(ctx, nil)
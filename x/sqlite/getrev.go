@@ -23,6 +23,9 @@ import (
 )
 
 func (d *db) GetRev(ctx context.Context, id string, options driver.Options) (string, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return "", err
+	}
 	opts := newOpts(options)
 
 	var r revision
@@ -0,0 +1,741 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+/*
+Mango JSON indexes are stored as design documents with `"language":"query"`,
+in the same format used by CouchDB. Each index is additionally backed by a
+SQLite expression index on the main documents table, which indexes the JSON
+value of each indexed field, using CouchDB collation.
+
+The index is only used to narrow the set of documents considered by _find;
+the selector is always evaluated in full against each candidate document.
+*/
+
+// indexField is a single field of a Mango JSON index.
+type indexField struct {
+	name string
+	desc bool
+}
+
+func (f indexField) direction() string {
+	if f.desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// indexFields is the ordered list of fields of a Mango JSON index.
+type indexFields []indexField
+
+var errInvalidIndexFields = &internal.Error{Status: http.StatusBadRequest, Message: "invalid index fields definition"}
+
+// parseIndexFields parses the fields of an index definition, which may be an
+// array of field names, or of single-key objects mapping the field name to a
+// sort direction, or (as stored in design documents) an object mapping field
+// names to sort directions.
+func parseIndexFields(raw json.RawMessage) (indexFields, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, errInvalidIndexFields
+	}
+	var fields indexFields
+	switch tok {
+	case json.Delim('['):
+		for dec.More() {
+			var field interface{}
+			if err := dec.Decode(&field); err != nil {
+				return nil, errInvalidIndexFields
+			}
+			switch t := field.(type) {
+			case string:
+				fields = append(fields, indexField{name: t})
+			case map[string]interface{}:
+				if len(t) != 1 {
+					return nil, errInvalidIndexFields
+				}
+				for name, dir := range t {
+					f, err := newIndexField(name, dir)
+					if err != nil {
+						return nil, err
+					}
+					fields = append(fields, f)
+				}
+			default:
+				return nil, errInvalidIndexFields
+			}
+		}
+	case json.Delim('{'):
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, errInvalidIndexFields
+			}
+			var dir interface{}
+			if err := dec.Decode(&dir); err != nil {
+				return nil, errInvalidIndexFields
+			}
+			f, err := newIndexField(name.(string), dir)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		}
+	default:
+		return nil, errInvalidIndexFields
+	}
+	if len(fields) == 0 {
+		return nil, &internal.Error{Status: http.StatusBadRequest, Message: "index fields must not be empty"}
+	}
+	for _, f := range fields {
+		if f.name == "" || strings.Contains(f.name, `"`) {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid index field name: %q", f.name)}
+		}
+	}
+	return fields, nil
+}
+
+func newIndexField(name string, dir interface{}) (indexField, error) {
+	switch dir {
+	case "asc":
+		return indexField{name: name}, nil
+	case "desc":
+		return indexField{name: name, desc: true}, nil
+	}
+	return indexField{}, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid sort direction for field %q", name)}
+}
+
+// MarshalJSON marshals the fields as an array of single-key objects, which
+// is the format reported by GetIndexes.
+func (f indexFields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		fmt.Fprintf(&buf, `{%s:"%s"}`, name, field.direction())
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// object returns the fields as an ordered JSON object, as stored in the map
+// of a design document.
+func (f indexFields) object() json.RawMessage {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		fmt.Fprintf(&buf, `%s:"%s"`, name, field.direction())
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func (f indexFields) definition() map[string]interface{} {
+	fields := make([]interface{}, len(f))
+	for i, field := range f {
+		fields[i] = map[string]interface{}{field.name: field.direction()}
+	}
+	return map[string]interface{}{"fields": fields}
+}
+
+// fieldPath returns the SQLite JSON path of field. As in the Mango selector
+// matcher, a field name containing dots is a single top-level key, rather than
+// a path to a nested field.
+func fieldPath(field string) string {
+	return `'$."` + strings.ReplaceAll(field, "'", "''") + `"'`
+}
+
+// fieldExpr returns the SQL expression which extracts the JSON value of field
+// from a document. The expression must match exactly the one used in the
+// SQLite index, for the index to be used.
+func fieldExpr(field string) string {
+	return `(CAST(doc AS TEXT) -> ` + fieldPath(field) + `) COLLATE COUCHDB_UCI`
+}
+
+// sqlIndexName returns the name of the SQLite index which backs a Mango index
+// with the given fields. Mango indexes with identical fields share a single
+// SQLite index.
+func (d *db) sqlIndexName(fields indexFields) string {
+	def, _ := json.Marshal(fields)
+	return strconv.Quote("idx_" + md5sumString(d.name + string(def))[:16])
+}
+
+// createMangoIndex creates the SQLite index backing a Mango index. Fields
+// which are not stored in the document body, such as `_id`, are skipped.
+func (d *db) createMangoIndex(ctx context.Context, tx *sql.Tx, fields indexFields) error {
+	columns := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, "_") {
+			continue
+		}
+		columns = append(columns, fieldExpr(f.name)+" "+strings.ToUpper(f.direction()))
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS "+d.sqlIndexName(fields)+" ON "+d.query("{{ .Docs }}")+" ("+strings.Join(columns, ", ")+")")
+	return err
+}
+
+// jsonIndex converts an index definition passed to CreateIndex to JSON.
+func jsonIndex(index interface{}) (json.RawMessage, error) {
+	switch t := index.(type) {
+	case json.RawMessage:
+		return t, nil
+	case []byte:
+		return t, nil
+	case string:
+		return json.RawMessage(t), nil
+	}
+	raw, err := json.Marshal(index)
+	if err != nil {
+		return nil, &internal.Error{Status: http.StatusBadRequest, Err: err}
+	}
+	return raw, nil
+}
+
+func ddocID(ddoc string) string {
+	if strings.HasPrefix(ddoc, "_design/") {
+		return ddoc
+	}
+	return "_design/" + ddoc
+}
+
+// getIndexDoc fetches the design document ddoc, for modification. A nil map
+// is returned if the design document does not exist.
+func (d *db) getIndexDoc(ctx context.Context, ddoc string) (map[string]interface{}, error) {
+	doc, err := d.Get(ctx, ddoc, kivik.Params(nil))
+	if err != nil {
+		if kivik.HTTPStatus(err) == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer doc.Body.Close()
+	body, err := io.ReadAll(doc.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (d *db) CreateIndex(ctx context.Context, ddoc, name string, index interface{}, options driver.Options) error {
+	d = d.withUserCtx(options)
+	raw, err := jsonIndex(index)
+	if err != nil {
+		return err
+	}
+	var def struct {
+		Fields                json.RawMessage `json:"fields"`
+		PartialFilterSelector json.RawMessage `json:"partial_filter_selector"`
+	}
+	if err := json.Unmarshal(raw, &def); err != nil {
+		return &internal.Error{Status: http.StatusBadRequest, Err: err}
+	}
+	if len(def.PartialFilterSelector) > 0 {
+		return &internal.Error{Status: http.StatusBadRequest, Message: "partial_filter_selector is not supported"}
+	}
+	fields, err := parseIndexFields(def.Fields)
+	if err != nil {
+		return err
+	}
+	fieldsJSON, _ := json.Marshal(fields)
+	hash := md5sumString(string(fieldsJSON))
+	if name == "" {
+		name = hash
+	}
+	if ddoc == "" {
+		ddoc = hash
+	}
+	ddoc = ddocID(ddoc)
+
+	doc, err := d.getIndexDoc(ctx, ddoc)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		doc = map[string]interface{}{"language": "query"}
+	}
+	if doc["language"] != "query" {
+		return &internal.Error{Status: http.StatusBadRequest, Message: "design document " + ddoc + " is not a query index design document"}
+	}
+	views, _ := doc["views"].(map[string]interface{})
+	if views == nil {
+		views = map[string]interface{}{}
+	}
+	if existing, ok := views[name].(map[string]interface{}); ok {
+		if current, err := existingIndexFields(existing); err == nil && sameIndexFields(current, fields) {
+			return nil
+		}
+	}
+	views[name] = map[string]interface{}{
+		"map":     map[string]interface{}{"fields": fields.object()},
+		"reduce":  "_count",
+		"options": map[string]interface{}{"def": fields.definition()},
+	}
+	doc["views"] = views
+	_, err = d.Put(ctx, ddoc, doc, kivik.Params(nil))
+	return err
+}
+
+func existingIndexFields(view map[string]interface{}) (indexFields, error) {
+	m, ok := view["map"].(map[string]interface{})
+	if !ok {
+		return nil, errInvalidIndexFields
+	}
+	// The field order is lost when decoding to a map, so fall back to the
+	// definition stored in the options, which is an array.
+	if opts, ok := view["options"].(map[string]interface{}); ok {
+		if def, ok := opts["def"].(map[string]interface{}); ok {
+			m = def
+		}
+	}
+	raw, err := json.Marshal(m["fields"])
+	if err != nil {
+		return nil, err
+	}
+	return parseIndexFields(raw)
+}
+
+func sameIndexFields(a, b indexFields) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mangoIndex is a Mango JSON index, as stored in the design table.
+type mangoIndex struct {
+	ddoc   string
+	name   string
+	fields indexFields
+}
+
+// rowsQueryer is implemented by both *sql.DB and *sql.Tx.
+type rowsQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// mangoIndexes returns the Mango JSON indexes defined by the winning revisions
+// of all design documents, ordered by design document and name.
+func (d *db) mangoIndexes(ctx context.Context, tx rowsQueryer) ([]mangoIndex, error) {
+	rows, err := tx.QueryContext(ctx, d.query(leavesCTE+`
+		SELECT design.id, design.func_name, design.func_body
+		FROM (
+			SELECT
+				id,
+				rev,
+				rev_id,
+				ROW_NUMBER() OVER (PARTITION BY id ORDER BY rev DESC, rev_id DESC) AS rank
+			FROM leaves
+			WHERE id LIKE '\_design/%' ESCAPE '\'
+		) AS ddoc
+		JOIN {{ .Design }} AS design ON design.id = ddoc.id AND design.rev = ddoc.rev AND design.rev_id = ddoc.rev_id
+		WHERE ddoc.rank = 1
+			AND design.language = 'query'
+			AND design.func_type = 'map'
+		ORDER BY design.id, design.func_name
+	`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []mangoIndex
+	for rows.Next() {
+		var (
+			idx  mangoIndex
+			body string
+		)
+		if err := rows.Scan(&idx.ddoc, &idx.name, &body); err != nil {
+			return nil, err
+		}
+		if idx.fields, err = parseIndexFields(json.RawMessage(body)); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
+
+// allDocsIndex is the special index reported by GetIndexes, and used by _find
+// when no other index applies.
+var allDocsIndex = driver.Index{
+	Name: "_all_docs",
+	Type: "special",
+	Definition: map[string]interface{}{
+		"fields": []interface{}{map[string]interface{}{"_id": "asc"}},
+	},
+}
+
+func (d *db) GetIndexes(ctx context.Context, options driver.Options) ([]driver.Index, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
+	indexes, err := d.mangoIndexes(ctx, d.db)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]driver.Index, 0, len(indexes)+1)
+	result = append(result, allDocsIndex)
+	for _, idx := range indexes {
+		result = append(result, driver.Index{
+			DesignDoc:  idx.ddoc,
+			Name:       idx.name,
+			Type:       "json",
+			Definition: idx.fields.definition(),
+		})
+	}
+	return result, nil
+}
+
+func (d *db) DeleteIndex(ctx context.Context, ddoc, name string, options driver.Options) error {
+	d = d.withUserCtx(options)
+	ddoc = ddocID(ddoc)
+	notFound := &internal.Error{Status: http.StatusNotFound, Message: "index not found"}
+	doc, err := d.getIndexDoc(ctx, ddoc)
+	if err != nil {
+		return err
+	}
+	if doc == nil || doc["language"] != "query" {
+		return notFound
+	}
+	views, _ := doc["views"].(map[string]interface{})
+	view, ok := views[name].(map[string]interface{})
+	if !ok {
+		return notFound
+	}
+	fields, err := existingIndexFields(view)
+	if err != nil {
+		return err
+	}
+	delete(views, name)
+	if len(views) == 0 {
+		_, err = d.Delete(ctx, ddoc, kivik.Rev(doc["_rev"].(string)))
+	} else {
+		_, err = d.Put(ctx, ddoc, doc, kivik.Params(nil))
+	}
+	if err != nil {
+		return err
+	}
+	return d.dropMangoIndex(ctx, fields)
+}
+
+// dropMangoIndex drops the SQLite index backing fields, unless another Mango
+// index still uses it.
+func (d *db) dropMangoIndex(ctx context.Context, fields indexFields) error {
+	indexes, err := d.mangoIndexes(ctx, d.db)
+	if err != nil {
+		return err
+	}
+	name := d.sqlIndexName(fields)
+	for _, idx := range indexes {
+		if d.sqlIndexName(idx.fields) == name {
+			return nil
+		}
+	}
+	_, err = d.db.ExecContext(ctx, "DROP INDEX IF EXISTS "+name)
+	return err
+}
+
+// indexCond is a condition on a single field, extracted from a selector, which
+// can be evaluated by SQLite.
+type indexCond struct {
+	field string
+	op    string
+	// value is the JSON-encoded operand.
+	value string
+}
+
+var indexOps = map[string]string{
+	"$eq":  "=",
+	"$gt":  ">",
+	"$gte": ">=",
+	"$lt":  "<",
+	"$lte": "<=",
+}
+
+// selectorConds extracts the equality and range conditions from a raw
+// selector, which must all be satisfied by matching documents. Any other
+// conditions are ignored, so the extracted conditions may match a superset of
+// the documents matched by the selector.
+func selectorConds(raw json.RawMessage) []indexCond {
+	var sel map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &sel); err != nil {
+		return nil
+	}
+	var conds []indexCond
+	for key, value := range sel {
+		if key == "$and" {
+			var subs []json.RawMessage
+			if err := json.Unmarshal(value, &subs); err != nil {
+				continue
+			}
+			for _, sub := range subs {
+				conds = append(conds, selectorConds(sub)...)
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "$") || (strings.HasPrefix(key, "_") && key != "_id") {
+			continue
+		}
+		if isScalar(value) {
+			conds = append(conds, indexCond{field: key, op: "=", value: string(value)})
+			continue
+		}
+		var ops map[string]json.RawMessage
+		if err := json.Unmarshal(value, &ops); err != nil {
+			continue
+		}
+		for op, operand := range ops {
+			if sqlOp, ok := indexOps[op]; ok && isScalar(operand) {
+				conds = append(conds, indexCond{field: key, op: sqlOp, value: string(operand)})
+			}
+		}
+	}
+	sort.Slice(conds, func(i, j int) bool {
+		return conds[i].field < conds[j].field
+	})
+	return conds
+}
+
+func isScalar(value json.RawMessage) bool {
+	value = bytes.TrimSpace(value)
+	return len(value) > 0 && value[0] != '{' && value[0] != '['
+}
+
+// chooseIndex returns the index which covers the longest prefix of
+// conditioned fields, or nil if no index applies.
+func chooseIndex(indexes []mangoIndex, conds []indexCond) *mangoIndex {
+	conditioned := make(map[string]bool, len(conds))
+	for _, c := range conds {
+		conditioned[c.field] = true
+	}
+	var (
+		best      *mangoIndex
+		bestScore int
+	)
+	for i, idx := range indexes {
+		var score int
+		for _, f := range idx.fields {
+			if strings.HasPrefix(f.name, "_") || !conditioned[f.name] {
+				break
+			}
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = &indexes[i], score
+		}
+	}
+	return best
+}
+
+// findWhere returns the WHERE conditions which restrict the documents scanned
+// by _find to those matching the conditions on the chosen index's fields, and
+// on `_id`. Design documents are never returned by _find.
+func (d *db) findWhere(v *viewOptions, args *[]interface{}) []string {
+	if v.selector == nil {
+		return nil
+	}
+	where := []string{`view.key NOT LIKE '"\_design/%' ESCAPE '\'`}
+	var docWhere []string
+	indexed := map[string]bool{}
+	if v.index != nil {
+		for _, f := range v.index.fields {
+			indexed[f.name] = true
+		}
+	}
+	for _, c := range v.indexConds {
+		switch {
+		case c.field == "_id":
+			where = append(where, fmt.Sprintf("view.key %s $%d", c.op, len(*args)+1))
+		case indexed[c.field]:
+			docWhere = append(docWhere, fmt.Sprintf("%s %s $%d", fieldExpr(c.field), c.op, len(*args)+1))
+		default:
+			continue
+		}
+		*args = append(*args, c.value)
+	}
+	if len(docWhere) > 0 {
+		where = append(where, "view.id IN (SELECT id FROM "+d.query("{{ .Docs }}")+" WHERE "+strings.Join(docWhere, " AND ")+")")
+	}
+	return where
+}
+
+// selectorWhere returns a WHERE clause which restricts a changes feed to the
+// documents which may match the raw selector, or an empty string if none of
+// its conditions can be evaluated by SQLite. As with findWhere, the selector
+// must still be evaluated in full against each remaining document.
+func selectorWhere(raw json.RawMessage, args *[]interface{}) string {
+	var where []string
+	for _, c := range selectorConds(raw) {
+		switch {
+		case c.field == "_id":
+			where = append(where, fmt.Sprintf("json_quote(results.id) COLLATE COUCHDB_UCI %s $%d", c.op, len(*args)+1))
+		case strings.Contains(c.field, "."):
+			// Nested fields are left to the selector.
+			continue
+		default:
+			where = append(where, fmt.Sprintf("%s %s $%d", fieldExpr(c.field), c.op, len(*args)+1))
+		}
+		*args = append(*args, c.value)
+	}
+	if len(where) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(where, " AND ")
+}
+
+// sortExpr returns the SQL expression by which _find results are sorted by
+// field. It extracts the same value as [fieldExpr].
+func sortExpr(field string) string {
+	return `(CAST(view.doc AS TEXT) -> ` + fieldPath(field) + `) COLLATE COUCHDB_UCI`
+}
+
+// sortable returns true if the index can satisfy the requested sort order. The
+// sort fields must appear, in order, in the index, following only fields
+// which are constrained by the selector.
+func (idx *mangoIndex) sortable(sort []indexField, conds []indexCond) bool {
+	conditioned := make(map[string]bool, len(conds))
+	for _, c := range conds {
+		conditioned[c.field] = true
+	}
+	for start, f := range idx.fields {
+		if f.name == sort[0].name {
+			if len(idx.fields)-start < len(sort) {
+				return false
+			}
+			for i, s := range sort {
+				if idx.fields[start+i].name != s.name {
+					return false
+				}
+			}
+			return true
+		}
+		if !conditioned[f.name] {
+			return false
+		}
+	}
+	return false
+}
+
+// chooseSortIndex returns the first index which can satisfy the requested
+// sort order, or nil if there is none.
+func chooseSortIndex(indexes []mangoIndex, sort []indexField, conds []indexCond) *mangoIndex {
+	for i := range indexes {
+		if indexes[i].sortable(sort, conds) {
+			return &indexes[i]
+		}
+	}
+	return nil
+}
+
+// sortByID returns true if results are sorted only by document ID, which is
+// satisfied by the special _all_docs index.
+func sortByID(sort []indexField) bool {
+	return len(sort) == 0 || (len(sort) == 1 && sort[0].name == "_id")
+}
+
+// matchesUseIndex returns true if idx was requested by use_index.
+func (idx *mangoIndex) matchesUseIndex(useIndex []string) bool {
+	return idx.ddoc == useIndex[0] && (len(useIndex) == 1 || idx.name == useIndex[1])
+}
+
+const noIndexWarning = "No matching index found, create an index to optimize query time."
+
+// planFind selects the index used to satisfy a _find query, following
+// CouchDB's rules: an index requested with use_index is used if it is
+// suitable; otherwise, unless allow_fallback is false, another index is
+// selected, and a warning is added.
+func (d *db) planFind(ctx context.Context, v *viewOptions) error {
+	if v.stats != nil {
+		v.findStart = time.Now()
+	}
+	var indexes []mangoIndex
+	if len(v.indexConds) > 0 || !sortByID(v.sort) || len(v.useIndex) > 0 {
+		var err error
+		indexes, err = d.mangoIndexes(ctx, d.db)
+		if err != nil {
+			return err
+		}
+	}
+	choose := func(candidates []mangoIndex) (*mangoIndex, bool) {
+		if !sortByID(v.sort) {
+			idx := chooseSortIndex(candidates, v.sort, v.indexConds)
+			return idx, idx != nil
+		}
+		return chooseIndex(candidates, v.indexConds), true
+	}
+
+	if len(v.useIndex) > 0 {
+		var requested []mangoIndex
+		for _, idx := range indexes {
+			if idx.matchesUseIndex(v.useIndex) {
+				requested = append(requested, idx)
+			}
+		}
+		if idx, ok := choose(requested); ok && idx != nil {
+			v.index = idx
+			return nil
+		}
+		if !v.allowFallback {
+			return &internal.Error{Status: http.StatusBadRequest, Message: `the index specified with "use_index" is not usable for the query`}
+		}
+		v.warnings = append(v.warnings, strings.Join(v.useIndex, ", ")+" was not used because it does not contain a valid index for this query.")
+	}
+
+	idx, ok := choose(indexes)
+	if !ok {
+		return &internal.Error{Status: http.StatusBadRequest, Message: "no index exists for this sort, try indexing by the sort fields"}
+	}
+	v.index = idx
+	if idx == nil {
+		v.warnings = append(v.warnings, noIndexWarning)
+	}
+	return nil
+}
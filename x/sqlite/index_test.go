@@ -0,0 +1,323 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestCreateIndex(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		db         *testDB
+		ddoc, name string
+		index      interface{}
+		wantDdoc   string
+		want       []driver.Index
+		wantStatus int
+		wantErr    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("invalid JSON", test{
+		index:      "invalid json",
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "invalid character 'i' looking for beginning of value",
+	})
+	tests.Add("missing fields", test{
+		index:      `{}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "invalid index fields definition",
+	})
+	tests.Add("empty fields", test{
+		index:      `{"fields":[]}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "index fields must not be empty",
+	})
+	tests.Add("invalid direction", test{
+		index:      `{"fields":[{"foo":"up"}]}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    `invalid sort direction for field "foo"`,
+	})
+	tests.Add("partial filter selector", test{
+		index:      `{"fields":["foo"],"partial_filter_selector":{"foo":"bar"}}`,
+		wantStatus: http.StatusBadRequest,
+		wantErr:    "partial_filter_selector is not supported",
+	})
+	tests.Add("named index", test{
+		ddoc:     "foo",
+		name:     "foo-index",
+		index:    map[string]interface{}{"fields": []interface{}{"foo", map[string]string{"bar": "desc"}}},
+		wantDdoc: `{"language":"query","views":{"foo-index":{"map":{"fields":{"foo":"asc","bar":"desc"}},"options":{"def":{"fields":[{"foo":"asc"},{"bar":"desc"}]}},"reduce":"_count"}}}`,
+		want: []driver.Index{
+			allDocsIndex,
+			{
+				DesignDoc: "_design/foo",
+				Name:      "foo-index",
+				Type:      "json",
+				Definition: map[string]interface{}{"fields": []interface{}{
+					map[string]interface{}{"foo": "asc"},
+					map[string]interface{}{"bar": "desc"},
+				}},
+			},
+		},
+	})
+	tests.Add("add to existing design doc", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("foo", "a", `{"fields":["a"]}`)
+
+		return test{
+			db:    d,
+			ddoc:  "_design/foo",
+			name:  "b",
+			index: `{"fields":["b"]}`,
+			want: []driver.Index{
+				allDocsIndex,
+				{DesignDoc: "_design/foo", Name: "a", Type: "json", Definition: map[string]interface{}{"fields": []interface{}{map[string]interface{}{"a": "asc"}}}},
+				{DesignDoc: "_design/foo", Name: "b", Type: "json", Definition: map[string]interface{}{"fields": []interface{}{map[string]interface{}{"b": "asc"}}}},
+			},
+		}
+	})
+	tests.Add("javascript design doc", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"views": map[string]interface{}{
+				"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id); }"},
+			},
+		})
+
+		return test{
+			db:         d,
+			ddoc:       "foo",
+			index:      `{"fields":["foo"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantErr:    "design document _design/foo is not a query index design document",
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		db := tt.db
+		if db == nil {
+			db = newDB(t)
+		}
+		err := db.CreateIndex(context.Background(), tt.ddoc, tt.name, tt.index, mock.NilOption)
+		if !testy.ErrorMatches(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if err != nil {
+			return
+		}
+		if tt.wantDdoc != "" {
+			var ddoc map[string]interface{}
+			var body string
+			if err := db.underlying().QueryRow(`SELECT doc FROM test WHERE id = $1 ORDER BY rev DESC LIMIT 1`, ddocID(tt.ddoc)).Scan(&body); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(body), &ddoc); err != nil {
+				t.Fatal(err)
+			}
+			if d := testy.DiffAsJSON([]byte(tt.wantDdoc), ddoc); d != nil {
+				t.Errorf("Unexpected design doc:\n%s", d)
+			}
+		}
+		got, err := db.GetIndexes(context.Background(), mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(tt.want, got); d != "" {
+			t.Errorf("Unexpected indexes:\n%s", d)
+		}
+	})
+}
+
+func TestCreateIndexIdempotent(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	d.tCreateIndex("", "", `{"fields":["foo"]}`)
+	d.tCreateIndex("", "", `{"fields":["foo"]}`)
+	var revs int
+	if err := d.underlying().QueryRow(`SELECT COUNT(*) FROM test_revs WHERE id LIKE '_design/%'`).Scan(&revs); err != nil {
+		t.Fatal(err)
+	}
+	if revs != 1 {
+		t.Errorf("Expected the design doc to be written once, got %d revisions", revs)
+	}
+}
+
+func TestGetIndexes(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	got, err := d.GetIndexes(context.Background(), mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]driver.Index{allDocsIndex}, got); d != "" {
+		t.Error(d)
+	}
+}
+
+func TestDeleteIndex(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		db         *testDB
+		ddoc, name string
+		want       []driver.Index
+		wantStatus int
+		wantErr    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("not found", test{
+		ddoc:       "foo",
+		name:       "bar",
+		wantStatus: http.StatusNotFound,
+		wantErr:    "index not found",
+	})
+	tests.Add("last index in design doc", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("foo", "bar", `{"fields":["foo"]}`)
+
+		return test{
+			db:   d,
+			ddoc: "foo",
+			name: "bar",
+			want: []driver.Index{allDocsIndex},
+		}
+	})
+	tests.Add("other indexes remain", func(t *testing.T) interface{} {
+		d := newDB(t)
+		d.tCreateIndex("foo", "a", `{"fields":["a"]}`)
+		d.tCreateIndex("foo", "b", `{"fields":["b"]}`)
+
+		return test{
+			db:   d,
+			ddoc: "_design/foo",
+			name: "a",
+			want: []driver.Index{
+				allDocsIndex,
+				{DesignDoc: "_design/foo", Name: "b", Type: "json", Definition: map[string]interface{}{"fields": []interface{}{map[string]interface{}{"b": "asc"}}}},
+			},
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		db := tt.db
+		if db == nil {
+			db = newDB(t)
+		}
+		err := db.DeleteIndex(context.Background(), tt.ddoc, tt.name, mock.NilOption)
+		if !testy.ErrorMatches(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if err != nil {
+			return
+		}
+		got, err := db.GetIndexes(context.Background(), mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(tt.want, got); d != "" {
+			t.Errorf("Unexpected indexes:\n%s", d)
+		}
+	})
+}
+
+func TestDeleteIndexDropsSQLiteIndex(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	d.tCreateIndex("a", "a", `{"fields":["foo"]}`)
+	d.tCreateIndex("b", "b", `{"fields":["foo"]}`)
+	count := func() int {
+		t.Helper()
+		var n int
+		if err := d.underlying().QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND sql LIKE '%CAST(doc AS TEXT)%'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("Expected identical indexes to share a SQLite index, found %d", n)
+	}
+	if err := d.DeleteIndex(context.Background(), "a", "a", mock.NilOption); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("SQLite index dropped while still in use")
+	}
+	if err := d.DeleteIndex(context.Background(), "b", "b", mock.NilOption); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("SQLite index not dropped")
+	}
+}
+
+func TestFindUsesIndex(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	d.tCreateIndex("", "", `{"fields":["foo","bar"]}`)
+	vopts, err := findOptions(json.RawMessage(`{"selector":{"foo":{"$gt":"x"},"baz":1}}`), mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DB.(*db).planFind(context.Background(), vopts); err != nil {
+		t.Fatal(err)
+	}
+	if vopts.index == nil {
+		t.Fatal("Expected an index to be chosen")
+	}
+	var args []interface{}
+	where := d.DB.(*db).findWhere(vopts, &args)
+	rows, err := d.underlying().Query(`EXPLAIN QUERY PLAN SELECT id FROM test_revs AS view WHERE `+strings.Join(where, " AND "), args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(plan, "\n"), "USING INDEX idx_") {
+		t.Errorf("Expected the SQLite index to be used, got plan:\n%s", strings.Join(plan, "\n"))
+	}
+}
@@ -0,0 +1,233 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Option configures the JavaScript environment in which a function runs.
+type Option func(*env)
+
+// DesignDoc makes the properties of ddoc available as CommonJS modules to the
+// require function. Map functions may only require modules from views.lib, as
+// in CouchDB.
+func DesignDoc(ddoc map[string]any) Option {
+	return func(e *env) {
+		e.ddoc = ddoc
+	}
+}
+
+// Logger sends messages passed to the log function to logger. Without a
+// logger, such messages are discarded.
+func Logger(logger *log.Logger) Option {
+	return func(e *env) {
+		e.logger = logger
+	}
+}
+
+// Timeout interrupts calls of a function which run for longer than timeout,
+// so that runaway functions can't block indefinitely. A zero timeout disables
+// the limit.
+func Timeout(timeout time.Duration) Option {
+	return func(e *env) {
+		e.timeout = timeout
+	}
+}
+
+// builtins defines the helper functions which CouchDB's JavaScript query
+// server makes available. List and show functions are not supported, so
+// provides, registerType, start, send and getRow do nothing, and exist only
+// for the benefit of shared code which refers to them.
+const builtins = `
+function isArray(obj) {
+	return Array.isArray(obj);
+}
+function sum(values) {
+	var total = 0;
+	for (var i = 0; i < values.length; i++) {
+		total += values[i];
+	}
+	return total;
+}
+function toJSON(obj) {
+	return JSON.stringify(obj);
+}
+function provides(type, fn) {}
+function registerType() {}
+function start(resp) {}
+function send(chunk) {}
+function getRow() {
+	return null;
+}
+`
+
+// env is the JavaScript environment of a single VM.
+type env struct {
+	vm      *goja.Runtime
+	ddoc    map[string]any
+	logger  *log.Logger
+	timeout time.Duration
+	// modules caches the exports of each module loaded by require, by ID.
+	modules map[string]goja.Value
+}
+
+// newVM returns a new VM, with the CouchDB builtins and the environment
+// configured by opts.
+func newVM(opts []Option) (*goja.Runtime, *env, error) {
+	e := &env{
+		vm:      goja.New(),
+		modules: map[string]goja.Value{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if err := e.vm.Set("log", e.log); err != nil {
+		return nil, nil, err
+	}
+	if err := e.vm.Set("require", e.require("")); err != nil {
+		return nil, nil, err
+	}
+	if _, err := e.vm.RunString(builtins); err != nil {
+		return nil, nil, err
+	}
+	return e.vm, e, nil
+}
+
+// call calls fn with args, interrupting it if it runs for longer than the
+// timeout.
+func (e *env) call(fn goja.Callable, args ...goja.Value) (goja.Value, error) {
+	if e.timeout <= 0 {
+		return fn(goja.Undefined(), args...)
+	}
+	interrupted := make(chan struct{})
+	timer := time.AfterFunc(e.timeout, func() {
+		e.vm.Interrupt(fmt.Sprintf("function timed out after %s", e.timeout))
+		close(interrupted)
+	})
+	result, err := fn(goja.Undefined(), args...)
+	if !timer.Stop() {
+		// The interrupt may have arrived just after fn returned, in which case
+		// it must not affect the next call.
+		<-interrupted
+		e.vm.ClearInterrupt()
+	}
+	return result, err
+}
+
+// viewsLibOnly restricts require to the views.lib property of the design
+// document.
+func (e *env) viewsLibOnly() {
+	views, _ := e.ddoc["views"].(map[string]any)
+	lib, ok := views["lib"]
+	if !ok {
+		e.ddoc = nil
+		return
+	}
+	e.ddoc = map[string]any{"views": map[string]any{"lib": lib}}
+}
+
+func (e *env) log(msg goja.Value) {
+	if e.logger == nil {
+		return
+	}
+	if s, ok := msg.Export().(string); ok {
+		e.logger.Print(s)
+		return
+	}
+	s, _ := json.Marshal(msg.Export())
+	e.logger.Print(string(s))
+}
+
+// require returns the require function of the module parent, against which
+// relative paths are resolved. parent is empty for the top-level function.
+func (e *env) require(parent string) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		id, src, err := e.resolve(parent, call.Argument(0).String())
+		if err != nil {
+			e.throw(err)
+		}
+		if exports, ok := e.modules[id]; ok {
+			return exports
+		}
+		compiled, err := e.vm.RunScript(id, "(function(module, exports, require) {\n"+src+"\n})")
+		if err != nil {
+			e.throw(fmt.Errorf("failed to compile module %s: %w", id, exception(err)))
+		}
+		moduleFunc, _ := goja.AssertFunction(compiled)
+		module := e.vm.NewObject()
+		exports := e.vm.NewObject()
+		_ = module.Set("id", id)
+		_ = module.Set("exports", exports)
+		// Cache the initial exports, so that circular requires terminate.
+		e.modules[id] = exports
+		if _, err := moduleFunc(goja.Undefined(), module, exports, e.vm.ToValue(e.require(id))); err != nil {
+			delete(e.modules, id)
+			panic(err)
+		}
+		e.modules[id] = module.Get("exports")
+		return e.modules[id]
+	}
+}
+
+// throw throws err as a JavaScript Error.
+func (e *env) throw(err error) {
+	ctor, _ := goja.AssertConstructor(e.vm.Get("Error"))
+	obj, _ := ctor(nil, e.vm.ToValue(err.Error()))
+	panic(obj)
+}
+
+// resolve returns the ID and source code of the module at path, relative to
+// the module parent if path begins with ./ or ../.
+func (e *env) resolve(parent, path string) (string, string, error) {
+	var segments []string
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		if parent != "" {
+			segments = strings.Split(parent, "/")
+			segments = segments[:len(segments)-1]
+		}
+	}
+	for _, segment := range strings.Split(path, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) == 0 {
+				return "", "", fmt.Errorf("invalid require path: %s", path)
+			}
+			segments = segments[:len(segments)-1]
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	id := strings.Join(segments, "/")
+	var current any = e.ddoc
+	for _, segment := range segments {
+		obj, _ := current.(map[string]any)
+		next, ok := obj[segment]
+		if !ok {
+			return "", "", fmt.Errorf("invalid require path: object has no property %q, while resolving %s", segment, path)
+		}
+		current = next
+	}
+	src, ok := current.(string)
+	if !ok {
+		return "", "", fmt.Errorf("invalid require path: %s is not a string", path)
+	}
+	return id, src, nil
+}
@@ -14,6 +14,8 @@
 package js
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -29,8 +31,12 @@ type MapFunc func(doc any) error
 
 // Map compiles the provided JavaScript code into a MapFunc, and makes emit
 // available to the JavaScript code.
-func Map(code string, emit func(key, value any)) (MapFunc, error) {
-	vm := goja.New()
+func Map(code string, emit func(key, value any), opts ...Option) (MapFunc, error) {
+	vm, e, err := newVM(opts)
+	if err != nil {
+		return nil, err
+	}
+	e.viewsLibOnly()
 
 	if err := vm.Set("emit", emit); err != nil {
 		return nil, err
@@ -46,7 +52,7 @@ func Map(code string, emit func(key, value any)) (MapFunc, error) {
 	}
 
 	return func(doc any) error {
-		_, err := mapFunc(goja.Undefined(), vm.ToValue(doc))
+		_, err := e.call(mapFunc, vm.ToValue(doc))
 		return exception(err)
 	}, nil
 }
@@ -58,8 +64,11 @@ func Map(code string, emit func(key, value any)) (MapFunc, error) {
 type FilterFunc func(doc, req any) (bool, error)
 
 // Filter compiles the provided JavaScript code into a FilterFunc.
-func Filter(code string) (FilterFunc, error) {
-	vm := goja.New()
+func Filter(code string, opts ...Option) (FilterFunc, error) {
+	vm, e, err := newVM(opts)
+	if err != nil {
+		return nil, err
+	}
 	if _, err := vm.RunString("const filter = " + code); err != nil {
 		return nil, fmt.Errorf("failed to compile filter function: %s", err)
 	}
@@ -68,7 +77,7 @@ func Filter(code string) (FilterFunc, error) {
 		panic(fmt.Sprintf("expected filter to be a function, got %T", vm.Get("filter")))
 	}
 	return func(doc, req any) (bool, error) {
-		result, err := filterFunc(goja.Undefined(), vm.ToValue(doc), vm.ToValue(req))
+		result, err := e.call(filterFunc, vm.ToValue(doc), vm.ToValue(req))
 		if err != nil {
 			return false, exception(err)
 		}
@@ -78,6 +87,131 @@ func Filter(code string) (FilterFunc, error) {
 	}, nil
 }
 
+// ValidateFunc is the Go representation of a CouchDB [validate document update
+// function]. Validation failures are returned as a [*ValidationError], and
+// other exceptions are converted to errors.
+//
+// [validate document update function]: https://docs.couchdb.org/en/stable/ddocs/ddocs.html#validate-document-update-functions
+type ValidateFunc func(newDoc, oldDoc, userCtx, secObj any) error
+
+// ValidationError is returned by a [ValidateFunc] or [UpdateFunc] when the
+// function rejects an update, by throwing an object with a forbidden or
+// unauthorized key.
+type ValidationError struct {
+	// Reason is either "forbidden" or "unauthorized".
+	Reason  string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Validate compiles the provided JavaScript code into a ValidateFunc.
+func Validate(code string, opts ...Option) (ValidateFunc, error) {
+	vm, e, err := newVM(opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString("const validate = " + code); err != nil {
+		return nil, fmt.Errorf("failed to compile validate_doc_update function: %s", err)
+	}
+	validateFunc, ok := goja.AssertFunction(vm.Get("validate"))
+	if !ok {
+		return nil, fmt.Errorf("expected validate_doc_update to be a function, got %T", vm.Get("validate"))
+	}
+	return func(newDoc, oldDoc, userCtx, secObj any) error {
+		_, err := e.call(validateFunc, vm.ToValue(newDoc), vm.ToValue(oldDoc), vm.ToValue(userCtx), vm.ToValue(secObj))
+		return rejection(err)
+	}, nil
+}
+
+// rejection converts a JavaScript exception to a [ValidationError], if the
+// thrown object has a forbidden or unauthorized key, or to a Go error
+// otherwise.
+func rejection(err error) error {
+	if err == nil {
+		return nil
+	}
+	var jsErr *goja.Exception
+	if errors.As(err, &jsErr) {
+		if thrown, ok := jsErr.Value().Export().(map[string]any); ok {
+			for _, reason := range []string{"forbidden", "unauthorized"} {
+				if msg, ok := thrown[reason]; ok {
+					return &ValidationError{Reason: reason, Message: fmt.Sprint(msg)}
+				}
+			}
+		}
+	}
+	return exception(err)
+}
+
+// UpdateFunc is the Go representation of a CouchDB [update function]. It
+// returns the document to be saved, which is nil if the function returned
+// null, and the response body. Exceptions are converted to errors, with
+// forbidden and unauthorized rejections returned as a [ValidationError].
+//
+// [update function]: https://docs.couchdb.org/en/stable/ddocs/ddocs.html#update-functions
+type UpdateFunc func(doc, req any) (newDoc map[string]any, body []byte, err error)
+
+// Update compiles the provided JavaScript code into an UpdateFunc.
+func Update(code string, opts ...Option) (UpdateFunc, error) {
+	vm, e, err := newVM(opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString("const update = " + code); err != nil {
+		return nil, fmt.Errorf("failed to compile update function: %s", err)
+	}
+	updateFunc, ok := goja.AssertFunction(vm.Get("update"))
+	if !ok {
+		return nil, fmt.Errorf("expected update to be a function, got %T", vm.Get("update"))
+	}
+	return func(doc, req any) (map[string]any, []byte, error) {
+		result, err := e.call(updateFunc, vm.ToValue(doc), vm.ToValue(req))
+		if err != nil {
+			return nil, nil, rejection(err)
+		}
+		pair, ok := result.Export().([]any)
+		if !ok || len(pair) != 2 {
+			return nil, nil, errors.New("update function must return a [doc, response] pair")
+		}
+		var newDoc map[string]any
+		if pair[0] != nil {
+			newDoc, ok = pair[0].(map[string]any)
+			if !ok {
+				return nil, nil, fmt.Errorf("update function returned invalid document: %v", pair[0])
+			}
+		}
+		body, err := updateResponse(pair[1])
+		return newDoc, body, err
+	}, nil
+}
+
+// updateResponse returns the body of the response returned by an update
+// function, which may be either a string, or a response object with a body,
+// json, or base64 field.
+func updateResponse(resp any) ([]byte, error) {
+	switch t := resp.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(t), nil
+	case map[string]any:
+		if v, ok := t["json"]; ok {
+			return json.Marshal(v)
+		}
+		if v, ok := t["base64"].(string); ok {
+			return base64.StdEncoding.DecodeString(v)
+		}
+		if v, ok := t["body"].(string); ok {
+			return []byte(v), nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("update function returned invalid response: %v", resp)
+}
+
 // ReduceFunc is the Go representation of a CouchDB [reduce function]. Exceptions
 // are converted to errors. The JavaScript function may return either a single
 // item, or an array.  If a single item is returned, it is wrapped in an array
@@ -87,8 +221,11 @@ func Filter(code string) (FilterFunc, error) {
 type ReduceFunc func(keys [][2]interface{}, values []interface{}, rereduce bool) ([]interface{}, error)
 
 // Reduce compiles the provided JavaScript code into a ReduceFunc.
-func Reduce(code string) (ReduceFunc, error) {
-	vm := goja.New()
+func Reduce(code string, opts ...Option) (ReduceFunc, error) {
+	vm, e, err := newVM(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	if _, err := vm.RunString("const reduce = " + code); err != nil {
 		return nil, err
@@ -99,7 +236,7 @@ func Reduce(code string) (ReduceFunc, error) {
 	}
 
 	return func(keys [][2]interface{}, values []interface{}, rereduce bool) ([]interface{}, error) {
-		reduceValue, err := reduceFunc(goja.Undefined(), vm.ToValue(keys), vm.ToValue(values), vm.ToValue(rereduce))
+		reduceValue, err := e.call(reduceFunc, vm.ToValue(keys), vm.ToValue(values), vm.ToValue(rereduce))
 		if err != nil {
 			return nil, exception(err)
 		}
@@ -128,6 +265,10 @@ func exception(err error) error {
 	if errors.As(err, &exception) {
 		return errors.New(exception.String())
 	}
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		return fmt.Errorf("%v", interrupted.Value())
+	}
 	// should never happen that we get a non-exception error
 	return err
 }
@@ -83,6 +83,38 @@ func (d *docData) IsDesignDoc() bool {
 type views struct {
 	Map    string `json:"map"`
 	Reduce string `json:"reduce,omitempty"`
+	// Index holds the indexed fields of a Mango JSON index, whose map is an
+	// object rather than a JavaScript function.
+	Index indexFields `json:"-"`
+}
+
+func (v *views) UnmarshalJSON(p []byte) error {
+	var raw struct {
+		Map    json.RawMessage `json:"map"`
+		Reduce string          `json:"reduce"`
+	}
+	if err := json.Unmarshal(p, &raw); err != nil {
+		return err
+	}
+	v.Reduce = raw.Reduce
+	if len(raw.Map) == 0 || raw.Map[0] != '{' {
+		if len(raw.Map) == 0 {
+			return nil
+		}
+		return json.Unmarshal(raw.Map, &v.Map)
+	}
+	var index struct {
+		Fields json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(raw.Map, &index); err != nil {
+		return err
+	}
+	fields, err := parseIndexFields(index.Fields)
+	if err != nil {
+		return err
+	}
+	v.Index = fields
+	return nil
 }
 
 type designDocViewOptions struct {
@@ -225,6 +257,15 @@ func (m md5sum) MarshalText() ([]byte, error) {
 	return b, nil
 }
 
+func (m *md5sum) UnmarshalText(text []byte) error {
+	sum, err := parseDigest(string(text))
+	if err != nil {
+		return err
+	}
+	*m = sum
+	return nil
+}
+
 func (m md5sum) Bytes() []byte {
 	return m[:]
 }
@@ -240,9 +281,10 @@ type attachment struct {
 	Length      int64  `json:"length"`
 	RevPos      int    `json:"revpos"`
 	Stub        bool   `json:"stub,omitempty"`
-	// TODO: Add encoding support to compress certain types of attachments.
-	// Encoding      string `json:"encoding"`
-	// EncodedLength int64  `json:"encoded_length"`
+	// Encoding and EncodedLength describe how the attachment is stored, and
+	// are only reported when requested with att_encoding_info.
+	Encoding      string `json:"encoding,omitempty"`
+	EncodedLength int64  `json:"encoded_length,omitempty"`
 
 	// Data is the raw JSON representation of the attachment data. It is decoded
 	// into Content by the [attachment.calculate] method.
@@ -0,0 +1,204 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/x/sqlite/v4/js"
+)
+
+// defaultFuncTimeout matches the default os_process_timeout of CouchDB.
+const defaultFuncTimeout = 5 * time.Second
+
+type optionMapParallelism int
+
+var _ kivik.Option = optionMapParallelism(0)
+
+func (o optionMapParallelism) Apply(target interface{}) {
+	if client, ok := target.(*client); ok && o > 0 {
+		client.parallelism = int(o)
+	}
+}
+
+// OptionMapParallelism sets the number of documents which are passed to a
+// view's map function concurrently, while building the view's index. Each
+// runs in its own JavaScript VM, so native Go map functions must be safe for
+// concurrent use. The default is [runtime.GOMAXPROCS].
+func OptionMapParallelism(n int) kivik.Option {
+	return optionMapParallelism(n)
+}
+
+type optionFuncTimeout time.Duration
+
+var _ kivik.Option = optionFuncTimeout(0)
+
+func (o optionFuncTimeout) Apply(target interface{}) {
+	if client, ok := target.(*client); ok {
+		client.funcTimeout = time.Duration(o)
+	}
+}
+
+// OptionFunctionTimeout limits the time a single call of a JavaScript map,
+// reduce, filter, update or validate_doc_update function may run, before it
+// is interrupted and treated as having thrown an exception. The default is 5
+// seconds. A timeout of zero disables the limit.
+func OptionFunctionTimeout(timeout time.Duration) kivik.Option {
+	return optionFuncTimeout(timeout)
+}
+
+// mapper is a compiled instance of a map function, which collects the pairs
+// emitted for a single document.
+type mapper struct {
+	fn      js.MapFunc
+	emitted []mapIndexEntry
+}
+
+func (m *mapper) emit(key, value any) {
+	m.emitted = append(m.emitted, mapIndexEntry{Key: key, Value: value})
+}
+
+// run calls the map function for doc, and returns the emitted pairs.
+func (m *mapper) run(doc map[string]any) ([]mapIndexEntry, error) {
+	m.emitted = nil
+	err := m.fn(doc)
+	return m.emitted, err
+}
+
+// mapperPools holds the idle compiled instances of the map function of each
+// view, so that they can be reused by subsequent index updates. Only the
+// instances of the current revision of each design document are kept.
+type mapperPools struct {
+	mu    sync.Mutex
+	pools map[string]*mapperPool
+}
+
+type mapperPool struct {
+	rev  string
+	idle []*mapper
+}
+
+// get returns an idle instance of the map function of view, as of the design
+// document revision rev, or calls compile to create a new one.
+func (p *mapperPools) get(view, rev string, compile func(*mapper) error) (*mapper, error) {
+	p.mu.Lock()
+	if p.pools == nil {
+		p.pools = map[string]*mapperPool{}
+	}
+	pool, ok := p.pools[view]
+	if !ok || pool.rev != rev {
+		pool = &mapperPool{rev: rev}
+		p.pools[view] = pool
+	}
+	if n := len(pool.idle); n > 0 {
+		m := pool.idle[n-1]
+		pool.idle = pool.idle[:n-1]
+		p.mu.Unlock()
+		return m, nil
+	}
+	p.mu.Unlock()
+
+	m := &mapper{}
+	if err := compile(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// put returns m to the pool of view, unless the design document has since
+// been updated.
+func (p *mapperPools) put(view, rev string, m *mapper) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pool, ok := p.pools[view]; ok && pool.rev == rev {
+		pool.idle = append(pool.idle, m)
+	}
+}
+
+// mapJob is a single document to be indexed.
+type mapJob struct {
+	id      string
+	rev     revision
+	doc     *fullDoc
+	deleted bool
+
+	emitted []mapIndexEntry
+	err     error
+}
+
+// mapJobs calls a map function, as returned by get, for each non-deleted
+// document of jobs. Up to d.parallelism documents are mapped concurrently.
+// The instances of the map function are returned to the pool with put.
+func (d *db) mapJobs(jobs []*mapJob, get func() (*mapper, error), put func(*mapper)) error {
+	pending := make([]*mapJob, 0, len(jobs))
+	for _, job := range jobs {
+		if !job.deleted {
+			pending = append(pending, job)
+		}
+	}
+	mappers := make([]*mapper, 0, min(d.parallelism, len(pending)))
+	defer func() {
+		for _, m := range mappers {
+			put(m)
+		}
+	}()
+	for len(mappers) < cap(mappers) {
+		m, err := get()
+		if err != nil {
+			return err
+		}
+		mappers = append(mappers, m)
+	}
+
+	var (
+		next int64
+		wg   sync.WaitGroup
+	)
+	for _, m := range mappers {
+		wg.Add(1)
+		go func(m *mapper) {
+			defer wg.Done()
+			for i := atomic.AddInt64(&next, 1) - 1; i < int64(len(pending)); i = atomic.AddInt64(&next, 1) - 1 {
+				job := pending[i]
+				job.emitted, job.err = m.run(job.doc.toMap())
+			}
+		}(m)
+	}
+	wg.Wait()
+	return nil
+}
+
+// addMapJobs adds the results of jobs to batch, in order. Documents which
+// were deleted, which emitted nothing, or whose map function threw an
+// exception, are removed from the index.
+func (d *db) addMapJobs(batch *mapIndexBatch, jobs []*mapJob) {
+	for _, job := range jobs {
+		switch {
+		case job.deleted:
+			batch.delete(job.id, job.rev)
+		case job.err != nil:
+			d.logger.Printf("map function threw exception for %s: %s", job.id, job.err)
+			batch.delete(job.id, job.rev)
+		case len(job.emitted) == 0:
+			// Remove any rows emitted for a previous revision.
+			batch.delete(job.id, job.rev)
+		default:
+			for _, entry := range job.emitted {
+				batch.add(job.id, job.rev, entry.Key, entry.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestUpdateIndex_parallel(t *testing.T) {
+	t.Parallel()
+	d := newDB(t, OptionMapParallelism(4))
+	ddocRev := d.tPut("_design/foo", map[string]interface{}{
+		"views": map[string]interface{}{
+			"even": map[string]string{
+				"map": `function(doc) { if (doc.n % 2 === 0) { emit(doc.n, null); } }`,
+			},
+		},
+	})
+	const count = 250
+	for i := 0; i < count; i++ {
+		_ = d.tPut(fmt.Sprintf("doc%03d", i), map[string]interface{}{"n": i})
+	}
+	query := func(view string) []rowResult {
+		t.Helper()
+		rows, err := d.Query(context.Background(), "_design/foo", "_view/"+view, mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		return readRows(t, rows)
+	}
+
+	var want []rowResult
+	for i := 0; i < count; i += 2 {
+		want = append(want, rowResult{ID: fmt.Sprintf("doc%03d", i), Key: strconv.Itoa(i), Value: "null"})
+	}
+	if d := cmp.Diff(want, query("even")); d != "" {
+		t.Errorf("Unexpected even rows:\n%s", d)
+	}
+
+	pools := d.DB.(*db).mappers
+	pool := pools.pools["test/foo/even"]
+	if pool == nil || pool.rev != ddocRev || len(pool.idle) == 0 {
+		t.Errorf("Expected idle mappers to be pooled for rev %s", ddocRev)
+	}
+}
+
+func TestUpdateIndex_timeout(t *testing.T) {
+	t.Parallel()
+	d := newDB(t, OptionFunctionTimeout(50*time.Millisecond))
+	_ = d.tPut("_design/foo", map[string]interface{}{
+		"views": map[string]interface{}{
+			"bar": map[string]string{
+				"map": `function(doc) {
+					if (doc._id === "slow") {
+						while (true) {}
+					}
+					emit(doc._id, null);
+				}`,
+			},
+		},
+	})
+	_ = d.tPut("fast", map[string]string{})
+	_ = d.tPut("slow", map[string]string{})
+
+	rows, err := d.Query(context.Background(), "_design/foo", "_view/bar", kivik.Params(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	want := []rowResult{{ID: "fast", Key: `"fast"`, Value: "null"}}
+	if d := cmp.Diff(want, readRows(t, rows)); d != "" {
+		t.Errorf("Unexpected rows:\n%s", d)
+	}
+	d.checkLogs([]string{`^map function threw exception for slow: function timed out after 50ms$`})
+}
@@ -0,0 +1,195 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// schemaVersion is the version of the schema created by this driver, which is
+// stored in the SQLite user_version of the file. Files of version 0, created
+// by earlier versions of the driver, are migrated when opened. Files of a
+// later version are refused.
+const schemaVersion = 1
+
+// migrateSchema brings the schema of the file up to date, in a single
+// transaction.
+func (c *client) migrateSchema(ctx context.Context) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	switch {
+	case version == schemaVersion:
+		return nil
+	case version > schemaVersion:
+		return fmt.Errorf("unsupported schema version %d, the newest supported version is %d", version, schemaVersion)
+	}
+
+	dbs, err := c.allDBs(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, name := range dbs {
+		if err := c.newDB(name).migrateV1(ctx, tx); err != nil {
+			return fmt.Errorf("failed to migrate database %s: %w", name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ifNotExists rewrites a schema statement to skip existing tables and indexes.
+func ifNotExists(query string) string {
+	query = strings.Replace(query, "CREATE TABLE ", "CREATE TABLE IF NOT EXISTS ", 1)
+	return strings.Replace(query, "CREATE INDEX ", "CREATE INDEX IF NOT EXISTS ", 1)
+}
+
+// migrateV1 migrates a database created with schema version 0, which lacks
+// the purges, security, revs limit and reduce cache tables, names the indexes
+// of the revs table without the database name, and stores attachment content
+// in the attachments table, rather than in the attachment data table.
+func (d *db) migrateV1(ctx context.Context, tx *sql.Tx) error {
+	// The revs indexes are recreated with per-database names below.
+	for _, index := range []string{"default_key", "idx_parent"} {
+		var owned bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'index' AND name = $1 AND tbl_name = $2)
+		`, index, unquote(d.query("{{ .Revs }}"))).Scan(&owned)
+		if err != nil {
+			return err
+		}
+		if owned {
+			if _, err := tx.ExecContext(ctx, "DROP INDEX "+index); err != nil {
+				return err
+			}
+		}
+	}
+	for _, query := range schema {
+		if _, err := tx.ExecContext(ctx, ifNotExists(d.query(query))); err != nil {
+			return err
+		}
+	}
+	if err := d.migrateAttachmentData(ctx, tx); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, d.query(`
+		SELECT DISTINCT id, rev, rev_id, func_name, collation
+		FROM {{ .Design }}
+		WHERE func_type = 'map'
+	`))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type view struct {
+		ddoc, name string
+		rev        revision
+		collation  *string
+	}
+	var views []view
+	for rows.Next() {
+		var v view
+		if err := rows.Scan(&v.ddoc, &v.rev.rev, &v.rev.id, &v.name, &v.collation); err != nil {
+			return err
+		}
+		views = append(views, v)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+	for _, v := range views {
+		for _, query := range viewSchema {
+			if _, err := tx.ExecContext(ctx, d.createDdocQuery(v.ddoc, v.name, v.rev.String(), ifNotExists(query), v.collation)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateAttachmentData moves the content of attachments from the data column
+// of the attachments table to the attachment data table, keyed by hash.
+func (d *db) migrateAttachmentData(ctx context.Context, tx *sql.Tx) error {
+	var legacy bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pragma_table_info($1) WHERE name = 'data')
+	`, unquote(d.query("{{ .Attachments }}"))).Scan(&legacy); err != nil {
+		return err
+	}
+	if !legacy {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, d.query(`
+		ALTER TABLE {{ .Attachments }} ADD COLUMN hash BLOB REFERENCES {{ .AttachmentData }} (hash)
+	`)); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, d.query(`
+		SELECT pk, content_type, data
+		FROM {{ .Attachments }}
+	`))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type legacyAttachment struct {
+		pk          int64
+		contentType string
+		att         attachment
+	}
+	var atts []legacyAttachment
+	for rows.Next() {
+		var a legacyAttachment
+		if err := rows.Scan(&a.pk, &a.contentType, &a.att.Content); err != nil {
+			return err
+		}
+		a.att.Length = int64(len(a.att.Content))
+		atts = append(atts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	stmts := newStmtCache()
+	for _, a := range atts {
+		hash, err := d.storeAttachmentData(ctx, tx, stmts, &a.att, a.contentType)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, d.query(`
+			UPDATE {{ .Attachments }} SET hash = $1 WHERE pk = $2
+		`), hash, a.pk); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, d.query(`
+		ALTER TABLE {{ .Attachments }} DROP COLUMN data
+	`))
+	return err
+}
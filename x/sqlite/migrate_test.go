@@ -0,0 +1,198 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+// schemaV0 is the schema of the test database, as created by schema version 0.
+var schemaV0 = []string{
+	`CREATE TABLE "test_revs" (
+		id TEXT NOT NULL,
+		rev INTEGER NOT NULL,
+		rev_id TEXT NOT NULL,
+		key TEXT GENERATED ALWAYS AS (json_quote(id)) VIRTUAL COLLATE COUCHDB_UCI,
+		parent_rev INTEGER,
+		parent_rev_id TEXT,
+		FOREIGN KEY (id, parent_rev, parent_rev_id) REFERENCES "test_revs" (id, rev, rev_id) ON DELETE CASCADE,
+		UNIQUE(id, rev, rev_id)
+	)`,
+	`CREATE INDEX default_key ON "test_revs" (key)`,
+	`CREATE INDEX idx_parent ON "test_revs" (id, parent_rev, parent_rev_id)`,
+	`CREATE TABLE "test" (
+		seq INTEGER PRIMARY KEY,
+		id TEXT NOT NULL,
+		rev INTEGER NOT NULL,
+		rev_id TEXT NOT NULL,
+		doc BLOB NOT NULL,
+		md5sum BLOB NOT NULL,
+		deleted BOOLEAN NOT NULL DEFAULT FALSE,
+		FOREIGN KEY (id, rev, rev_id) REFERENCES "test_revs" (id, rev, rev_id) ON DELETE CASCADE,
+		UNIQUE(id, rev, rev_id)
+	)`,
+	`CREATE TABLE "test_attachments" (
+		pk INTEGER PRIMARY KEY,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		length INTEGER NOT NULL,
+		digest BLOB NOT NULL,
+		data BLOB NOT NULL,
+		rev_pos INTEGER NOT NULL
+	)`,
+	`CREATE TABLE "test_attachments_bridge" (
+		pk INTEGER,
+		id TEXT NOT NULL,
+		rev INTEGER NOT NULL,
+		rev_id TEXT NOT NULL,
+		FOREIGN KEY (pk) REFERENCES "test_attachments" (pk),
+		FOREIGN KEY (id, rev, rev_id) REFERENCES "test" (id, rev, rev_id) ON DELETE CASCADE,
+		UNIQUE (id, rev, rev_id, pk)
+	)`,
+	`CREATE TABLE "test_design" (
+		id TEXT NOT NULL,
+		rev INTEGER NOT NULL,
+		rev_id TEXT NOT NULL,
+		language TEXT NOT NULL DEFAULT 'javascript',
+		func_type TEXT CHECK (func_type IN ('map', 'reduce', 'update', 'filter', 'validate')) NOT NULL,
+		func_name TEXT NOT NULL,
+		func_body TEXT NOT NULL,
+		auto_update BOOLEAN NOT NULL DEFAULT TRUE,
+		include_design BOOLEAN,
+		local_seq BOOLEAN,
+		collation STRING CHECK (collation IN ('raw', 'ascii')),
+		last_seq INTEGER,
+		FOREIGN KEY (id, rev, rev_id) REFERENCES "test" (id, rev, rev_id) ON DELETE CASCADE,
+		UNIQUE (id, rev, rev_id, func_type, func_name)
+	)`,
+}
+
+// newV0File returns the path to a file created with schema version 0, with a
+// document with an attachment, and a design document with a view.
+func newV0File(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	digest := md5.Sum([]byte("hello"))
+	queries := append(schemaV0,
+		`INSERT INTO "test_revs" (id, rev, rev_id) VALUES ('foo', 1, 'abc'), ('_design/foo', 1, 'abc')`,
+		`INSERT INTO "test" (id, rev, rev_id, doc, md5sum) VALUES ('foo', 1, 'abc', '{}', ''), ('_design/foo', 1, 'abc', '{"views":{"bar":{"map":"function(doc) { emit(doc._id, null); }"}}}', '')`,
+		`INSERT INTO "test_attachments" (pk, filename, content_type, length, digest, data, rev_pos) VALUES (1, 'att.txt', 'text/plain', 5, x'`+hex.EncodeToString(digest[:])+`', 'hello', 1)`,
+		`INSERT INTO "test_attachments_bridge" (pk, id, rev, rev_id) VALUES (1, 'foo', 1, 'abc')`,
+		`INSERT INTO "test_design" (id, rev, rev_id, func_type, func_name, func_body) VALUES ('_design/foo', 1, 'abc', 'map', 'bar', 'function(doc) { emit(doc._id, null); }')`,
+	)
+	d := (&client{}).newDB("test")
+	queries = append(queries,
+		d.ddocQuery("_design/foo", "bar", "1-abc", `CREATE TABLE {{ .Map }} (
+			pk INTEGER PRIMARY KEY,
+			id TEXT NOT NULL,
+			rev INTEGER NOT NULL,
+			rev_id TEXT NOT NULL,
+			key TEXT COLLATE COUCHDB_UCI,
+			value TEXT,
+			FOREIGN KEY (id, rev, rev_id) REFERENCES "test" (id, rev, rev_id)
+		)`),
+	)
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+	}
+	return path
+}
+
+func TestMigrateSchema(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("version 0", func(t *testing.T) {
+		t.Parallel()
+		dClient, err := drv{}.NewClient(newV0File(t), mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := dClient.(*client)
+		t.Cleanup(func() { _ = c.db.Close() })
+
+		var version int
+		if err := c.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != schemaVersion {
+			t.Errorf("Unexpected schema version: %d", version)
+		}
+
+		d, err := dClient.DB("test", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		att, err := d.(*db).GetAttachment(ctx, "foo", "att.txt", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(att.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "hello" {
+			t.Errorf("Unexpected attachment content: %q", content)
+		}
+
+		rows, err := d.Query(ctx, "_design/foo", "_view/bar", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRows(t, rows, []rowResult{
+			{ID: "foo", Key: `"foo"`, Value: "null"},
+		})
+
+		// A second database no longer collides on the revs index names.
+		if err := dClient.CreateDB(ctx, "second", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("newer version", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "test.db")
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("PRAGMA user_version = 99"); err != nil {
+			t.Fatal(err)
+		}
+		_ = db.Close()
+
+		_, err = drv{}.NewClient(path, mock.NilOption)
+		if !testy.ErrorMatches("unsupported schema version 99, the newest supported version is 1", err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kivik/kivik/v4"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/x/sqlite/v4/js"
+	"github.com/go-kivik/kivik/x/sqlite/v4/reduce"
+)
+
+// languageGo is the design document language which refers to native Go
+// functions, registered by name, rather than JavaScript source.
+const languageGo = "go"
+
+// MapFunc is a native Go map function. It is called once for each document,
+// and calls emit for each key/value pair to add to the view.
+type MapFunc func(doc map[string]any, emit func(key, value any))
+
+// ReduceFunc is a native Go reduce function. It has the same semantics as a
+// JavaScript reduce function, but returns a single value.
+type ReduceFunc func(keys [][2]any, values []any, rereduce bool) (any, error)
+
+// FilterFunc is a native Go changes filter function. req is always nil.
+type FilterFunc func(doc, req map[string]any) bool
+
+// ValidateFunc is a native Go validate_doc_update function. oldDoc is nil
+// when a new document is created. Returning an error rejects the update;
+// errors with the status 401 are reported as unauthorized, all others as
+// forbidden.
+type ValidateFunc func(newDoc, oldDoc, userCtx, secObj map[string]any) error
+
+var native = struct {
+	mu        sync.RWMutex
+	maps      map[string]MapFunc
+	filters   map[string]FilterFunc
+	validates map[string]ValidateFunc
+}{
+	maps:      map[string]MapFunc{},
+	filters:   map[string]FilterFunc{},
+	validates: map[string]ValidateFunc{},
+}
+
+func register[T any](registry map[string]T, kind, name string, fn T, isNil bool) {
+	native.mu.Lock()
+	defer native.mu.Unlock()
+	if isNil {
+		panic("sqlite: Register" + kind + " function is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("sqlite: Register" + kind + " called twice for " + name)
+	}
+	registry[name] = fn
+}
+
+func lookup[T any](registry map[string]T, kind, name string) (T, error) {
+	native.mu.RLock()
+	defer native.mu.RUnlock()
+	fn, ok := registry[name]
+	if !ok {
+		return fn, &internal.Error{Status: http.StatusInternalServerError, Message: fmt.Sprintf("no Go %s function registered as '%s'", kind, name)}
+	}
+	return fn, nil
+}
+
+// RegisterMap makes fn available, as name, as the map function of views in
+// design documents with "language": "go". It panics if called twice with the
+// same name, or if fn is nil.
+func RegisterMap(name string, fn MapFunc) {
+	register(native.maps, "Map", name, fn, fn == nil)
+}
+
+// RegisterReduce makes fn available, as name, as the reduce function of views.
+// As with the built-in reduce functions, such as _count, views of design
+// documents in any language may refer to it by name. It panics if called twice
+// with the same name, if name begins with an underscore, or if fn is nil.
+func RegisterReduce(name string, fn ReduceFunc) {
+	if fn == nil {
+		panic("sqlite: RegisterReduce function is nil")
+	}
+	reduce.Register(name, func(keys [][2]any, values []any, rereduce bool) ([]any, error) {
+		value, err := fn(keys, values, rereduce)
+		if err != nil {
+			return nil, err
+		}
+		return []any{value}, nil
+	})
+}
+
+// RegisterFilter makes fn available, as name, as a changes filter function in
+// design documents with "language": "go". It panics if called twice with the
+// same name, or if fn is nil.
+func RegisterFilter(name string, fn FilterFunc) {
+	register(native.filters, "Filter", name, fn, fn == nil)
+}
+
+// RegisterValidate makes fn available, as name, as the validate_doc_update
+// function of design documents with "language": "go". It panics if called
+// twice with the same name, or if fn is nil.
+func RegisterValidate(name string, fn ValidateFunc) {
+	register(native.validates, "Validate", name, fn, fn == nil)
+}
+
+// recoverPanic converts a panic in a Go design function into an error, so
+// that it is treated as an exception in a JavaScript function would be.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("panic: %v", r)
+	}
+}
+
+// toMap returns v as a map, or nil if it is not one.
+func toMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+// compileMap returns the map function body, in language, which calls emit.
+// opts configure the environment of JavaScript functions, and are ignored for
+// Go functions, as are those of the other compile functions.
+func compileMap(language, body string, emit func(key, value any), opts ...js.Option) (js.MapFunc, error) {
+	if language != languageGo {
+		return js.Map(body, emit, opts...)
+	}
+	fn, err := lookup(native.maps, "map", body)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc any) (err error) {
+		defer recoverPanic(&err)
+		fn(toMap(doc), emit)
+		return nil
+	}, nil
+}
+
+// compileFilter returns the filter function body, in language.
+func compileFilter(language, body string, opts ...js.Option) (js.FilterFunc, error) {
+	if language != languageGo {
+		return js.Filter(body, opts...)
+	}
+	fn, err := lookup(native.filters, "filter", body)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc, req any) (ok bool, err error) {
+		defer recoverPanic(&err)
+		return fn(toMap(doc), toMap(req)), nil
+	}, nil
+}
+
+// compileValidate returns the validate_doc_update function body, in language.
+// Errors returned by a Go function are converted to [js.ValidationError]s.
+func compileValidate(language, body string, opts ...js.Option) (js.ValidateFunc, error) {
+	if language != languageGo {
+		return js.Validate(body, opts...)
+	}
+	fn, err := lookup(native.validates, "validate", body)
+	if err != nil {
+		return nil, err
+	}
+	return func(newDoc, oldDoc, userCtx, secObj any) (err error) {
+		defer recoverPanic(&err)
+		if err := fn(toMap(newDoc), toMap(oldDoc), toMap(userCtx), toMap(secObj)); err != nil {
+			reason := "forbidden"
+			if kivik.HTTPStatus(err) == http.StatusUnauthorized {
+				reason = "unauthorized"
+			}
+			return &js.ValidationError{Reason: reason, Message: err.Error()}
+		}
+		return nil
+	}, nil
+}
+
+// compileReduce returns the reduce function body, in language. Built-in and
+// registered reduce functions are available in any language. As with
+// JavaScript, errors and panics in a Go function are logged, and the result is
+// null.
+func compileReduce(language, body string, logger *log.Logger, opts ...js.Option) (reduce.Func, error) {
+	if language == languageGo && body != "" && !strings.HasPrefix(body, "_") && !reduce.Registered(body) {
+		return nil, &internal.Error{Status: http.StatusInternalServerError, Message: fmt.Sprintf("no Go reduce function registered as '%s'", body)}
+	}
+	return reduce.ParseFunc(body, logger, opts...)
+}
@@ -0,0 +1,238 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func init() {
+	RegisterMap("test/byType", func(doc map[string]any, emit func(key, value any)) {
+		if typ, ok := doc["type"].(string); ok {
+			emit(typ, doc["value"])
+		}
+	})
+	RegisterMap("test/panics", func(doc map[string]any, emit func(key, value any)) {
+		if doc["_id"] == "bad" {
+			panic("bad doc")
+		}
+		emit(doc["_id"], nil)
+	})
+	RegisterReduce("test/max", func(_ [][2]any, values []any, _ bool) (any, error) {
+		var largest float64
+		for _, v := range values {
+			if f, _ := v.(float64); f > largest {
+				largest = f
+			}
+		}
+		return largest, nil
+	})
+	RegisterReduce("test/fails", func([][2]any, []any, bool) (any, error) {
+		return nil, errors.New("reduce failed")
+	})
+	RegisterFilter("test/posts", func(doc, _ map[string]any) bool {
+		return doc["type"] == "post"
+	})
+	RegisterValidate("test/requireType", func(newDoc, _, _, _ map[string]any) error {
+		if newDoc["_deleted"] == true {
+			return &internal.Error{Status: http.StatusUnauthorized, Message: "deletes not allowed"}
+		}
+		if _, ok := newDoc["type"]; !ok {
+			return errors.New("type is required")
+		}
+		return nil
+	})
+}
+
+func TestNativeFunctions_query(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		view       string
+		options    map[string]interface{}
+		want       []rowResult
+		wantLogs   []string
+		wantStatus int
+		wantErr    string
+	}
+
+	tests := testy.NewTable()
+	tests.Add("map", test{
+		view:    "byType",
+		options: map[string]interface{}{"reduce": false},
+		want: []rowResult{
+			{ID: "a", Key: `"post"`, Value: "1"},
+			{ID: "c", Key: `"post"`, Value: "3"},
+			{ID: "b", Key: `"user"`, Value: "2"},
+		},
+	})
+	tests.Add("Go reduce", test{
+		view:    "byType",
+		options: map[string]interface{}{"group": true},
+		want: []rowResult{
+			{Key: `"post"`, Value: "3"},
+			{Key: `"user"`, Value: "2"},
+		},
+	})
+	tests.Add("built-in reduce", test{
+		view: "count",
+		want: []rowResult{
+			{Key: "null", Value: "3"},
+		},
+	})
+	tests.Add("reduce error is logged", test{
+		view: "fails",
+		want: []rowResult{
+			{Key: "null", Value: "null"},
+		},
+		wantLogs: []string{
+			"reduce function threw exception: reduce failed",
+			"reduce function threw exception: reduce failed",
+			"reduce function threw exception: reduce failed",
+		},
+	})
+	tests.Add("map panic is logged", test{
+		view: "panics",
+		want: []rowResult{
+			{ID: "a", Key: `"a"`, Value: "null"},
+			{ID: "b", Key: `"b"`, Value: "null"},
+			{ID: "c", Key: `"c"`, Value: "null"},
+		},
+		wantLogs: []string{"map function threw exception for bad: panic: bad doc"},
+	})
+	tests.Add("unregistered map", test{
+		view:       "unregistered",
+		wantStatus: http.StatusInternalServerError,
+		wantErr:    "no Go map function registered as 'test/unregistered'",
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		d := newDB(t)
+		mapFunc := "test/byType"
+		reduceFunc := "test/max"
+		switch tt.view {
+		case "count":
+			reduceFunc = "_count"
+		case "fails":
+			reduceFunc = "test/fails"
+		case "panics":
+			mapFunc, reduceFunc = "test/panics", ""
+		case "unregistered":
+			mapFunc = "test/unregistered"
+		}
+		view := map[string]string{"map": mapFunc}
+		if reduceFunc != "" {
+			view["reduce"] = reduceFunc
+		}
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"language": "go",
+			"views":    map[string]interface{}{tt.view: view},
+		})
+		_ = d.tPut("a", map[string]interface{}{"type": "post", "value": 1})
+		_ = d.tPut("b", map[string]interface{}{"type": "user", "value": 2})
+		_ = d.tPut("c", map[string]interface{}{"type": "post", "value": 3})
+		if tt.view == "panics" {
+			_ = d.tPut("bad", map[string]interface{}{})
+		}
+
+		rows, err := d.Query(context.Background(), "_design/foo", "_view/"+tt.view, kivik.Params(tt.options))
+		if !testy.ErrorMatches(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if err != nil {
+			return
+		}
+		if d := cmp.Diff(tt.want, readRows(t, rows)); d != "" {
+			t.Errorf("Unexpected rows:\n%s", d)
+		}
+		d.checkLogs(tt.wantLogs)
+	})
+}
+
+func TestNativeFunctions_changes_filter(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	_ = d.tPut("_design/foo", map[string]interface{}{
+		"language": "go",
+		"filters":  map[string]string{"posts": "test/posts"},
+	})
+	_ = d.tPut("a", map[string]interface{}{"type": "post"})
+	_ = d.tPut("b", map[string]interface{}{"type": "user"})
+	_ = d.tPut("c", map[string]interface{}{"type": "post"})
+
+	changes, err := d.Changes(context.Background(), kivik.Param("filter", "foo/posts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer changes.Close()
+	var got []string
+	for {
+		var change driver.Change
+		err := changes.Next(&change)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, change.ID)
+	}
+	if d := cmp.Diff([]string{"a", "c"}, got); d != "" {
+		t.Errorf("Unexpected changes:\n%s", d)
+	}
+}
+
+func TestNativeFunctions_validate(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	_ = d.tPut("_design/foo", map[string]interface{}{
+		"language":            "go",
+		"validate_doc_update": "test/requireType",
+	})
+	ctx := context.Background()
+
+	_, err := d.Put(ctx, "a", map[string]interface{}{}, mock.NilOption)
+	if status := kivik.HTTPStatus(err); status != http.StatusForbidden {
+		t.Errorf("Unexpected status for missing type: %d (%v)", status, err)
+	}
+	if !testy.ErrorMatches("type is required", err) {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	rev := d.tPut("a", map[string]interface{}{"type": "post"})
+	_, err = d.Delete(ctx, "a", kivik.Rev(rev))
+	if status := kivik.HTTPStatus(err); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status for delete: %d (%v)", status, err)
+	}
+	if !testy.ErrorMatches("deletes not allowed", err) {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// notifier wakes continuous changes feeds when a write to their database is
+// committed, and DB updates feeds when any database is created, updated, or
+// deleted. Writes made by other processes sharing the same SQLite file are
+// not detected.
+type notifier struct {
+	mu      sync.Mutex
+	waiting map[string]chan struct{}
+}
+
+// wait returns a channel which is closed at the next commit to the named
+// database.
+func (n *notifier) wait(name string) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.waiting == nil {
+		n.waiting = map[string]chan struct{}{}
+	}
+	ch, ok := n.waiting[name]
+	if !ok {
+		ch = make(chan struct{})
+		n.waiting[name] = ch
+	}
+	return ch
+}
+
+func (n *notifier) notify(name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ch, ok := n.waiting[name]; ok {
+		close(ch)
+		delete(n.waiting, name)
+	}
+}
+
+// commit records an update event for d, commits tx, which writes to d, and
+// notifies any waiting changes and DB updates feeds.
+func (d *db) commit(ctx context.Context, tx *sql.Tx) error {
+	if err := recordDBUpdate(ctx, tx, d.name, dbUpdateUpdated); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.notifier.notify(d.name)
+	d.notifier.notify(dbUpdatesTable)
+	return nil
+}
@@ -26,6 +26,9 @@ import (
 )
 
 func (d *db) OpenRevs(ctx context.Context, docID string, revs []string, options driver.Options) (driver.Rows, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
 	opts := newOpts(options)
 	values := make([]string, 0, len(revs))
 	args := make([]interface{}, 5, len(revs)*2+5)
@@ -137,7 +140,9 @@ func (d *db) OpenRevs(ctx context.Context, docID string, revs []string, options
 			length,
 			digest,
 			rev_pos,
-			data
+			encoding,
+			data,
+			blob
 		FROM (
 			SELECT
 				open_revs.rev,
@@ -153,7 +158,9 @@ func (d *db) OpenRevs(ctx context.Context, docID string, revs []string, options
 				att.length,
 				att.digest,
 				att.rev_pos,
-				att.data,
+				content.encoding,
+				content.data,
+				IIF(content.data IS NULL, lower(hex(content.hash)), '') AS blob,
 				SUM(CASE WHEN bridge.pk IS NOT NULL THEN 1 ELSE 0 END) OVER (PARTITION BY open_revs.rev, open_revs.rev_id) AS attachment_count,
 				ROW_NUMBER() OVER (PARTITION BY open_revs.rev, open_revs.rev_id) AS row_number
 			FROM open_revs
@@ -161,9 +168,10 @@ func (d *db) OpenRevs(ctx context.Context, docID string, revs []string, options
 			LEFT JOIN ancestors ON $5 AND open_revs.id = ancestors.id AND open_revs.rev = ancestors.rev AND open_revs.rev_id = ancestors.rev_id
 			LEFT JOIN {{ .AttachmentsBridge }} AS bridge ON open_revs.id = bridge.id AND open_revs.rev = bridge.rev AND open_revs.rev_id = bridge.rev_id
 			LEFT JOIN {{ .Attachments }} AS att ON bridge.pk = att.pk
+			LEFT JOIN {{ .AttachmentData }} AS content ON content.hash = att.hash
 			ORDER BY open_revs.rev, open_revs.rev_id, parent_rev DESC, parent_rev_id DESC
 		)
-		GROUP BY rev, rev_id, deleted, doc, attachment_count, filename, content_type, length, digest, rev_pos, data
+		GROUP BY rev, rev_id, deleted, doc, attachment_count, filename, content_type, length, digest, rev_pos, encoding, data, blob
 	`), strings.Join(values, ", "))
 	rows, err := d.db.QueryContext(ctx, query, args...) //nolint:rowserrcheck // Err checked in Next
 	if err != nil {
@@ -180,6 +188,7 @@ func (d *db) OpenRevs(ctx context.Context, docID string, revs []string, options
 	}
 
 	return &openRevsRows{
+		db:   d,
 		id:   docID,
 		ctx:  ctx,
 		pre:  true,
@@ -188,6 +197,7 @@ func (d *db) OpenRevs(ctx context.Context, docID string, revs []string, options
 }
 
 type openRevsRows struct {
+	db  *db
 	id  string
 	ctx context.Context
 	// pre is during instantiation to indicate that the first call to Next has
@@ -220,11 +230,13 @@ func (r *openRevsRows) Next(row *driver.Row) error {
 			length                *int64
 			revPos                *int
 			digest                *md5sum
-			data                  *[]byte
+			encoding              *string
+			data                  []byte
+			blob                  *string
 		)
 		if err := r.rows.Scan(
 			&rowRev, &rowRevID, &rowDeleted, &rowDoc, &ancestors,
-			&attachmentCount, &filename, &contentType, &length, &digest, &revPos, &data,
+			&attachmentCount, &filename, &contentType, &length, &digest, &revPos, &encoding, &data, &blob,
 		); err != nil {
 			return err
 		}
@@ -273,7 +285,11 @@ func (r *openRevsRows) Next(row *driver.Row) error {
 				Length:      *length,
 				RevPos:      *revPos,
 			}
-			att.Data, _ = json.Marshal(*data)
+			content, err := r.db.readAttachment(encoding, data, *blob)
+			if err != nil {
+				return err
+			}
+			att.Data, _ = json.Marshal(content)
 			doc.Attachments[*filename] = att
 		}
 
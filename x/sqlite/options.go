@@ -20,6 +20,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-kivik/kivik/v4/driver"
 	internal "github.com/go-kivik/kivik/v4/int/errors"
@@ -169,10 +170,40 @@ func (o optsMap) feed() (string, error) {
 		return "normal", nil
 	}
 	switch feed {
-	case feedNormal, feedLongpoll:
+	case feedNormal, feedLongpoll, feedContinuous:
 		return feed, nil
+	case feedEventsource:
+		// The eventsource format only differs from continuous on the wire.
+		return feedContinuous, nil
 	}
-	return "", &internal.Error{Status: http.StatusBadRequest, Message: "supported `feed` types: normal, longpoll"}
+	return "", &internal.Error{Status: http.StatusBadRequest, Message: "supported `feed` types: normal, longpoll, continuous, eventsource"}
+}
+
+// heartbeat returns true if the heartbeat option is set, to either true, or
+// a number of milliseconds.
+func (o optsMap) heartbeat() (bool, error) {
+	in, ok := o["heartbeat"]
+	if !ok {
+		return false, nil
+	}
+	if b, ok := in.(bool); ok {
+		return b, nil
+	}
+	if s, ok := in.(string); ok && s == "true" {
+		return true, nil
+	}
+	ms, err := toUint64(in, "invalid value for 'heartbeat'")
+	return ms > 0, err
+}
+
+// timeout returns the timeout option, or the default of 60 seconds if unset.
+func (o optsMap) timeout() (time.Duration, error) {
+	in, ok := o["timeout"]
+	if !ok {
+		return defaultChangesTimeout, nil
+	}
+	ms, err := toUint64(in, "invalid value for 'timeout'")
+	return time.Duration(ms) * time.Millisecond, err
 }
 
 // since returns true if the value is "now", otherwise it returns the sequence
@@ -214,8 +245,8 @@ func (o optsMap) changesFilter() (filterType, filterDdoc, filterName string, _ e
 	filter, _ := raw.(string)
 	field, filterType := "filter", "filter"
 	switch filter {
-	case "_doc_ids":
-		return "_doc_ids", "", "", nil
+	case "_doc_ids", "_selector", "_design":
+		return filter, "", "", nil
 	case "_view":
 		raw, ok := o["view"]
 		if !ok {
@@ -236,7 +267,17 @@ func (o optsMap) changesWhere(args *[]any) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if filterType != "_doc_ids" {
+	switch filterType {
+	case "_design":
+		return `WHERE results.id LIKE '\_design/%' ESCAPE '\'`, nil
+	case "_selector":
+		_, raw, err := o.changesSelector()
+		if err != nil {
+			return "", err
+		}
+		return selectorWhere(raw, args), nil
+	case "_doc_ids":
+	default:
 		return "", nil
 	}
 
@@ -259,6 +300,35 @@ func (o optsMap) changesWhere(args *[]any) (string, error) {
 	return fmt.Sprintf("WHERE results.id IN (%s)", placeholders(start+1, len(*args)-start)), nil
 }
 
+// changesSelector returns the selector for filter=_selector, both parsed and
+// in its raw JSON form.
+func (o optsMap) changesSelector() (*mango.Selector, json.RawMessage, error) {
+	in, ok := o["selector"]
+	if !ok {
+		return nil, nil, &internal.Error{Status: http.StatusBadRequest, Message: "filter=_selector requires 'selector' parameter"}
+	}
+	var raw json.RawMessage
+	switch t := in.(type) {
+	case json.RawMessage:
+		raw = t
+	case []byte:
+		raw = t
+	case string:
+		raw = json.RawMessage(t)
+	default:
+		var err error
+		raw, err = json.Marshal(in)
+		if err != nil {
+			return nil, nil, &internal.Error{Status: http.StatusBadRequest, Err: err}
+		}
+	}
+	var sel mango.Selector
+	if err := json.Unmarshal(raw, &sel); err != nil {
+		return nil, nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid value for 'selector': %s", err)}
+	}
+	return &sel, raw, nil
+}
+
 // limit returns the limit value as an int64, or -1 if the limit is unset.
 // If the limit is invalid, an error is returned with status 400.
 func (o optsMap) limit() (int64, error) {
@@ -553,7 +623,10 @@ func (o optsMap) groupLevel() (uint64, error) {
 	return toUint64(raw, "invalid value for 'group_level'")
 }
 
-func (o optsMap) sort() ([]string, error) {
+// sort returns the fields by which _find results are sorted. Each field may
+// be a field name, for ascending order, or an object mapping the field name to
+// a direction.
+func (o optsMap) sort() ([]indexField, error) {
 	raw, ok := o["sort"]
 	if !ok {
 		return nil, nil
@@ -562,17 +635,111 @@ func (o optsMap) sort() ([]string, error) {
 	if !ok {
 		return nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid value for 'sort': %v", raw)}
 	}
-	sort := make([]string, len(list))
+	sort := make([]indexField, len(list))
 	for i, v := range list {
-		s, ok := v.(string)
-		if !ok {
-			return nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid 'sort' field: %v", v)}
+		switch t := v.(type) {
+		case string:
+			sort[i] = indexField{name: t}
+			continue
+		case map[string]interface{}:
+			if len(t) == 1 {
+				for name, dir := range t {
+					field, err := newIndexField(name, dir)
+					if err != nil {
+						return nil, err
+					}
+					sort[i] = field
+				}
+				continue
+			}
+		}
+		return nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid 'sort' field: %v", v)}
+	}
+	for _, field := range sort {
+		if field.name == "" || strings.Contains(field.name, `"`) {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid 'sort' field: %v", field.name)}
+		}
+		if field.desc != sort[0].desc {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Message: "sorts currently only support a single direction for all fields"}
 		}
-		sort[i] = s
 	}
 	return sort, nil
 }
 
+// useIndex returns the design document, and optionally the index name, of
+// the index requested with use_index.
+func (o optsMap) useIndex() ([]string, error) {
+	raw, ok := o["use_index"]
+	if !ok {
+		return nil, nil
+	}
+	invalid := &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid value for 'use_index': %v", raw)}
+	var parts []string
+	switch t := raw.(type) {
+	case string:
+		parts = []string{t}
+	case []interface{}:
+		if len(t) == 0 || len(t) > 2 {
+			return nil, invalid
+		}
+		for _, v := range t {
+			s, ok := v.(string)
+			if !ok {
+				return nil, invalid
+			}
+			parts = append(parts, s)
+		}
+	default:
+		return nil, invalid
+	}
+	if parts[0] == "" {
+		return nil, invalid
+	}
+	parts[0] = ddocID(parts[0])
+	return parts, nil
+}
+
+// findBool returns the value of the boolean _find option key, or def if it is
+// unset.
+func (o optsMap) findBool(key string, def bool) (bool, error) {
+	raw, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	v, ok := raw.(bool)
+	if !ok {
+		return false, &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid value for '%s': %v", key, raw)}
+	}
+	return v, nil
+}
+
+// readQuorum validates the r option. It has no effect, as there is only ever
+// a single copy of each document.
+func (o optsMap) readQuorum() error {
+	raw, ok := o["r"]
+	if !ok {
+		return nil
+	}
+	r, err := toInt64(raw, "invalid value for 'r'")
+	if err != nil {
+		return err
+	}
+	if r < 1 {
+		return &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid value for 'r': %v", raw)}
+	}
+	return nil
+}
+
+// stale validates the deprecated stale option, which is only accepted by
+// _find for backward compatibility.
+func (o optsMap) stale() error {
+	raw, ok := o["stale"]
+	if !ok || raw == "ok" {
+		return nil
+	}
+	return &internal.Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid value for 'stale': %v", raw)}
+}
+
 func (o optsMap) bookmark() (string, error) {
 	raw, ok := o["bookmark"]
 	if !ok {
@@ -661,35 +828,6 @@ func (o optsMap) attEncodingInfo() (bool, error) {
 
 const defaultWhereCap = 3
 
-// buildReduceCacheWhere returns WHERE conditions for use when querying the
-// reduce cache.
-func (v viewOptions) buildReduceCacheWhere(args *[]any) []string {
-	where := make([]string, 0, defaultWhereCap)
-	if v.endkey != "" {
-		op := endKeyOp(v.descending, v.inclusiveEnd)
-		where = append(where, fmt.Sprintf("view.last_key %s $%d", op, len(*args)+1))
-		*args = append(*args, v.endkey)
-	}
-	if v.startkey != "" {
-		op := startKeyOp(v.descending)
-		where = append(where, fmt.Sprintf("view.first_key %s $%d", op, len(*args)+1))
-		*args = append(*args, v.startkey)
-	}
-	if v.key != "" {
-		idx := strconv.Itoa(len(*args) + 1)
-		where = append(where, "view.last_key = $"+idx, "view.first_key = $"+idx)
-		*args = append(*args, v.key)
-	}
-	if len(v.keys) > 0 {
-		for _, key := range v.keys {
-			idx := strconv.Itoa(len(*args) + 1)
-			where = append(where, "view.last_key = $"+idx, "view.first_key = $"+idx)
-			*args = append(*args, key)
-		}
-	}
-	return where
-}
-
 // buildGroupWhere returns WHERE conditions for use with grouping.
 func (v viewOptions) buildGroupWhere(args *[]any) []string {
 	where := make([]string, 0, defaultWhereCap)
@@ -767,6 +905,19 @@ func (v viewOptions) buildWhere(args *[]any) []string {
 }
 
 func (v viewOptions) buildOrderBy(moreColumns ...string) string {
+	if len(v.sort) > 0 {
+		direction := descendingToDirection(v.sort[0].desc)
+		conditions := make([]string, 0, len(v.sort)+1)
+		for _, field := range v.sort {
+			if field.name == "_id" {
+				conditions = append(conditions, "view.key "+direction)
+				continue
+			}
+			conditions = append(conditions, sortExpr(field.name)+" "+direction)
+		}
+		conditions = append(conditions, "view.key "+direction)
+		return "ORDER BY " + strings.Join(conditions, ", ")
+	}
 	if v.sorted {
 		direction := descendingToDirection(v.descending)
 		conditions := make([]string, 0, len(moreColumns)+1)
@@ -824,12 +975,38 @@ type viewOptions struct {
 	findSkip  int64
 	fields    []string
 	bookmark  string
-	sort      []string
+	sort      []indexField
+	// useIndex is the design document, and optionally the name, of the index
+	// requested by the use_index option.
+	useIndex      []string
+	allowFallback bool
+	// warnings are returned to the client, as CouchDB does.
+	warnings []string
+	// rawSelector is the selector, as provided by the client.
+	rawSelector json.RawMessage
+	// indexConds are the conditions extracted from the selector, which may be
+	// satisfied by an index.
+	indexConds []indexCond
+	// index is the Mango index chosen to satisfy the query, or nil to scan
+	// all documents.
+	index *mangoIndex
+	// stats collects the execution statistics, if requested with the
+	// execution_stats option, from the time the query is planned.
+	stats     *ExecutionStats
+	findStart time.Time
 }
 
-// findOptions converts a _find query body into a viewOptions struct.
-func findOptions(query interface{}) (*viewOptions, error) {
-	input := query.(json.RawMessage)
+// defaultFindLimit is the maximum number of results returned by _find, when
+// no limit is specified, as in CouchDB.
+const defaultFindLimit = 25
+
+// findOptions converts a _find query body into a viewOptions struct. Any
+// options are merged into the query body, taking precedence over its values.
+func findOptions(query interface{}, options driver.Options) (*viewOptions, error) {
+	input, err := mergeFindOptions(query.(json.RawMessage), options)
+	if err != nil {
+		return nil, err
+	}
 	var s struct {
 		Selector *mango.Selector `json:"selector"`
 	}
@@ -867,15 +1044,49 @@ func findOptions(query interface{}) (*viewOptions, error) {
 	if bookmark != "" {
 		skip = 0
 	}
+	if _, ok := o["limit"]; !ok {
+		limit = defaultFindLimit
+	}
 	sort, err := o.sort()
 	if err != nil {
 		return nil, err
 	}
-	if len(sort) > 0 {
-		return nil, &internal.Error{Status: http.StatusBadRequest, Message: "no index exists for this sort, try indexing by the sort fields"}
+	useIndex, err := o.useIndex()
+	if err != nil {
+		return nil, err
+	}
+	allowFallback, err := o.findBool("allow_fallback", true)
+	if err != nil {
+		return nil, err
+	}
+	// update, stable and r are validated, but otherwise ignored, as their
+	// guarantees always hold: Mango indexes are updated before every query,
+	// and there is only a single copy of each document.
+	if _, err := o.update(); err != nil {
+		return nil, err
+	}
+	if _, err := o.findBool("stable", false); err != nil {
+		return nil, err
+	}
+	executionStats, err := o.findBool("execution_stats", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.stale(); err != nil {
+		return nil, err
+	}
+	if err := o.readQuorum(); err != nil {
+		return nil, err
 	}
 
+	var raw struct {
+		Selector json.RawMessage `json:"selector"`
+	}
+	_ = json.Unmarshal(input, &raw)
+
 	v := &viewOptions{
+		rawSelector: raw.Selector,
+		indexConds:  selectorConds(raw.Selector),
 		view:        viewAllDocs,
 		conflicts:   conflicts,
 		includeDocs: true,
@@ -886,11 +1097,40 @@ func findOptions(query interface{}) (*viewOptions, error) {
 		fields:      fields,
 		bookmark:    bookmark,
 		sort:        sort,
+
+		useIndex:      useIndex,
+		allowFallback: allowFallback,
+	}
+	if executionStats {
+		v.stats = &ExecutionStats{}
 	}
 
 	return v, v.validate()
 }
 
+func mergeFindOptions(query json.RawMessage, options driver.Options) (json.RawMessage, error) {
+	if options == nil {
+		return query, nil
+	}
+	opts := map[string]interface{}{}
+	options.Apply(opts)
+	if len(opts) == 0 {
+		return query, nil
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(query, &body); err != nil {
+		return nil, &internal.Error{Status: http.StatusBadRequest, Err: err}
+	}
+	for k, v := range opts {
+		body[k] = v
+	}
+	merged, err := json.Marshal(body)
+	if err != nil {
+		return nil, &internal.Error{Status: http.StatusBadRequest, Err: err}
+	}
+	return merged, nil
+}
+
 func (o optsMap) viewOptions(view string) (*viewOptions, error) {
 	limit, err := o.limit()
 	if err != nil {
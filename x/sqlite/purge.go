@@ -22,6 +22,9 @@ import (
 )
 
 func (d *db) Purge(ctx context.Context, request map[string][]string) (*driver.PurgeResult, error) {
+	if err := d.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
 	tx, err := d.db.Begin()
 	if err != nil {
 		return nil, err
@@ -59,6 +62,16 @@ func (d *db) Purge(ctx context.Context, request map[string][]string) (*driver.Pu
 			if err != nil {
 				return nil, fmt.Errorf("exec failed: %w", err)
 			}
+			stmt, err = stmts.prepare(ctx, tx, d.query(`
+				INSERT INTO {{ .Purges }} (id, rev, rev_id)
+				VALUES ($1, $2, $3)
+			`))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := stmt.ExecContext(ctx, docID, r.rev, r.id); err != nil {
+				return nil, err
+			}
 			if result.Purged == nil {
 				result.Purged = map[string][]string{}
 			}
@@ -66,5 +79,5 @@ func (d *db) Purge(ctx context.Context, request map[string][]string) (*driver.Pu
 		}
 	}
 
-	return result, tx.Commit()
+	return result, d.commit(ctx, tx)
 }
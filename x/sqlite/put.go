@@ -24,23 +24,32 @@ import (
 )
 
 func (d *db) Put(ctx context.Context, docID string, doc interface{}, options driver.Options) (string, error) {
-	docRev, err := extractRev(doc)
+	d = d.withUserCtx(options)
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
-	opts := newOpts(options)
-	optsRev := opts.rev()
-	newEdits := opts.newEdits()
-	data, err := prepareDoc(docID, doc)
+	defer tx.Rollback()
+
+	rev, err := d.put(ctx, tx, docID, doc, newOpts(options))
 	if err != nil {
 		return "", err
 	}
+	return rev, d.commit(ctx, tx)
+}
 
-	tx, err := d.db.BeginTx(ctx, nil)
+// put stores doc within tx, and returns the new revision.
+func (d *db) put(ctx context.Context, tx *sql.Tx, docID string, doc interface{}, opts optsMap) (string, error) {
+	docRev, err := extractRev(doc)
+	if err != nil {
+		return "", err
+	}
+	optsRev := opts.rev()
+	newEdits := opts.newEdits()
+	data, err := prepareDoc(docID, doc)
 	if err != nil {
 		return "", err
 	}
-	defer tx.Rollback()
 
 	if data.Revisions.Start != 0 {
 		if newEdits {
@@ -79,6 +88,14 @@ func (d *db) Put(ctx context.Context, docID string, doc interface{}, options dri
 	}
 
 	if !newEdits { // new_edits=false means replication mode
+		var parentRev revision
+		if revs := data.Revisions.revs(); len(revs) > 1 {
+			parentRev = revs[len(revs)-2]
+		}
+		if err := d.validateDocUpdate(ctx, tx, data, parentRev); err != nil {
+			return "", err
+		}
+
 		var rev revision
 		var ancestorRev *revision
 		if data.Revisions.Start != 0 {
@@ -135,7 +152,7 @@ func (d *db) Put(ctx context.Context, docID string, doc interface{}, options dri
 			// No rows means a conflict, so  we assume that the documents are
 			// identical, for the sake of idempotency, and return the current
 			// rev, to match CouchDB behavior.
-			return docRev, nil
+			return docRev, d.stemDoc(ctx, tx, docID, rev)
 		}
 		if err != nil {
 			return "", err
@@ -144,8 +161,11 @@ func (d *db) Put(ctx context.Context, docID string, doc interface{}, options dri
 		if err := d.createDocAttachments(ctx, data, tx, rev, ancestorRev); err != nil {
 			return "", err
 		}
+		if err := d.stemDoc(ctx, tx, docID, rev); err != nil {
+			return "", err
+		}
 
-		return newRev, tx.Commit()
+		return newRev, nil
 	}
 
 	var curRev revision
@@ -171,5 +191,5 @@ func (d *db) Put(ctx context.Context, docID string, doc interface{}, options dri
 		return "", err
 	}
 
-	return r.String(), tx.Commit()
+	return r.String(), nil
 }
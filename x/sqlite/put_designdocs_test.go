@@ -316,12 +316,67 @@ func TestDBPut_designDocs(t *testing.T) {
 			},
 		}
 	})
+	tests.Add("Add a validate_doc_update function", func(t *testing.T) interface{} {
+		d := newDB(t)
+
+		return test{
+			db:    d,
+			docID: "_design/foo",
+			doc: map[string]interface{}{
+				"language":            "javascript",
+				"validate_doc_update": "function(newDoc, oldDoc, userCtx, secObj) {}",
+			},
+			wantRev: "1-.*",
+			wantRevs: []leaf{
+				{ID: "_design/foo", Rev: 1},
+			},
+			wantDDocs: []ddoc{
+				{
+					ID:         "_design/foo",
+					Rev:        1,
+					Lang:       "javascript",
+					FuncType:   "validate",
+					FuncName:   "validate_doc_update",
+					FuncBody:   "function(newDoc, oldDoc, userCtx, secObj) {}",
+					AutoUpdate: true,
+				},
+			},
+		}
+	})
+	tests.Add("Add an update function", func(t *testing.T) interface{} {
+		d := newDB(t)
+
+		return test{
+			db:    d,
+			docID: "_design/foo",
+			doc: map[string]interface{}{
+				"language": "javascript",
+				"updates": map[string]interface{}{
+					"bar": "function(doc, req) { return [doc, 'ok']; }",
+				},
+			},
+			wantRev: "1-.*",
+			wantRevs: []leaf{
+				{ID: "_design/foo", Rev: 1},
+			},
+			wantDDocs: []ddoc{
+				{
+					ID:         "_design/foo",
+					Rev:        1,
+					Lang:       "javascript",
+					FuncType:   "update",
+					FuncName:   "bar",
+					FuncBody:   "function(doc, req) { return [doc, 'ok']; }",
+					AutoUpdate: true,
+				},
+			},
+		}
+	})
 
 	/*
 		TODO:
 		- unsupported language? -- ignored?
 		- Drop old indexes when a ddoc changes
-		- func_type: update, validate
 	*/
 
 	tests.Run(t, func(t *testing.T, tt test) {
@@ -23,6 +23,7 @@ import (
 )
 
 func (d *db) PutAttachment(ctx context.Context, docID string, att *driver.Attachment, options driver.Options) (string, error) {
+	d = d.withUserCtx(options)
 	opts := newOpts(options)
 
 	tx, err := d.db.BeginTx(ctx, nil)
@@ -76,5 +77,5 @@ func (d *db) PutAttachment(ctx context.Context, docID string, att *driver.Attach
 		return "", err
 	}
 
-	return r.String(), tx.Commit()
+	return r.String(), d.commit(ctx, tx)
 }
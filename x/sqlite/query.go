@@ -43,6 +43,9 @@ func fromJSValue(v any) (*string, error) {
 }
 
 func (d *db) Query(ctx context.Context, ddoc, view string, options driver.Options) (driver.Rows, error) {
+	if err := d.authorizeRead(ctx, options); err != nil {
+		return nil, err
+	}
 	opts := newOpts(options)
 	vopts, err := opts.viewOptions(ddoc)
 	if err != nil {
@@ -110,19 +113,29 @@ func (d *db) performQuery(
 			return nil, err
 		}
 
+		// The reduce cache can't be used when rows of a single key are
+		// selected by document ID.
+		useCache := vopts.startkeyDocID == "" && vopts.endkeyDocID == ""
+		if useCache && (vopts.reduce == nil || *vopts.reduce) {
+			if err := d.updateReduceCache(ctx, ddoc, view, rev, vopts); err != nil {
+				return nil, err
+			}
+		}
+
 		args := []interface{}{
 			vopts.includeDocs, vopts.conflicts, vopts.reduce, vopts.updateSeq,
-			"_design/" + ddoc, rev.rev, rev.id, view, vopts.attachments,
+			"_design/" + ddoc, rev.rev, rev.id, view, vopts.attachments, useCache,
 		}
 
 		where := append([]string{""}, vopts.buildWhere(&args)...)
-		reduceWhere := append([]string{""}, vopts.buildReduceCacheWhere(&args)...)
+		reduceWhere := append([]string{""}, vopts.buildGroupWhere(&args)...)
 
 		query := fmt.Sprintf(d.ddocQuery(ddoc, view, rev.String(), leavesCTE+`,
 			 reduce AS (
 				SELECT
 					CASE WHEN MAX(id) IS NOT NULL THEN TRUE ELSE FALSE END AS reducible,
-					COALESCE(func_body, "")                                AS reduce_func
+					COALESCE(func_body, "")                                AS reduce_func,
+					COALESCE(language, "")                                 AS reduce_language
 				FROM {{ .Design }}
 				WHERE id = $5
 					AND rev = $6
@@ -138,14 +151,16 @@ func (d *db) performQuery(
 				reduce.reduce_func,
 				IIF($4, last_seq, "") AS update_seq,
 				MAX(last_seq)         AS last_seq,
-				NULL,
+				reduce.reduce_language,
 				0    AS attachment_count,
 				NULL AS filename,
 				NULL AS content_type,
 				NULL AS length,
 				NULL AS digest,
 				NULL AS rev_pos,
-				NULL AS data
+				NULL AS encoding,
+				NULL AS data,
+				NULL AS blob
 			FROM {{ .Design }} AS map
 			JOIN reduce
 			WHERE id = $5
@@ -156,28 +171,54 @@ func (d *db) performQuery(
 
 			UNION ALL
 
-			-- View map to pass to reduce
+			-- Cached reductions, and uncached view map rows, to pass to reduce
 			SELECT
 				*
 			FROM (
 				SELECT
-					view.id    AS id,
-					view.key   AS first_key,
-					view.value AS value,
-					view.pk    AS first_pk,
-					view.pk    AS last_pk,
-					NULL, -- conflicts,
+					view.id       AS id,
+					view.key      AS first_key,
+					view.value    AS value,
+					view.pk       AS first_pk,
+					view.pk       AS last_pk,
+					view.last_key AS last_key,
 					0,    -- attachment_count,
 					NULL, -- filename
 					NULL, -- content_type
 					NULL, -- length
 					NULL, -- digest
 					NULL, -- rev_pos
-					NULL  -- data
-				FROM {{ .Map }} AS view
+					NULL, -- encoding
+					NULL, -- data
+					NULL  -- blob
+				FROM (
+					SELECT
+						''         AS id,
+						view.key   AS key,
+						view.value AS value,
+						view.pk    AS pk,
+						view.key   AS last_key
+					FROM {{ .Reduce }} AS view
+					WHERE $10
+						%[5]s -- WHERE
+
+					UNION ALL
+
+					SELECT
+						view.id    AS id,
+						view.key   AS key,
+						view.value AS value,
+						view.pk    AS pk,
+						NULL       AS last_key
+					FROM {{ .Map }} AS view
+					WHERE (NOT $10 OR NOT EXISTS (
+							SELECT 1
+							FROM {{ .Reduce }} AS cache
+							WHERE cache.key IS view.key
+						))
+						%[2]s -- WHERE
+				) AS view
 				JOIN reduce ON reduce.reducible AND ($3 IS NULL OR $3 == TRUE)
-				WHERE TRUE
-					%[2]s -- WHERE
 				%[1]s -- ORDER BY
 			)
 
@@ -197,7 +238,9 @@ func (d *db) performQuery(
 				length,
 				digest,
 				rev_pos,
-				data
+				encoding,
+				data,
+				blob
 			FROM (
 				SELECT
 					view.id,
@@ -213,7 +256,9 @@ func (d *db) performQuery(
 					att.length AS length,
 					att.digest AS digest,
 					att.rev_pos AS rev_pos,
-					IIF($9, att.data, NULL) AS data
+					content.encoding AS encoding,
+					IIF($9, content.data, NULL) AS data,
+					IIF($9, IIF(content.data IS NULL, lower(hex(content.hash)), ''), NULL) AS blob
 				FROM (
 					SELECT
 						view.pk,
@@ -236,6 +281,7 @@ func (d *db) performQuery(
 				) AS view
 				LEFT JOIN {{ .AttachmentsBridge }} AS bridge ON view.id = bridge.id AND view.rev = bridge.rev AND view.rev_id = bridge.rev_id AND $1
 				LEFT JOIN {{ .Attachments }} AS att ON bridge.pk = att.pk
+				LEFT JOIN {{ .AttachmentData }} AS content ON content.hash = att.hash
 				%[1]s -- ORDER BY
 			)
 		`), vopts.buildOrderBy("pk"), strings.Join(where, " AND "), vopts.limit, vopts.skip, strings.Join(reduceWhere, " AND "))
@@ -266,7 +312,7 @@ func (d *db) performQuery(
 				_ = results.Close() //nolint:sqlclosecheck // invalid option specified for reduce, so abort the query
 				return nil, &internal.Error{Status: http.StatusBadRequest, Message: "conflicts is invalid for reduce"}
 			}
-			result, err := d.reduce(results, meta.reduceFuncJS, vopts.reduceGroupLevel())
+			result, err := d.reduce(results, meta, vopts.reduceGroupLevel())
 			if err != nil {
 				return nil, err
 			}
@@ -307,6 +353,10 @@ func (d *db) performGroupQuery(ctx context.Context, ddoc, view string, vopts *vi
 			return nil, err
 		}
 
+		if err := d.updateReduceCache(ctx, ddoc, view, rev, vopts); err != nil {
+			return nil, err
+		}
+
 		args := []any{"_design/" + ddoc, rev.rev, rev.id, view, kivik.EndKeySuffix, true, vopts.updateSeq}
 		where := append([]string{""}, vopts.buildGroupWhere(&args)...)
 
@@ -314,7 +364,8 @@ func (d *db) performGroupQuery(ctx context.Context, ddoc, view string, vopts *vi
 			WITH reduce AS (
 				SELECT
 					CASE WHEN MAX(id) IS NOT NULL THEN TRUE ELSE FALSE END AS reducible,
-					COALESCE(func_body, "")                                AS reduce_func
+					COALESCE(func_body, "")                                AS reduce_func,
+					COALESCE(language, "")                                 AS reduce_language
 				FROM {{ .Design }}
 				WHERE id = $1
 					AND rev = $2
@@ -330,14 +381,16 @@ func (d *db) performGroupQuery(ctx context.Context, ddoc, view string, vopts *vi
 				reduce.reduce_func,
 				IIF($7, last_seq, "") AS update_seq,
 				MAX(last_seq)         AS last_seq,
-				NULL,
+				reduce.reduce_language,
 				0    AS attachment_count,
 				NULL AS filename,
 				NULL AS content_type,
 				NULL AS length,
 				NULL AS digest,
 				NULL AS rev_pos,
-				NULL AS data
+				NULL AS encoding,
+				NULL AS data,
+				NULL AS blob
 			FROM {{ .Design }} AS map
 			JOIN reduce
 			WHERE id = $1
@@ -348,31 +401,57 @@ func (d *db) performGroupQuery(ctx context.Context, ddoc, view string, vopts *vi
 
 			UNION ALL
 
-			-- Actual results
+			-- Cached reductions, and uncached view map rows, to pass to reduce
 			SELECT *
 			FROM (
 				SELECT
-					view.id    AS id,
+					view.id       AS id,
 					COALESCE(view.key, "null") AS key,
-					view.value AS value,
-					view.pk    AS first,
-					view.pk    AS last,
-					NULL  AS conflicts,
+					view.value    AS value,
+					view.pk       AS first,
+					view.pk       AS last,
+					view.last_key AS last_key,
 					0    AS attachment_count,
 					NULL, --
 					NULL AS content_type,
 					NULL AS length,
 					NULL AS digest,
 					NULL AS rev_pos,
-					NULL AS data
-				FROM {{ .Map }} AS view
+					NULL AS encoding,
+					NULL AS data,
+					NULL AS blob
+				FROM (
+					SELECT
+						''         AS id,
+						view.key   AS key,
+						view.value AS value,
+						view.pk    AS pk,
+						COALESCE(view.key, "null") AS last_key
+					FROM {{ .Reduce }} AS view
+					WHERE TRUE
+						%[2]s
+
+					UNION ALL
+
+					SELECT
+						view.id    AS id,
+						view.key   AS key,
+						view.value AS value,
+						view.pk    AS pk,
+						NULL       AS last_key
+					FROM {{ .Map }} AS view
+					WHERE NOT EXISTS (
+							SELECT 1
+							FROM {{ .Reduce }} AS cache
+							WHERE cache.key IS view.key
+						)
+						%[2]s
+				) AS view
 				JOIN reduce
 				WHERE reduce.reducible AND ($6 IS NULL OR $6 == TRUE)
-					%[2]s
 				%[1]s -- ORDER BY
-				LIMIT %[3]d OFFSET %[4]d
 			)
-		`), vopts.buildOrderBy("pk"), strings.Join(where, " AND "), vopts.limit, vopts.skip)
+		`), vopts.buildOrderBy("pk"), strings.Join(where, " AND "))
 		results, err = d.db.QueryContext(ctx, query, args...) //nolint:rowserrcheck // Err checked in iterator
 
 		switch {
@@ -400,15 +479,25 @@ func (d *db) performGroupQuery(ctx context.Context, ddoc, view string, vopts *vi
 		}
 	}
 
-	result, err := d.reduce(results, meta.reduceFuncJS, vopts.reduceGroupLevel())
+	result, err := d.reduce(results, meta, vopts.reduceGroupLevel())
 	if err != nil {
 		return nil, err
 	}
+	// Skip and limit apply to the groups, so can only be applied after
+	// reduction.
+	*result = (*result)[min(vopts.skip, int64(len(*result))):]
+	if vopts.limit >= 0 && vopts.limit < int64(len(*result)) {
+		*result = (*result)[:vopts.limit]
+	}
 	return metaReduced{Rows: result, meta: meta}, nil
 }
 
-func (d *db) reduce(results *sql.Rows, reduceFuncJS string, groupLevel int) (driver.Rows, error) {
-	return reduce.Reduce(&reduceRowIter{results: results}, reduceFuncJS, d.logger, groupLevel)
+func (d *db) reduce(results *sql.Rows, meta *viewMetadata, groupLevel int) (*reduce.Rows, error) {
+	fn, err := compileReduce(meta.reduceLanguage, meta.reduceFuncJS, d.logger, js.Timeout(d.funcTimeout))
+	if err != nil {
+		return nil, err
+	}
+	return reduce.ReduceFunc(&reduceRowIter{results: results, reduceFuncJS: meta.reduceFuncJS}, fn, groupLevel)
 }
 
 const batchSize = 100
@@ -418,7 +507,8 @@ const batchSize = 100
 func (d *db) updateIndex(ctx context.Context, ddoc, view, mode string) (revision, error) {
 	var (
 		ddocRev                 revision
-		mapFuncJS               *string
+		mapFuncJS, ddocJSON     *string
+		language                sql.NullString
 		lastSeq                 int
 		includeDesign, localSeq sql.NullBool
 	)
@@ -426,16 +516,18 @@ func (d *db) updateIndex(ctx context.Context, ddoc, view, mode string) (revision
 		SELECT
 			docs.rev,
 			docs.rev_id,
+			docs.doc,
 			design.func_body,
+			design.language,
 			design.include_design,
 			design.local_seq,
 			COALESCE(design.last_seq, 0) AS last_seq
 		FROM {{ .Docs }} AS docs
-		LEFT JOIN {{ .Design }} AS design ON docs.id = design.id AND docs.rev = design.rev AND docs.rev_id = design.rev_id AND design.func_type = 'map'
+		LEFT JOIN {{ .Design }} AS design ON docs.id = design.id AND docs.rev = design.rev AND docs.rev_id = design.rev_id AND design.func_type = 'map' AND design.func_name = $2
 		WHERE docs.id = $1
 		ORDER BY docs.rev DESC, docs.rev_id DESC
 		LIMIT 1
-	`), "_design/"+ddoc).Scan(&ddocRev.rev, &ddocRev.id, &mapFuncJS, &includeDesign, &localSeq, &lastSeq)
+	`), "_design/"+ddoc, view).Scan(&ddocRev.rev, &ddocRev.id, &ddocJSON, &mapFuncJS, &language, &includeDesign, &localSeq, &lastSeq)
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		return revision{}, &internal.Error{Status: http.StatusNotFound, Message: "missing"}
@@ -526,20 +618,49 @@ func (d *db) updateIndex(ctx context.Context, ddoc, view, mode string) (revision
 	}
 	defer docs.Close()
 
-	batch := newMapIndexBatch()
-
-	var (
-		emitID  string
-		emitRev revision
-	)
-	mapFunc, err := js.Map(*mapFuncJS, func(key, value any) {
-		batch.add(emitID, emitRev, key, value)
-	})
+	jsOpts, err := d.jsOptions(ddocJSON)
 	if err != nil {
 		return revision{}, err
 	}
+	poolKey := d.name + "/" + ddoc + "/" + view
+	getMapper := func() (*mapper, error) {
+		return d.mappers.get(poolKey, ddocRev.String(), func(m *mapper) error {
+			var err error
+			m.fn, err = compileMap(language.String, *mapFuncJS, m.emit, jsOpts...)
+			return err
+		})
+	}
+	putMapper := func(m *mapper) {
+		d.mappers.put(poolKey, ddocRev.String(), m)
+	}
+	// Compile the map function up front, so that errors are reported even
+	// when there is nothing to index.
+	m, err := getMapper()
+	if err != nil {
+		return revision{}, err
+	}
+	putMapper(m)
 
+	batch := newMapIndexBatch()
+	jobs := make([]*mapJob, 0, batchSize)
 	var seq int
+	// flush maps the pending jobs, and writes the results to the index once
+	// the batch is full, or when force is true.
+	flush := func(force bool) error {
+		if err := d.mapJobs(jobs, getMapper, putMapper); err != nil {
+			return err
+		}
+		d.addMapJobs(batch, jobs)
+		jobs = jobs[:0]
+		if !force && batch.insertCount < batchSize {
+			return nil
+		}
+		if err := d.writeMapIndexBatch(ctx, seq, ddocRev, ddoc, view, batch); err != nil {
+			return err
+		}
+		batch.clear()
+		return nil
+	}
 	for {
 		full := &fullDoc{}
 		err := iter(docs, &seq, full)
@@ -566,31 +687,19 @@ func (d *db) updateIndex(ctx context.Context, ddoc, view, mode string) (revision
 			continue
 		}
 
-		if full.Deleted {
-			batch.delete(full.ID, rev)
-			continue
-		}
-
 		if localSeq.Bool {
 			full.LocalSeq = seq
 		}
+		jobs = append(jobs, &mapJob{id: full.ID, rev: rev, doc: full, deleted: full.Deleted})
 
-		emitID = full.ID
-		emitRev = rev
-		if err := mapFunc(full.toMap()); err != nil {
-			d.logger.Printf("map function threw exception for %s: %s", full.ID, err)
-			batch.delete(full.ID, rev)
-		}
-
-		if batch.insertCount >= batchSize {
-			if err := d.writeMapIndexBatch(ctx, seq, ddocRev, ddoc, view, batch); err != nil {
+		if len(jobs) >= batchSize {
+			if err := flush(false); err != nil {
 				return revision{}, err
 			}
-			batch.clear()
 		}
 	}
 
-	if err := d.writeMapIndexBatch(ctx, seq, ddocRev, ddoc, view, batch); err != nil {
+	if err := flush(true); err != nil {
 		return revision{}, err
 	}
 
@@ -724,14 +833,18 @@ func (d *db) writeMapIndexBatch(ctx context.Context, seq int, rev revision, ddoc
 		return err
 	}
 
+	ids := make([]interface{}, 0, len(batch.entries)+len(batch.deleted))
+	for mapKey := range batch.entries {
+		ids = append(ids, mapKey.id)
+	}
+	for _, mapKey := range batch.deleted {
+		ids = append(ids, mapKey.id)
+	}
+
 	// Clear any stale entries
-	if len(batch.entries) > 0 || len(batch.deleted) > 0 {
-		ids := make([]interface{}, 0, len(batch.entries)+len(batch.deleted))
-		for mapKey := range batch.entries {
-			ids = append(ids, mapKey.id)
-		}
-		for _, mapKey := range batch.deleted {
-			ids = append(ids, mapKey.id)
+	if len(ids) > 0 {
+		if err := d.invalidateReduceCache(ctx, tx, rev, ddoc, viewName, ids); err != nil {
+			return err
 		}
 		query := fmt.Sprintf(d.ddocQuery(ddoc, viewName, rev.String(), `
 			DELETE FROM {{ .Map }}
@@ -801,6 +914,9 @@ func (d *db) writeMapIndexBatch(ctx context.Context, seq int, rev revision, ddoc
 			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
 				return err
 			}
+			if err := d.invalidateReduceCache(ctx, tx, rev, ddoc, viewName, ids); err != nil {
+				return err
+			}
 		}
 	}
 
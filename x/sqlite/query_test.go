@@ -655,9 +655,15 @@ func TestDBQuery(t *testing.T) {
 					Value: "null",
 				},
 			},
+			// Once for each of the two cached keys, and once more for the
+			// rereduce.
 			wantLogs: []string{
 				`^reduce function threw exception: Error: broken`,
 				`at reduce`,
+				`^reduce function threw exception: Error: broken`,
+				`at reduce`,
+				`^reduce function threw exception: Error: broken`,
+				`at reduce`,
 			},
 		}
 	})
@@ -2564,6 +2570,83 @@ func TestDBQuery(t *testing.T) {
 			},
 		}
 	})
+	tests.Add("map function requires modules from views.lib", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"views": map[string]interface{}{
+				"lib": map[string]interface{}{
+					"keys":  `var util = require('./util/upper'); exports.key = function(doc) { return util.upper(doc._id); };`,
+					"util":  map[string]string{"upper": `exports.upper = function(s) { return s.toUpperCase(); };`},
+					"other": "not a module",
+				},
+				"bar": map[string]string{
+					"map": `function(doc) { emit(require('views/lib/keys').key(doc), null); }`,
+				},
+			},
+		})
+		_ = d.tPut("a", map[string]string{})
+
+		return test{
+			db:   d,
+			ddoc: "_design/foo",
+			view: "_view/bar",
+			want: []rowResult{
+				{ID: "a", Key: `"A"`, Value: "null"},
+			},
+		}
+	})
+	tests.Add("map function cannot require modules outside views.lib", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"lib": map[string]string{"keys": `exports.key = 1;`},
+			"views": map[string]interface{}{
+				"bar": map[string]string{
+					"map": `function(doc) { emit(require('lib/keys').key, null); }`,
+				},
+			},
+		})
+		_ = d.tPut("a", map[string]string{})
+
+		return test{
+			db:   d,
+			ddoc: "_design/foo",
+			view: "_view/bar",
+			want: nil,
+			wantLogs: []string{
+				`^map function threw exception for a: Error: invalid require path: object has no property "lib", while resolving lib/keys$`,
+				"^\tat ",
+				"^\tat map ",
+			},
+		}
+	})
+	tests.Add("map function uses CouchDB builtins", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"views": map[string]interface{}{
+				"bar": map[string]string{
+					"map": `function(doc) {
+						log("indexing " + doc._id);
+						log({id: doc._id});
+						emit(doc._id, [sum(doc.values), isArray(doc.values), toJSON(doc.values)]);
+					}`,
+				},
+			},
+		})
+		_ = d.tPut("a", map[string]interface{}{"values": []int{1, 2, 3}})
+
+		return test{
+			db:   d,
+			ddoc: "_design/foo",
+			view: "_view/bar",
+			want: []rowResult{
+				{ID: "a", Key: `"a"`, Value: `[6,true,"[1,2,3]"]`},
+			},
+			wantLogs: []string{
+				`^indexing a$`,
+				`^{"id":"a"}$`,
+			},
+		}
+	})
 
 	/*
 		TODO:
@@ -2815,3 +2898,69 @@ func TestDBQuery_update_lazy(t *testing.T) {
 		{ID: "foo", Key: `"foo"`, Value: "null"},
 	})
 }
+
+func TestDBQuery_multiple_views(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+
+	_ = d.tPut("_design/foo", map[string]interface{}{
+		"views": map[string]interface{}{
+			"a": map[string]string{
+				"map": `function(doc) { emit(doc._id, "a"); }`,
+			},
+			"b": map[string]string{
+				"map": `function(doc) { emit(doc._id, "b"); }`,
+			},
+		},
+	})
+	_ = d.tPut("foo", map[string]string{"_id": "foo"})
+
+	// Each view must be indexed with its own map function.
+	for _, view := range []string{"a", "b", "a"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		rows, err := d.Query(ctx, "_design/foo", "_view/"+view, mock.NilOption)
+		if err != nil {
+			cancel()
+			t.Fatalf("Failed to query view %s: %s", view, err)
+		}
+		checkRows(t, rows, []rowResult{
+			{ID: "foo", Key: `"foo"`, Value: `"` + view + `"`},
+		})
+		_ = rows.Close()
+		cancel()
+	}
+}
+
+func TestDBQuery_doc_stops_emitting(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+
+	_ = d.tPut("_design/foo", map[string]interface{}{
+		"views": map[string]interface{}{
+			"bar": map[string]string{
+				"map": `function(doc) { if (doc.emit) { emit(doc._id, null); } }`,
+			},
+		},
+	})
+	rev := d.tPut("foo", map[string]interface{}{"emit": true})
+	_ = d.tPut("bar", map[string]interface{}{"emit": true})
+	query := func(want []rowResult) {
+		t.Helper()
+		rows, err := d.Query(context.Background(), "_design/foo", "_view/bar", mock.NilOption)
+		if err != nil {
+			t.Fatalf("Failed to query view: %s", err)
+		}
+		defer rows.Close()
+		checkRows(t, rows, want)
+	}
+	query([]rowResult{
+		{ID: "bar", Key: `"bar"`, Value: "null"},
+		{ID: "foo", Key: `"foo"`, Value: "null"},
+	})
+
+	// The rows emitted for the previous revision are removed.
+	_ = d.tPut("foo", map[string]interface{}{"_rev": rev, "emit": false})
+	query([]rowResult{
+		{ID: "bar", Key: `"bar"`, Value: "null"},
+	})
+}
@@ -13,15 +13,21 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 
+	"github.com/go-kivik/kivik/x/sqlite/v4/js"
 	"github.com/go-kivik/kivik/x/sqlite/v4/reduce"
 )
 
 type reduceRowIter struct {
-	results *sql.Rows
+	results      *sql.Rows
+	reduceFuncJS string
 }
 
 func (r *reduceRowIter) ReduceNext(row *reduce.Row) error {
@@ -34,7 +40,7 @@ func (r *reduceRowIter) ReduceNext(row *reduce.Row) error {
 	var firstKey, lastKey, value *[]byte
 	err := r.results.Scan(
 		&row.ID, &firstKey, &value, &row.FirstPK, &row.LastPK, &lastKey,
-		discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
+		discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
 	)
 	if err != nil {
 		return err
@@ -54,12 +60,262 @@ func (r *reduceRowIter) ReduceNext(row *reduce.Row) error {
 		row.LastKey = nil
 		row.LastPK = 0
 	}
-	if value != nil {
+	switch {
+	case value == nil:
+		row.Value = nil
+	case row.ID == "":
+		// A cached reduction
+		if row.Value, err = reduce.UnmarshalValue(r.reduceFuncJS, *value); err != nil {
+			return err
+		}
+	default:
 		if err = json.Unmarshal(*value, &row.Value); err != nil {
 			return err
 		}
-	} else {
-		row.Value = nil
 	}
 	return nil
 }
+
+// invalidateReduceCache removes from the view's reduce cache the keys of the
+// map rows of the documents with the given ids.
+func (d *db) invalidateReduceCache(ctx context.Context, tx *sql.Tx, rev revision, ddoc, viewName string, ids []interface{}) error {
+	query := fmt.Sprintf(d.ddocQuery(ddoc, viewName, rev.String(), `
+		DELETE FROM {{ .Reduce }}
+		WHERE key IN (
+				SELECT key
+				FROM {{ .Map }}
+				WHERE id IN (%[1]s)
+			)
+			OR (key IS NULL AND EXISTS (
+				SELECT 1
+				FROM {{ .Map }}
+				WHERE key IS NULL
+					AND id IN (%[1]s)
+			))
+	`), placeholders(1, len(ids)))
+	_, err := tx.ExecContext(ctx, query, ids...)
+	return err
+}
+
+// updateReduceCache calculates and stores the reductions of those keys,
+// matching vopts, which are missing from the view's reduce cache. It is a no-op
+// for map-only views.
+func (d *db) updateReduceCache(ctx context.Context, ddoc, view string, rev revision, vopts *viewOptions) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var reduceFuncJS, language string
+	err = tx.QueryRowContext(ctx, d.query(`
+		SELECT func_body, language
+		FROM {{ .Design }}
+		WHERE id = $1
+			AND rev = $2
+			AND rev_id = $3
+			AND func_type = 'reduce'
+			AND func_name = $4
+	`), "_design/"+ddoc, rev.rev, rev.id, view).Scan(&reduceFuncJS, &language)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	var args []interface{}
+	where := append([]string{""}, vopts.buildGroupWhere(&args)...)
+	uncached := fmt.Sprintf(d.ddocQuery(ddoc, view, rev.String(), `
+		FROM {{ .Map }} AS view
+		WHERE NOT EXISTS (
+				SELECT 1
+				FROM {{ .Reduce }} AS cache
+				WHERE cache.key IS view.key
+			)
+			%s -- WHERE
+	`), strings.Join(where, " AND "))
+	insert, err := tx.PrepareContext(ctx, d.ddocQuery(ddoc, view, rev.String(), `
+		INSERT INTO {{ .Reduce }} (key, value)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO NOTHING
+	`))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	reduced, err := d.reduceBuiltinSQL(ctx, tx, insert, reduceFuncJS, uncached, args)
+	if err != nil {
+		return err
+	}
+	if !reduced {
+		if err := d.reduceUncached(ctx, tx, insert, language, reduceFuncJS, uncached, args); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// reduceBuiltinSQL calculates the built-in _count, _sum and _stats reduce
+// functions in SQL, for the uncached keys. It returns false if reduceFuncJS is
+// not one of these functions, or if the map values are not suitable for
+// reduction in SQL, in which case nothing is stored.
+func (d *db) reduceBuiltinSQL(ctx context.Context, tx *sql.Tx, insert *sql.Stmt, reduceFuncJS, uncached string, args []interface{}) (bool, error) {
+	switch reduceFuncJS {
+	case "_count", "_sum", "_stats":
+	default:
+		return false, nil
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			view.key,
+			COUNT(*),
+			TOTAL(CAST(view.value AS REAL)),
+			MIN(CAST(view.value AS REAL)),
+			MAX(CAST(view.value AS REAL)),
+			TOTAL(CAST(view.value AS REAL) * CAST(view.value AS REAL)),
+			TOTAL(json_type(view.value) NOT IN ('integer', 'real')),
+			TOTAL(view.value IS NULL)
+		`+uncached+`
+		GROUP BY view.key
+	`, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	type reduction struct {
+		key   *string
+		value interface{}
+	}
+	var reductions []reduction
+	for rows.Next() {
+		var (
+			key                          *string
+			count                        int64
+			sum, sumSqr, nonNumeric, nul float64
+			minimum, maximum             *float64
+		)
+		if err := rows.Scan(&key, &count, &sum, &minimum, &maximum, &sumSqr, &nonNumeric, &nul); err != nil {
+			return false, err
+		}
+		var value interface{}
+		switch reduceFuncJS {
+		case "_count":
+			value = float64(count)
+		case "_sum":
+			if nonNumeric > 0 {
+				return false, nil
+			}
+			value = sum
+		case "_stats":
+			if nonNumeric > 0 || nul > 0 {
+				return false, nil
+			}
+			value = map[string]float64{
+				"sum":    sum,
+				"min":    *minimum,
+				"max":    *maximum,
+				"count":  float64(count),
+				"sumsqr": sumSqr,
+			}
+		}
+		reductions = append(reductions, reduction{key: key, value: value})
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	_ = rows.Close()
+
+	for _, r := range reductions {
+		value, _ := json.Marshal(r.value)
+		if _, err := insert.ExecContext(ctx, r.key, string(value)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// reduceUncached calls the reduce function on the map rows of each uncached
+// key, and stores the results.
+func (d *db) reduceUncached(ctx context.Context, tx *sql.Tx, insert *sql.Stmt, language, reduceFuncJS, uncached string, args []interface{}) error {
+	fn, err := compileReduce(language, reduceFuncJS, d.logger, js.Timeout(d.funcTimeout))
+	if err != nil {
+		return err
+	}
+	// DENSE_RANK numbers the keys by collation, so that keys are grouped
+	// exactly as they are by SQLite.
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			DENSE_RANK() OVER (ORDER BY view.key) AS rank,
+			view.key,
+			view.id,
+			view.value,
+			view.pk
+		`+uncached+`
+		ORDER BY view.key, view.pk
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		group    reduce.Rows
+		groupKey *string
+		lastRank int64
+	)
+	flush := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		result, err := reduce.ReduceFunc(&group, fn, 0)
+		if err != nil {
+			return err
+		}
+		var value *string
+		if len(*result) > 0 && (*result)[0].Value != nil {
+			data, err := reduce.MarshalValue(reduceFuncJS, (*result)[0].Value)
+			if err != nil {
+				return err
+			}
+			value = new(string)
+			*value = string(data)
+		}
+		_, err = insert.ExecContext(ctx, groupKey, value)
+		group = group[:0]
+		return err
+	}
+	for rows.Next() {
+		var (
+			rank       int64
+			key, value *string
+			row        reduce.Row
+		)
+		if err := rows.Scan(&rank, &key, &row.ID, &value, &row.FirstPK); err != nil {
+			return err
+		}
+		if rank != lastRank {
+			if err := flush(); err != nil {
+				return err
+			}
+			groupKey, lastRank = key, rank
+		}
+		if key != nil {
+			if err := json.Unmarshal([]byte(*key), &row.FirstKey); err != nil {
+				return err
+			}
+		}
+		if value != nil {
+			if err := json.Unmarshal([]byte(*value), &row.Value); err != nil {
+				return err
+			}
+		}
+		group = append(group, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
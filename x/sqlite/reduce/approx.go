@@ -0,0 +1,189 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package reduce
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"net/http"
+	"slices"
+
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+// sketchPrecision is the number of bits of each hash which select a register
+// of a sketch, as used by CouchDB.
+const sketchPrecision = 11
+
+const sketchRegisters = 1 << sketchPrecision
+
+// sketch is a HyperLogLog sketch, the intermediate result of
+// _approx_count_distinct. It marshals to JSON as its estimate.
+type sketch struct {
+	// registers holds the non-zero registers, by index.
+	registers map[uint16]uint8
+}
+
+func newSketch() *sketch {
+	return &sketch{registers: map[uint16]uint8{}}
+}
+
+// add adds the JSON-encoded value data to the sketch.
+func (s *sketch) add(data []byte) {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	hash := mix(h.Sum64())
+	index := uint16(hash >> (64 - sketchPrecision))
+	rank := uint8(bits.LeadingZeros64(hash<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// mix is the 64-bit finalizer of MurmurHash3, which spreads the bits of the
+// FNV hash more evenly across the registers.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// merge sets each register of s to the larger of it and that of other.
+func (s *sketch) merge(other *sketch) {
+	for index, rank := range other.registers {
+		if rank > s.registers[index] {
+			s.registers[index] = rank
+		}
+	}
+}
+
+// estimate returns the estimated number of distinct values added to the
+// sketch.
+func (s *sketch) estimate() uint64 {
+	m := float64(sketchRegisters)
+	sum := m - float64(len(s.registers))
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if zeros := m - float64(len(s.registers)); estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities.
+		estimate = m * math.Log(m/zeros)
+	}
+	return uint64(math.Round(estimate))
+}
+
+// MarshalJSON returns the estimate.
+func (s *sketch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.estimate())
+}
+
+// encode returns the registers of s, for storage. Sparse sketches are encoded
+// as three bytes per non-zero register, and dense sketches as one byte per
+// register.
+func (s *sketch) encode() []byte {
+	if len(s.registers)*3 >= sketchRegisters {
+		data := make([]byte, sketchRegisters)
+		for index, rank := range s.registers {
+			data[index] = rank
+		}
+		return data
+	}
+	indexes := make([]uint16, 0, len(s.registers))
+	for index := range s.registers {
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+	data := make([]byte, 0, len(indexes)*3)
+	for _, index := range indexes {
+		data = append(data, byte(index>>8), byte(index), s.registers[index])
+	}
+	return data
+}
+
+func decodeSketch(data []byte) (*sketch, error) {
+	s := newSketch()
+	if len(data) == sketchRegisters {
+		for index, rank := range data {
+			if rank > 0 {
+				s.registers[uint16(index)] = rank
+			}
+		}
+		return s, nil
+	}
+	if len(data)%3 != 0 {
+		return nil, fmt.Errorf("invalid _approx_count_distinct sketch of %d bytes", len(data))
+	}
+	for i := 0; i < len(data); i += 3 {
+		s.registers[uint16(data[i])<<8|uint16(data[i+1])] = data[i+2]
+	}
+	return s, nil
+}
+
+// ApproxCountDistinct is the built-in reduce function,
+// [_approx_count_distinct]. It estimates the number of distinct keys with a
+// HyperLogLog sketch, which is merged on rereduce.
+//
+// [_approx_count_distinct]: https://docs.couchdb.org/en/stable/ddocs/ddocs.html#approx_count_distinct
+func ApproxCountDistinct(keys [][2]interface{}, values []interface{}, rereduce bool) ([]interface{}, error) {
+	result := newSketch()
+	if !rereduce {
+		for _, key := range keys {
+			data, err := json.Marshal(key[0])
+			if err != nil {
+				return nil, err
+			}
+			result.add(data)
+		}
+		return []interface{}{result}, nil
+	}
+	for _, value := range values {
+		s, ok := value.(*sketch)
+		if !ok {
+			return nil, &internal.Error{
+				Status:  http.StatusInternalServerError,
+				Message: fmt.Sprintf("_approx_count_distinct cannot rereduce %T", value),
+			}
+		}
+		result.merge(s)
+	}
+	return []interface{}{result}, nil
+}
+
+func marshalSketch(value interface{}) ([]byte, error) {
+	s, ok := value.(*sketch)
+	if !ok {
+		return json.Marshal(value)
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(s.encode()))
+}
+
+func unmarshalSketch(data []byte) (interface{}, error) {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSketch(raw)
+}
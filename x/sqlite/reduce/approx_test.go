@@ -0,0 +1,94 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package reduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"testing"
+)
+
+func TestApproxCountDistinct(t *testing.T) {
+	tests := []struct {
+		name      string
+		distinct  int
+		tolerance float64
+	}{
+		{name: "none", distinct: 0},
+		{name: "few", distinct: 5},
+		{name: "hundreds", distinct: 500, tolerance: 0.05},
+		{name: "many", distinct: 100000, tolerance: 0.05},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each key is emitted twice, in separate batches, so that the
+			// sketches are merged on rereduce.
+			rows := make(Rows, 0, tt.distinct*2)
+			for i := 0; i < tt.distinct; i++ {
+				rows = append(rows,
+					Row{ID: "a", FirstKey: []any{"key", float64(i)}, FirstPK: i * 2},
+					Row{ID: "b", FirstKey: []any{"key", float64(i)}, FirstPK: i*2 + 1},
+				)
+			}
+			got, err := reduceWithBatchSize(&rows, "_approx_count_distinct", log.New(io.Discard, "", 0), 0, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := float64(tt.distinct)
+			var estimate float64
+			if len(*got) > 0 {
+				data, _ := json.Marshal((*got)[0].Value)
+				if err := json.Unmarshal(data, &estimate); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if math.Abs(estimate-want) > want*tt.tolerance {
+				t.Errorf("Estimated %v distinct keys, want %v", estimate, want)
+			}
+		})
+	}
+}
+
+func TestApproxCountDistinct_marshal(t *testing.T) {
+	for _, n := range []int{3, 5000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			keys := make([][2]interface{}, n)
+			for i := range keys {
+				keys[i] = [2]interface{}{float64(i), "id"}
+			}
+			result, err := ApproxCountDistinct(keys, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := MarshalValue("_approx_count_distinct", result[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := UnmarshalValue("_approx_count_distinct", data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := json.Marshal(result[0])
+			got, _ := json.Marshal(value)
+			if string(got) != string(want) {
+				t.Errorf("Restored sketch estimates %s, want %s", got, want)
+			}
+			if s := value.(*sketch); len(s.registers) != len(result[0].(*sketch).registers) {
+				t.Errorf("Restored sketch has %d registers, want %d", len(s.registers), len(result[0].(*sketch).registers))
+			}
+		})
+	}
+}
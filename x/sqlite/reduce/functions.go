@@ -13,12 +13,14 @@
 package reduce
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/mitchellh/mapstructure"
 
@@ -240,12 +242,83 @@ func rereduceStatsFloatArray(values [][]stats) []interface{} {
 	return []interface{}{result}
 }
 
+// MarshalValue encodes value, the output of the named reduce function, as JSON
+// for storage, such that it may be restored with [UnmarshalValue]. This
+// differs from the JSON output of a query only for _approx_count_distinct,
+// whose sketch is stored in full.
+func MarshalValue(javascript string, value interface{}) ([]byte, error) {
+	if javascript == "_approx_count_distinct" {
+		return marshalSketch(value)
+	}
+	return json.Marshal(value)
+}
+
+// UnmarshalValue unmarshals data, the JSON-encoded output of a previous call
+// to the named reduce function, such that it may be passed back to the function
+// for rereduce.
+func UnmarshalValue(javascript string, data []byte) (interface{}, error) {
+	if javascript == "_approx_count_distinct" {
+		return unmarshalSketch(data)
+	}
+	if javascript == "_stats" {
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			var value []stats
+			err := json.Unmarshal(data, &value)
+			return value, err
+		}
+		var value stats
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+var registry = struct {
+	mu    sync.RWMutex
+	funcs map[string]Func
+}{
+	funcs: map[string]Func{},
+}
+
+// Register makes fn available to [ParseFunc] as name, so that views may refer
+// to it by name in place of JavaScript source, as they do to the built-in
+// reduce functions. It panics if called twice with the same name, if name
+// begins with an underscore, which is reserved for built-in functions, or if
+// fn is nil.
+func Register(name string, fn Func) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if fn == nil {
+		panic("reduce: Register function is nil")
+	}
+	if name == "" || strings.HasPrefix(name, "_") {
+		panic("reduce: Register called with reserved name '" + name + "'")
+	}
+	if _, dup := registry.funcs[name]; dup {
+		panic("reduce: Register called twice for " + name)
+	}
+	registry.funcs[name] = fn
+}
+
+// Registered reports whether a function has been registered as name with
+// [Register].
+func Registered(name string) bool {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	_, ok := registry.funcs[name]
+	return ok
+}
+
 // ParseFunc parses the passed javascript string, and returns a Go function that
 // will execute it.  If the input is empty, nil is returned. If the input is a
 // string that corresponds to one of the built-in function names (i.e. '_sum',
-// '_count', etc), the native Go implementation is returned instead. The logger
-// is used to log any unhandled exceptions thrown by the JavaScript function.
-func ParseFunc(javascript string, logger *log.Logger) (Func, error) {
+// '_count', etc), or to a function registered with [Register], the native Go
+// implementation is returned instead. The logger is used to log any unhandled
+// exceptions thrown by the JavaScript function, which runs in the environment
+// configured by opts, or errors and panics of a registered function.
+func ParseFunc(javascript string, logger *log.Logger, opts ...js.Option) (Func, error) {
 	switch javascript {
 	case "":
 		return nil, nil
@@ -255,8 +328,16 @@ func ParseFunc(javascript string, logger *log.Logger) (Func, error) {
 		return Sum, nil
 	case "_stats":
 		return Stats, nil
+	case "_approx_count_distinct":
+		return ApproxCountDistinct, nil
 	default:
-		reduceFunc, err := js.Reduce(javascript)
+		registry.mu.RLock()
+		fn, ok := registry.funcs[javascript]
+		registry.mu.RUnlock()
+		if ok {
+			return registered(fn, logger), nil
+		}
+		reduceFunc, err := js.Reduce(javascript, append([]js.Option{js.Logger(logger)}, opts...)...)
 		if err != nil {
 			return nil, err
 		}
@@ -273,3 +354,22 @@ func ParseFunc(javascript string, logger *log.Logger) (Func, error) {
 		}, nil
 	}
 }
+
+// registered wraps fn, a registered function, so that its errors and panics
+// are logged, and the result is null, as for a JavaScript function.
+func registered(fn Func, logger *log.Logger) Func {
+	return func(keys [][2]interface{}, values []interface{}, rereduce bool) (result []interface{}, _ error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Printf("reduce function threw exception: panic: %v", r)
+				result = []interface{}{nil}
+			}
+		}()
+		ret, err := fn(keys, values, rereduce)
+		if err != nil {
+			logger.Printf("reduce function threw exception: %s", err)
+			return []interface{}{nil}, nil
+		}
+		return ret, nil
+	}
+}
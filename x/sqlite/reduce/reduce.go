@@ -127,6 +127,12 @@ func Reduce(rows Reducer, javascript string, logger *log.Logger, groupLevel int)
 	return reduceWithBatchSize(rows, javascript, logger, groupLevel, defaultBatchSize)
 }
 
+// ReduceFunc works like [Reduce], but calls fn, as returned by [ParseFunc],
+// rather than parsing the function anew.
+func ReduceFunc(rows Reducer, fn Func, groupLevel int) (*Rows, error) {
+	return reduce(rows, fn, groupLevel, defaultBatchSize)
+}
+
 func reduceWithBatchSize(rows Reducer, javascript string, logger *log.Logger, groupLevel int, batchSize int) (*Rows, error) {
 	fn, err := ParseFunc(javascript, logger)
 	if err != nil {
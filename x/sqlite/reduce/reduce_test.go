@@ -13,6 +13,7 @@
 package reduce
 
 import (
+	"bytes"
 	"io"
 	"log"
 	"testing"
@@ -221,3 +222,82 @@ func TestReduce(t *testing.T) {
 		}
 	})
 }
+
+func TestUnmarshalValue(t *testing.T) {
+	type test struct {
+		javascript string
+		data       string
+		want       interface{}
+	}
+
+	tests := testy.NewTable()
+	tests.Add("_sum", test{
+		javascript: "_sum",
+		data:       `3`,
+		want:       3.0,
+	})
+	tests.Add("_stats", test{
+		javascript: "_stats",
+		data:       `{"sum":3,"min":1,"max":2,"count":2,"sumsqr":5}`,
+		want:       stats{Sum: 3, Min: 1, Max: 2, Count: 2, SumSqr: 5},
+	})
+	tests.Add("_stats array", test{
+		javascript: "_stats",
+		data:       `[{"sum":1,"min":1,"max":1,"count":1,"sumsqr":1}]`,
+		want:       []stats{{Sum: 1, Min: 1, Max: 1, Count: 1, SumSqr: 1}},
+	})
+	tests.Add("javascript", test{
+		javascript: "function(keys, values) { return values; }",
+		data:       `["a"]`,
+		want:       []interface{}{"a"},
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		got, err := UnmarshalValue(tt.javascript, []byte(tt.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := cmp.Diff(tt.want, got); d != "" {
+			t.Errorf("Unexpected value (-want +got):\n%s", d)
+		}
+	})
+}
+
+func TestRegister(t *testing.T) {
+	Register("test/first", func(_ [][2]interface{}, values []interface{}, _ bool) ([]interface{}, error) {
+		if values[0] == "panic" {
+			panic("first failed")
+		}
+		return values[:1], nil
+	})
+
+	buf := &bytes.Buffer{}
+	fn, err := ParseFunc("test/first", log.New(buf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fn(nil, []interface{}{"a", "b"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]interface{}{"a"}, got); d != "" {
+		t.Errorf("Unexpected result:\n%s", d)
+	}
+	got, err = fn(nil, []interface{}{"panic"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff([]interface{}{nil}, got); d != "" {
+		t.Errorf("Unexpected result after panic:\n%s", d)
+	}
+	if want := "reduce function threw exception: panic: first failed\n"; buf.String() != want {
+		t.Errorf("Unexpected log output: %q", buf.String())
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected a panic when registering a reserved name")
+		}
+	}()
+	Register("_first", func([][2]interface{}, []interface{}, bool) ([]interface{}, error) { return nil, nil })
+}
@@ -14,3 +14,220 @@
 // +build !js
 
 package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+func TestReduceCache(t *testing.T) {
+	t.Parallel()
+
+	// newReduceDB returns a database with a view, reduced with reduceFunc,
+	// which emits each document's type and value.
+	newReduceDB := func(t *testing.T, reduceFunc string) (*testDB, string) {
+		t.Helper()
+		d := newDB(t)
+		rev := d.tPut("_design/foo", map[string]interface{}{
+			"views": map[string]interface{}{
+				"bar": map[string]string{
+					"map":    `function(doc) { if (doc.type) { emit([doc.type, doc._id], doc.value); } }`,
+					"reduce": reduceFunc,
+				},
+			},
+		})
+		return d, d.DB.(*db).ddocQuery("_design/foo", "bar", rev, "{{ .Reduce }}")
+	}
+	query := func(t *testing.T, d *testDB, options map[string]interface{}) []rowResult {
+		t.Helper()
+		rows, err := d.Query(context.Background(), "_design/foo", "_view/bar", kivik.Params(options))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readRows(t, rows)
+	}
+	check := func(t *testing.T, got, want []rowResult) {
+		t.Helper()
+		if d := cmp.Diff(want, got); d != "" {
+			t.Errorf("Unexpected rows:\n%s", d)
+		}
+	}
+
+	t.Run("keys are cached and invalidated on update", func(t *testing.T) {
+		t.Parallel()
+		d, table := newReduceDB(t, "_sum")
+		rev := d.tPut("a", map[string]interface{}{"type": "x", "value": 1})
+		_ = d.tPut("b", map[string]interface{}{"type": "x", "value": 2})
+		_ = d.tPut("c", map[string]interface{}{"type": "y", "value": 4})
+
+		check(t, query(t, d, map[string]interface{}{"group_level": 1}), []rowResult{
+			{Key: `["x"]`, Value: "3"},
+			{Key: `["y"]`, Value: "4"},
+		})
+		if n := d.count(`SELECT COUNT(*) FROM ` + table); n != 3 {
+			t.Fatalf("Expected 3 cached keys, got %d", n)
+		}
+		var pk int
+		if err := d.underlying().QueryRow(`SELECT pk FROM ` + table + ` WHERE key = '["x","b"]'`).Scan(&pk); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = d.tPut("a", map[string]interface{}{"_rev": rev, "type": "x", "value": 10})
+		check(t, query(t, d, nil), []rowResult{
+			{Key: "null", Value: "16"},
+		})
+		// The unchanged key keeps its cached reduction
+		if n := d.count(`SELECT COUNT(*) FROM `+table+` WHERE pk = $1`, pk); n != 1 {
+			t.Errorf("Cached reduction of unchanged key was recalculated")
+		}
+	})
+	t.Run("deleted documents are removed from the cache", func(t *testing.T) {
+		t.Parallel()
+		d, table := newReduceDB(t, "_count")
+		rev := d.tPut("a", map[string]interface{}{"type": "x"})
+		_ = d.tPut("b", map[string]interface{}{"type": "x"})
+
+		check(t, query(t, d, nil), []rowResult{{Key: "null", Value: "2"}})
+		_ = d.tDelete("a", kivik.Rev(rev))
+		check(t, query(t, d, nil), []rowResult{{Key: "null", Value: "1"}})
+		if n := d.count(`SELECT COUNT(*) FROM ` + table); n != 1 {
+			t.Errorf("Expected 1 cached key, got %d", n)
+		}
+	})
+	t.Run("only queried keys are cached", func(t *testing.T) {
+		t.Parallel()
+		d, table := newReduceDB(t, "_count")
+		_ = d.tPut("a", map[string]interface{}{"type": "x"})
+		_ = d.tPut("b", map[string]interface{}{"type": "y"})
+		_ = d.tPut("c", map[string]interface{}{"type": "z"})
+
+		check(t, query(t, d, map[string]interface{}{
+			"startkey": []string{"y"},
+			"group":    true,
+		}), []rowResult{
+			{Key: `["y","b"]`, Value: "1"},
+			{Key: `["z","c"]`, Value: "1"},
+		})
+		if n := d.count(`SELECT COUNT(*) FROM ` + table); n != 2 {
+			t.Errorf("Expected 2 cached keys, got %d", n)
+		}
+		check(t, query(t, d, nil), []rowResult{{Key: "null", Value: "3"}})
+	})
+	t.Run("_stats calculated in SQL", func(t *testing.T) {
+		t.Parallel()
+		d, table := newReduceDB(t, "_stats")
+		_ = d.tPut("a", map[string]interface{}{"type": "x", "value": 2})
+		_ = d.tPut("b", map[string]interface{}{"type": "x", "value": -3})
+
+		check(t, query(t, d, map[string]interface{}{"group_level": 1}), []rowResult{
+			{Key: `["x"]`, Value: `{"sum":-1,"min":-3,"max":2,"count":2,"sumsqr":13}`},
+		})
+		var value string
+		if err := d.underlying().QueryRow(`SELECT value FROM ` + table + ` WHERE key = '["x","a"]'`).Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+		if want := `{"count":1,"max":2,"min":2,"sum":2,"sumsqr":4}`; value != want {
+			t.Errorf("Unexpected cached value: %s", value)
+		}
+	})
+	t.Run("_approx_count_distinct sketches are cached", func(t *testing.T) {
+		t.Parallel()
+		d, table := newReduceDB(t, "_approx_count_distinct")
+		_ = d.tPut("a", map[string]interface{}{"type": "x"})
+		_ = d.tPut("b", map[string]interface{}{"type": "x"})
+		_ = d.tPut("c", map[string]interface{}{"type": "y"})
+
+		check(t, query(t, d, map[string]interface{}{"group_level": 1}), []rowResult{
+			{Key: `["x"]`, Value: "2"},
+			{Key: `["y"]`, Value: "1"},
+		})
+		var value string
+		if err := d.underlying().QueryRow(`SELECT value FROM ` + table + ` WHERE key = '["x","a"]'`).Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+		if value == "1" {
+			t.Errorf("Expected the sketch to be cached, not its estimate")
+		}
+		// The cached sketches are merged on rereduce.
+		check(t, query(t, d, nil), []rowResult{{Key: "null", Value: "3"}})
+	})
+	t.Run("registered Go reduce function", func(t *testing.T) {
+		t.Parallel()
+		d, _ := newReduceDB(t, "test/max")
+		_ = d.tPut("a", map[string]interface{}{"type": "x", "value": 1})
+		_ = d.tPut("b", map[string]interface{}{"type": "x", "value": 5})
+		_ = d.tPut("c", map[string]interface{}{"type": "y", "value": 3})
+
+		check(t, query(t, d, map[string]interface{}{"group_level": 1}), []rowResult{
+			{Key: `["x"]`, Value: "5"},
+			{Key: `["y"]`, Value: "3"},
+		})
+		check(t, query(t, d, nil), []rowResult{{Key: "null", Value: "5"}})
+	})
+	t.Run("JavaScript reduce function", func(t *testing.T) {
+		t.Parallel()
+		d, _ := newReduceDB(t, `function(keys, values, rereduce) {
+			if (rereduce) {
+				return values.join("+");
+			}
+			return values.join("");
+		}`)
+		_ = d.tPut("a", map[string]interface{}{"type": "x", "value": "a"})
+		_ = d.tPut("b", map[string]interface{}{"type": "x", "value": "b"})
+		_ = d.tPut("c", map[string]interface{}{"type": "y", "value": "c"})
+
+		check(t, query(t, d, map[string]interface{}{"group_level": 1}), []rowResult{
+			{Key: `["x"]`, Value: `"a+b"`},
+			{Key: `["y"]`, Value: `"c"`},
+		})
+	})
+	t.Run("limit and skip apply to groups", func(t *testing.T) {
+		t.Parallel()
+		d, _ := newReduceDB(t, "_count")
+		_ = d.tPut("a", map[string]interface{}{"type": "x"})
+		_ = d.tPut("b", map[string]interface{}{"type": "x"})
+		_ = d.tPut("c", map[string]interface{}{"type": "y"})
+		_ = d.tPut("d", map[string]interface{}{"type": "z"})
+
+		check(t, query(t, d, map[string]interface{}{
+			"group_level": 1,
+			"skip":        1,
+			"limit":       1,
+		}), []rowResult{
+			{Key: `["y"]`, Value: "1"},
+		})
+	})
+	t.Run("startkey_docid bypasses the cache", func(t *testing.T) {
+		t.Parallel()
+		d, _ := newReduceDB(t, "_count")
+		_ = d.tPut("a", map[string]interface{}{"type": "x"})
+		_ = d.tPut("b", map[string]interface{}{"type": "x"})
+		check(t, query(t, d, nil), []rowResult{{Key: "null", Value: "2"}})
+
+		check(t, query(t, d, map[string]interface{}{
+			"startkey":       []string{"x", "b"},
+			"startkey_docid": "b",
+		}), []rowResult{{Key: "null", Value: "1"}})
+	})
+}
+
+func TestViewTableNames(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	const ddoc = "_design/a_very_long_design_document_name_which_needs_truncation"
+
+	// The map table name must not change, so that the views of existing
+	// databases are found.
+	const wantMap = `"a_very_long_design_document_name_which_needs_trunca_b1f5a3e1"`
+	if got := d.DB.(*db).ddocQuery(ddoc, "bar", "1-abc", "{{ .Map }}"); got != wantMap {
+		t.Errorf("Unexpected map table name: %s", got)
+	}
+	const wantReduce = `"a_very_long_design_document_name_which_needs_reduce_b1f5a3e1"`
+	if got := d.DB.(*db).ddocQuery(ddoc, "bar", "1-abc", "{{ .Reduce }}"); got != wantReduce {
+		t.Errorf("Unexpected reduce table name: %s", got)
+	}
+}
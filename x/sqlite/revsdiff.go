@@ -39,6 +39,9 @@ func toRevDiffRequest(revMap interface{}) (map[string][]string, error) {
 }
 
 func (d *db) RevsDiff(ctx context.Context, revMap interface{}) (driver.Rows, error) {
+	if err := d.authorizeRead(ctx, nil); err != nil {
+		return nil, err
+	}
 	req, err := toRevDiffRequest(revMap)
 	if err != nil {
 		return nil, err
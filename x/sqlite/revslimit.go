@@ -0,0 +1,188 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+// defaultRevsLimit matches the default revs_limit of CouchDB.
+const defaultRevsLimit = 1000
+
+// RevsLimit returns the maximum number of revisions tracked for each document.
+func (d *db) RevsLimit(ctx context.Context) (int, error) {
+	return d.revsLimit(ctx, d.db)
+}
+
+func (d *db) revsLimit(ctx context.Context, tx queryer) (int, error) {
+	var limit int
+	err := tx.QueryRowContext(ctx, d.query(`
+		SELECT revs_limit
+		FROM {{ .RevsLimit }}
+	`)).Scan(&limit)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return defaultRevsLimit, nil
+	case errIsNoSuchTable(err):
+		return 0, &internal.Error{Status: http.StatusNotFound, Message: "database not found"}
+	}
+	return limit, err
+}
+
+// SetRevsLimit sets the maximum number of revisions tracked for each document.
+// Existing revision histories are stemmed as documents are updated, or when
+// the database is compacted.
+func (d *db) SetRevsLimit(ctx context.Context, limit int) error {
+	if limit <= 0 {
+		return &internal.Error{Status: http.StatusBadRequest, Message: "revs_limit must be a positive integer"}
+	}
+	_, err := d.db.ExecContext(ctx, d.query(`
+		INSERT INTO {{ .RevsLimit }} (pk, revs_limit)
+		VALUES (1, $1)
+		ON CONFLICT (pk) DO UPDATE SET revs_limit = excluded.revs_limit
+	`), limit)
+	if errIsNoSuchTable(err) {
+		return &internal.Error{Status: http.StatusNotFound, Message: "database not found"}
+	}
+	return err
+}
+
+// stemDoc stems the revision history of docID, after rev was added to it. As
+// the history of a leaf is never longer than its generation, this is a no-op
+// unless rev is beyond the database's revs_limit.
+func (d *db) stemDoc(ctx context.Context, tx *sql.Tx, docID string, rev revision) error {
+	limit, err := d.revsLimit(ctx, tx)
+	if err != nil || rev.rev <= limit {
+		return err
+	}
+	if strings.HasPrefix(docID, "_design/") {
+		// The views of stemmed design document revisions would otherwise be
+		// left behind, as the design table rows go with their revisions.
+		if err := d.viewCleanup(ctx, tx, docID); err != nil {
+			return err
+		}
+	}
+	return d.stemRevs(ctx, tx, docID, limit)
+}
+
+// stemRevs removes the revisions of docID, or of all documents if docID is
+// empty, which are more than limit revisions away from every leaf. As in
+// CouchDB, each branch of the revision tree keeps up to limit revisions, and
+// the oldest remaining revision of a branch becomes a root.
+//
+// Documents with a non-leaf revision still indexed by a view are skipped,
+// until the index has been updated, as stemming removes revision bodies.
+func (d *db) stemRevs(ctx context.Context, tx *sql.Tx, docID string, limit int) error {
+	// kept selects the revisions within limit of a leaf.
+	const kept = `
+		WITH RECURSIVE kept (id, rev, rev_id, parent_rev, parent_rev_id, distance) AS (
+			SELECT leaf.id, leaf.rev, leaf.rev_id, leaf.parent_rev, leaf.parent_rev_id, 1
+			FROM {{ .Revs }} AS leaf
+			WHERE ($1 = '' OR leaf.id = $1)
+				AND NOT EXISTS (
+					SELECT 1
+					FROM {{ .Revs }} AS child
+					WHERE child.id = leaf.id
+						AND child.parent_rev = leaf.rev
+						AND child.parent_rev_id = leaf.rev_id
+				)
+			UNION
+			SELECT parent.id, parent.rev, parent.rev_id, parent.parent_rev, parent.parent_rev_id, kept.distance + 1
+			FROM kept
+			JOIN {{ .Revs }} AS parent ON parent.id = kept.id
+				AND parent.rev = kept.parent_rev
+				AND parent.rev_id = kept.parent_rev_id
+			WHERE kept.distance < $2
+		)
+	`
+
+	maps, err := d.viewTables(ctx, tx, "{{ .Map }}")
+	if err != nil {
+		return err
+	}
+	var indexed strings.Builder
+	for _, table := range maps {
+		indexed.WriteString(d.query(`
+			AND id NOT IN (
+				SELECT view.id
+				FROM ` + table + ` AS view
+				JOIN {{ .Revs }} AS child ON child.id = view.id
+					AND child.parent_rev = view.rev
+					AND child.parent_rev_id = view.rev_id
+				WHERE ($1 = '' OR view.id = $1)
+			)`))
+	}
+
+	rows, err := tx.QueryContext(ctx, d.query(kept+`
+		SELECT id, rev, rev_id
+		FROM {{ .Revs }}
+		WHERE ($1 = '' OR id = $1)
+			AND (id, rev, rev_id) NOT IN (SELECT id, rev, rev_id FROM kept)
+	`)+indexed.String(), docID, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type docRev struct {
+		id  string
+		rev revision
+	}
+	var stemmed []docRev
+	for rows.Next() {
+		var r docRev
+		if err := rows.Scan(&r.id, &r.rev.rev, &r.rev.id); err != nil {
+			return err
+		}
+		stemmed = append(stemmed, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	// The children of stemmed revisions become roots first, as deleting a
+	// revision cascades to its children.
+	stmts := newStmtCache()
+	for _, r := range stemmed {
+		stmt, err := stmts.prepare(ctx, tx, d.query(`
+			UPDATE {{ .Revs }}
+			SET parent_rev = NULL, parent_rev_id = NULL
+			WHERE id = $1 AND parent_rev = $2 AND parent_rev_id = $3
+		`))
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, r.id, r.rev.rev, r.rev.id); err != nil {
+			return err
+		}
+	}
+	for _, r := range stemmed {
+		stmt, err := stmts.prepare(ctx, tx, d.query(`
+			DELETE FROM {{ .Revs }}
+			WHERE id = $1 AND rev = $2 AND rev_id = $3
+		`))
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, r.id, r.rev.rev, r.rev.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func (tdb *testDB) tSetRevsLimit(limit int) {
+	tdb.t.Helper()
+	if err := tdb.DB.(*db).SetRevsLimit(context.Background(), limit); err != nil {
+		tdb.t.Fatalf("Failed to set revs_limit: %s", err)
+	}
+}
+
+// revisions returns the _revisions of docID.
+func (tdb *testDB) revisions(docID string) map[string]interface{} {
+	tdb.t.Helper()
+	doc, err := tdb.Get(context.Background(), docID, kivik.Param("revs", true))
+	if err != nil {
+		tdb.t.Fatal(err)
+	}
+	defer doc.Body.Close()
+	var body struct {
+		Revisions map[string]interface{} `json:"_revisions"`
+	}
+	if err := json.NewDecoder(doc.Body).Decode(&body); err != nil {
+		tdb.t.Fatal(err)
+	}
+	return body.Revisions
+}
+
+func TestRevsLimit(t *testing.T) {
+	t.Parallel()
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		limit, err := d.DB.(*db).RevsLimit(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limit != 1000 {
+			t.Errorf("Unexpected limit: %d", limit)
+		}
+	})
+	t.Run("set and replace", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		d.tSetRevsLimit(5)
+		d.tSetRevsLimit(10)
+		limit, err := d.DB.(*db).RevsLimit(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limit != 10 {
+			t.Errorf("Unexpected limit: %d", limit)
+		}
+	})
+	t.Run("invalid limit", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		err := d.DB.(*db).SetRevsLimit(context.Background(), 0)
+		if status := kivik.HTTPStatus(err); status != http.StatusBadRequest {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+	t.Run("database not found", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		missing := *d.DB.(*db)
+		missing.name = "missing"
+		_, err := missing.RevsLimit(context.Background())
+		if status := kivik.HTTPStatus(err); status != http.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		err = missing.SetRevsLimit(context.Background(), 10)
+		if status := kivik.HTTPStatus(err); status != http.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+}
+
+func TestStemming(t *testing.T) {
+	t.Parallel()
+
+	t.Run("on write", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		d.tSetRevsLimit(3)
+		rev := d.tPut("foo", map[string]interface{}{"value": 1})
+		for i := 2; i <= 5; i++ {
+			rev = d.tPut("foo", map[string]interface{}{"_rev": rev, "value": i})
+		}
+
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 3 {
+			t.Errorf("Expected 3 revisions, found %d", n)
+		}
+		revs := d.revisions("foo")
+		if revs["start"] != float64(5) || len(revs["ids"].([]interface{})) != 3 {
+			t.Errorf("Unexpected _revisions: %v", revs)
+		}
+
+		// The stemmed document can still be updated and deleted.
+		rev = d.tPut("foo", map[string]interface{}{"_rev": rev, "value": 6})
+		_ = d.tDelete("foo", kivik.Rev(rev))
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 3 {
+			t.Errorf("Expected 3 revisions after deletion, found %d", n)
+		}
+	})
+	t.Run("on replication", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		d.tSetRevsLimit(2)
+		_ = d.tPut("foo", map[string]interface{}{
+			"_revisions": map[string]interface{}{
+				"start": 4,
+				"ids":   []string{"ddd", "ccc", "bbb", "aaa"},
+			},
+		}, kivik.Param("new_edits", false))
+
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 2 {
+			t.Errorf("Expected 2 revisions, found %d", n)
+		}
+	})
+	t.Run("conflicting branches are kept", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		for _, ids := range [][]string{{"ccc", "bbb", "aaa"}, {"yyy", "xxx", "aaa"}} {
+			_ = d.tPut("foo", map[string]interface{}{
+				"_revisions": map[string]interface{}{"start": 3, "ids": ids},
+			}, kivik.Param("new_edits", false))
+		}
+		d.tSetRevsLimit(2)
+		if err := d.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		rows, err := d.underlying().Query(`
+			SELECT rev || '-' || rev_id || COALESCE(' <- ' || parent_rev || '-' || parent_rev_id, '')
+			FROM test_revs
+			WHERE id = 'foo'
+			ORDER BY rev, rev_id
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var rev string
+			if err := rows.Scan(&rev); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rev)
+		}
+		want := []string{"2-bbb", "2-xxx", "3-ccc <- 2-bbb", "3-yyy <- 2-xxx"}
+		if d := testy.DiffInterface(want, got); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("on compaction", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		rev := d.tPut("foo", map[string]interface{}{"value": 1})
+		for i := 2; i <= 5; i++ {
+			rev = d.tPut("foo", map[string]interface{}{"_rev": rev, "value": i})
+		}
+		_ = d.tPut("bar", map[string]interface{}{"value": 1})
+		d.tSetRevsLimit(2)
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 5 {
+			t.Errorf("Expected revisions to be kept until compaction, found %d", n)
+		}
+
+		if err := d.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 2 {
+			t.Errorf("Expected 2 revisions, found %d", n)
+		}
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'bar'`); n != 1 {
+			t.Errorf("Expected 1 revision, found %d", n)
+		}
+	})
+	t.Run("indexed revisions are kept", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"views": map[string]interface{}{
+				"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id, null); }"},
+			},
+		})
+		d.tSetRevsLimit(1)
+		query := func() {
+			rows, err := d.Query(context.Background(), "_design/foo", "_view/bar", mock.NilOption)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = rows.Close()
+		}
+		rev := d.tPut("foo", map[string]interface{}{"value": 1})
+		query()
+		_ = d.tPut("foo", map[string]interface{}{"_rev": rev, "value": 2})
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 2 {
+			t.Errorf("Expected the indexed revision to be kept, found %d revisions", n)
+		}
+
+		query()
+		if err := d.Compact(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if n := d.count(`SELECT COUNT(*) FROM test_revs WHERE id = 'foo'`); n != 1 {
+			t.Errorf("Expected 1 revision after reindexing, found %d", n)
+		}
+	})
+	t.Run("design documents", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		d.tSetRevsLimit(1)
+		ddoc := map[string]interface{}{
+			"views": map[string]interface{}{
+				"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id, null); }"},
+			},
+		}
+		oldRev := d.tPut("_design/foo", ddoc)
+		oldTable := d.DB.(*db).ddocQuery("_design/foo", "bar", oldRev, "{{ .Map }}")
+		ddoc["_rev"] = oldRev
+		_ = d.tPut("_design/foo", ddoc)
+
+		if d.tableExists(oldTable) {
+			t.Error("Expected the map table of the stemmed revision to be dropped")
+		}
+		rows, err := d.Query(context.Background(), "_design/foo", "_view/bar", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rows.Close()
+	})
+}
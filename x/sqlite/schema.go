@@ -24,8 +24,8 @@ var schema = []string{
 		FOREIGN KEY (id, parent_rev, parent_rev_id) REFERENCES {{ .Revs }} (id, rev, rev_id) ON DELETE CASCADE,
 		UNIQUE(id, rev, rev_id)
 	)`,
-	`CREATE INDEX default_key ON {{ .Revs }} (key)`,
-	`CREATE INDEX idx_parent ON {{ .Revs }} (id, parent_rev, parent_rev_id)`,
+	`CREATE INDEX {{ .IndexRevsKey }} ON {{ .Revs }} (key)`,
+	`CREATE INDEX {{ .IndexRevsParent }} ON {{ .Revs }} (id, parent_rev, parent_rev_id)`,
 	// the main db table
 	`CREATE TABLE {{ .Docs }} (
 		seq INTEGER PRIMARY KEY,
@@ -38,6 +38,17 @@ var schema = []string{
 		FOREIGN KEY (id, rev, rev_id) REFERENCES {{ .Revs }} (id, rev, rev_id) ON DELETE CASCADE,
 		UNIQUE(id, rev, rev_id)
 	)`,
+	// attachment_data holds the content of attachments, once for each distinct
+	// SHA-256 hash. The MD5 digest reported by CouchDB is not used as the key,
+	// as MD5 collisions can be crafted. encoding is 'gzip' for compressed
+	// content, or NULL. data is NULL for content stored in the attachment
+	// directory.
+	`CREATE TABLE {{ .AttachmentData }} (
+		hash BLOB PRIMARY KEY,
+		encoding TEXT,
+		encoded_length INTEGER NOT NULL,
+		data BLOB
+	)`,
 	// attachments
 	`CREATE TABLE {{ .Attachments }} (
 		pk INTEGER PRIMARY KEY,
@@ -45,8 +56,9 @@ var schema = []string{
 		content_type TEXT NOT NULL,
 		length INTEGER NOT NULL,
 		digest BLOB NOT NULL,
-		data BLOB NOT NULL,
-		rev_pos INTEGER NOT NULL
+		hash BLOB NOT NULL,
+		rev_pos INTEGER NOT NULL,
+		FOREIGN KEY (hash) REFERENCES {{ .AttachmentData }} (hash)
 	)`,
 	`CREATE TABLE {{ .AttachmentsBridge }} (
 		pk INTEGER,
@@ -57,6 +69,25 @@ var schema = []string{
 		FOREIGN KEY (id, rev, rev_id) REFERENCES {{ .Docs }} (id, rev, rev_id) ON DELETE CASCADE,
 		UNIQUE (id, rev, rev_id, pk)
 	)`,
+	// purges records each purged revision, so that the purge sequence can be
+	// reported.
+	`CREATE TABLE {{ .Purges }} (
+		seq INTEGER PRIMARY KEY,
+		id TEXT NOT NULL,
+		rev INTEGER NOT NULL,
+		rev_id TEXT NOT NULL
+	)`,
+	// security holds the database's security object, in a single row.
+	`CREATE TABLE {{ .Security }} (
+		pk INTEGER PRIMARY KEY CHECK (pk = 1),
+		security TEXT NOT NULL
+	)`,
+	// revs_limit holds the database's revs_limit, in a single row, if it has
+	// been changed from the default.
+	`CREATE TABLE {{ .RevsLimit }} (
+		pk INTEGER PRIMARY KEY CHECK (pk = 1),
+		revs_limit INTEGER NOT NULL
+	)`,
 	/*
 		The .Design table is used to store design documents. The schema is as follows:
 		- id: The document ID.
@@ -98,4 +129,13 @@ var viewSchema = []string{
 		FOREIGN KEY (id, rev, rev_id) REFERENCES {{ .Docs }} (id, rev, rev_id)
 	)`,
 	`CREATE INDEX {{ .IndexMap }} ON {{ .Map }} (key)`,
+	// The reduce cache holds the reduction of the map rows of each distinct
+	// key, for rereduce at query time. Rows are removed whenever the map rows
+	// of the key change, and recalculated on demand.
+	`CREATE TABLE {{ .Reduce }} (
+		pk INTEGER PRIMARY KEY,
+		key TEXT COLLATE {{ .Collation }},
+		value TEXT,
+		UNIQUE (key)
+	)`,
 }
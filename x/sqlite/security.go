@@ -0,0 +1,226 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+)
+
+var _ driver.SecurityDB = (*db)(nil)
+
+// userCtx identifies the user on whose behalf a request is made.
+type userCtx struct {
+	Name  string
+	Roles []string
+}
+
+type optionUserCtx userCtx
+
+var _ kivik.Option = optionUserCtx{}
+
+func (o optionUserCtx) Apply(target interface{}) {
+	if u, ok := target.(**userCtx); ok {
+		user := userCtx(o)
+		*u = &user
+	}
+}
+
+// OptionUserCtx makes a request on behalf of the named user, with the given
+// roles, rather than as server admin. The database's security object is then
+// enforced: reading or writing documents requires membership, and writing
+// design documents requires admin rights. An empty name denotes an anonymous
+// user. The user context is also passed to validate_doc_update and update
+// functions.
+//
+// Passed when opening a database, it applies to every request made with it,
+// including those which take no options: SetSecurity, Purge, Compact,
+// CompactView and ViewCleanup require admin rights, and RevsDiff and Stats
+// require membership.
+func OptionUserCtx(name string, roles ...string) kivik.Option {
+	return optionUserCtx{Name: name, Roles: roles}
+}
+
+// withUserCtx returns a copy of d which acts on behalf of the user set with
+// [OptionUserCtx], if any.
+func (d *db) withUserCtx(options driver.Options) *db {
+	if options == nil {
+		return d
+	}
+	var user *userCtx
+	options.Apply(&user)
+	if user == nil {
+		return d
+	}
+	dup := *d
+	dup.user = user
+	return &dup
+}
+
+func (d *db) Security(ctx context.Context) (*driver.Security, error) {
+	return d.security(ctx, d.db)
+}
+
+func (d *db) security(ctx context.Context, tx queryer) (*driver.Security, error) {
+	var raw []byte
+	err := tx.QueryRowContext(ctx, d.query(`
+		SELECT security
+		FROM {{ .Security }}
+	`)).Scan(&raw)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return &driver.Security{}, nil
+	case errIsNoSuchTable(err):
+		return nil, &internal.Error{Status: http.StatusNotFound, Message: "database not found"}
+	case err != nil:
+		return nil, err
+	}
+	var sec driver.Security
+	if err := json.Unmarshal(raw, &sec); err != nil {
+		return nil, err
+	}
+	return &sec, nil
+}
+
+func (d *db) SetSecurity(ctx context.Context, security *driver.Security) error {
+	if err := d.authorizeAdmin(ctx); err != nil {
+		return err
+	}
+	if security == nil {
+		security = &driver.Security{}
+	}
+	raw, err := json.Marshal(security)
+	if err != nil {
+		return &internal.Error{Status: http.StatusBadRequest, Err: err}
+	}
+	_, err = d.db.ExecContext(ctx, d.query(`
+		INSERT INTO {{ .Security }} (pk, security)
+		VALUES (1, $1)
+		ON CONFLICT (pk) DO UPDATE SET security = excluded.security
+	`), string(raw))
+	if errIsNoSuchTable(err) {
+		return &internal.Error{Status: http.StatusNotFound, Message: "database not found"}
+	}
+	return err
+}
+
+// authorizeRead checks that the user set with [OptionUserCtx], if any, is a
+// member of the database.
+func (d *db) authorizeRead(ctx context.Context, options driver.Options) error {
+	d = d.withUserCtx(options)
+	if d.user == nil {
+		return nil
+	}
+	sec, err := d.security(ctx, d.db)
+	if err != nil {
+		return err
+	}
+	return d.authorize(sec, false)
+}
+
+// authorizeAdmin checks that the user set with [OptionUserCtx] when the
+// database was opened, if any, is a database admin. It guards the operations
+// which take no per-request options.
+func (d *db) authorizeAdmin(ctx context.Context) error {
+	if d.user == nil {
+		return nil
+	}
+	sec, err := d.security(ctx, d.db)
+	if err != nil {
+		return err
+	}
+	return d.authorize(sec, true)
+}
+
+// authorize checks that d's user is a member of the database, or, if admin is
+// true, a database admin. As in CouchDB, a database without members is public.
+// Requests made without a user context are made as server admin, and are
+// always authorized.
+func (d *db) authorize(sec *driver.Security, admin bool) error {
+	switch {
+	case d.user == nil || d.user.isAdmin(sec):
+		return nil
+	case admin:
+		return &internal.Error{Status: http.StatusUnauthorized, Message: "You are not a db or server admin."}
+	case d.user.isMember(sec):
+		return nil
+	case d.user.Name == "":
+		return &internal.Error{Status: http.StatusUnauthorized, Message: "You are not authorized to access this db."}
+	default:
+		return &internal.Error{Status: http.StatusForbidden, Message: "You are not allowed to access this db."}
+	}
+}
+
+func (u *userCtx) isAdmin(sec *driver.Security) bool {
+	return slices.Contains(u.Roles, "_admin") || u.in(sec.Admins)
+}
+
+func (u *userCtx) isMember(sec *driver.Security) bool {
+	if len(sec.Members.Names) == 0 && len(sec.Members.Roles) == 0 {
+		return true
+	}
+	return u.in(sec.Members)
+}
+
+func (u *userCtx) in(members driver.Members) bool {
+	if u.Name != "" && slices.Contains(members.Names, u.Name) {
+		return true
+	}
+	for _, role := range u.Roles {
+		if slices.Contains(members.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// userCtx returns the user context passed to JavaScript functions. Without a
+// user set by [OptionUserCtx], this is that of an anonymous server admin.
+func (d *db) userCtx() map[string]interface{} {
+	if d.user == nil {
+		return map[string]interface{}{
+			"db":    d.name,
+			"name":  nil,
+			"roles": []interface{}{"_admin"},
+		}
+	}
+	var name interface{}
+	if d.user.Name != "" {
+		name = d.user.Name
+	}
+	roles := make([]interface{}, len(d.user.Roles))
+	for i, role := range d.user.Roles {
+		roles[i] = role
+	}
+	return map[string]interface{}{
+		"db":    d.name,
+		"name":  name,
+		"roles": roles,
+	}
+}
+
+// securityObject returns sec in the form passed to JavaScript functions.
+func securityObject(sec *driver.Security) map[string]interface{} {
+	raw, _ := json.Marshal(sec)
+	var obj map[string]interface{}
+	_ = json.Unmarshal(raw, &obj)
+	return obj
+}
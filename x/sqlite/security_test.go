@@ -0,0 +1,359 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func (tdb *testDB) tSetSecurity(sec *driver.Security) {
+	tdb.t.Helper()
+	if err := tdb.DB.(driver.SecurityDB).SetSecurity(context.Background(), sec); err != nil {
+		tdb.t.Fatalf("Failed to set security: %s", err)
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	t.Parallel()
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		sec, err := d.DB.(driver.SecurityDB).Security(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface(&driver.Security{}, sec); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("set and replace", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		d.tSetSecurity(&driver.Security{
+			Admins: driver.Members{Names: []string{"bob"}},
+		})
+		want := &driver.Security{
+			Admins:  driver.Members{Roles: []string{"boss"}},
+			Members: driver.Members{Names: []string{"alice"}, Roles: []string{"staff"}},
+		}
+		d.tSetSecurity(want)
+		sec, err := d.DB.(driver.SecurityDB).Security(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := testy.DiffInterface(want, sec); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("database not found", func(t *testing.T) {
+		t.Parallel()
+		d := newDB(t)
+		missing := *d.DB.(*db)
+		missing.name = "missing"
+		_, err := missing.Security(context.Background())
+		if status := kivik.HTTPStatus(err); status != http.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		err = missing.SetSecurity(context.Background(), &driver.Security{})
+		if status := kivik.HTTPStatus(err); status != http.StatusNotFound {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		db         *testDB
+		call       func(*testDB) error
+		wantStatus int
+		wantErr    string
+	}
+
+	// newSecuredDB returns a database with a document, bob as admin, and
+	// alice and the staff role as members.
+	newSecuredDB := func(t *testing.T) *testDB {
+		d := newDB(t)
+		_ = d.tPut("foo", map[string]interface{}{"type": "post"})
+		d.tSetSecurity(&driver.Security{
+			Admins:  driver.Members{Names: []string{"bob"}},
+			Members: driver.Members{Names: []string{"alice"}, Roles: []string{"staff"}},
+		})
+		return d
+	}
+	get := func(user kivik.Option) func(*testDB) error {
+		return func(d *testDB) error {
+			_, err := d.Get(context.Background(), "foo", user)
+			return err
+		}
+	}
+	put := func(docID string, user kivik.Option) func(*testDB) error {
+		return func(d *testDB) error {
+			_, err := d.Put(context.Background(), docID, map[string]interface{}{"type": "post"}, user)
+			return err
+		}
+	}
+	// as returns d, opened on behalf of the given user, as by
+	// client.DB("test", user), for the requests which take no options.
+	as := func(d *testDB, user kivik.Option) *db {
+		return d.DB.(*db).withUserCtx(user)
+	}
+	notAdmin := func(call func(*db) error) func(*testing.T) interface{} {
+		return func(t *testing.T) interface{} {
+			return test{
+				db:         newSecuredDB(t),
+				call:       func(d *testDB) error { return call(as(d, OptionUserCtx("alice"))) },
+				wantStatus: http.StatusUnauthorized,
+				wantErr:    "You are not a db or server admin.",
+			}
+		}
+	}
+	notMember := func(call func(*db) error) func(*testing.T) interface{} {
+		return func(t *testing.T) interface{} {
+			return test{
+				db:         newSecuredDB(t),
+				call:       func(d *testDB) error { return call(as(d, OptionUserCtx("carol"))) },
+				wantStatus: http.StatusForbidden,
+				wantErr:    "You are not allowed to access this db.",
+			}
+		}
+	}
+
+	tests := testy.NewTable()
+	tests.Add("no user context is server admin", func(t *testing.T) interface{} {
+		return test{
+			db:   newSecuredDB(t),
+			call: put("_design/foo", mock.NilOption),
+		}
+	})
+	tests.Add("member reads", func(t *testing.T) interface{} {
+		return test{
+			db:   newSecuredDB(t),
+			call: get(OptionUserCtx("alice")),
+		}
+	})
+	tests.Add("member by role reads", func(t *testing.T) interface{} {
+		return test{
+			db:   newSecuredDB(t),
+			call: get(OptionUserCtx("carol", "staff")),
+		}
+	})
+	tests.Add("non-member read forbidden", func(t *testing.T) interface{} {
+		return test{
+			db:         newSecuredDB(t),
+			call:       get(OptionUserCtx("carol")),
+			wantStatus: http.StatusForbidden,
+			wantErr:    "You are not allowed to access this db.",
+		}
+	})
+	tests.Add("anonymous read unauthorized", func(t *testing.T) interface{} {
+		return test{
+			db:         newSecuredDB(t),
+			call:       get(OptionUserCtx("")),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "You are not authorized to access this db.",
+		}
+	})
+	tests.Add("public database", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("foo", map[string]interface{}{})
+		return test{
+			db:   d,
+			call: get(OptionUserCtx("")),
+		}
+	})
+	tests.Add("admin is a member", func(t *testing.T) interface{} {
+		return test{
+			db:   newSecuredDB(t),
+			call: get(OptionUserCtx("bob")),
+		}
+	})
+	tests.Add("server admin role", func(t *testing.T) interface{} {
+		return test{
+			db:   newSecuredDB(t),
+			call: put("_design/foo", OptionUserCtx("root", "_admin")),
+		}
+	})
+	tests.Add("member writes", func(t *testing.T) interface{} {
+		return test{
+			db:   newSecuredDB(t),
+			call: put("bar", OptionUserCtx("alice")),
+		}
+	})
+	tests.Add("non-member write forbidden", func(t *testing.T) interface{} {
+		return test{
+			db:         newSecuredDB(t),
+			call:       put("bar", OptionUserCtx("carol")),
+			wantStatus: http.StatusForbidden,
+			wantErr:    "You are not allowed to access this db.",
+		}
+	})
+	tests.Add("member design doc write unauthorized", func(t *testing.T) interface{} {
+		return test{
+			db:         newSecuredDB(t),
+			call:       put("_design/foo", OptionUserCtx("alice")),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "You are not a db or server admin.",
+		}
+	})
+	tests.Add("admin writes design doc", func(t *testing.T) interface{} {
+		return test{
+			db:   newSecuredDB(t),
+			call: put("_design/foo", OptionUserCtx("bob")),
+		}
+	})
+	tests.Add("non-member delete forbidden", func(t *testing.T) interface{} {
+		d := newDB(t)
+		rev := d.tPut("foo", map[string]interface{}{})
+		d.tSetSecurity(&driver.Security{Members: driver.Members{Names: []string{"alice"}}})
+		return test{
+			db: d,
+			call: func(d *testDB) error {
+				_, err := d.Delete(context.Background(), "foo", multiOptions{kivik.Rev(rev), OptionUserCtx("carol")})
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "You are not allowed to access this db.",
+		}
+	})
+	tests.Add("non-member query forbidden", func(t *testing.T) interface{} {
+		return test{
+			db: newSecuredDB(t),
+			call: func(d *testDB) error {
+				_, err := d.AllDocs(context.Background(), OptionUserCtx("carol"))
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "You are not allowed to access this db.",
+		}
+	})
+	tests.Add("non-member changes forbidden", func(t *testing.T) interface{} {
+		return test{
+			db: newSecuredDB(t),
+			call: func(d *testDB) error {
+				_, err := d.Changes(context.Background(), OptionUserCtx("carol"))
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "You are not allowed to access this db.",
+		}
+	})
+	tests.Add("bulk docs design doc unauthorized", func(t *testing.T) interface{} {
+		return test{
+			db: newSecuredDB(t),
+			call: func(d *testDB) error {
+				results, err := d.DB.(driver.BulkDocer).BulkDocs(context.Background(), []interface{}{
+					map[string]interface{}{"_id": "bar"},
+					map[string]interface{}{"_id": "_design/bar"},
+				}, OptionUserCtx("alice"))
+				if err != nil {
+					return err
+				}
+				if results[0].Error != nil {
+					return results[0].Error
+				}
+				return results[1].Error
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "You are not a db or server admin.",
+		}
+	})
+	tests.Add("user context passed to validation", func(t *testing.T) interface{} {
+		d := newSecuredDB(t)
+		_ = d.tPut("_design/validate", map[string]interface{}{
+			"validate_doc_update": `function(newDoc, oldDoc, userCtx, secObj) {
+				if (secObj.admins.names.indexOf(userCtx.name) === -1) {
+					throw({forbidden: userCtx.name + " is not " + secObj.admins.names[0]});
+				}
+			}`,
+		})
+		return test{
+			db:         d,
+			call:       put("bar", OptionUserCtx("alice")),
+			wantStatus: http.StatusForbidden,
+			wantErr:    "alice is not bob",
+		}
+	})
+	tests.Add("member set security unauthorized", notAdmin(func(d *db) error {
+		return d.SetSecurity(context.Background(), &driver.Security{})
+	}))
+	tests.Add("admin sets security", func(t *testing.T) interface{} {
+		return test{
+			db: newSecuredDB(t),
+			call: func(d *testDB) error {
+				return as(d, OptionUserCtx("bob")).SetSecurity(context.Background(), &driver.Security{})
+			},
+		}
+	})
+	tests.Add("member purge unauthorized", notAdmin(func(d *db) error {
+		_, err := d.Purge(context.Background(), map[string][]string{"foo": {"1-xxx"}})
+		return err
+	}))
+	tests.Add("member compact unauthorized", notAdmin(func(d *db) error {
+		return d.Compact(context.Background())
+	}))
+	tests.Add("admin compacts", func(t *testing.T) interface{} {
+		return test{
+			db: newSecuredDB(t),
+			call: func(d *testDB) error {
+				return as(d, OptionUserCtx("bob")).Compact(context.Background())
+			},
+		}
+	})
+	tests.Add("member compact view unauthorized", notAdmin(func(d *db) error {
+		return d.CompactView(context.Background(), "foo")
+	}))
+	tests.Add("member view cleanup unauthorized", notAdmin(func(d *db) error {
+		return d.ViewCleanup(context.Background())
+	}))
+	tests.Add("non-member revs diff forbidden", notMember(func(d *db) error {
+		_, err := d.RevsDiff(context.Background(), map[string][]string{"foo": {"1-xxx"}})
+		return err
+	}))
+	tests.Add("non-member stats forbidden", notMember(func(d *db) error {
+		_, err := d.Stats(context.Background())
+		return err
+	}))
+	tests.Add("member reads stats", func(t *testing.T) interface{} {
+		return test{
+			db: newSecuredDB(t),
+			call: func(d *testDB) error {
+				_, err := as(d, OptionUserCtx("alice")).Stats(context.Background())
+				return err
+			},
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		err := tt.call(tt.db)
+		if !testy.ErrorMatches(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); err != nil && status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+}
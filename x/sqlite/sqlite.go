@@ -18,7 +18,11 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
+	"runtime"
+	"strconv"
+	"time"
 
 	"modernc.org/sqlite"
 
@@ -48,21 +52,43 @@ func (drv) NewClient(dsn string, options driver.Options) (driver.Client, error)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := db.Exec(dbUpdatesSchema); err != nil {
+		return nil, err
+	}
 
 	c := &client{
-		dsn:    dsn,
-		db:     db,
-		logger: log.Default(),
+		dsn:               dsn,
+		db:                db,
+		logger:            log.Default(),
+		compactions:       &compactions{},
+		notifier:          &notifier{},
+		mappers:           &mapperPools{},
+		parallelism:       runtime.GOMAXPROCS(0),
+		funcTimeout:       defaultFuncTimeout,
+		compressibleTypes: defaultCompressibleTypes,
 	}
 	options.Apply(c)
+	if err := c.migrateSchema(context.Background()); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
 
 type client struct {
-	dsn    string
-	db     *sql.DB
-	logger *log.Logger
+	dsn               string
+	db                *sql.DB
+	logger            *log.Logger
+	compactions       *compactions
+	notifier          *notifier
+	mappers           *mapperPools
+	vacuum            bool
+	parallelism       int
+	funcTimeout       time.Duration
+	compressibleTypes []string
+
+	attachmentDir       string
+	attachmentThreshold int64
 }
 
 var _ driver.Client = (*client)(nil)
@@ -80,14 +106,26 @@ func (client) Version(context.Context) (*driver.Version, error) {
 }
 
 func (c *client) AllDBs(ctx context.Context, _ driver.Options) ([]string, error) {
-	rows, err := c.db.QueryContext(ctx, `
+	return c.allDBs(ctx, c.db)
+}
+
+// allDBs returns the names of the main documents tables, which are those
+// accompanied by a revs table.
+func (c *client) allDBs(ctx context.Context, tx rowsQueryer) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
 		SELECT
 			name
 		FROM
-			sqlite_schema
+			sqlite_schema AS db
 		WHERE
 			type ='table' AND
-			name NOT LIKE 'sqlite_%'
+			name NOT LIKE 'sqlite_%' AND
+			name NOT LIKE '\_%' ESCAPE '\' AND
+			EXISTS (
+				SELECT 1
+				FROM sqlite_schema AS revs
+				WHERE revs.type = 'table' AND revs.name = db.name || '_revs'
+			)
 		`)
 	if err != nil {
 		return nil, err
@@ -144,26 +182,54 @@ func (c *client) CreateDB(ctx context.Context, name string, _ driver.Options) er
 		}
 		return err
 	}
-	return tx.Commit()
+	if err := recordDBUpdate(ctx, tx, name, dbUpdateCreated); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.notifier.notify(dbUpdatesTable)
+	return nil
 }
 
 func (c *client) DestroyDB(ctx context.Context, name string, _ driver.Options) error {
 	if !validDBNameRE.MatchString(name) {
 		return &internal.Error{Status: http.StatusBadRequest, Message: "invalid database name"}
 	}
-	_, err := c.db.ExecContext(ctx, `DROP TABLE "`+name+`"`)
-	if err == nil {
-		return nil
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
+
+	tables, err := c.newDB(name).tables(ctx, tx)
 	if errIsNoSuchTable(err) {
 		return &internal.Error{Status: http.StatusNotFound, Message: "database not found"}
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if _, err := tx.ExecContext(ctx, `DROP TABLE `+strconv.Quote(table)); err != nil {
+			return err
+		}
+	}
+	if err := recordDBUpdate(ctx, tx, name, dbUpdateDeleted); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.notifier.notify(dbUpdatesTable)
+	if dir := blobDir(c.attachmentDir, name); dir != "" {
+		return os.RemoveAll(dir)
+	}
+	return nil
 }
 
-func (c *client) DB(name string, _ driver.Options) (driver.DB, error) {
+func (c *client) DB(name string, options driver.Options) (driver.DB, error) {
 	if !validDBNameRE.MatchString(name) {
 		return nil, &internal.Error{Status: http.StatusBadRequest, Message: "invalid database name"}
 	}
-	return c.newDB(name), nil
+	return c.newDB(name).withUserCtx(options), nil
 }
@@ -59,12 +59,14 @@ func TestClientAllDBs(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	c := dClient.(*client)
-
-	if _, err := c.db.Exec("CREATE TABLE foo (id INTEGER)"); err != nil {
+	if err := dClient.CreateDB(context.Background(), "foo", mock.NilOption); err != nil {
+		t.Fatal(err)
+	}
+	if err := dClient.CreateDB(context.Background(), "bar", mock.NilOption); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := c.db.Exec("CREATE TABLE bar (id INTEGER)"); err != nil {
+	// Tables which don't belong to a database are ignored.
+	if _, err := dClient.(*client).db.Exec("CREATE TABLE baz (id INTEGER)"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -180,6 +182,20 @@ func TestClientCreateDB(t *testing.T) {
 			t.Fatalf("status should be %d", wantStatus)
 		}
 	})
+	t.Run("second db", func(t *testing.T) {
+		d := drv{}
+		dClient, err := d.NewClient(":memory:", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := dClient.CreateDB(context.Background(), "foo", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+		if err := dClient.CreateDB(context.Background(), "bar", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+	})
 }
 
 func TestClientDestroyDB(t *testing.T) {
@@ -206,6 +222,55 @@ func TestClientDestroyDB(t *testing.T) {
 			t.Fatal("foo should not exist")
 		}
 	})
+	t.Run("re-create", func(t *testing.T) {
+		d := drv{}
+		dClient, err := d.NewClient(":memory:", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+
+		if err := dClient.CreateDB(ctx, "foo", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+		db, err := dClient.DB("foo", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Put(ctx, "_design/foo", map[string]interface{}{
+			"views": map[string]interface{}{
+				"bar": map[string]interface{}{
+					"map":    "function(doc) { emit(doc._id, 1); }",
+					"reduce": "_sum",
+				},
+			},
+		}, mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query(ctx, "_design/foo", "_view/bar", mock.NilOption)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rows.Close()
+
+		if err := dClient.DestroyDB(ctx, "foo", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+		var tables int
+		if err := dClient.(*client).db.QueryRow(`
+			SELECT COUNT(*)
+			FROM sqlite_schema
+			WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE '\_%' ESCAPE '\'
+		`).Scan(&tables); err != nil {
+			t.Fatal(err)
+		}
+		if tables != 0 {
+			t.Errorf("Expected all tables to be dropped, found %d", tables)
+		}
+		if err := dClient.CreateDB(ctx, "foo", mock.NilOption); err != nil {
+			t.Fatal(err)
+		}
+	})
 	t.Run("doesn't exist", func(t *testing.T) {
 		d := drv{}
 		dClient, err := d.NewClient(":memory:", mock.NilOption)
@@ -227,3 +292,33 @@ func TestClientDestroyDB(t *testing.T) {
 		}
 	})
 }
+
+func TestClientDB_user_ctx(t *testing.T) {
+	d := drv{}
+	dClient, err := d.NewClient(":memory:", mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dClient.CreateDB(context.Background(), "foo", mock.NilOption); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := dClient.DB("foo", mock.NilOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.(driver.SecurityDB).SetSecurity(context.Background(), &driver.Security{
+		Admins: driver.Members{Names: []string{"bob"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := dClient.DB("foo", OptionUserCtx("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Compact(context.Background())
+	const wantStatus = http.StatusUnauthorized
+	if status := kivik.HTTPStatus(err); status != wantStatus {
+		t.Errorf("Unexpected status: %d", status)
+	}
+}
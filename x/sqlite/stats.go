@@ -0,0 +1,184 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4/driver"
+)
+
+// rawStats is the CouchDB-compatible representation of the database
+// statistics, returned as the raw response.
+type rawStats struct {
+	DBName         string `json:"db_name"`
+	UpdateSeq      string `json:"update_seq"`
+	PurgeSeq       string `json:"purge_seq"`
+	DocCount       int64  `json:"doc_count"`
+	DocDelCount    int64  `json:"doc_del_count"`
+	CompactRunning bool   `json:"compact_running"`
+	Sizes          struct {
+		File     int64 `json:"file"`
+		External int64 `json:"external"`
+		Active   int64 `json:"active"`
+	} `json:"sizes"`
+}
+
+// Stats returns the database statistics. Document counts consider only the
+// winning revision of each document, and exclude local documents. Sizes are
+// calculated from the SQLite pages used by the tables and indexes which belong
+// to the database, when the dbstat virtual table is available, or else
+// estimated from the database file size.
+func (d *db) Stats(ctx context.Context) (*driver.DBStats, error) {
+	if err := d.authorizeRead(ctx, nil); err != nil {
+		return nil, err
+	}
+	tx, err := d.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats := rawStats{
+		DBName:         d.name,
+		CompactRunning: d.compactions.isRunning(d.name),
+	}
+	var updateSeq, purgeSeq uint64
+	err = tx.QueryRowContext(ctx, d.query(`
+		WITH leaves AS (
+			SELECT
+				rev.id,
+				doc.deleted,
+				LENGTH(doc.doc) AS size,
+				(
+					SELECT COALESCE(SUM(att.length), 0)
+					FROM {{ .AttachmentsBridge }} AS bridge
+					JOIN {{ .Attachments }} AS att ON att.pk = bridge.pk
+					WHERE bridge.id = rev.id AND bridge.rev = rev.rev AND bridge.rev_id = rev.rev_id
+				) AS att_size,
+				ROW_NUMBER() OVER (PARTITION BY rev.id ORDER BY doc.deleted, rev.rev DESC, rev.rev_id DESC) AS rank
+			FROM {{ .Revs }} AS rev
+			LEFT JOIN {{ .Revs }} AS child ON child.id = rev.id AND rev.rev = child.parent_rev AND rev.rev_id = child.parent_rev_id
+			JOIN {{ .Docs }} AS doc ON rev.id = doc.id AND rev.rev = doc.rev AND rev.rev_id = doc.rev_id
+			WHERE child.id IS NULL
+				AND rev.id NOT LIKE '\_local/%' ESCAPE '\'
+		)
+		SELECT
+			COALESCE(SUM(CASE WHEN NOT deleted THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN deleted THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN NOT deleted THEN size + att_size ELSE 0 END), 0),
+			(SELECT COALESCE(MAX(seq), 0) FROM {{ .Docs }}),
+			(SELECT COALESCE(MAX(seq), 0) FROM {{ .Purges }})
+		FROM leaves
+		WHERE rank = 1
+	`)).Scan(&stats.DocCount, &stats.DocDelCount, &stats.Sizes.External, &updateSeq, &purgeSeq)
+	if err != nil {
+		return nil, err
+	}
+	stats.UpdateSeq = strconv.FormatUint(updateSeq, 10)
+	stats.PurgeSeq = strconv.FormatUint(purgeSeq, 10)
+
+	stats.Sizes.File, stats.Sizes.Active, err = d.diskSizes(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.DBStats{
+		Name:           stats.DBName,
+		CompactRunning: stats.CompactRunning,
+		DocCount:       stats.DocCount,
+		DeletedCount:   stats.DocDelCount,
+		UpdateSeq:      stats.UpdateSeq,
+		DiskSize:       stats.Sizes.File,
+		ActiveSize:     stats.Sizes.Active,
+		ExternalSize:   stats.Sizes.External,
+		RawResponse:    raw,
+	}, nil
+}
+
+// diskSizes returns the total size of the pages used by the database's tables
+// and indexes, and the number of bytes of those pages which are in use.
+func (d *db) diskSizes(ctx context.Context, tx *sql.Tx) (file, active int64, err error) {
+	tables, err := d.tables(ctx, tx)
+	if err != nil {
+		return 0, 0, err
+	}
+	args := make([]interface{}, len(tables))
+	for i, table := range tables {
+		args[i] = table
+	}
+	err = tx.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(stat.pgsize), 0),
+			COALESCE(SUM(stat.pgsize - stat.unused), 0)
+		FROM dbstat AS stat
+		JOIN sqlite_schema AS schema ON schema.name = stat.name
+		WHERE schema.tbl_name IN (`+placeholders(1, len(tables))+`)
+	`, args...).Scan(&file, &active)
+	if err == nil {
+		return file, active, nil
+	}
+	if !strings.Contains(err.Error(), "no such table: dbstat") {
+		return 0, 0, err
+	}
+
+	// The dbstat virtual table is not available, so the best we can do is to
+	// report the size of the entire database file.
+	var pageCount, pageSize, freeCount int64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT page_count, page_size, freelist_count
+		FROM pragma_page_count(), pragma_page_size(), pragma_freelist_count()
+	`).Scan(&pageCount, &pageSize, &freeCount); err != nil {
+		return 0, 0, err
+	}
+	return pageCount * pageSize, (pageCount - freeCount) * pageSize, nil
+}
+
+// tables returns the names of all tables which belong to the database,
+// including the existing map and reduce cache tables of its views. Tables
+// are listed before those they reference, so they may be dropped in order.
+func (d *db) tables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	tables := []string{}
+	for _, name := range []string{"{{ .Map }}", "{{ .Reduce }}"} {
+		views, err := d.viewTables(ctx, tx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range views {
+			tables = append(tables, unquote(table))
+		}
+	}
+
+	for _, name := range []string{"{{ .Design }}", "{{ .AttachmentsBridge }}", "{{ .Attachments }}", "{{ .AttachmentData }}", "{{ .Purges }}", "{{ .Security }}", "{{ .RevsLimit }}", "{{ .Docs }}", "{{ .Revs }}"} {
+		tables = append(tables, unquote(d.query(name)))
+	}
+	return tables, nil
+}
+
+func unquote(name string) string {
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		return unquoted
+	}
+	return name
+}
@@ -0,0 +1,153 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		db               *testDB
+		wantDocCount     int64
+		wantDeletedCount int64
+		wantUpdateSeq    string
+		wantPurgeSeq     string
+		wantExternal     int64
+	}
+
+	tests := testy.NewTable()
+	tests.Add("empty database", test{
+		wantUpdateSeq: "0",
+		wantPurgeSeq:  "0",
+	})
+	tests.Add("documents", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("foo", map[string]interface{}{"foo": "bar"})
+		_ = d.tPut("bar", map[string]interface{}{"_attachments": newAttachments().add("att.txt", "hello")})
+		rev := d.tPut("baz", map[string]interface{}{})
+		_ = d.tDelete("baz", kivik.Rev(rev))
+		_ = d.tPut("_local/qux", map[string]interface{}{"qux": true})
+		_ = d.tPut("xlocal/qux", map[string]interface{}{})
+
+		return test{
+			db:               d,
+			wantDocCount:     3,
+			wantDeletedCount: 1,
+			wantUpdateSeq:    "6",
+			wantPurgeSeq:     "0",
+			wantExternal:     int64(len(`{"foo":"bar"}`) + len(`{}`) + len("hello") + len(`{}`)),
+		}
+	})
+	tests.Add("conflicts count once", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("foo", map[string]interface{}{"_rev": "1-abc"}, kivik.Param("new_edits", false))
+		_ = d.tPut("foo", map[string]interface{}{"_rev": "1-def", "_deleted": true}, kivik.Param("new_edits", false))
+
+		return test{
+			db:            d,
+			wantDocCount:  1,
+			wantUpdateSeq: "2",
+			wantPurgeSeq:  "0",
+			wantExternal:  int64(len(`{}`)),
+		}
+	})
+	tests.Add("purged", func(t *testing.T) interface{} {
+		d := newDB(t)
+		rev := d.tPut("foo", map[string]interface{}{})
+		if _, err := d.Purge(context.Background(), map[string][]string{"foo": {rev}}); err != nil {
+			t.Fatal(err)
+		}
+
+		return test{
+			db:            d,
+			wantUpdateSeq: "0",
+			wantPurgeSeq:  "1",
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		db := tt.db
+		if db == nil {
+			db = newDB(t)
+		}
+		got, err := db.Stats(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "test" {
+			t.Errorf("Unexpected name: %s", got.Name)
+		}
+		if got.DocCount != tt.wantDocCount {
+			t.Errorf("Unexpected doc count: %d", got.DocCount)
+		}
+		if got.DeletedCount != tt.wantDeletedCount {
+			t.Errorf("Unexpected deleted count: %d", got.DeletedCount)
+		}
+		if got.UpdateSeq != tt.wantUpdateSeq {
+			t.Errorf("Unexpected update seq: %s", got.UpdateSeq)
+		}
+		if got.ExternalSize != tt.wantExternal {
+			t.Errorf("Unexpected external size: %d", got.ExternalSize)
+		}
+		if got.DiskSize <= 0 || got.ActiveSize <= 0 || got.ActiveSize > got.DiskSize {
+			t.Errorf("Unexpected sizes: disk=%d, active=%d", got.DiskSize, got.ActiveSize)
+		}
+		var raw struct {
+			PurgeSeq string `json:"purge_seq"`
+		}
+		if err := json.Unmarshal(got.RawResponse, &raw); err != nil {
+			t.Fatal(err)
+		}
+		if raw.PurgeSeq != tt.wantPurgeSeq {
+			t.Errorf("Unexpected purge seq: %s", raw.PurgeSeq)
+		}
+	})
+}
+
+func TestStatsIncludesViewTables(t *testing.T) {
+	t.Parallel()
+	d := newDB(t)
+	rev := d.tPut("_design/foo", map[string]interface{}{
+		"views": map[string]interface{}{
+			"bar": map[string]interface{}{"map": "function(doc) { emit(doc._id, null); }"},
+		},
+	})
+	tx, err := d.underlying().Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	tables, err := d.DB.(*db).tables(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := unquote(d.DB.(*db).ddocQuery("_design/foo", "bar", rev, "{{ .Map }}"))
+	for _, table := range tables {
+		if table == want {
+			return
+		}
+	}
+	t.Errorf("Map table %s not found in %v", want, tables)
+}
@@ -50,6 +50,10 @@ func (t *tmplFuncs) Attachments() string {
 	return strconv.Quote(t.db.name + "_attachments")
 }
 
+func (t *tmplFuncs) AttachmentData() string {
+	return strconv.Quote(t.db.name + "_attachment_data")
+}
+
 func (t *tmplFuncs) AttachmentsBridge() string {
 	return strconv.Quote(t.db.name + "_attachments_bridge")
 }
@@ -58,6 +62,28 @@ func (t *tmplFuncs) Design() string {
 	return strconv.Quote(t.db.name + "_design")
 }
 
+func (t *tmplFuncs) Purges() string {
+	return strconv.Quote(t.db.name + "_purges")
+}
+
+func (t *tmplFuncs) Security() string {
+	return strconv.Quote(t.db.name + "_security")
+}
+
+func (t *tmplFuncs) RevsLimit() string {
+	return strconv.Quote(t.db.name + "_revs_limit")
+}
+
+// IndexRevsKey and IndexRevsParent name the indexes of the revs table. Index
+// names are global to the SQLite file, so must include the database name.
+func (t *tmplFuncs) IndexRevsKey() string {
+	return strconv.Quote("idx_" + t.db.name + "_revs_key")
+}
+
+func (t *tmplFuncs) IndexRevsParent() string {
+	return strconv.Quote("idx_" + t.db.name + "_revs_parent")
+}
+
 const maxTableLen = 59 // 64 minus the `idx_` prefix, and one more `_` separator
 
 // hashedName returns a table name in the format "{{db name}}_{{ddoc}}_{{typ}}_{{hash}}"
@@ -65,6 +91,16 @@ const maxTableLen = 59 // 64 minus the `idx_` prefix, and one more `_` separator
 // If the final version is longer than 64 characters, it is truncated to size,
 // before appending the hash.
 func (t *tmplFuncs) hashedName(typ string) string {
+	name := t.viewPrefix() + "_" + typ
+	if len(name) > maxTableLen-len(t.hash) {
+		name = name[:maxTableLen-len(t.hash)]
+	}
+	return name + "_" + t.hash
+}
+
+// viewPrefix returns the ddoc, rev and view name portion of a view's table
+// names, and sets the hash which distinguishes them once truncated.
+func (t *tmplFuncs) viewPrefix() string {
 	if t.ddoc == "" {
 		panic("ddoc template method called outside of a ddoc template")
 	}
@@ -72,11 +108,7 @@ func (t *tmplFuncs) hashedName(typ string) string {
 	if t.hash == "" {
 		t.hash = md5sumString(name)[:8]
 	}
-	name += "_" + typ
-	if len(name) > maxTableLen-len(t.hash) {
-		name = name[:maxTableLen-len(t.hash)]
-	}
-	return name + "_" + t.hash
+	return name
 }
 
 func (t *tmplFuncs) Map() string {
@@ -87,6 +119,18 @@ func (t *tmplFuncs) IndexMap() string {
 	return strconv.Quote("idx_" + t.hashedName("map"))
 }
 
+// Reduce returns the name of the reduce cache table. Unlike the map table
+// name, the view portion, rather than the type, is truncated to size, so
+// that long names never collide with that of the map table.
+func (t *tmplFuncs) Reduce() string {
+	const suffix = "_reduce"
+	name := t.viewPrefix()
+	if maxLen := maxTableLen - len(t.hash) - len(suffix); len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	return strconv.Quote(name + suffix + "_" + t.hash)
+}
+
 func (t *tmplFuncs) Collation() string {
 	if t.collation == nil {
 		return "COUCHDB_UCI"
@@ -106,7 +150,11 @@ func (t *tmplFuncs) Collation() string {
 //	{{ .Revs }} -> db.name + "_revs"
 //	{{ .Attachments }} -> db.name + "_attachments"
 //	{{ .AttachmentsBridge }} -> db.name + "_attachments_bridge"
+//	{{ .AttachmentData }} -> db.name + "_attachment_data"
 //	{{ .Design }} -> db.name + "_design"
+//	{{ .Purges }} -> db.name + "_purges"
+//	{{ .Security }} -> db.name + "_security"
+//	{{ .RevsLimit }} -> db.name + "_revs_limit"
 func (d *db) query(format string) string {
 	var buf bytes.Buffer
 	tmpl := getTmpl(format)
@@ -122,6 +170,7 @@ func (d *db) query(format string) string {
 //
 //	{{ .Map }} -> the view map table name
 //	{{ .IndexMap }} -> the view map index name
+//	{{ .Reduce }} -> the view reduce cache table name
 func (d *db) ddocQuery(docID, viewOrFuncName, rev, format string) string {
 	var buf bytes.Buffer
 	tmpl := getTmpl(format)
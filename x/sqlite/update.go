@@ -0,0 +1,195 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/x/sqlite/v4/js"
+)
+
+// UpdateHandler calls the update function funcName of the design document
+// ddoc, with the current revision of docID, or null if docID is empty or the
+// document does not exist. The document returned by the update function, if
+// any, is saved, and the response body is returned.
+func (d *db) UpdateHandler(ctx context.Context, ddoc, funcName, docID string, body interface{}, options driver.Options) (string, string, []byte, error) {
+	d = d.withUserCtx(options)
+	ddocID := "_design/" + strings.TrimPrefix(ddoc, "_design/")
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer tx.Rollback()
+
+	sec, err := d.security(ctx, tx)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if err := d.authorize(sec, false); err != nil {
+		return "", "", nil, err
+	}
+
+	update, err := d.updateFunc(ctx, tx, ddocID, funcName)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var doc interface{}
+	if docID != "" {
+		current, rev, err := d.getCoreDoc(ctx, tx, docID, revision{}, false, false)
+		switch {
+		case kivik.HTTPStatus(err) == http.StatusNotFound:
+		case err != nil:
+			return "", "", nil, err
+		default:
+			atts, err := d.getAttachments(ctx, tx, docID, rev, false, false, nil)
+			if err != nil {
+				return "", "", nil, err
+			}
+			current.Attachments = atts.inlineAttachments()
+			doc = current.toMap()
+		}
+	}
+
+	req, err := d.updateRequest(ddocID, funcName, docID, body, newOpts(options), sec)
+	if err != nil {
+		return "", "", nil, err
+	}
+	newDoc, respBody, err := update(doc, req)
+	var valErr *js.ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &valErr) && valErr.Reason == "forbidden":
+		return "", "", nil, &internal.Error{Status: http.StatusForbidden, Message: valErr.Message}
+	case errors.As(err, &valErr):
+		return "", "", nil, &internal.Error{Status: http.StatusUnauthorized, Message: valErr.Message}
+	default:
+		return "", "", nil, &internal.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	if newDoc == nil {
+		return "", "", respBody, tx.Commit()
+	}
+
+	id, _ := newDoc["_id"].(string)
+	if id == "" {
+		return "", "", nil, &internal.Error{Status: http.StatusBadRequest, Message: "Document id must be a string"}
+	}
+	rev, err := d.put(ctx, tx, id, newDoc, optsMap{})
+	if err != nil {
+		return "", "", nil, err
+	}
+	return id, rev, respBody, d.commit(ctx, tx)
+}
+
+// updateFunc returns the compiled update function funcName from the winning
+// revision of the design document ddocID.
+func (d *db) updateFunc(ctx context.Context, tx *sql.Tx, ddocID, funcName string) (js.UpdateFunc, error) {
+	var code, ddocJSON *string
+	err := tx.QueryRowContext(ctx, d.query(leavesCTE+`
+		SELECT
+			-- NULL if the function doesn't exist
+			design.func_body,
+			leaves.doc
+		FROM leaves
+		LEFT JOIN {{ .Design }} AS design ON design.id = leaves.id AND design.rev = leaves.rev AND design.rev_id = leaves.rev_id AND design.func_type = 'update' AND design.func_name = $2
+		WHERE leaves.id = $1
+		ORDER BY leaves.rev DESC, leaves.rev_id DESC
+		LIMIT 1
+	`), ddocID, funcName).Scan(&code, &ddocJSON)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, &internal.Error{Status: http.StatusNotFound, Message: "missing"}
+	case err != nil:
+		return nil, err
+	case code == nil:
+		return nil, &internal.Error{Status: http.StatusNotFound, Message: fmt.Sprintf("missing update function %s on design doc %s", funcName, ddocID)}
+	}
+	jsOpts, err := d.jsOptions(ddocJSON)
+	if err != nil {
+		return nil, err
+	}
+	update, err := js.Update(*code, jsOpts...)
+	if err != nil {
+		return nil, &internal.Error{Status: http.StatusInternalServerError, Err: err}
+	}
+	return update, nil
+}
+
+// updateRequest returns a CouchDB-style request object, as passed to update
+// functions.
+func (d *db) updateRequest(ddocID, funcName, docID string, body interface{}, opts optsMap, sec *driver.Security) (map[string]interface{}, error) {
+	var reqBody string
+	switch t := body.(type) {
+	case nil:
+		reqBody = "undefined"
+	case string:
+		reqBody = t
+	case []byte:
+		reqBody = string(t)
+	case json.RawMessage:
+		reqBody = string(t)
+	default:
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, &internal.Error{Status: http.StatusBadRequest, Err: err}
+		}
+		reqBody = string(b)
+	}
+
+	query := make(map[string]interface{}, len(opts))
+	for k, v := range opts {
+		if s, ok := v.(string); ok {
+			query[k] = s
+			continue
+		}
+		query[k] = fmt.Sprint(v)
+	}
+
+	method := http.MethodPost
+	path := []interface{}{d.name, "_design", strings.TrimPrefix(ddocID, "_design/"), "_update", funcName}
+	var id interface{}
+	if docID != "" {
+		method = http.MethodPut
+		path = append(path, docID)
+		id = docID
+	}
+
+	return map[string]interface{}{
+		"info":           map[string]interface{}{"db_name": d.name},
+		"id":             id,
+		"uuid":           strings.ReplaceAll(uuid.NewString(), "-", ""),
+		"method":         method,
+		"path":           path,
+		"requested_path": path,
+		"query":          query,
+		"headers":        map[string]interface{}{},
+		"body":           reqBody,
+		"form":           map[string]interface{}{},
+		"cookie":         map[string]interface{}{},
+		"peer":           "127.0.0.1",
+		"userCtx":        d.userCtx(),
+		"secObj":         securityObject(sec),
+	}, nil
+}
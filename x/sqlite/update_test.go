@@ -0,0 +1,303 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestUpdateHandler(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		db         *testDB
+		ddoc       string
+		funcName   string
+		docID      string
+		body       interface{}
+		options    driver.Options
+		wantID     string
+		wantRev    string
+		wantBody   string
+		check      func(*testing.T, *testDB)
+		wantStatus int
+		wantErr    string
+	}
+
+	// newUpdateDB returns a database with a design document containing a
+	// few update functions.
+	newUpdateDB := func(t *testing.T) *testDB {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"updates": map[string]string{
+				"hello": `function(doc, req) {
+					return [null, "hello " + (req.query.name || "world")];
+				}`,
+				"create": `function(doc, req) {
+					if (doc) {
+						return [null, {code: 409, json: {error: "exists"}}];
+					}
+					return [{_id: req.id || req.uuid, body: JSON.parse(req.body)}, "created"];
+				}`,
+				"bump": `function(doc, req) {
+					doc.count = (doc.count || 0) + 1;
+					return [doc, {json: {count: doc.count, method: req.method}}];
+				}`,
+				"base64": `function(doc, req) {
+					return [null, {base64: "aGVsbG8="}];
+				}`,
+				"throws": `function(doc, req) {
+					throw("oops");
+				}`,
+				"forbidden": `function(doc, req) {
+					throw({forbidden: "not allowed"});
+				}`,
+				"unauthorized": `function(doc, req) {
+					throw({unauthorized: "log in first"});
+				}`,
+				"greet": `function(doc, req) {
+					return [null, require('lib/greeting').greet(req.query.name)];
+				}`,
+			},
+			"lib": map[string]string{
+				"greeting": `exports.greet = function(name) { return "greetings " + name; };`,
+			},
+		})
+		return d
+	}
+
+	tests := testy.NewTable()
+	tests.Add("design doc not found", func(t *testing.T) interface{} {
+		return test{
+			db:         newDB(t),
+			ddoc:       "foo",
+			funcName:   "hello",
+			wantStatus: http.StatusNotFound,
+			wantErr:    "missing",
+		}
+	})
+	tests.Add("update function not found", func(t *testing.T) interface{} {
+		return test{
+			db:         newUpdateDB(t),
+			ddoc:       "foo",
+			funcName:   "nope",
+			wantStatus: http.StatusNotFound,
+			wantErr:    "missing update function nope on design doc _design/foo",
+		}
+	})
+	tests.Add("response only", func(t *testing.T) interface{} {
+		return test{
+			db:       newUpdateDB(t),
+			ddoc:     "_design/foo",
+			funcName: "hello",
+			options:  kivik.Param("name", "bob"),
+			wantBody: "hello bob",
+		}
+	})
+	tests.Add("require module from design doc", func(t *testing.T) interface{} {
+		return test{
+			db:       newUpdateDB(t),
+			ddoc:     "foo",
+			funcName: "greet",
+			options:  kivik.Param("name", "bob"),
+			wantBody: "greetings bob",
+		}
+	})
+	tests.Add("base64 response", func(t *testing.T) interface{} {
+		return test{
+			db:       newUpdateDB(t),
+			ddoc:     "foo",
+			funcName: "base64",
+			wantBody: "hello",
+		}
+	})
+	tests.Add("create new document", func(t *testing.T) interface{} {
+		return test{
+			db:       newUpdateDB(t),
+			ddoc:     "foo",
+			funcName: "create",
+			docID:    "bar",
+			body:     map[string]interface{}{"value": 1},
+			wantID:   "bar",
+			wantRev:  "1-.*",
+			wantBody: "created",
+			check: func(t *testing.T, d *testDB) {
+				doc, err := d.Get(context.Background(), "bar", mock.NilOption)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(doc.Body)
+				if !strings.Contains(string(body), `"body":{"value":1}`) {
+					t.Errorf("Unexpected document: %s", body)
+				}
+			},
+		}
+	})
+	tests.Add("create document with generated id", func(t *testing.T) interface{} {
+		return test{
+			db:       newUpdateDB(t),
+			ddoc:     "foo",
+			funcName: "create",
+			body:     `{"value":1}`,
+			wantID:   "[0-9a-f]{32}",
+			wantRev:  "1-.*",
+			wantBody: "created",
+		}
+	})
+	tests.Add("update existing document", func(t *testing.T) interface{} {
+		d := newUpdateDB(t)
+		_ = d.tPut("bar", map[string]interface{}{"count": 1})
+
+		return test{
+			db:       d,
+			ddoc:     "foo",
+			funcName: "bump",
+			docID:    "bar",
+			wantID:   "bar",
+			wantRev:  "2-.*",
+			wantBody: `{"count":2,"method":"PUT"}`,
+		}
+	})
+	tests.Add("update preserves attachments", func(t *testing.T) interface{} {
+		d := newUpdateDB(t)
+		_ = d.tPut("bar", map[string]interface{}{
+			"_attachments": newAttachments().add("foo.txt", "This is a base64 encoding"),
+		})
+
+		return test{
+			db:       d,
+			ddoc:     "foo",
+			funcName: "bump",
+			docID:    "bar",
+			wantID:   "bar",
+			wantRev:  "2-.*",
+			wantBody: `{"count":1,"method":"PUT"}`,
+			check: func(t *testing.T, d *testDB) {
+				doc, err := d.Get(context.Background(), "bar", mock.NilOption)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got struct {
+					Attachments map[string]interface{} `json:"_attachments"`
+				}
+				if err := json.NewDecoder(doc.Body).Decode(&got); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := got.Attachments["foo.txt"]; !ok {
+					t.Errorf("attachment lost: %v", got.Attachments)
+				}
+			},
+		}
+	})
+	tests.Add("function does not save existing document", func(t *testing.T) interface{} {
+		d := newUpdateDB(t)
+		_ = d.tPut("bar", map[string]interface{}{})
+
+		return test{
+			db:       d,
+			ddoc:     "foo",
+			funcName: "create",
+			docID:    "bar",
+			wantBody: `{"error":"exists"}`,
+		}
+	})
+	tests.Add("exception", func(t *testing.T) interface{} {
+		return test{
+			db:         newUpdateDB(t),
+			ddoc:       "foo",
+			funcName:   "throws",
+			wantStatus: http.StatusInternalServerError,
+			wantErr:    "oops",
+		}
+	})
+	tests.Add("forbidden", func(t *testing.T) interface{} {
+		return test{
+			db:         newUpdateDB(t),
+			ddoc:       "foo",
+			funcName:   "forbidden",
+			wantStatus: http.StatusForbidden,
+			wantErr:    "not allowed",
+		}
+	})
+	tests.Add("unauthorized", func(t *testing.T) interface{} {
+		return test{
+			db:         newUpdateDB(t),
+			ddoc:       "foo",
+			funcName:   "unauthorized",
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "log in first",
+		}
+	})
+	tests.Add("saved document is validated", func(t *testing.T) interface{} {
+		d := newUpdateDB(t)
+		_ = d.tPut("_design/validate", map[string]interface{}{
+			"validate_doc_update": `function(newDoc) {
+				throw({forbidden: "read only"});
+			}`,
+		})
+
+		return test{
+			db:         d,
+			ddoc:       "foo",
+			funcName:   "create",
+			docID:      "bar",
+			body:       "{}",
+			wantStatus: http.StatusForbidden,
+			wantErr:    "read only",
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		opts := tt.options
+		if opts == nil {
+			opts = mock.NilOption
+		}
+		id, rev, body, err := tt.db.DB.(*db).UpdateHandler(context.Background(), tt.ddoc, tt.funcName, tt.docID, tt.body, opts)
+		if !testy.ErrorMatchesRE(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); err != nil && status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+		if err != nil {
+			return
+		}
+		if !regexp.MustCompile("^" + tt.wantID + "$").MatchString(id) {
+			t.Errorf("Unexpected id: %s", id)
+		}
+		if !regexp.MustCompile("^" + tt.wantRev + "$").MatchString(rev) {
+			t.Errorf("Unexpected rev: %s", rev)
+		}
+		if string(body) != tt.wantBody {
+			t.Errorf("Unexpected body: %s", body)
+		}
+		if tt.check != nil {
+			tt.check(t, tt.db)
+		}
+	})
+}
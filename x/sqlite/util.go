@@ -124,6 +124,9 @@ func (d *db) createRev(ctx context.Context, tx *sql.Tx, data *docData, curRev re
 		curRevRev *int
 		curRevID  *string
 	)
+	if err := d.validateDocUpdate(ctx, tx, data, curRev); err != nil {
+		return r, err
+	}
 	if curRev.rev != 0 {
 		curRevRev = &curRev.rev
 		curRevID = &curRev.id
@@ -162,8 +165,10 @@ func (d *db) createRev(ctx context.Context, tx *sql.Tx, data *docData, curRev re
 	if err := d.createDocAttachments(ctx, data, tx, r, &curRev); err != nil {
 		return r, err
 	}
-	err = d.updateDesignDoc(ctx, tx, r, data)
-	return r, err
+	if err := d.updateDesignDoc(ctx, tx, r, data); err != nil {
+		return r, err
+	}
+	return r, d.stemDoc(ctx, tx, data.ID, r)
 }
 
 func (d *db) createDocAttachments(ctx context.Context, data *docData, tx *sql.Tx, r revision, curRev *revision) error {
@@ -216,8 +221,12 @@ func (d *db) createDocAttachments(ctx context.Context, data *docData, tx *sql.Tx
 				contentType = "application/octet-stream"
 			}
 
+			hash, err := d.storeAttachmentData(ctx, tx, stmts, &att, contentType)
+			if err != nil {
+				return err
+			}
 			attStmt, err := stmts.prepare(ctx, tx, d.query(`
-				INSERT INTO {{ .Attachments }} (rev_pos, filename, content_type, length, digest, data)
+				INSERT INTO {{ .Attachments }} (rev_pos, filename, content_type, length, digest, hash)
 				VALUES ($1, $2, $3, $4, $5, $6)
 				RETURNING pk
 			`))
@@ -225,7 +234,7 @@ func (d *db) createDocAttachments(ctx context.Context, data *docData, tx *sql.Tx
 				return err
 			}
 
-			err = attStmt.QueryRowContext(ctx, r.rev, filename, contentType, att.Length, att.Digest, att.Content).Scan(&pk)
+			err = attStmt.QueryRowContext(ctx, r.rev, filename, contentType, att.Length, att.Digest, hash).Scan(&pk)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,189 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	internal "github.com/go-kivik/kivik/v4/int/errors"
+	"github.com/go-kivik/kivik/x/sqlite/v4/js"
+)
+
+// validateDocUpdate checks that d's user may write data, then runs the
+// validate_doc_update functions of all design documents against the update of
+// data, which replaces oldRev. As in CouchDB, design and local documents are
+// not validated, and the first function to reject the update determines the
+// error.
+func (d *db) validateDocUpdate(ctx context.Context, tx *sql.Tx, data *docData, oldRev revision) error {
+	sec, err := d.security(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := d.authorize(sec, strings.HasPrefix(data.ID, "_design/")); err != nil {
+		return err
+	}
+	if strings.HasPrefix(data.ID, "_design/") || strings.HasPrefix(data.ID, "_local/") {
+		return nil
+	}
+	funcs, err := d.validateFuncs(ctx, tx)
+	if err != nil || len(funcs) == 0 {
+		return err
+	}
+
+	oldDoc, err := d.validateOldDoc(ctx, tx, data.ID, oldRev)
+	if err != nil {
+		return err
+	}
+	newDoc, err := validateNewDoc(data, oldRev, oldDoc)
+	if err != nil {
+		return err
+	}
+	var oldDocMap interface{}
+	if oldDoc != nil {
+		oldDocMap = oldDoc.toMap()
+	}
+	userCtx := d.userCtx()
+	secObj := securityObject(sec)
+
+	for _, f := range funcs {
+		jsOpts, err := d.jsOptions(&f.ddocJSON)
+		if err != nil {
+			return err
+		}
+		validate, err := compileValidate(f.language, f.body, jsOpts...)
+		if err != nil {
+			return &internal.Error{Status: http.StatusInternalServerError, Err: err}
+		}
+		err = validate(newDoc, oldDocMap, userCtx, secObj)
+		var valErr *js.ValidationError
+		switch {
+		case err == nil:
+			continue
+		case errors.As(err, &valErr) && valErr.Reason == "forbidden":
+			return &internal.Error{Status: http.StatusForbidden, Message: valErr.Message}
+		case errors.As(err, &valErr):
+			return &internal.Error{Status: http.StatusUnauthorized, Message: valErr.Message}
+		default:
+			return &internal.Error{Status: http.StatusInternalServerError, Err: err}
+		}
+	}
+	return nil
+}
+
+type validateFunc struct {
+	ddoc     string
+	ddocJSON string
+	language string
+	body     string
+}
+
+// validateFuncs returns the validate_doc_update functions of the winning
+// revisions of all design documents, ordered by design document ID.
+func (d *db) validateFuncs(ctx context.Context, tx *sql.Tx) ([]validateFunc, error) {
+	rows, err := tx.QueryContext(ctx, d.query(leavesCTE+`
+		SELECT design.id, ddoc.doc, design.language, design.func_body
+		FROM (
+			SELECT
+				id,
+				rev,
+				rev_id,
+				doc,
+				ROW_NUMBER() OVER (PARTITION BY id ORDER BY rev DESC, rev_id DESC) AS rank
+			FROM leaves
+			WHERE id LIKE '\_design/%' ESCAPE '\'
+		) AS ddoc
+		JOIN {{ .Design }} AS design ON design.id = ddoc.id AND design.rev = ddoc.rev AND design.rev_id = ddoc.rev_id
+		WHERE ddoc.rank = 1
+			AND design.func_type = 'validate'
+		ORDER BY design.id
+	`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var funcs []validateFunc
+	for rows.Next() {
+		var f validateFunc
+		if err := rows.Scan(&f.ddoc, &f.ddocJSON, &f.language, &f.body); err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, f)
+	}
+	return funcs, rows.Err()
+}
+
+// validateOldDoc returns the revision of docID being replaced, or nil if there
+// is none, or its body is no longer available.
+func (d *db) validateOldDoc(ctx context.Context, tx *sql.Tx, docID string, rev revision) (*fullDoc, error) {
+	if rev.IsZero() {
+		return nil, nil
+	}
+	doc := &fullDoc{ID: docID, Rev: rev.String()}
+	err := tx.QueryRowContext(ctx, d.query(`
+		SELECT doc, deleted
+		FROM {{ .Docs }}
+		WHERE id = $1
+			AND rev = $2
+			AND rev_id = $3
+	`), docID, rev.rev, rev.id).Scan(&doc.Doc, &doc.Deleted)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return doc, nil
+}
+
+// validateNewDoc returns the new document, as passed to validate_doc_update
+// functions. If data has no body, as when only an attachment is added or
+// removed, the body of oldDoc is used.
+func validateNewDoc(data *docData, oldRev revision, oldDoc *fullDoc) (map[string]interface{}, error) {
+	doc := &fullDoc{
+		ID:      data.ID,
+		Doc:     data.Doc,
+		Deleted: data.Deleted,
+	}
+	if !oldRev.IsZero() {
+		doc.Rev = oldRev.String()
+	}
+	if len(doc.Doc) == 0 {
+		doc.Doc = []byte("{}")
+		if oldDoc != nil {
+			doc.Doc = oldDoc.Doc
+		}
+	}
+	if len(data.Attachments) > 0 {
+		doc.Attachments = make(map[string]*attachment, len(data.Attachments))
+		for filename, att := range data.Attachments {
+			att := att
+			if !att.Stub {
+				if err := att.calculate(filename); err != nil {
+					return nil, err
+				}
+				data.Attachments[filename] = att
+			}
+			doc.Attachments[filename] = &att
+		}
+	}
+	result := doc.toMap()
+	if doc.Rev == "" {
+		delete(result, "_rev")
+	}
+	return result, nil
+}
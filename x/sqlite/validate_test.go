@@ -0,0 +1,285 @@
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not
+// use this file except in compliance with the License. You may obtain a copy of
+// the License at
+//
+//  http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations under
+// the License.
+
+//go:build !js
+// +build !js
+
+package sqlite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gitlab.com/flimzy/testy"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/go-kivik/kivik/v4/driver"
+	"github.com/go-kivik/kivik/v4/int/mock"
+)
+
+func TestValidateDocUpdate(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		db         *testDB
+		write      func(*testDB) error
+		wantStatus int
+		wantErr    string
+	}
+
+	// newValidatedDB returns a database with validation functions which
+	// require a type field, forbid changing the type, and require
+	// authorization to delete documents.
+	newValidatedDB := func(t *testing.T) *testDB {
+		d := newDB(t)
+		_ = d.tPut("_design/types", map[string]interface{}{
+			"validate_doc_update": `function(newDoc, oldDoc, userCtx, secObj) {
+				if (newDoc._deleted) {
+					return;
+				}
+				if (!newDoc.type) {
+					throw({forbidden: "type is required"});
+				}
+				if (oldDoc && oldDoc.type !== newDoc.type) {
+					throw({forbidden: "type cannot be changed from " + oldDoc.type});
+				}
+			}`,
+		})
+		_ = d.tPut("_design/deletes", map[string]interface{}{
+			"validate_doc_update": `function(newDoc, oldDoc, userCtx, secObj) {
+				if (newDoc._deleted && userCtx.roles.indexOf("_admin") === -1) {
+					throw({unauthorized: "only admins may delete"});
+				}
+				if (newDoc._deleted && !oldDoc.deletable) {
+					throw({unauthorized: "not deletable"});
+				}
+			}`,
+		})
+		return d
+	}
+
+	tests := testy.NewTable()
+	tests.Add("put allowed", func(t *testing.T) interface{} {
+		return test{
+			db: newValidatedDB(t),
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "foo", map[string]interface{}{"type": "post"}, mock.NilOption)
+				return err
+			},
+		}
+	})
+	tests.Add("put forbidden", func(t *testing.T) interface{} {
+		return test{
+			db: newValidatedDB(t),
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "foo", map[string]interface{}{"title": "x"}, mock.NilOption)
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "type is required",
+		}
+	})
+	tests.Add("update receives old doc", func(t *testing.T) interface{} {
+		d := newValidatedDB(t)
+		rev := d.tPut("foo", map[string]interface{}{"type": "post"})
+
+		return test{
+			db: d,
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "foo", map[string]interface{}{"_rev": rev, "type": "comment"}, mock.NilOption)
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "type cannot be changed from post",
+		}
+	})
+	tests.Add("create doc forbidden", func(t *testing.T) interface{} {
+		return test{
+			db: newValidatedDB(t),
+			write: func(d *testDB) error {
+				_, _, err := d.CreateDoc(context.Background(), map[string]interface{}{}, mock.NilOption)
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "type is required",
+		}
+	})
+	tests.Add("delete unauthorized", func(t *testing.T) interface{} {
+		d := newValidatedDB(t)
+		rev := d.tPut("foo", map[string]interface{}{"type": "post"})
+
+		return test{
+			db: d,
+			write: func(d *testDB) error {
+				_, err := d.Delete(context.Background(), "foo", kivik.Rev(rev))
+				return err
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    "not deletable",
+		}
+	})
+	tests.Add("delete allowed", func(t *testing.T) interface{} {
+		d := newValidatedDB(t)
+		rev := d.tPut("foo", map[string]interface{}{"type": "post", "deletable": true})
+
+		return test{
+			db: d,
+			write: func(d *testDB) error {
+				_, err := d.Delete(context.Background(), "foo", kivik.Rev(rev))
+				return err
+			},
+		}
+	})
+	tests.Add("put attachment validates existing body", func(t *testing.T) interface{} {
+		d := newDB(t)
+		rev := d.tPut("foo", map[string]interface{}{"type": "post"})
+		_ = d.tPut("_design/atts", map[string]interface{}{
+			"validate_doc_update": `function(newDoc) {
+				if (newDoc.type === "post" && newDoc._attachments && newDoc._attachments["bad.txt"]) {
+					throw({forbidden: "no bad attachments on posts"});
+				}
+			}`,
+		})
+
+		return test{
+			db: d,
+			write: func(d *testDB) error {
+				_, err := d.DB.(*db).PutAttachment(context.Background(), "foo", &driver.Attachment{
+					Filename:    "bad.txt",
+					ContentType: "text/plain",
+					Content:     io.NopCloser(strings.NewReader("bad")),
+				}, kivik.Rev(rev))
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "no bad attachments on posts",
+		}
+	})
+	tests.Add("validation function requires module", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/rules", map[string]interface{}{
+			"validate_doc_update": `function(newDoc) {
+				require('lib/rules').check(newDoc);
+			}`,
+			"lib": map[string]string{
+				"rules": `exports.check = function(doc) {
+					if (!isArray(doc.tags)) {
+						throw({forbidden: "tags must be an array"});
+					}
+				};`,
+			},
+		})
+
+		return test{
+			db: d,
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "foo", map[string]interface{}{"tags": "x"}, mock.NilOption)
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "tags must be an array",
+		}
+	})
+	tests.Add("bulk docs", func(t *testing.T) interface{} {
+		return test{
+			db: newValidatedDB(t),
+			write: func(d *testDB) error {
+				results, err := d.DB.(driver.BulkDocer).BulkDocs(context.Background(), []interface{}{
+					map[string]interface{}{"_id": "foo", "type": "post"},
+					map[string]interface{}{"_id": "bar"},
+				}, mock.NilOption)
+				if err != nil {
+					return err
+				}
+				if results[0].Error != nil {
+					return results[0].Error
+				}
+				return results[1].Error
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "type is required",
+		}
+	})
+	tests.Add("replication is validated", func(t *testing.T) interface{} {
+		return test{
+			db: newValidatedDB(t),
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "foo", map[string]interface{}{"_rev": "1-abc"}, kivik.Param("new_edits", false))
+				return err
+			},
+			wantStatus: http.StatusForbidden,
+			wantErr:    "type is required",
+		}
+	})
+	tests.Add("design docs are not validated", func(t *testing.T) interface{} {
+		return test{
+			db: newValidatedDB(t),
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "_design/foo", map[string]interface{}{}, mock.NilOption)
+				return err
+			},
+		}
+	})
+	tests.Add("local docs are not validated", func(t *testing.T) interface{} {
+		return test{
+			db: newValidatedDB(t),
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "_local/foo", map[string]interface{}{}, mock.NilOption)
+				return err
+			},
+		}
+	})
+	tests.Add("deleted design doc is ignored", func(t *testing.T) interface{} {
+		d := newDB(t)
+		rev := d.tPut("_design/foo", map[string]interface{}{
+			"validate_doc_update": `function() { throw({forbidden: "nope"}); }`,
+		})
+		_ = d.tDelete("_design/foo", kivik.Rev(rev))
+
+		return test{
+			db: d,
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "foo", map[string]interface{}{}, mock.NilOption)
+				return err
+			},
+		}
+	})
+	tests.Add("other exceptions", func(t *testing.T) interface{} {
+		d := newDB(t)
+		_ = d.tPut("_design/foo", map[string]interface{}{
+			"validate_doc_update": `function() { throw("oops"); }`,
+		})
+
+		return test{
+			db: d,
+			write: func(d *testDB) error {
+				_, err := d.Put(context.Background(), "foo", map[string]interface{}{}, mock.NilOption)
+				return err
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantErr:    "oops",
+		}
+	})
+
+	tests.Run(t, func(t *testing.T, tt test) {
+		t.Parallel()
+		err := tt.write(tt.db)
+		if !testy.ErrorMatchesRE(tt.wantErr, err) {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if status := kivik.HTTPStatus(err); err != nil && status != tt.wantStatus {
+			t.Errorf("Unexpected status: %d", status)
+		}
+	})
+}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-kivik/kivik/v4/driver"
 	internal "github.com/go-kivik/kivik/v4/int/errors"
@@ -83,6 +84,7 @@ func (d *db) queryBuiltinView(
 	args := []interface{}{vopts.includeDocs, vopts.conflicts, vopts.updateSeq, vopts.attachments, vopts.bookmark}
 
 	where := append([]string{""}, vopts.buildWhere(&args)...)
+	where = append(where, d.findWhere(vopts, &args)...)
 
 	query := fmt.Sprintf(d.query(leavesCTE+`,
 		main AS (
@@ -99,7 +101,9 @@ func (d *db) queryBuiltinView(
 				length,
 				digest,
 				rev_pos,
+				encoding,
 				data,
+				blob,
 				doc_number
 			FROM (
 				SELECT
@@ -116,7 +120,9 @@ func (d *db) queryBuiltinView(
 					att.length AS length,
 					att.digest AS digest,
 					att.rev_pos AS rev_pos,
-					IIF($4, att.data, NULL) AS data,
+					content.encoding AS encoding,
+					IIF($4, content.data, NULL) AS data,
+					IIF($4, IIF(content.data IS NULL, lower(hex(content.hash)), ''), NULL) AS blob,
 					ROW_NUMBER() OVER (%[1]s) AS doc_number
 				FROM (
 					SELECT
@@ -145,6 +151,7 @@ func (d *db) queryBuiltinView(
 				) AS view
 				LEFT JOIN {{ .AttachmentsBridge }} AS bridge ON view.id = bridge.id AND view.rev = bridge.rev AND view.rev_id = bridge.rev_id AND $1
 				LEFT JOIN {{ .Attachments }} AS att ON bridge.pk = att.pk
+				LEFT JOIN {{ .AttachmentData }} AS content ON content.hash = att.hash
 				%[1]s -- ORDER BY
 			)
 		),
@@ -167,7 +174,9 @@ func (d *db) queryBuiltinView(
 			NULL AS length,
 			NULL AS digest,
 			NULL AS rev_pos,
-			NULL AS data
+			NULL AS encoding,
+			NULL AS data,
+			NULL AS blob
 		FROM {{ .Docs }}
 
 		UNION ALL
@@ -185,7 +194,9 @@ func (d *db) queryBuiltinView(
 			length,
 			digest,
 			rev_pos,
-			data
+			encoding,
+			data,
+			blob
 		FROM main
 		%[5]s -- bookmark filtering
 	`), vopts.buildOrderBy(), strings.Join(where, " AND "), vopts.limit, vopts.skip, vopts.bookmarkWhere())
@@ -208,15 +219,19 @@ func (d *db) queryBuiltinView(
 		findLimit: vopts.findLimit,
 		findSkip:  vopts.findSkip,
 		fields:    vopts.fields,
+		warning:   strings.Join(vopts.warnings, "\n"),
+		stats:     vopts.stats,
+		start:     vopts.findStart,
 	}, nil
 }
 
 type viewMetadata struct {
-	upToDate     bool
-	reducible    bool
-	reduceFuncJS string
-	updateSeq    string
-	lastSeq      int
+	upToDate       bool
+	reducible      bool
+	reduceFuncJS   string
+	reduceLanguage string
+	updateSeq      string
+	lastSeq        int
 }
 
 // readFirstRow reads the first row from the resultset, which contains. In the
@@ -227,11 +242,14 @@ func readFirstRow(results *sql.Rows, vopts *viewOptions) (*viewMetadata, error)
 		_ = results.Close() //nolint:sqlclosecheck // Aborting
 		return nil, errors.New("no rows returned")
 	}
-	var meta viewMetadata
-	var lastSeq *int
+	var (
+		meta           viewMetadata
+		lastSeq        *int
+		reduceLanguage *string
+	)
 	if err := results.Scan(
-		&meta.upToDate, &meta.reducible, &meta.reduceFuncJS, &meta.updateSeq, &lastSeq, discard{},
-		discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
+		&meta.upToDate, &meta.reducible, &meta.reduceFuncJS, &meta.updateSeq, &lastSeq, &reduceLanguage,
+		discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{}, discard{},
 	); err != nil {
 		_ = results.Close() //nolint:sqlclosecheck // Aborting
 		return nil, err
@@ -250,6 +268,9 @@ func readFirstRow(results *sql.Rows, vopts *viewOptions) (*viewMetadata, error)
 	if lastSeq != nil {
 		meta.lastSeq = *lastSeq
 	}
+	if reduceLanguage != nil {
+		meta.reduceLanguage = *reduceLanguage
+	}
 	return &meta, nil
 }
 
@@ -269,12 +290,18 @@ type rows struct {
 	findLimit, findSkip int64
 	index               int64
 	fields              []string
+	warning             string
+	stats               *ExecutionStats
+	start               time.Time
 
 	done     bool
 	bookmark string
 }
 
-var _ driver.Rows = (*rows)(nil)
+var (
+	_ driver.Rows       = (*rows)(nil)
+	_ driver.RowsWarner = (*rows)(nil)
+)
 
 func (r *rows) Next(row *driver.Row) error {
 	var (
@@ -286,21 +313,22 @@ func (r *rows) Next(row *driver.Row) error {
 			if err := r.rows.Err(); err != nil {
 				return err
 			}
-			r.done = true
+			r.finish()
 			return io.EOF
 		}
 		var (
-			key, doc                                     []byte
-			value, data                                  *[]byte
-			id, conflicts, rowRev, filename, contentType *string
-			length                                       *int64
-			revPos                                       *int
-			digest                                       *md5sum
+			key, doc, data                                         []byte
+			value                                                  *[]byte
+			id, conflicts, rowRev, filename, contentType, encoding *string
+			length                                                 *int64
+			revPos                                                 *int
+			digest                                                 *md5sum
+			blob                                                   *string
 		)
 		if err := r.rows.Scan(
 			&id, &key, &value, &rowRev, &doc, &conflicts,
 			&attachmentCount,
-			&filename, &contentType, &length, &digest, &revPos, &data,
+			&filename, &contentType, &length, &digest, &revPos, &encoding, &data, &blob,
 		); err != nil {
 			return err
 		}
@@ -310,6 +338,12 @@ func (r *rows) Next(row *driver.Row) error {
 			if id != nil {
 				row.ID = *id
 			}
+			if r.stats != nil {
+				r.stats.TotalKeysExamined++
+				if doc != nil {
+					r.stats.TotalDocsExamined++
+				}
+			}
 			row.Key = key
 			if len(key) == 0 {
 				row.Key = []byte("null")
@@ -335,9 +369,12 @@ func (r *rows) Next(row *driver.Row) error {
 				full.Attachments = make(map[string]*attachment)
 			}
 			var jsonData json.RawMessage
-			if data != nil {
-				var err error
-				jsonData, err = json.Marshal(*data)
+			if blob != nil {
+				content, err := r.db.readAttachment(encoding, data, *blob)
+				if err != nil {
+					return err
+				}
+				jsonData, err = json.Marshal(content)
 				if err != nil {
 					return err
 				}
@@ -365,10 +402,13 @@ func (r *rows) Next(row *driver.Row) error {
 			if r.index <= r.findSkip {
 				return r.Next(row)
 			}
-			if r.findLimit > 0 && r.index > r.findLimit+r.findSkip {
-				r.done = true
+			if r.findLimit >= 0 && r.index > r.findLimit+r.findSkip {
+				r.finish()
 				return io.EOF
 			}
+			if r.stats != nil {
+				r.stats.ResultsReturned++
+			}
 			// These values are omitted from the _find response
 			r.bookmark = row.ID
 			row.ID = ""
@@ -380,6 +420,14 @@ func (r *rows) Next(row *driver.Row) error {
 	return nil
 }
 
+// finish marks the end of the rows, and stops the execution timer.
+func (r *rows) finish() {
+	r.done = true
+	if r.stats != nil {
+		r.stats.ExecutionTimeMs = float64(time.Since(r.start).Microseconds()) / 1000
+	}
+}
+
 func (r *rows) Close() error {
 	return r.rows.Close()
 }
@@ -396,6 +444,25 @@ func (*rows) TotalRows() int64 {
 	return 0
 }
 
+// Warning returns the warnings generated by a _find query, if any.
+func (r *rows) Warning() string {
+	return r.warning
+}
+
+// ExecutionStats returns the execution statistics of a _find query, if they
+// were requested with the execution_stats option, or nil otherwise. The
+// statistics are complete once the rows have been read to the end.
+func (r *rows) ExecutionStats() *ExecutionStats {
+	if r.stats == nil {
+		return nil
+	}
+	stats := *r.stats
+	if !r.done {
+		stats.ExecutionTimeMs = float64(time.Since(r.start).Microseconds()) / 1000
+	}
+	return &stats
+}
+
 func (r *rows) Bookmark() string {
 	if r.done {
 		// Only return the bookmark if we've reached the end of the rows.